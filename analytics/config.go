@@ -0,0 +1,25 @@
+package analytics
+
+import (
+	"errors"
+	"time"
+)
+
+type Config struct {
+	// how often are progress events emitted, zero disables them
+	Interval time.Duration
+	// how long after last request is session considered ended
+	IdleTimeout time.Duration
+}
+
+func (c *Config) Validate() error {
+	if c.Interval < 0 {
+		return errors.New("analytics interval must not be negative")
+	}
+
+	if c.IdleTimeout <= 0 {
+		return errors.New("analytics idle timeout must be positive")
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// LogSink writes events to log.
+type LogSink struct {
+	logger zerolog.Logger
+}
+
+func NewLogSink() *LogSink {
+	return &LogSink{
+		logger: log.With().Str("module", "analytics").Logger(),
+	}
+}
+
+func (s *LogSink) Emit(event Event) {
+	s.logger.Info().
+		Str("type", string(event.Type)).
+		Str("session", event.Session).
+		Str("stream", event.Stream).
+		Str("profile", event.Profile).
+		Str("client", event.Client).
+		Int64("bytes", event.Bytes).
+		Float64("duration", event.Duration).
+		Msg("analytics event")
+}
+
+// HTTPSink posts events as JSON to given url.
+type HTTPSink struct {
+	logger zerolog.Logger
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{
+		logger: log.With().Str("module", "analytics").Str("url", url).Logger(),
+		url:    url,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+func (s *HTTPSink) Emit(event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		s.logger.Err(err).Msg("unable to marshal event")
+		return
+	}
+
+	res, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("unable to send event")
+		return
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		s.logger.Warn().Err(fmt.Errorf("unexpected status %s", res.Status)).Msg("unable to send event")
+	}
+}
+
+// ChanSink sends events to channel, dropping them when channel is full.
+type ChanSink chan Event
+
+func (s ChanSink) Emit(event Event) {
+	select {
+	case s <- event:
+	default:
+	}
+}
@@ -0,0 +1,221 @@
+package analytics
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// how many events can wait for sink
+const queueSize = 1024
+
+type Session struct {
+	id       string
+	stream   string
+	profile  string
+	client   string
+	started  time.Time
+	lastSeen time.Time
+	bytes    int64
+	// long lived sessions are closed explicitly
+	open bool
+}
+
+// Tracker aggregates served bytes into viewer sessions and emits their
+// events to sink. Sink is called from single goroutine, so that slow
+// sink never blocks serving.
+type Tracker struct {
+	logger zerolog.Logger
+	mu     sync.Mutex
+	sink   Sink
+	config Config
+
+	sessions map[string]*Session
+	queue    chan Event
+	shutdown chan struct{}
+	done     chan struct{}
+}
+
+func New(sink Sink, config Config) *Tracker {
+	return &Tracker{
+		logger: log.With().Str("module", "analytics").Logger(),
+		sink:   sink,
+		config: config,
+
+		sessions: map[string]*Session{},
+		queue:    make(chan Event, queueSize),
+		shutdown: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (t *Tracker) Start() {
+	go func() {
+		defer close(t.done)
+
+		for event := range t.queue {
+			t.sink.Emit(event)
+		}
+	}()
+
+	go func() {
+		period := t.config.IdleTimeout
+		if t.config.Interval > 0 && t.config.Interval < period {
+			period = t.config.Interval
+		}
+
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		lastProgress := time.Now()
+		for {
+			select {
+			case <-t.shutdown:
+				return
+			case now := <-ticker.C:
+				progress := t.config.Interval > 0 && now.Sub(lastProgress) >= t.config.Interval
+				if progress {
+					lastProgress = now
+				}
+
+				t.tick(now, progress)
+			}
+		}
+	}()
+}
+
+// Stop ends all sessions and waits until their events are passed to sink.
+func (t *Tracker) Stop() {
+	t.mu.Lock()
+	close(t.shutdown)
+	now := time.Now()
+	for key, session := range t.sessions {
+		t.emit(EventSessionEnd, session, now)
+		delete(t.sessions, key)
+	}
+	close(t.queue)
+	t.sessions = nil
+	t.mu.Unlock()
+
+	<-t.done
+}
+
+func (t *Tracker) tick(now time.Time, progress bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, session := range t.sessions {
+		if !session.open && now.Sub(session.lastSeen) > t.config.IdleTimeout {
+			t.emit(EventSessionEnd, session, session.lastSeen)
+			delete(t.sessions, key)
+			continue
+		}
+
+		if progress {
+			t.emit(EventSessionProgress, session, now)
+		}
+	}
+}
+
+// Served accounts bytes served to client by request based protocol,
+// e.g. HLS, where session ends after being idle.
+func (t *Tracker) Served(stream string, profile string, client string, bytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.sessions == nil {
+		return
+	}
+
+	key := stream + "/" + profile + "/" + client
+	session, ok := t.sessions[key]
+	if !ok {
+		session = t.newSession(stream, profile, client, false)
+		t.sessions[key] = session
+	}
+
+	session.lastSeen = time.Now()
+	session.bytes += bytes
+}
+
+// Open starts long lived session, e.g. HTTP stream, that needs to be closed.
+func (t *Tracker) Open(stream string, profile string, client string) *Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	session := t.newSession(stream, profile, client, true)
+	if t.sessions != nil {
+		t.sessions[session.id] = session
+	}
+
+	return session
+}
+
+// Add accounts bytes served in long lived session.
+func (t *Tracker) Add(session *Session, bytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	session.lastSeen = time.Now()
+	session.bytes += bytes
+}
+
+func (t *Tracker) Close(session *Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.sessions[session.id]; !ok {
+		return
+	}
+
+	delete(t.sessions, session.id)
+	t.emit(EventSessionEnd, session, time.Now())
+}
+
+func (t *Tracker) newSession(stream string, profile string, client string, open bool) *Session {
+	id := make([]byte, 8)
+	//nolint
+	rand.Read(id)
+
+	now := time.Now()
+	session := &Session{
+		id:       hex.EncodeToString(id),
+		stream:   stream,
+		profile:  profile,
+		client:   client,
+		started:  now,
+		lastSeen: now,
+		open:     open,
+	}
+
+	t.emit(EventSessionStart, session, now)
+	return session
+}
+
+// emit queues event without blocking, must be called with lock held.
+func (t *Tracker) emit(kind EventType, session *Session, now time.Time) {
+	if t.sessions == nil {
+		return
+	}
+
+	event := Event{
+		Type:     kind,
+		Time:     now,
+		Session:  session.id,
+		Stream:   session.stream,
+		Profile:  session.profile,
+		Client:   session.client,
+		Bytes:    session.bytes,
+		Duration: now.Sub(session.started).Seconds(),
+	}
+
+	select {
+	case t.queue <- event:
+	default:
+		t.logger.Warn().Str("type", string(kind)).Msg("analytics queue full, dropping event")
+	}
+}
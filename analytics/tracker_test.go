@@ -0,0 +1,140 @@
+package analytics
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSink records emitted events.
+type testSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *testSink) Emit(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+}
+
+func (s *testSink) types() []EventType {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	types := []EventType{}
+	for _, event := range s.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func (s *testSink) last() Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events[len(s.events)-1]
+}
+
+func equalTypes(a []EventType, b []EventType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestServedSession(t *testing.T) {
+	sink := &testSink{}
+	tracker := New(sink, Config{IdleTimeout: time.Minute})
+	tracker.Start()
+
+	tracker.Served("cam", "h264_720p", "10.0.0.1", 0)
+	tracker.Served("cam", "h264_720p", "10.0.0.1", 100)
+	tracker.Served("cam", "h264_720p", "10.0.0.1", 50)
+	tracker.Stop()
+
+	want := []EventType{EventSessionStart, EventSessionEnd}
+	if got := sink.types(); !equalTypes(got, want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+
+	event := sink.last()
+	if event.Bytes != 150 || event.Client != "10.0.0.1" || event.Stream != "cam" || event.Profile != "h264_720p" {
+		t.Errorf("got event %+v", event)
+	}
+}
+
+func TestIdleSession(t *testing.T) {
+	sink := &testSink{}
+	tracker := New(sink, Config{Interval: time.Minute, IdleTimeout: time.Minute})
+	tracker.Start()
+	defer tracker.Stop()
+
+	tracker.Served("cam", "h264_720p", "10.0.0.1", 100)
+	// other client is a separate session
+	tracker.Served("cam", "h264_720p", "10.0.0.2", 10)
+
+	tracker.tick(time.Now(), true)
+	tracker.tick(time.Now().Add(2*time.Minute), false)
+
+	// events are emitted asynchronously
+	ok := waitFor(time.Second, func() bool { return len(sink.types()) == 6 })
+	if !ok {
+		t.Fatalf("got events %v, want 6", sink.types())
+	}
+
+	ended := map[string]int64{}
+	sink.mu.Lock()
+	for _, event := range sink.events {
+		if event.Type == EventSessionEnd {
+			ended[event.Client] = event.Bytes
+		}
+	}
+	sink.mu.Unlock()
+
+	if ended["10.0.0.1"] != 100 || ended["10.0.0.2"] != 10 {
+		t.Errorf("got ended sessions %v", ended)
+	}
+}
+
+func TestOpenSession(t *testing.T) {
+	sink := &testSink{}
+	tracker := New(sink, Config{IdleTimeout: time.Minute})
+	tracker.Start()
+
+	session := tracker.Open("cam", "h264_720p", "10.0.0.1")
+	tracker.Add(session, 1000)
+
+	// long lived session is not ended while idle
+	tracker.tick(time.Now().Add(2*time.Minute), false)
+
+	tracker.Close(session)
+	// closing twice emits single event
+	tracker.Close(session)
+	tracker.Stop()
+
+	want := []EventType{EventSessionStart, EventSessionEnd}
+	if got := sink.types(); !equalTypes(got, want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+
+	if event := sink.last(); event.Bytes != 1000 || event.Client != "10.0.0.1" {
+		t.Errorf("got event %+v", event)
+	}
+}
+
+// waitFor polls condition until it holds or timeout passes.
+func waitFor(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return condition()
+}
@@ -0,0 +1,28 @@
+package analytics
+
+import "time"
+
+type EventType string
+
+const (
+	EventSessionStart    EventType = "session_start"
+	EventSessionProgress EventType = "session_progress"
+	EventSessionEnd      EventType = "session_end"
+)
+
+type Event struct {
+	Type    EventType `json:"type"`
+	Time    time.Time `json:"time"`
+	Session string    `json:"session"`
+	Stream  string    `json:"stream"`
+	Profile string    `json:"profile"`
+	Client  string    `json:"client"`
+	// bytes served since session start
+	Bytes int64 `json:"bytes"`
+	// session duration in seconds
+	Duration float64 `json:"duration"`
+}
+
+type Sink interface {
+	Emit(event Event)
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// how long are fetched keys used, before key set is fetched again
+const keySetTTL = 10 * time.Minute
+
+// unknown key ids cause fetch at most this often
+const keySetMinRefresh = time.Minute
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	// rsa
+	N string `json:"n"`
+	E string `json:"e"`
+	// ec
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// keySet caches public keys fetched from jwks url, refreshed once they
+// expire or token references unknown key (e.g. after key rotation).
+type keySet struct {
+	logger zerolog.Logger
+	url    string
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	// closed once fetch in progress finishes, nil when none
+	fetching chan struct{}
+}
+
+func newKeySet(url string) *keySet {
+	return &keySet{
+		logger: log.With().Str("module", "auth").Str("submodule", "jwks").Logger(),
+		url:    url,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		keys: map[string]crypto.PublicKey{},
+	}
+}
+
+// get returns key of id. Known keys are returned right away, also while
+// key set is being fetched again, only unknown keys wait for fetch.
+func (s *keySet) get(kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	key, ok := s.keys[kid]
+	expired := time.Since(s.fetchedAt) > keySetTTL
+
+	// unknown keys do not cause fetch on every request
+	if (!ok || expired) && s.fetching == nil && time.Since(s.attemptedAt) > keySetMinRefresh {
+		s.attemptedAt = time.Now()
+		s.fetching = make(chan struct{})
+		go s.refresh(s.fetching)
+	}
+
+	fetching := s.fetching
+	s.mu.Unlock()
+
+	// previous keys are used while key set is unavailable
+	if ok {
+		return key, nil
+	}
+
+	if fetching != nil {
+		<-fetching
+
+		s.mu.Lock()
+		key, ok = s.keys[kid]
+		s.mu.Unlock()
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+
+	return key, nil
+}
+
+// refresh fetches key set without lock held and closes done, once fetched
+// keys replace previous ones.
+func (s *keySet) refresh(done chan struct{}) {
+	defer close(done)
+
+	keys, err := s.fetch()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fetching = nil
+	if err != nil {
+		s.logger.Err(err).Str("url", s.url).Msg("could not fetch key set")
+		return
+	}
+
+	s.keys = keys
+	s.fetchedAt = time.Now()
+}
+
+func (s *keySet) fetch() (map[string]crypto.PublicKey, error) {
+	res, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			s.logger.Warn().Err(err).Str("kid", k.Kid).Msg("skipping key")
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	s.logger.Debug().Int("keys", len(keys)).Msg("fetched key set")
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := map[string]elliptic.Curve{
+			"P-256": elliptic.P256(),
+			"P-384": elliptic.P384(),
+			"P-521": elliptic.P521(),
+		}[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeySetUnknownKey(t *testing.T) {
+	keys := newTestKeys(t)
+	var requests int32
+	server := testJWKS(t, keys, &requests)
+
+	v := New(Config{JWKSURL: server.URL})
+	claims := Claims{Streams: []string{"cam"}}
+
+	if _, err := v.Verify(testToken(t, "ES256", "p256", keys["p256"], claims)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// unknown keys are refused without fetching key set again
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(testToken(t, "ES256", "rotated", keys["p256"], claims)); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("got error %v, want %v", err, ErrInvalidToken)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("got %d key set requests, want 1 within refresh period", n)
+	}
+
+	// fetched again once refresh period passes
+	v.keys.mu.Lock()
+	v.keys.attemptedAt = time.Now().Add(-2 * keySetMinRefresh)
+	v.keys.mu.Unlock()
+
+	if _, err := v.Verify(testToken(t, "ES256", "rotated", keys["p256"], claims)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidToken)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("got %d key set requests, want 2 after refresh period", n)
+	}
+}
+
+func TestKeySetSlowRefresh(t *testing.T) {
+	keys := newTestKeys(t)
+	var requests int32
+	server := testJWKS(t, keys, &requests)
+
+	v := New(Config{JWKSURL: server.URL})
+	claims := Claims{Streams: []string{"cam"}}
+
+	if _, err := v.Verify(testToken(t, "RS256", "rsa", keys["rsa"], claims)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// key set expires while its url hangs
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	v.keys.mu.Lock()
+	v.keys.url = slow.URL
+	v.keys.fetchedAt = time.Now().Add(-2 * keySetTTL)
+	v.keys.attemptedAt = time.Time{}
+	v.keys.mu.Unlock()
+
+	token := testToken(t, "RS256", "rsa", keys["rsa"], claims)
+	done := make(chan error)
+	go func() {
+		_, err := v.Verify(token)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cached key blocked by key set fetch")
+	}
+
+	v.keys.mu.Lock()
+	fetching := v.keys.fetching != nil
+	v.keys.mu.Unlock()
+	if !fetching {
+		t.Error("expired key set not fetched in background")
+	}
+}
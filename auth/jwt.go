@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// clock skew tolerated by expiry checks
+const leeway = 30 * time.Second
+
+// curves of ES* algorithms
+var ecdsaCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+)
+
+type Config struct {
+	// secret of HS256, HS384 and HS512 signed tokens
+	Secret string
+	// url of key set verifying RS* and ES* signed tokens
+	JWKSURL string
+	// required issuer of tokens, any when empty
+	Issuer string
+}
+
+func (c *Config) Enabled() bool {
+	return c.Secret != "" || c.JWKSURL != ""
+}
+
+func (c *Config) Validate() error {
+	if c.JWKSURL == "" {
+		return nil
+	}
+
+	if _, err := url.ParseRequestURI(c.JWKSURL); err != nil {
+		return fmt.Errorf("invalid jwks url: %w", err)
+	}
+
+	return nil
+}
+
+// Claims of verified token.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+	Streams   []string `json:"streams"`
+}
+
+// Allows reports whether token lists stream, or all streams using "*".
+func (c *Claims) Allows(stream string) bool {
+	for _, allowed := range c.Streams {
+		if allowed == stream || allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verifier verifies signature and claims of JSON web tokens.
+type Verifier struct {
+	config Config
+	keys   *keySet
+}
+
+func New(config Config) *Verifier {
+	v := &Verifier{
+		config: config,
+	}
+
+	if config.JWKSURL != "" {
+		v.keys = newKeySet(config.JWKSURL)
+	}
+
+	return v
+}
+
+// Verify returns claims of token, once its signature, issuer and validity
+// period are verified.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	if err := v.verifySignature(h, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, err
+	}
+
+	if v.config.Issuer != "" && claims.Issuer != v.config.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt != nil && now.After(time.Unix(*claims.ExpiresAt, 0).Add(leeway)) {
+		return nil, ErrExpiredToken
+	}
+
+	if claims.NotBefore != nil && now.Add(leeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+func (v *Verifier) verifySignature(h header, signed string, signature []byte) error {
+	hash, ok := map[string]crypto.Hash{
+		"256": crypto.SHA256,
+		"384": crypto.SHA384,
+		"512": crypto.SHA512,
+	}[strings.TrimLeft(h.Alg, "HSRE")]
+	if !ok || len(h.Alg) != 5 {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, h.Alg)
+	}
+
+	digest := hash.New()
+	digest.Write([]byte(signed))
+
+	switch h.Alg[:2] {
+	case "HS":
+		if v.config.Secret == "" {
+			return fmt.Errorf("%w: no secret for %s", ErrInvalidToken, h.Alg)
+		}
+
+		mac := hmac.New(hash.New, []byte(v.config.Secret))
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+		return nil
+	case "RS", "ES":
+		if v.keys == nil {
+			return fmt.Errorf("%w: no key set for %s", ErrInvalidToken, h.Alg)
+		}
+
+		key, err := v.keys.get(h.Kid)
+		if err != nil {
+			return err
+		}
+
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			if h.Alg[0] == 'R' && rsa.VerifyPKCS1v15(key, hash, digest.Sum(nil), signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			// fixed size r and s concatenated, curve is given by algorithm
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if h.Alg[0] == 'E' && key.Curve == ecdsaCurves[h.Alg] && len(signature) == 2*size {
+				r := new(big.Int).SetBytes(signature[:size])
+				s := new(big.Int).SetBytes(signature[size:])
+				if ecdsa.Verify(key, digest.Sum(nil), r, s) {
+					return nil
+				}
+			}
+		}
+
+		return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+	}
+
+	return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, h.Alg)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed segment", ErrInvalidToken)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testKeys are private keys by their id, published by testJWKS.
+type testKeys map[string]crypto.Signer
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+
+	keys := testKeys{}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys["rsa"] = rsaKey
+
+	for kid, curve := range map[string]elliptic.Curve{"p256": elliptic.P256(), "p384": elliptic.P384(), "p521": elliptic.P521()} {
+		ecKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[kid] = ecKey
+	}
+
+	return keys
+}
+
+// testJWKS serves public keys as key set, counting its requests.
+func testJWKS(t *testing.T, keys testKeys, requests *int32) *httptest.Server {
+	t.Helper()
+
+	encode := func(i *big.Int, size int) string {
+		return base64.RawURLEncoding.EncodeToString(i.FillBytes(make([]byte, size)))
+	}
+
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	for kid, key := range keys {
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			set.Keys = append(set.Keys, jwk{
+				Kty: "RSA",
+				Kid: kid,
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		case *ecdsa.PrivateKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			set.Keys = append(set.Keys, jwk{
+				Kty: "EC",
+				Kid: kid,
+				Crv: key.Curve.Params().Name,
+				X:   encode(key.X, size),
+				Y:   encode(key.Y, size),
+			})
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		//nolint
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testToken returns token of claims signed by key using alg, key is secret
+// for HS* algorithms.
+func testToken(t *testing.T, alg string, kid string, key interface{}, claims interface{}) string {
+	t.Helper()
+
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := segment(header{Alg: alg, Kid: kid}) + "." + segment(claims)
+
+	hash := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[alg[2:]]
+	digest := hash.New()
+	digest.Write([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case string:
+		mac := hmac.New(hash.New, []byte(key))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, hash, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		signature = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifySignature(t *testing.T) {
+	keys := newTestKeys(t)
+	var requests int32
+	server := testJWKS(t, keys, &requests)
+
+	v := New(Config{Secret: "secret", JWKSURL: server.URL})
+	claims := Claims{Streams: []string{"cam"}}
+
+	tests := []struct {
+		name  string
+		alg   string
+		kid   string
+		key   interface{}
+		valid bool
+	}{
+		{"HS256", "HS256", "", "secret", true},
+		{"HS384", "HS384", "", "secret", true},
+		{"HS512", "HS512", "", "secret", true},
+		{"HS256 wrong secret", "HS256", "", "other", false},
+		{"RS256", "RS256", "rsa", keys["rsa"], true},
+		{"RS384", "RS384", "rsa", keys["rsa"], true},
+		{"RS512", "RS512", "rsa", keys["rsa"], true},
+		{"ES256", "ES256", "p256", keys["p256"], true},
+		{"ES384", "ES384", "p384", keys["p384"], true},
+		{"ES512", "ES512", "p521", keys["p521"], true},
+		{"RS256 of ec key", "RS256", "p256", keys["p256"], false},
+		{"ES256 of rsa key", "ES256", "rsa", keys["rsa"], false},
+		{"ES256 of other key", "ES256", "p256", keys["p384"], false},
+		{"ES512 of p256 key", "ES512", "p256", keys["p256"], false},
+		{"ES384 of p521 key", "ES384", "p521", keys["p521"], false},
+		{"unsupported", "PS256", "", "secret", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.Verify(testToken(t, tt.alg, tt.kid, tt.key, claims))
+			if tt.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("got error %v, want %v", err, ErrInvalidToken)
+			}
+			if tt.valid && !got.Allows("cam") {
+				t.Errorf("got streams %v, want cam allowed", got.Streams)
+			}
+		})
+	}
+
+	// token with no secret configured
+	v = New(Config{JWKSURL: server.URL})
+	if _, err := v.Verify(testToken(t, "HS256", "", "secret", claims)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got error %v, want %v without secret", err, ErrInvalidToken)
+	}
+}
+
+func TestVerifyClaims(t *testing.T) {
+	v := New(Config{Secret: "secret", Issuer: "https://issuer.example.com"})
+
+	unix := func(d time.Duration) *int64 {
+		at := time.Now().Add(d).Unix()
+		return &at
+	}
+
+	tests := []struct {
+		name   string
+		claims Claims
+		err    error
+	}{
+		{"valid", Claims{Issuer: "https://issuer.example.com", ExpiresAt: unix(time.Minute), NotBefore: unix(-time.Minute)}, nil},
+		{"no validity period", Claims{Issuer: "https://issuer.example.com"}, nil},
+		{"expired within leeway", Claims{Issuer: "https://issuer.example.com", ExpiresAt: unix(-leeway / 2)}, nil},
+		{"expired", Claims{Issuer: "https://issuer.example.com", ExpiresAt: unix(-2 * leeway)}, ErrExpiredToken},
+		{"not before within leeway", Claims{Issuer: "https://issuer.example.com", NotBefore: unix(leeway / 2)}, nil},
+		{"not valid yet", Claims{Issuer: "https://issuer.example.com", NotBefore: unix(2 * leeway)}, ErrInvalidToken},
+		{"other issuer", Claims{Issuer: "https://other.example.com"}, ErrInvalidToken},
+		{"no issuer", Claims{}, ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Verify(testToken(t, "HS256", "", "secret", tt.claims))
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	v := New(Config{Secret: "secret"})
+
+	for _, token := range []string{"", "a.b", "a.b.c.d", "!.e30.e30", "e30.!.e30", "e30.e30.!"} {
+		if _, err := v.Verify(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%q: got error %v, want %v", token, err, ErrInvalidToken)
+		}
+	}
+}
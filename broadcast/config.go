@@ -0,0 +1,32 @@
+package broadcast
+
+import (
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+type Config struct {
+	// receives transcode output besides logger, e.g. log of stream
+	CmdLog io.Writer
+
+	// container of transcode output, FormatMPEGTS or FormatMP4
+	Format string
+	// signal only transcode process instead of its whole process group
+	SingleProcess bool
+	// nice level, cpu affinity and cgroup limits of transcode process
+	ProcessLimits utils.ProcessLimits
+	// slots held while transcode runs, start fails once any is full
+	// longer than limit timeout
+	Limits       []*utils.Semaphore
+	LimitTimeout time.Duration
+	// status of responses to transcode failing to start, 500 when nil
+	ErrorStatus func(err error) int
+	// Retry-After of responses refused because of transcode limits
+	RetryAfter string
+	// called once cmd returned by factory exits or fails to start, e.g.
+	// releasing its hardware encoder session
+	Exited func(cmd *exec.Cmd)
+}
@@ -0,0 +1,372 @@
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// how many units can be queued for client, before it is dropped
+const clientBuffer = 256
+
+// size of single read from transcode output
+const readSize = 64 * 1024
+
+// limit of concurrent transcodes is reached
+var ErrTooManyTranscodes = errors.New("too many transcodes")
+
+type client struct {
+	data chan []byte
+	// client has received header and joined the stream
+	joined bool
+}
+
+type ManagerCtx struct {
+	logger     zerolog.Logger
+	mu         sync.Mutex
+	cmdFactory func() (*exec.Cmd, error)
+	config     Config
+
+	cmd      *exec.Cmd
+	splitter splitter
+	clients  map[*client]struct{}
+}
+
+func New(cmdFactory func() (*exec.Cmd, error), config Config) *ManagerCtx {
+	return &ManagerCtx{
+		logger:     log.With().Str("module", "broadcast").Str("submodule", "manager").Logger(),
+		cmdFactory: cmdFactory,
+		config:     config,
+
+		clients: map[*client]struct{}{},
+	}
+}
+
+// acquire waits up to limit timeout for slots of transcode.
+func (m *ManagerCtx) acquire(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, m.config.LimitTimeout)
+	defer cancel()
+
+	if err := utils.AcquireAll(ctx, m.config.Limits); err != nil {
+		m.logger.Warn().Dur("timeout", m.config.LimitTimeout).Msg("transcode limit reached")
+		return ErrTooManyTranscodes
+	}
+
+	return nil
+}
+
+// start must be called with lock and slots of transcode held, slots are
+// released when it fails.
+func (m *ManagerCtx) start() error {
+	if m.cmd != nil {
+		utils.ReleaseAll(m.config.Limits)
+		return errors.New("has already started")
+	}
+
+	m.logger.Debug().Msg("performing start")
+
+	cmd, err := m.cmdFactory()
+	if err != nil {
+		utils.ReleaseAll(m.config.Limits)
+		return err
+	}
+
+	read, write := io.Pipe()
+	cmd.Stdout = write
+	cmd.Stderr = utils.LogWriter(m.logger)
+	if m.config.CmdLog != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, m.config.CmdLog)
+	}
+
+	//create a new process group
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: !m.config.SingleProcess}
+
+	if err := cmd.Start(); err != nil {
+		read.Close()
+		write.Close()
+		utils.ReleaseAll(m.config.Limits)
+		m.exited(cmd)
+		return err
+	}
+
+	if err := m.config.ProcessLimits.Apply(cmd.Process.Pid); err != nil {
+		m.logger.Warn().Err(err).Msg("unable to apply process limits")
+	}
+
+	m.cmd = cmd
+	m.splitter = newSplitter(m.config.Format)
+
+	go func() {
+		err := cmd.Wait()
+		m.logger.Info().Err(err).Msg("cmd exited")
+		m.exited(cmd)
+		write.Close()
+	}()
+
+	go func() {
+		defer read.Close()
+
+		buf := make([]byte, readSize)
+		for {
+			n, err := read.Read(buf)
+			if n > 0 {
+				m.broadcast(cmd, buf[:n])
+			}
+
+			if err != nil {
+				m.logger.Debug().Err(err).Msg("cmd output closed")
+				m.finished(cmd)
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// exited calls exit hook of cmd, that exited or failed to start.
+func (m *ManagerCtx) exited(cmd *exec.Cmd) {
+	if m.config.Exited != nil {
+		m.config.Exited(cmd)
+	}
+}
+
+// broadcast sends output of cmd to its clients, clients join at random
+// access units only, so that every client receives clean stream start.
+func (m *ManagerCtx) broadcast(cmd *exec.Cmd, p []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd != cmd {
+		return
+	}
+
+	for _, unit := range m.splitter.split(p) {
+		for c := range m.clients {
+			if !c.joined {
+				header := m.splitter.header()
+				if !unit.random || header == nil {
+					continue
+				}
+
+				if !m.send(c, header) {
+					continue
+				}
+				c.joined = true
+			}
+
+			m.send(c, unit.data)
+		}
+	}
+
+	if len(m.clients) == 0 {
+		m.stop()
+	}
+}
+
+// send queues data for client, slow clients are dropped. Must be called
+// with lock held.
+func (m *ManagerCtx) send(c *client, data []byte) bool {
+	select {
+	case c.data <- data:
+		return true
+	default:
+		m.logger.Warn().Msg("client is too slow, dropping")
+		m.drop(c)
+		return false
+	}
+}
+
+// drop must be called with lock held.
+func (m *ManagerCtx) drop(c *client) {
+	if _, ok := m.clients[c]; !ok {
+		return
+	}
+
+	delete(m.clients, c)
+	close(c.data)
+}
+
+// finished disconnects all clients of exited cmd.
+func (m *ManagerCtx) finished(cmd *exec.Cmd) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd != cmd {
+		return
+	}
+
+	for c := range m.clients {
+		m.drop(c)
+	}
+
+	m.cmd = nil
+	utils.ReleaseAll(m.config.Limits)
+}
+
+// stop must be called with lock held.
+func (m *ManagerCtx) stop() {
+	if m.cmd == nil {
+		return
+	}
+
+	m.logger.Debug().Msg("performing stop")
+
+	for c := range m.clients {
+		m.drop(c)
+	}
+
+	if m.cmd.Process != nil && m.config.SingleProcess {
+		err := m.cmd.Process.Kill()
+		m.logger.Err(err).Msg("killing proccess")
+	} else if m.cmd.Process != nil {
+		pgid, err := syscall.Getpgid(m.cmd.Process.Pid)
+		if err == nil {
+			err := syscall.Kill(-pgid, syscall.SIGKILL)
+			m.logger.Err(err).Msg("killing proccess group")
+		} else {
+			m.logger.Err(err).Msg("could not get proccess group id")
+			err := m.cmd.Process.Kill()
+			m.logger.Err(err).Msg("killing proccess")
+		}
+	}
+
+	m.cmd = nil
+	utils.ReleaseAll(m.config.Limits)
+}
+
+func (m *ManagerCtx) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stop()
+}
+
+// Clients returns count of connected clients.
+func (m *ManagerCtx) Clients() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.clients)
+}
+
+// Pid returns ffmpeg process id, or zero when not running.
+func (m *ManagerCtx) Pid() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil || m.cmd.Process == nil {
+		return 0
+	}
+
+	return m.cmd.Process.Pid
+}
+
+// subscribe adds new client, transcode is started for first one. Slots of
+// transcode are awaited without lock held, so that clients joining
+// running transcode are not blocked.
+func (m *ManagerCtx) subscribe(ctx context.Context) (*client, error) {
+	m.mu.Lock()
+	running := m.cmd != nil
+	m.mu.Unlock()
+
+	if !running {
+		if err := m.acquire(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case m.cmd == nil && running:
+		// stopped meanwhile
+		if err := utils.TryAcquireAll(m.config.Limits); err != nil {
+			m.logger.Warn().Msg("transcode limit reached")
+			return nil, ErrTooManyTranscodes
+		}
+		fallthrough
+	case m.cmd == nil:
+		if err := m.start(); err != nil {
+			return nil, err
+		}
+	case !running:
+		// started concurrently
+		utils.ReleaseAll(m.config.Limits)
+	}
+
+	c := &client{
+		data: make(chan []byte, clientBuffer),
+	}
+
+	m.clients[c] = struct{}{}
+	return c, nil
+}
+
+// unsubscribe removes client, transcode is stopped with last one.
+func (m *ManagerCtx) unsubscribe(c *client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.drop(c)
+
+	if len(m.clients) == 0 {
+		m.stop()
+	}
+}
+
+func (m *ManagerCtx) ServeStream(w http.ResponseWriter, r *http.Request) {
+	c, err := m.subscribe(r.Context())
+	if errors.Is(err, ErrTooManyTranscodes) {
+		w.Header().Set("Retry-After", m.config.RetryAfter)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 too many transcodes"))
+		return
+	}
+
+	if err != nil {
+		m.logger.Warn().Err(err).Msg("transcode could not be started")
+
+		status := http.StatusInternalServerError
+		if m.config.ErrorStatus != nil {
+			status = m.config.ErrorStatus(err)
+		}
+
+		w.WriteHeader(status)
+		w.Write([]byte(fmt.Sprintf("%d transcode could not be started", status)))
+		return
+	}
+
+	defer m.unsubscribe(c)
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data, ok := <-c.data:
+			if !ok {
+				return
+			}
+
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
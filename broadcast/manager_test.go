@@ -0,0 +1,215 @@
+package broadcast
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// waitFor polls condition until it holds or timeout passes.
+func waitFor(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return condition()
+}
+
+func TestManagerShared(t *testing.T) {
+	var starts int32
+	// output is broadcast by test itself
+	m := New(func() (*exec.Cmd, error) {
+		atomic.AddInt32(&starts, 1)
+		return exec.Command("sh", "-c", "exec sleep 30"), nil
+	}, Config{Format: FormatMP4})
+	defer m.Stop()
+
+	fast, err := m.subscribe(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	slow, err := m.subscribe(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if n := atomic.LoadInt32(&starts); n != 1 || m.Clients() != 2 {
+		t.Fatalf("got %d starts for %d clients, want one shared", n, m.Clients())
+	}
+
+	m.mu.Lock()
+	cmd := m.cmd
+	m.mu.Unlock()
+	pid := cmd.Process.Pid
+
+	header := append(testBox("ftyp", []byte("iso5")), testBox("moov", nil)...)
+	fragment := append(testBox("moof", nil), testBox("mdat", make([]byte, 10))...)
+
+	received := make(chan int)
+	go func() {
+		n := 0
+		for data := range fast.data {
+			n += len(data)
+		}
+		received <- n
+	}()
+
+	// slow client never reads, its buffer overflows
+	m.broadcast(cmd, header)
+	for i := 0; i < clientBuffer; i++ {
+		m.broadcast(cmd, fragment)
+
+		// fast one keeps up
+		if i%32 == 0 {
+			waitFor(time.Second, func() bool { return len(fast.data) == 0 })
+		}
+	}
+
+	if !waitFor(time.Second, func() bool { return m.Clients() == 1 }) {
+		t.Fatalf("got %d clients, want slow one dropped", m.Clients())
+	}
+	m.mu.Lock()
+	_, ok := m.clients[fast]
+	m.mu.Unlock()
+	if !ok {
+		t.Error("fast client dropped instead of slow one")
+	}
+
+	// fast one keeps receiving
+	m.broadcast(cmd, fragment)
+
+	// last client leaving stops transcode
+	m.unsubscribe(fast)
+	if n, want := <-received, len(header)+(clientBuffer+1)*len(fragment); n != want {
+		t.Errorf("fast client received %d bytes, want %d", n, want)
+	}
+
+	if m.Pid() != 0 {
+		t.Error("transcode kept running without clients")
+	}
+	if !waitFor(time.Second, func() bool { return syscall.Kill(pid, 0) != nil }) {
+		t.Error("transcode process not exited")
+	}
+
+	drained := [][]byte{}
+	for data := range slow.data {
+		drained = append(drained, data)
+	}
+	if len(drained) != clientBuffer || !bytes.Equal(drained[0], header) {
+		t.Errorf("slow client got %d queued units, want %d starting with header", len(drained), clientBuffer)
+	}
+}
+
+func TestManagerLimitTimeout(t *testing.T) {
+	limit := utils.NewSemaphore(1)
+	if err := limit.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(func() (*exec.Cmd, error) {
+		return exec.Command("sh", "-c", "exec sleep 30"), nil
+	}, Config{Format: FormatMPEGTS, Limits: []*utils.Semaphore{limit}, LimitTimeout: 50 * time.Millisecond})
+	defer m.Stop()
+
+	if _, err := m.subscribe(context.Background()); !errors.Is(err, ErrTooManyTranscodes) {
+		t.Fatalf("got error %v, want %v", err, ErrTooManyTranscodes)
+	}
+
+	// slot freed while client is queued
+	m.config.LimitTimeout = time.Second
+	time.AfterFunc(50*time.Millisecond, limit.Release)
+
+	c, err := m.subscribe(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.unsubscribe(c)
+
+	// slot is released with transcode
+	if err := utils.TryAcquireAll([]*utils.Semaphore{limit}); err != nil {
+		t.Errorf("slot held after transcode stopped: %v", err)
+	}
+}
+
+func TestManagerErrorStatus(t *testing.T) {
+	errNotFound := errors.New("stream not found")
+
+	for _, tt := range []struct {
+		name   string
+		status func(err error) int
+		want   int
+	}{
+		{"default", nil, http.StatusInternalServerError},
+		{"mapped", func(err error) int {
+			if errors.Is(err, errNotFound) {
+				return http.StatusNotFound
+			}
+			return http.StatusInternalServerError
+		}, http.StatusNotFound},
+	} {
+		m := New(func() (*exec.Cmd, error) {
+			return nil, errNotFound
+		}, Config{Format: FormatMPEGTS, ErrorStatus: tt.status})
+
+		rec := httptest.NewRecorder()
+		m.ServeStream(rec, httptest.NewRequest(http.MethodGet, "/h264_720p/cam", nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestManagerExited(t *testing.T) {
+	exited := make(chan *exec.Cmd, 1)
+	config := Config{Format: FormatMPEGTS, Exited: func(cmd *exec.Cmd) {
+		exited <- cmd
+	}}
+
+	var started *exec.Cmd
+	m := New(func() (*exec.Cmd, error) {
+		started = exec.Command("sh", "-c", "exit 0")
+		return started, nil
+	}, config)
+	defer m.Stop()
+
+	c, err := m.subscribe(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer m.unsubscribe(c)
+
+	select {
+	case cmd := <-exited:
+		if cmd != started {
+			t.Error("exit hook called for other cmd")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("exit hook not called once cmd exited")
+	}
+
+	// not started at all
+	m = New(func() (*exec.Cmd, error) {
+		return exec.Command("/nonexistent"), nil
+	}, config)
+
+	if _, err := m.subscribe(context.Background()); err == nil {
+		t.Fatal("expected start to fail")
+	}
+	select {
+	case <-exited:
+	default:
+		t.Error("exit hook not called once cmd failed to start")
+	}
+}
@@ -0,0 +1,215 @@
+package broadcast
+
+import (
+	"encoding/binary"
+)
+
+// unit of output, that can be sent to clients as whole
+type unit struct {
+	data []byte
+	// clients can join at random access unit, after receiving header
+	random bool
+}
+
+// splitter splits output into units and keeps header, that must client
+// receive before its first unit.
+type splitter interface {
+	split(p []byte) []unit
+	header() []byte
+}
+
+func newSplitter(format string) splitter {
+	if format == FormatMP4 {
+		return &mp4Splitter{}
+	}
+	return &tsSplitter{pmtPID: -1}
+}
+
+const tsPacketSize = 188
+
+// tsSplitter splits mpeg-ts into packets, random access units start at
+// packets with random access indicator (keyframes). Header consists of
+// latest PAT and PMT packets.
+type tsSplitter struct {
+	buf    []byte
+	pat    []byte
+	pmt    []byte
+	pmtPID int
+}
+
+func (s *tsSplitter) split(p []byte) []unit {
+	s.buf = append(s.buf, p...)
+
+	units := []unit{}
+	var current *unit
+
+	for len(s.buf) >= tsPacketSize {
+		// resynchronize on sync byte
+		if s.buf[0] != 0x47 {
+			s.buf = s.buf[1:]
+			continue
+		}
+
+		packet := append([]byte{}, s.buf[:tsPacketSize]...)
+		s.buf = s.buf[tsPacketSize:]
+
+		s.track(packet)
+
+		if tsRandomAccess(packet) || current == nil {
+			units = append(units, unit{random: tsRandomAccess(packet)})
+			current = &units[len(units)-1]
+		}
+		current.data = append(current.data, packet...)
+	}
+
+	// keep memory of leftover bounded
+	s.buf = append([]byte{}, s.buf...)
+	return units
+}
+
+// track remembers PAT and PMT packets starting new sections.
+func (s *tsSplitter) track(packet []byte) {
+	pid := int(packet[1]&0x1f)<<8 | int(packet[2])
+	if packet[1]&0x40 == 0 {
+		return
+	}
+
+	switch pid {
+	case 0:
+		s.pat = packet
+		if pmtPID, ok := tsPMTPID(packet); ok {
+			s.pmtPID = pmtPID
+		}
+	case s.pmtPID:
+		s.pmt = packet
+	}
+}
+
+func (s *tsSplitter) header() []byte {
+	if s.pat == nil || s.pmt == nil {
+		return nil
+	}
+	return append(append([]byte{}, s.pat...), s.pmt...)
+}
+
+// tsPayload returns offset of packet payload, or -1 if it has none.
+func tsPayload(packet []byte) int {
+	control := packet[3] >> 4 & 0x3
+	if control&0x1 == 0 {
+		return -1
+	}
+
+	offset := 4
+	if control&0x2 != 0 {
+		offset += 1 + int(packet[4])
+	}
+
+	if offset >= tsPacketSize {
+		return -1
+	}
+	return offset
+}
+
+func tsRandomAccess(packet []byte) bool {
+	control := packet[3] >> 4 & 0x3
+	return control&0x2 != 0 && packet[4] > 0 && packet[5]&0x40 != 0
+}
+
+// tsPMTPID returns pid of first program in PAT packet.
+func tsPMTPID(packet []byte) (int, bool) {
+	offset := tsPayload(packet)
+	if offset < 0 {
+		return 0, false
+	}
+
+	// pointer field precedes section
+	section := offset + 1 + int(packet[offset])
+	if section+8 > tsPacketSize {
+		return 0, false
+	}
+
+	length := int(packet[section+1]&0x0f)<<8 | int(packet[section+2])
+	end := section + 3 + length - 4
+	if end > tsPacketSize {
+		end = tsPacketSize
+	}
+
+	for i := section + 8; i+4 <= end; i += 4 {
+		program := int(packet[i])<<8 | int(packet[i+1])
+		if program != 0 {
+			return int(packet[i+2]&0x1f)<<8 | int(packet[i+3]), true
+		}
+	}
+
+	return 0, false
+}
+
+// mp4Splitter splits fragmented mp4 into boxes, random access units
+// start at fragments (moof). Header consists of boxes preceding first
+// fragment, i.e. ftyp and moov.
+type mp4Splitter struct {
+	buf  []byte
+	head []byte
+	// first fragment was seen, header is complete
+	fragmented bool
+}
+
+func (s *mp4Splitter) split(p []byte) []unit {
+	s.buf = append(s.buf, p...)
+
+	units := []unit{}
+	for {
+		size, ok := mp4BoxSize(s.buf)
+		if !ok || len(s.buf) < size {
+			break
+		}
+
+		box := append([]byte{}, s.buf[:size]...)
+		s.buf = s.buf[size:]
+
+		moof := string(box[4:8]) == "moof"
+		if moof {
+			s.fragmented = true
+		}
+
+		if !s.fragmented {
+			s.head = append(s.head, box...)
+			continue
+		}
+
+		units = append(units, unit{data: box, random: moof})
+	}
+
+	s.buf = append([]byte{}, s.buf...)
+	return units
+}
+
+func (s *mp4Splitter) header() []byte {
+	if !s.fragmented {
+		return nil
+	}
+	return s.head
+}
+
+// mp4BoxSize returns size of box at beginning of data, if its header
+// is complete.
+func mp4BoxSize(data []byte) (int, bool) {
+	if len(data) < 8 {
+		return 0, false
+	}
+
+	size := uint64(binary.BigEndian.Uint32(data[:4]))
+	if size == 1 {
+		if len(data) < 16 {
+			return 0, false
+		}
+		size = binary.BigEndian.Uint64(data[8:16])
+	}
+
+	// boxes are never extended up to end of stream
+	if size < 8 {
+		size = 8
+	}
+
+	return int(size), true
+}
@@ -0,0 +1,110 @@
+package broadcast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func testBox(kind string, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	b = append(b, kind...)
+	return append(b, payload...)
+}
+
+// testPacket renders mpeg-ts packet of pid, starting section or with
+// random access indicator.
+func testPacket(pid int, start bool, random bool) []byte {
+	packet := make([]byte, tsPacketSize)
+	packet[0] = 0x47
+	packet[1] = byte(pid >> 8 & 0x1f)
+	if start {
+		packet[1] |= 0x40
+	}
+	packet[2] = byte(pid)
+	// payload only
+	packet[3] = 0x10
+
+	if random {
+		// adaptation field with random access indicator
+		packet[3] = 0x30
+		packet[4] = 1
+		packet[5] = 0x40
+	}
+	return packet
+}
+
+// testPAT renders PAT packet of single program with given PMT pid.
+func testPAT(pmtPID int) []byte {
+	packet := testPacket(0, true, false)
+	// pointer field, table id, section length
+	copy(packet[4:], []byte{0, 0, 0xb0, 13, 0, 1, 0xc1, 0, 0})
+	// program 1
+	copy(packet[13:], []byte{0, 1, byte(0xe0 | pmtPID>>8), byte(pmtPID)})
+	return packet
+}
+
+func TestTSSplitter(t *testing.T) {
+	s := newSplitter(FormatMPEGTS)
+
+	pat, pmt := testPAT(0x1000), testPacket(0x1000, true, false)
+	keyframe, frame := testPacket(0x100, true, true), testPacket(0x100, false, false)
+
+	var stream []byte
+	for _, p := range [][]byte{frame, pat, pmt, keyframe, frame, frame, keyframe} {
+		stream = append(stream, p...)
+	}
+
+	// split across writes, with garbage before sync byte
+	units := s.split(append([]byte{0x00, 0x01}, stream[:300]...))
+	units = append(units, s.split(stream[300:])...)
+
+	// every write starts new unit, further ones start at keyframes
+	want := []unit{
+		{data: frame, random: false},
+		{data: bytes.Join([][]byte{pat, pmt}, nil), random: false},
+		{data: bytes.Join([][]byte{keyframe, frame, frame}, nil), random: true},
+		{data: keyframe, random: true},
+	}
+
+	if len(units) != len(want) {
+		t.Fatalf("got %d units, want %d", len(units), len(want))
+	}
+	for i := range want {
+		if units[i].random != want[i].random || !bytes.Equal(units[i].data, want[i].data) {
+			t.Errorf("unit %d: got %d bytes random %v, want %d bytes random %v", i, len(units[i].data), units[i].random, len(want[i].data), want[i].random)
+		}
+	}
+
+	if !bytes.Equal(s.header(), append(append([]byte{}, pat...), pmt...)) {
+		t.Error("header is not PAT followed by PMT")
+	}
+}
+
+func TestMP4Splitter(t *testing.T) {
+	s := newSplitter(FormatMP4)
+
+	ftyp, moov := testBox("ftyp", []byte("iso5")), testBox("moov", make([]byte, 20))
+	moof, mdat := testBox("moof", make([]byte, 10)), testBox("mdat", make([]byte, 100))
+
+	units := s.split(append(ftyp, moov[:10]...))
+	if len(units) != 0 || s.header() != nil {
+		t.Fatal("got units before first fragment")
+	}
+
+	units = s.split(bytes.Join([][]byte{moov[10:], moof, mdat, moof[:4]}, nil))
+	want := []unit{{data: moof, random: true}, {data: mdat, random: false}}
+
+	if len(units) != len(want) {
+		t.Fatalf("got %d units, want %d", len(units), len(want))
+	}
+	for i := range want {
+		if units[i].random != want[i].random || !bytes.Equal(units[i].data, want[i].data) {
+			t.Errorf("unit %d: got %q random %v", i, units[i].data[4:8], units[i].random)
+		}
+	}
+
+	if !bytes.Equal(s.header(), append(append([]byte{}, ftyp...), moov...)) {
+		t.Error("header is not ftyp followed by moov")
+	}
+}
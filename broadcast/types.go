@@ -0,0 +1,17 @@
+package broadcast
+
+import "net/http"
+
+// output formats that can be shared among clients
+const (
+	FormatMPEGTS = "mpegts"
+	FormatMP4    = "mp4"
+)
+
+type Manager interface {
+	Stop()
+	Clients() int
+	Pid() int
+
+	ServeStream(w http.ResponseWriter, r *http.Request)
+}
@@ -18,6 +18,10 @@ func init() {
 
 	configs := []config.Config{
 		transcode.Service.ServerConfig,
+		transcode.Service.HLSConfig,
+		transcode.Service.ThumbnailsConfig,
+		transcode.Service.AnalyticsConfig,
+		transcode.Service.WebhooksConfig,
 	}
 
 	cobra.OnInitialize(func() {
@@ -34,4 +38,15 @@ func init() {
 	}
 
 	root.AddCommand(command)
+
+	// validates config of serve command, using its flags
+	validate := &cobra.Command{
+		Use:   "validate",
+		Short: "validate config of transcode server",
+		Long:  `validate streams config, profiles, required commands and listeners, without starting server`,
+		Run:   transcode.Service.ValidateCommand,
+	}
+	validate.Flags().AddFlagSet(command.PersistentFlags())
+
+	root.AddCommand(validate)
 }
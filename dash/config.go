@@ -0,0 +1,32 @@
+package dash
+
+import (
+	"io"
+	"os/exec"
+
+	"github.com/m1k1o/go-transcode/drm"
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+type Config struct {
+	// receives transcode output besides logger, e.g. log of stream
+	CmdLog io.Writer
+
+	// parent of tempdir of manifest and segments, default of os when empty
+	TempRoot string
+
+	// signal only transcode process instead of its whole process group
+	SingleProcess bool
+	// nice level, cpu affinity and cgroup limits of transcode process
+	ProcessLimits utils.ProcessLimits
+	// slots held while transcode runs, start fails once any is full
+	Limits []*utils.Semaphore
+	// Retry-After of responses refused because of transcode limits
+	RetryAfter string
+	// called once cmd returned by factory exits or fails to start, e.g.
+	// releasing its hardware encoder session
+	Exited func(cmd *exec.Cmd)
+
+	// content protection signaled in manifests
+	ContentProtection []drm.System
+}
@@ -0,0 +1,35 @@
+package dash
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/m1k1o/go-transcode/drm"
+)
+
+// namespace of license and certificate urls in ContentProtection elements
+const dashifNamespace = `xmlns:dashif="https://dashif.org/CPS"`
+
+var (
+	mpdRegex           = regexp.MustCompile(`<MPD\b`)
+	adaptationSetRegex = regexp.MustCompile(`<AdaptationSet\b[^>]*[^/]>`)
+)
+
+// manifestContentProtection inserts ContentProtection elements of systems
+// into every adaptation set of manifest written by profile.
+func manifestContentProtection(manifest string, systems []drm.System) string {
+	elements := drm.DASHContentProtection(systems)
+	if elements == "" {
+		return manifest
+	}
+
+	if !strings.Contains(manifest, "xmlns:dashif=") {
+		manifest = mpdRegex.ReplaceAllStringFunc(manifest, func(tag string) string {
+			return tag + " " + dashifNamespace
+		})
+	}
+
+	return adaptationSetRegex.ReplaceAllStringFunc(manifest, func(tag string) string {
+		return tag + elements
+	})
+}
@@ -0,0 +1,48 @@
+package dash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/m1k1o/go-transcode/drm"
+)
+
+func TestManifestContentProtection(t *testing.T) {
+	manifest := `<?xml version="1.0" encoding="utf-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="dynamic">
+<Period id="0">
+<AdaptationSet id="0" contentType="video"><Representation id="0"/></AdaptationSet>
+<AdaptationSet id="1" contentType="audio"><Representation id="1"/></AdaptationSet>
+</Period>
+</MPD>`
+
+	systems := []drm.System{
+		{SystemID: drm.Widevine, LicenseURL: "https://license.example.com/widevine"},
+		{SystemID: drm.FairPlay, LicenseURL: "https://license.example.com/fairplay", CertificateURL: "https://license.example.com/fairplay.cer"},
+	}
+
+	got := manifestContentProtection(manifest, systems)
+
+	if !strings.Contains(got, `<MPD xmlns:dashif="https://dashif.org/CPS" xmlns="urn:mpeg:dash:schema:mpd:2011"`) {
+		t.Errorf("dashif namespace missing in manifest:\n%s", got)
+	}
+
+	elements := drm.DASHContentProtection(systems)
+	if n := strings.Count(got, elements); n != 2 {
+		t.Errorf("got content protection in %d adaptation sets, want 2:\n%s", n, got)
+	}
+
+	for _, want := range []string{
+		`<AdaptationSet id="0" contentType="video"><ContentProtection schemeIdUri="urn:mpeg:dash:mp4protection:2011" value="cenc"/>`,
+		`<ContentProtection schemeIdUri="urn:uuid:` + drm.Widevine + `"><dashif:Laurl>https://license.example.com/widevine</dashif:Laurl></ContentProtection>`,
+		`<dashif:Certurl>https://license.example.com/fairplay.cer</dashif:Certurl>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q missing in manifest:\n%s", want, got)
+		}
+	}
+
+	if got := manifestContentProtection(manifest, nil); got != manifest {
+		t.Errorf("manifest changed without content protection:\n%s", got)
+	}
+}
@@ -0,0 +1,385 @@
+package dash
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// how often should be cleanup called
+const cleanupPeriod = 4 * time.Second
+
+// timeout for first manifest to be written
+const manifestTimeout = 20 * time.Second
+
+// how often is tempdir checked for first manifest
+const manifestPeriod = 100 * time.Millisecond
+
+// how long must be active stream idle to be considered as dead
+const activeIdleTimeout = 12 * time.Second
+
+// how long must be inactive stream idle to be considered as dead
+const inactiveIdleTimeout = 24 * time.Second
+
+// manifest written by profiles into their working directory
+const manifestName = "index.mpd"
+
+var mediaRegex = regexp.MustCompile(`^[0-9A-Za-z_-]+\.m4s$`)
+
+var ErrAlreadyStarted = errors.New("has already started")
+
+// limit of concurrent transcodes is reached
+var ErrTooManyTranscodes = errors.New("too many transcodes")
+
+type ManagerCtx struct {
+	logger     zerolog.Logger
+	mu         sync.Mutex
+	cmdFactory func() (*exec.Cmd, error)
+	config     Config
+	active     bool
+
+	cmd         *exec.Cmd
+	tempdir     string
+	lastRequest time.Time
+
+	shutdown chan interface{}
+}
+
+func New(cmdFactory func() (*exec.Cmd, error), config Config) *ManagerCtx {
+	return &ManagerCtx{
+		logger:     log.With().Str("module", "dash").Str("submodule", "manager").Logger(),
+		cmdFactory: cmdFactory,
+		config:     config,
+
+		shutdown: make(chan interface{}),
+	}
+}
+
+func (m *ManagerCtx) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd != nil {
+		return ErrAlreadyStarted
+	}
+
+	m.logger.Debug().Msg("performing start")
+
+	if err := utils.TryAcquireAll(m.config.Limits); err != nil {
+		m.logger.Warn().Msg("transcode limit reached")
+		return ErrTooManyTranscodes
+	}
+
+	started := false
+	defer func() {
+		if !started {
+			utils.ReleaseAll(m.config.Limits)
+		}
+	}()
+
+	cmd, err := m.cmdFactory()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if !started {
+			m.exited(cmd)
+		}
+	}()
+
+	if m.config.TempRoot != "" {
+		if err := os.MkdirAll(m.config.TempRoot, 0755); err != nil {
+			return err
+		}
+	}
+
+	tempdir, err := os.MkdirTemp(m.config.TempRoot, "go-transcode-dash")
+	if err != nil {
+		return err
+	}
+
+	cmd.Dir = tempdir
+	cmd.Stderr = utils.LogWriter(m.logger)
+	if m.config.CmdLog != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, m.config.CmdLog)
+	}
+
+	//create a new process group
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: !m.config.SingleProcess}
+
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(tempdir)
+		return err
+	}
+
+	if err := m.config.ProcessLimits.Apply(cmd.Process.Pid); err != nil {
+		m.logger.Warn().Err(err).Msg("unable to apply process limits")
+	}
+
+	started = true
+	m.cmd = cmd
+	m.tempdir = tempdir
+	m.active = false
+	m.lastRequest = time.Now()
+	m.shutdown = make(chan interface{})
+
+	go func() {
+		err := cmd.Wait()
+		m.logger.Info().Err(err).Msg("cmd exited")
+		m.exited(cmd)
+
+		m.mu.Lock()
+		exited := m.cmd == cmd
+		m.mu.Unlock()
+
+		// clean up after process, that exited by itself
+		if exited {
+			m.Stop()
+		}
+	}()
+
+	shutdown := m.shutdown
+	go func() {
+		ticker := time.NewTicker(cleanupPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-shutdown:
+				return
+			case <-ticker.C:
+				m.Cleanup()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// exited calls exit hook of cmd, that exited or failed to start.
+func (m *ManagerCtx) exited(cmd *exec.Cmd) {
+	if m.config.Exited != nil {
+		m.config.Exited(cmd)
+	}
+}
+
+// Shutdown stops transcode and removes its tempdir right away, instead of
+// after delay. Manager must not be used afterwards.
+func (m *ManagerCtx) Shutdown() {
+	m.mu.Lock()
+	tempdir := m.tempdir
+	m.mu.Unlock()
+
+	m.Stop()
+
+	// also tempdir of previous run, whose removal is pending
+	if tempdir != "" {
+		err := os.RemoveAll(tempdir)
+		m.logger.Err(err).Msg("removing tempdir")
+	}
+}
+
+func (m *ManagerCtx) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil {
+		return
+	}
+
+	m.logger.Debug().Msg("performing stop")
+	close(m.shutdown)
+
+	if m.cmd.Process != nil && m.config.SingleProcess {
+		err := m.cmd.Process.Kill()
+		m.logger.Err(err).Msg("killing proccess")
+	} else if m.cmd.Process != nil {
+		pgid, err := syscall.Getpgid(m.cmd.Process.Pid)
+		if err == nil {
+			err := syscall.Kill(-pgid, syscall.SIGKILL)
+			m.logger.Err(err).Msg("killing proccess group")
+		} else {
+			m.logger.Err(err).Msg("could not get proccess group id")
+			err := m.cmd.Process.Kill()
+			m.logger.Err(err).Msg("killing proccess")
+		}
+	}
+
+	m.cmd = nil
+	m.active = false
+	utils.ReleaseAll(m.config.Limits)
+
+	tempdir := m.tempdir
+	time.AfterFunc(2*time.Second, func() {
+		err := os.RemoveAll(tempdir)
+		m.logger.Err(err).Msg("removing tempdir")
+	})
+}
+
+func (m *ManagerCtx) Cleanup() {
+	m.mu.Lock()
+	diff := time.Since(m.lastRequest)
+	stop := m.active && diff > activeIdleTimeout || !m.active && diff > inactiveIdleTimeout
+	m.mu.Unlock()
+
+	m.logger.Debug().
+		Dur("diff", diff).
+		Bool("stop", stop).
+		Msg("performing cleanup")
+
+	if stop {
+		m.Stop()
+	}
+}
+
+// Active reports whether stream has written its manifest.
+func (m *ManagerCtx) Active() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.cmd != nil && m.active
+}
+
+// Pid returns transcode process id, or zero when not running.
+func (m *ManagerCtx) Pid() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil || m.cmd.Process == nil {
+		return 0
+	}
+
+	return m.cmd.Process.Pid
+}
+
+// waitForManifest waits until first manifest is written.
+func (m *ManagerCtx) waitForManifest(r *http.Request) bool {
+	timeout := time.NewTimer(manifestTimeout)
+	defer timeout.Stop()
+
+	ticker := time.NewTicker(manifestPeriod)
+	defer ticker.Stop()
+
+	for {
+		m.mu.Lock()
+		running, tempdir := m.cmd != nil, m.tempdir
+		m.mu.Unlock()
+
+		if !running {
+			return false
+		}
+
+		if _, err := os.Stat(path.Join(tempdir, manifestName)); err == nil {
+			m.mu.Lock()
+			m.active = true
+			m.mu.Unlock()
+			return true
+		}
+
+		select {
+		case <-r.Context().Done():
+			return false
+		case <-timeout.C:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *ManagerCtx) ServeManifest(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.lastRequest = time.Now()
+	running, active := m.cmd != nil, m.active
+	m.mu.Unlock()
+
+	if !running {
+		err := m.Start()
+		if errors.Is(err, ErrTooManyTranscodes) {
+			w.Header().Set("Retry-After", m.config.RetryAfter)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("503 too many transcodes"))
+			return
+		}
+
+		if err != nil && !errors.Is(err, ErrAlreadyStarted) {
+			m.logger.Warn().Err(err).Msg("transcode could not be started")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 transcode could not be started"))
+			return
+		}
+	}
+
+	if !active && !m.waitForManifest(r) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 manifest not available"))
+		return
+	}
+
+	m.mu.Lock()
+	path := path.Join(m.tempdir, manifestName)
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/dash+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if len(m.config.ContentProtection) == 0 {
+		http.ServeFile(w, r, path)
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		m.logger.Warn().Err(err).Msg("manifest could not be read")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 manifest not available"))
+		return
+	}
+
+	manifest := manifestContentProtection(string(data), m.config.ContentProtection)
+	w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write([]byte(manifest))
+}
+
+func (m *ManagerCtx) ServeMedia(w http.ResponseWriter, r *http.Request) {
+	fileName := path.Base(r.URL.Path)
+	if !mediaRegex.MatchString(fileName) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 invalid media"))
+		return
+	}
+
+	m.mu.Lock()
+	m.lastRequest = time.Now()
+	running, path := m.cmd != nil, path.Join(m.tempdir, fileName)
+	m.mu.Unlock()
+
+	if _, err := os.Stat(path); !running || os.IsNotExist(err) {
+		m.logger.Warn().Str("path", path).Msg("media file not found")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 media not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/iso.segment")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,15 @@
+package dash
+
+import "net/http"
+
+type Manager interface {
+	Start() error
+	Stop()
+	Shutdown()
+	Cleanup()
+	Active() bool
+	Pid() int
+
+	ServeManifest(w http.ResponseWriter, r *http.Request)
+	ServeMedia(w http.ResponseWriter, r *http.Request)
+}
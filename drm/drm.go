@@ -0,0 +1,101 @@
+package drm
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// well known DRM system ids
+const (
+	Widevine  = "edef8ba9-79d6-4ace-a3c8-27dcd51d21ed"
+	PlayReady = "9a04f079-9840-4286-ab92-e65be0885f95"
+	FairPlay  = "94ce86fb-07ff-4f43-adb8-93d2fa968ca2"
+)
+
+// session data id of FairPlay certificate url in master playlists
+const fairPlayCertificateDataID = "com.apple.streamingkeydelivery.certificate"
+
+var systemIDRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// System describes content protection signaled in manifests. Server does
+// not handle any keys, it only points players to license servers.
+type System struct {
+	SystemID       string `mapstructure:"system_id"`
+	LicenseURL     string `mapstructure:"license_url"`
+	CertificateURL string `mapstructure:"certificate_url"`
+	// HLS key uri, e.g. skd:// uri for FairPlay; defaults to license url
+	KeyURI string `mapstructure:"key_uri"`
+}
+
+func (s *System) Validate() error {
+	s.SystemID = strings.ToLower(s.SystemID)
+	if !systemIDRegex.MatchString(s.SystemID) {
+		return fmt.Errorf("invalid drm system id %q", s.SystemID)
+	}
+
+	if _, err := url.ParseRequestURI(s.LicenseURL); err != nil {
+		return fmt.Errorf("invalid drm license url: %w", err)
+	}
+
+	if s.CertificateURL != "" {
+		if _, err := url.ParseRequestURI(s.CertificateURL); err != nil {
+			return fmt.Errorf("invalid drm certificate url: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *System) keyFormat() string {
+	if s.SystemID == FairPlay {
+		return "com.apple.streamingkeydelivery"
+	}
+
+	return "urn:uuid:" + s.SystemID
+}
+
+// HLSSessionTags returns EXT-X-SESSION-KEY tags of master playlists,
+// followed by FairPlay certificate url as EXT-X-SESSION-DATA when set.
+// Media playlists do not carry them, their segments are not encrypted
+// by server.
+func HLSSessionTags(systems []System) []string {
+	tags := []string{}
+	for _, s := range systems {
+		uri := s.KeyURI
+		if uri == "" {
+			uri = s.LicenseURL
+		}
+
+		tags = append(tags, fmt.Sprintf(`#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI="%s",KEYFORMAT="%s",KEYFORMATVERSIONS="1"`, uri, s.keyFormat()))
+
+		if s.SystemID == FairPlay && s.CertificateURL != "" {
+			tags = append(tags, fmt.Sprintf(`#EXT-X-SESSION-DATA:DATA-ID="%s",URI="%s"`, fairPlayCertificateDataID, s.CertificateURL))
+		}
+	}
+	return tags
+}
+
+// DASHContentProtection returns ContentProtection elements for MPD
+// adaptation sets, preceded by common encryption scheme signaling.
+func DASHContentProtection(systems []System) string {
+	if len(systems) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(`<ContentProtection schemeIdUri="urn:mpeg:dash:mp4protection:2011" value="cenc"/>`)
+
+	for _, s := range systems {
+		fmt.Fprintf(&b, `<ContentProtection schemeIdUri="urn:uuid:%s">`, s.SystemID)
+		fmt.Fprintf(&b, `<dashif:Laurl>%s</dashif:Laurl>`, html.EscapeString(s.LicenseURL))
+		if s.CertificateURL != "" {
+			fmt.Fprintf(&b, `<dashif:Certurl>%s</dashif:Certurl>`, html.EscapeString(s.CertificateURL))
+		}
+		b.WriteString(`</ContentProtection>`)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,162 @@
+package hls
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how often are variant playlists of abr ladder checked
+const variantPollPeriod = 500 * time.Millisecond
+
+// master playlist written by abr profiles into tempdir
+const masterPlaylistName = "master.m3u8"
+
+var variantRegex = regexp.MustCompile(`^[0-9A-Za-z_-]+\.m3u8$`)
+
+// masterVariants returns uris of variant playlists in master playlist.
+func masterVariants(master string) []string {
+	variants := []string{}
+	for _, line := range strings.Split(master, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			variants = append(variants, line)
+		}
+	}
+	return variants
+}
+
+// watchVariants follows master and variant playlists written by abr
+// profile into tempdir. Stream is active once every variant has enough
+// segments, updates of first variant are considered as playlist updates.
+func (m *ManagerCtx) watchVariants(cmd *exec.Cmd, tempdir string, shutdown <-chan interface{}, playlistLoad chan struct{}) {
+	ticker := time.NewTicker(variantPollPeriod)
+	defer ticker.Stop()
+
+	last := ""
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+
+		master, err := os.ReadFile(path.Join(tempdir, masterPlaylistName))
+		if err != nil {
+			continue
+		}
+
+		variants := masterVariants(string(master))
+		if len(variants) == 0 {
+			continue
+		}
+
+		ready, first := true, ""
+		for i, variant := range variants {
+			playlist, err := os.ReadFile(path.Join(tempdir, path.Base(variant)))
+			if err != nil || strings.Count(string(playlist), "#EXTINF") < m.config.Manager.MinimumSegments {
+				ready = false
+			}
+			if i == 0 {
+				first = string(playlist)
+			}
+		}
+
+		changed := first != last
+		last = first
+
+		m.mu.Lock()
+		if m.cmd != cmd {
+			m.mu.Unlock()
+			return
+		}
+
+		if !changed && (m.active || !ready) {
+			m.mu.Unlock()
+			continue
+		}
+
+		m.playlist = string(master)
+		m.rendered = nil
+		m.lastUpdate = time.Now()
+		m.sequence++
+		m.segments++
+
+		// activate only once all variants can be played
+		activate := !m.active && ready
+		if activate {
+			m.active = true
+			m.breaker.success()
+			m.lastError = nil
+		}
+		sequence := m.sequence
+		m.mu.Unlock()
+
+		if activate {
+			close(playlistLoad)
+		}
+
+		if m.events.onPlaylist != nil {
+			m.events.onPlaylist(sequence)
+		}
+	}
+}
+
+// ServeVariant serves variant playlist of abr ladder.
+func (m *ManagerCtx) ServeVariant(w http.ResponseWriter, r *http.Request) {
+	if !m.verifySigned(w, r) {
+		return
+	}
+
+	fileName := path.Base(r.URL.Path)
+
+	m.mu.Lock()
+	running, path := m.cmd != nil, path.Join(m.tempdir, fileName)
+	m.mu.Unlock()
+
+	if !m.config.ABR || !running || fileName == masterPlaylistName || !variantRegex.MatchString(fileName) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 playlist not found"))
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		m.logger.Warn().Str("path", path).Msg("variant playlist not found")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 playlist not found"))
+		return
+	}
+
+	playlist := string(data)
+	if m.config.URIQuery != "" {
+		playlist = playlistAppendQuery(playlist, m.config.URIQuery)
+	}
+	playlist = m.signPlaylist(playlist, r)
+
+	// availability checks do not keep stream alive
+	if r.Method != http.MethodHead {
+		m.mu.Lock()
+		m.lastRequest = time.Now()
+		m.mu.Unlock()
+
+		if m.config.Viewers != nil {
+			m.config.Viewers.touch(r)
+		}
+	}
+
+	w.Header().Set("Content-Type", m.config.mimeType(fileName))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Length", strconv.Itoa(len(playlist)))
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	m.accounted(w).Write([]byte(playlist))
+}
@@ -0,0 +1,74 @@
+package hls
+
+import (
+	"os/exec"
+	"time"
+)
+
+// restartBackoff returns delay before restart after given consecutive exits.
+func (c *Config) restartBackoff(exits int) time.Duration {
+	delay := c.RestartBackoff
+	for i := 0; i < exits && delay < c.RestartBackoffMax; i++ {
+		delay *= 2
+	}
+
+	if delay > c.RestartBackoffMax {
+		delay = c.RestartBackoffMax
+	}
+
+	return delay
+}
+
+// exited restarts transcode, that exited on its own while it is still
+// requested, after backoff with discontinuity. Otherwise it is stopped,
+// instead of serving stale playlist until idle timeout. Must be called
+// with lock held.
+func (m *ManagerCtx) exited(cmd *exec.Cmd) {
+	if m.config.RestartBackoff <= 0 {
+		return
+	}
+
+	// run lasting longer than maximum backoff is not consecutive exit
+	if time.Since(m.startedAt) > m.config.RestartBackoffMax {
+		m.exits = 0
+	}
+
+	// failed start is not restarted, unless it is already restarted run
+	// or backup source remains
+	if !m.active && m.exits == 0 && !m.backupRemains() {
+		return
+	}
+
+	if !m.config.KeepAlive && time.Since(m.lastRequest) > m.config.Manager.ActiveIdleTimeout {
+		m.logger.Info().Msg("transcode exited without viewers, stopping")
+		m.stop()
+		return
+	}
+
+	delay := m.config.restartBackoff(m.exits)
+	m.exits++
+
+	m.logger.Warn().
+		Int("exits", m.exits).
+		Dur("backoff", delay).
+		Msg("transcode exited unexpectedly, restarting")
+
+	time.AfterFunc(delay, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		// stopped or started again meanwhile
+		if m.cmd != cmd {
+			return
+		}
+
+		reason := "exited"
+		if m.failover(reason) {
+			reason = "exited, using backup source"
+		}
+
+		if err := m.restart(reason, m.audioOnly, m.fallback); err != nil {
+			m.logger.Warn().Err(err).Msg("transcode could not be restarted")
+		}
+	})
+}
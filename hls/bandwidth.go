@@ -0,0 +1,145 @@
+package hls
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// buckets of bandwidth window
+const bandwidthBuckets = 60
+
+// BandwidthStats are bytes of playlists and segments served by manager.
+type BandwidthStats struct {
+	// since last reset
+	Total int64     `json:"total"`
+	Since time.Time `json:"since"`
+	// within last bandwidth window
+	Window int64 `json:"window"`
+}
+
+// bandwidth accounts served bytes, windowed total is kept in ring of
+// buckets, so it might span up to one bucket more than window.
+type bandwidth struct {
+	mu      sync.Mutex
+	bucket  time.Duration
+	buckets [bandwidthBuckets]int64
+	// start of current bucket and its index
+	current time.Time
+	index   int
+
+	total int64
+	since time.Time
+}
+
+func newBandwidth(window time.Duration) *bandwidth {
+	now := time.Now()
+	return &bandwidth{
+		bucket:  window / bandwidthBuckets,
+		current: now,
+		since:   now,
+	}
+}
+
+// advance rotates buckets up to now, must be called with lock held.
+func (b *bandwidth) advance(now time.Time) {
+	for i := 0; i < bandwidthBuckets && now.Sub(b.current) >= b.bucket; i++ {
+		b.index = (b.index + 1) % bandwidthBuckets
+		b.buckets[b.index] = 0
+		b.current = b.current.Add(b.bucket)
+	}
+
+	// idle longer than window, all buckets were cleared
+	if now.Sub(b.current) >= b.bucket {
+		b.current = now
+	}
+}
+
+func (b *bandwidth) add(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	b.buckets[b.index] += n
+	b.total += n
+}
+
+func (b *bandwidth) stats() BandwidthStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+
+	stats := BandwidthStats{
+		Total: b.total,
+		Since: b.since,
+	}
+
+	for _, n := range b.buckets {
+		stats.Window += n
+	}
+
+	return stats
+}
+
+func (b *bandwidth) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.buckets = [bandwidthBuckets]int64{}
+	b.current = now
+	b.total = 0
+	b.since = now
+}
+
+// bandwidthWriter accounts bytes actually written, so that partial
+// writes to aborted clients are counted only up to their failure.
+type bandwidthWriter struct {
+	http.ResponseWriter
+	bandwidth *bandwidth
+}
+
+func (w *bandwidthWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bandwidth.add(int64(n))
+	return n, err
+}
+
+// ReadFrom keeps sendfile of underlying writer, when available.
+func (w *bandwidthWriter) ReadFrom(r io.Reader) (int64, error) {
+	readerFrom, ok := w.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{w}, r)
+	}
+
+	n, err := readerFrom.ReadFrom(r)
+	w.bandwidth.add(n)
+	return n, err
+}
+
+func (w *bandwidthWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// accounted wraps writer to account served bytes, when enabled.
+func (m *ManagerCtx) accounted(w http.ResponseWriter) http.ResponseWriter {
+	if m.bandwidth == nil {
+		return w
+	}
+
+	return &bandwidthWriter{
+		ResponseWriter: w,
+		bandwidth:      m.bandwidth,
+	}
+}
+
+// ResetBandwidth resets served bytes accounting.
+func (m *ManagerCtx) ResetBandwidth() {
+	if m.bandwidth != nil {
+		m.bandwidth.reset()
+	}
+}
@@ -0,0 +1,108 @@
+package hls
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBandwidthServed(t *testing.T) {
+	m := testRunningManager(t, Config{SegmentDuration: 1, BandwidthWindow: time.Minute}, testPlaylist(0, 3, false))
+
+	w := httptest.NewRecorder()
+	m.ServePlaylist(w, httptest.NewRequest(http.MethodGet, "/h264_720p/cam/index.m3u8", nil))
+	playlist := int64(w.Body.Len())
+
+	testServeMedia(t, m, "index0.ts", make([]byte, 1000))
+	testServeMedia(t, m, "index1.ts", make([]byte, 500))
+
+	// headers only are not counted
+	w = httptest.NewRecorder()
+	m.ServeMedia(w, httptest.NewRequest(http.MethodHead, "/h264_720p/cam/index1.ts", nil))
+
+	stats := m.Stats().Bandwidth
+	if stats == nil {
+		t.Fatal("bandwidth stats missing")
+	}
+
+	want := playlist + 1500
+	if stats.Total != want || stats.Window != want {
+		t.Errorf("got total %d and window %d, want %d", stats.Total, stats.Window, want)
+	}
+
+	m.ResetBandwidth()
+	if stats := m.Stats().Bandwidth; stats.Total != 0 || stats.Window != 0 {
+		t.Errorf("got %+v after reset, want zero", stats)
+	}
+}
+
+func TestBandwidthDisabled(t *testing.T) {
+	m := testRunningManager(t, Config{SegmentDuration: 1}, testPlaylist(0, 3, false))
+	testServeMedia(t, m, "index0.ts", make([]byte, 1000))
+
+	if stats := m.Stats().Bandwidth; stats != nil {
+		t.Errorf("got %+v, want no accounting", stats)
+	}
+}
+
+// testAbortedWriter accepts limited count of bytes, as client aborting
+// download.
+type testAbortedWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *testAbortedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errors.New("connection reset")
+	}
+
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestBandwidthAbortedClient(t *testing.T) {
+	b := newBandwidth(time.Minute)
+	w := &bandwidthWriter{ResponseWriter: &testAbortedWriter{httptest.NewRecorder(), 300}, bandwidth: b}
+
+	w.Write(make([]byte, 200))
+	if _, err := w.Write(make([]byte, 200)); err == nil {
+		t.Fatal("got no error of aborted client")
+	}
+
+	if stats := b.stats(); stats.Total != 300 {
+		t.Errorf("got %d bytes, want only written 300", stats.Total)
+	}
+}
+
+func TestBandwidthWindow(t *testing.T) {
+	b := newBandwidth(time.Minute)
+	b.add(100)
+
+	// shift accounting back in time
+	shift := func(d time.Duration) {
+		b.mu.Lock()
+		b.current = b.current.Add(-d)
+		b.mu.Unlock()
+	}
+
+	shift(30 * time.Second)
+	b.add(50)
+	if stats := b.stats(); stats.Window != 150 || stats.Total != 150 {
+		t.Errorf("got %+v within window, want 150", stats)
+	}
+
+	shift(45 * time.Second)
+	if stats := b.stats(); stats.Window != 50 || stats.Total != 150 {
+		t.Errorf("got %+v once first bytes left window, want window 50 of total 150", stats)
+	}
+
+	shift(2 * time.Minute)
+	if stats := b.stats(); stats.Window != 0 || stats.Total != 150 {
+		t.Errorf("got %+v after idle window, want window 0 of total 150", stats)
+	}
+}
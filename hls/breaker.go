@@ -0,0 +1,48 @@
+package hls
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// breaker opens after threshold consecutive start failures and rejects
+// starts until cooldown passes, then allows another attempt.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	failures int
+	openedAt time.Time
+
+	// current time, time.Now when nil
+	now func() time.Time
+}
+
+func (b *breaker) clock() time.Time {
+	if b.now == nil {
+		return time.Now()
+	}
+	return b.now()
+}
+
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+
+	return b.clock().Sub(b.openedAt) >= b.cooldown
+}
+
+func (b *breaker) failure() {
+	b.failures++
+
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openedAt = b.clock()
+	}
+}
+
+func (b *breaker) success() {
+	b.failures = 0
+}
@@ -0,0 +1,93 @@
+package hls
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	type step struct {
+		// advances clock before step
+		elapsed time.Duration
+		// start outcome, nil only checks whether start is allowed
+		failed *bool
+		allow  bool
+	}
+
+	failed, succeeded := true, false
+
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+	}{
+		{
+			name:      "opens after consecutive failures",
+			threshold: 3,
+			steps: []step{
+				{failed: &failed, allow: true},
+				{failed: &failed, allow: true},
+				{failed: &failed, allow: false},
+				{elapsed: 29 * time.Second, allow: false},
+			},
+		},
+		{
+			name:      "closes after cooldown",
+			threshold: 2,
+			steps: []step{
+				{failed: &failed, allow: true},
+				{failed: &failed, allow: false},
+				{elapsed: 30 * time.Second, allow: true},
+				// another failure opens it again right away
+				{failed: &failed, allow: false},
+				{elapsed: 30 * time.Second, failed: &succeeded, allow: true},
+				{failed: &failed, allow: true},
+			},
+		},
+		{
+			name:      "success resets failures",
+			threshold: 2,
+			steps: []step{
+				{failed: &failed, allow: true},
+				{failed: &succeeded, allow: true},
+				{failed: &failed, allow: true},
+			},
+		},
+		{
+			name:      "disabled",
+			threshold: 0,
+			steps: []step{
+				{failed: &failed, allow: true},
+				{failed: &failed, allow: true},
+				{failed: &failed, allow: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(0, 0)
+			b := breaker{
+				threshold: tt.threshold,
+				cooldown:  30 * time.Second,
+				now:       func() time.Time { return now },
+			}
+
+			for i, step := range tt.steps {
+				now = now.Add(step.elapsed)
+
+				if step.failed != nil {
+					if *step.failed {
+						b.failure()
+					} else {
+						b.success()
+					}
+				}
+
+				if got := b.allow(); got != step.allow {
+					t.Errorf("step %d: got allow %v, want %v", i, got, step.allow)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/m1k1o/go-transcode/drm"
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// ServerControl holds delivery directives advertised to players using
+// EXT-X-SERVER-CONTROL tag. Durations are in seconds, zero hold backs
+// default to three target durations and three part durations.
+type ServerControl struct {
+	CanBlockReload bool
+	HoldBack       float64
+	PartHoldBack   float64
+}
+
+// ManagerConfig holds timing of stream lifecycle, zero values are
+// replaced by defaults.
+type ManagerConfig struct {
+	// how often should be cleanup called
+	CleanupPeriod time.Duration
+	// timeout for first playlist, when it waits for new data
+	PlaylistTimeout time.Duration
+	// minimum segments available to consider stream as active
+	MinimumSegments int
+	// how long must be active stream idle to be considered as dead
+	ActiveIdleTimeout time.Duration
+	// how long must be inactive stream idle to be considered as dead
+	InactiveIdleTimeout time.Duration
+}
+
+func (c *ManagerConfig) Validate() error {
+	if c.CleanupPeriod < 0 || c.PlaylistTimeout < 0 || c.ActiveIdleTimeout < 0 || c.InactiveIdleTimeout < 0 {
+		return errors.New("manager timeouts must not be negative")
+	}
+
+	if c.MinimumSegments < 0 {
+		return errors.New("minimum segments must not be negative")
+	}
+
+	return nil
+}
+
+// withDefaults returns config with zero values replaced by defaults.
+func (c ManagerConfig) withDefaults() ManagerConfig {
+	if c.CleanupPeriod == 0 {
+		c.CleanupPeriod = defaultCleanupPeriod
+	}
+	if c.PlaylistTimeout == 0 {
+		c.PlaylistTimeout = defaultPlaylistTimeout
+	}
+	if c.MinimumSegments == 0 {
+		c.MinimumSegments = defaultMinimumSegments
+	}
+	if c.ActiveIdleTimeout == 0 {
+		c.ActiveIdleTimeout = defaultActiveIdleTimeout
+	}
+	if c.InactiveIdleTimeout == 0 {
+		c.InactiveIdleTimeout = defaultInactiveIdleTimeout
+	}
+	return c
+}
+
+type Config struct {
+	// timing of stream lifecycle
+	Manager ManagerConfig
+
+	// enables low latency playlist tags
+	LowLatency bool
+
+	// target segment duration produced by profile, in seconds
+	SegmentDuration float64
+	// target partial segment duration, in seconds
+	PartDuration float64
+
+	ServerControl ServerControl
+	// maximum blocking playlist reloads held at once, further requests
+	// are served current playlist right away, zero means unlimited
+	MaxBlockingReloads int
+
+	// how long to wait for remaining output after cmd exits
+	ExitGrace time.Duration
+
+	// keep running even when idle
+	KeepAlive bool
+	// do not start on playlist request, only using Start()
+	ExplicitStart bool
+
+	// profile transcodes abr ladder, writing master playlist and variant
+	// playlists into tempdir instead of playlist to stdout
+	ABR bool
+
+	// content type overrides by file extension
+	MimeTypes map[string]string
+	// detect content type of files with unknown extension by their content
+	SniffMimeTypes bool
+
+	// consecutive start failures after which starts are rejected, zero disables
+	BreakerThreshold int
+	// how long are starts rejected
+	BreakerCooldown time.Duration
+
+	// content protection signaled in playlists
+	ContentProtection []drm.System
+	// AES-128 encryption of segments with rotating keys
+	Encryption Encryption
+
+	// secret of signed urls required by all requests, empty disables
+	SigningSecret string
+
+	// appended to relative uris of served playlists, so that requests of
+	// their segments reach the same manager, e.g. of selected track
+	URIQuery string
+
+	// how long are segments kept behind live edge in event playlist, zero
+	// keeps live edge only
+	DVRWindow time.Duration
+
+	// maximum served segments and playlist size in bytes, zero means unlimited
+	MaxSegments     int
+	MaxPlaylistSize int
+
+	// stable directory for segments cleaned on start, random when empty
+	TempDir string
+	// parent of random tempdirs, default of os when empty
+	TempRoot string
+	// restart within this period reuses previous tempdir, zero disables
+	RestartGrace time.Duration
+
+	// transcode exiting on its own while requested is restarted after
+	// backoff, doubled on consecutive exits up to maximum, zero disables
+	RestartBackoff    time.Duration
+	RestartBackoffMax time.Duration
+
+	// serve rendered playlist until new one arrives, not used in low
+	// latency mode where parts change in between
+	PlaylistCache bool
+
+	// segments are kept in memory instead of tempdir, nil disables
+	Memory *MemoryStore
+
+	// store receiving completed segments, playlists reference stored ones
+	Store SegmentStore
+	// parallel uploads to store
+	StoreWorkers int
+
+	// start stream on HEAD playlist request, otherwise 503 is returned
+	// until it is running
+	HeadColdStart bool
+
+	// shared by managers, keeps most recently requested ones running
+	WarmPool *WarmPool
+
+	// shared by managers of the same stream, counts its unique viewers
+	Viewers *Viewers
+
+	// how long can all clients wait for stream to warm up, before they
+	// are failed together, zero disables
+	ColdStartTimeout time.Duration
+
+	// how long can process run without producing segments, before it is
+	// torn down regardless of clients, zero disables
+	StartupTimeout time.Duration
+
+	// floor of Retry-After, which is segment duration otherwise
+	RetryAfterMin time.Duration
+
+	// stream is stopped after running this long regardless of viewers,
+	// and must be started explicitly again, zero disables
+	MaxDuration time.Duration
+
+	// signal only transcode process instead of its whole process group,
+	// for setups where process groups misbehave (e.g. pid namespaces)
+	SingleProcess bool
+	// nice level, cpu affinity and cgroup limits of transcode process
+	ProcessLimits utils.ProcessLimits
+
+	// receives transcode output besides logger, e.g. log of stream
+	CmdLog io.Writer
+
+	// video decode errors within 10s, after which output is switched to
+	// audio only, zero disables
+	AudioOnlyThreshold int
+	// how long is audio only output kept before video is tried again
+	AudioOnlyPeriod time.Duration
+
+	// window of served bytes reported in stats, zero disables accounting
+	BandwidthWindow time.Duration
+
+	// restarts frozen streams, escalating on repeated freezes
+	Watchdog Watchdog
+	// command transcoding fallback source, used by watchdog
+	FallbackCmd func() (*exec.Cmd, error)
+	// command transcoding source with reconnect options, used by watchdog
+	// after stall, plain command is restarted when nil
+	ReconnectCmd func() (*exec.Cmd, error)
+
+	// limits of concurrent transcodes shared by managers, e.g. per profile
+	// and global, their slots are held until transcode stops
+	Limits []*utils.Semaphore
+	// how long can playlist request wait for free slots, zero fails
+	// right away
+	LimitTimeout time.Duration
+	// aggregate size of tempdirs shared by managers, new transcodes are
+	// refused once exceeded, nil is unlimited
+	DiskBudget *DiskBudget
+
+	// commands transcoding backup sources in order, next one is used when
+	// source fails to start, exits or stalls
+	BackupCmds []func() (*exec.Cmd, error)
+	// called once cmd returned by any command factory exits or fails to
+	// start, e.g. releasing its hardware encoder session
+	Exited func(cmd *exec.Cmd)
+	// probes primary source before start and while backup is used
+	ProbePrimary func() error
+	// period of primary probes while backup is used, switching back once
+	// primary recovers, zero disables switching back
+	FailbackPeriod time.Duration
+}
+
+// RetryAfter returns Retry-After of unavailable responses, about one
+// segment duration with configured floor.
+func (c *Config) RetryAfter() string {
+	return utils.RetryAfter(time.Duration(c.SegmentDuration*float64(time.Second)), c.RetryAfterMin)
+}
+
+func (c *Config) Validate() error {
+	if c.SegmentDuration <= 0 {
+		return errors.New("segment duration must be positive")
+	}
+
+	for i := range c.ContentProtection {
+		if err := c.ContentProtection[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Encryption.KeyRotation < 0 {
+		return errors.New("key rotation must not be negative")
+	}
+
+	if c.Encryption.Enabled() && len(c.ContentProtection) > 0 {
+		return errors.New("encryption can not be combined with content protection")
+	}
+
+	if c.Encryption.Enabled() && c.LowLatency {
+		return errors.New("encryption is not supported in low latency mode")
+	}
+
+	// partial segments are read while being written
+	if c.Memory != nil && c.LowLatency {
+		return errors.New("memory store is not supported in low latency mode")
+	}
+
+	if c.DVRWindow < 0 {
+		return errors.New("dvr window must not be negative")
+	}
+
+	if c.DVRWindow > 0 && c.MaxSegments > 0 && c.MaxSegments < c.dvrSegments() {
+		return fmt.Errorf("dvr window of %d segments exceeds max segments %d", c.dvrSegments(), c.MaxSegments)
+	}
+
+	if c.LimitTimeout < 0 {
+		return errors.New("limit timeout must not be negative")
+	}
+
+	if c.FailbackPeriod < 0 {
+		return errors.New("failback period must not be negative")
+	}
+
+	if err := c.Watchdog.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Manager.Validate(); err != nil {
+		return err
+	}
+
+	if c.RestartBackoff < 0 || c.RestartBackoff > 0 && c.RestartBackoffMax < c.RestartBackoff {
+		return errors.New("restart backoff must not be negative or exceed its maximum")
+	}
+
+	if !c.LowLatency {
+		return nil
+	}
+
+	if c.PartDuration <= 0 {
+		return errors.New("part duration must be positive in low latency mode")
+	}
+
+	if c.PartDuration >= c.SegmentDuration {
+		return fmt.Errorf("part duration %gs must be lower than segment duration %gs", c.PartDuration, c.SegmentDuration)
+	}
+
+	// hold back must be at least three target durations
+	if hb := c.ServerControl.HoldBack; hb != 0 && hb < 3*c.SegmentDuration {
+		return fmt.Errorf("hold back %gs must be at least three times segment duration %gs", hb, c.SegmentDuration)
+	}
+
+	// part hold back must be at least two part target durations
+	if phb := c.ServerControl.PartHoldBack; phb != 0 && phb < 2*c.PartDuration {
+		return fmt.Errorf("part hold back %gs must be at least two times part duration %gs", phb, c.PartDuration)
+	}
+
+	return nil
+}
+
+// serverControlTag renders EXT-X-SERVER-CONTROL tag, hold backs default
+// to three times given target duration and part duration when not
+// configured.
+func (c *Config) serverControlTag(targetDuration float64) string {
+	tag := "#EXT-X-SERVER-CONTROL:"
+
+	if c.ServerControl.CanBlockReload {
+		tag += "CAN-BLOCK-RELOAD=YES,"
+	}
+
+	holdBack := c.ServerControl.HoldBack
+	if holdBack == 0 {
+		holdBack = 3 * targetDuration
+	}
+
+	tag += fmt.Sprintf("HOLD-BACK=%.3f", holdBack)
+
+	// required with partial segments
+	partHoldBack := c.ServerControl.PartHoldBack
+	if partHoldBack == 0 {
+		partHoldBack = 3 * c.PartDuration
+	}
+
+	if partHoldBack != 0 {
+		tag += fmt.Sprintf(",PART-HOLD-BACK=%.3f", partHoldBack)
+	}
+
+	return tag
+}
@@ -0,0 +1,86 @@
+package hls
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServerControlTag(t *testing.T) {
+	tests := []struct {
+		name    string
+		control ServerControl
+		want    string
+	}{
+		{
+			name:    "configured",
+			control: ServerControl{CanBlockReload: true, HoldBack: 12, PartHoldBack: 3},
+			want:    "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,HOLD-BACK=12.000,PART-HOLD-BACK=3.000",
+		},
+		{
+			name:    "defaults",
+			control: ServerControl{},
+			want:    "#EXT-X-SERVER-CONTROL:HOLD-BACK=12.000,PART-HOLD-BACK=3.000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{
+				LowLatency:      true,
+				SegmentDuration: 4,
+				PartDuration:    1,
+				ServerControl:   tt.control,
+			}
+
+			if err := c.Validate(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := c.serverControlTag(4); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerControlValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		control ServerControl
+		wantErr bool
+	}{
+		{"omitted", ServerControl{}, false},
+		{"consistent", ServerControl{HoldBack: 12, PartHoldBack: 2}, false},
+		{"hold back below three segments", ServerControl{HoldBack: 8}, true},
+		{"part hold back below two parts", ServerControl{PartHoldBack: 1.5}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{
+				LowLatency:      true,
+				SegmentDuration: 4,
+				PartDuration:    1,
+				ServerControl:   tt.control,
+			}
+
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerControlRendered(t *testing.T) {
+	c := Config{
+		LowLatency:      true,
+		SegmentDuration: 4,
+		PartDuration:    1,
+		ServerControl:   ServerControl{CanBlockReload: true, HoldBack: 12, PartHoldBack: 3},
+	}
+
+	playlist := playlistInsertTags(testPlaylist(0, 3, false), c.serverControlTag(4))
+	if !strings.Contains(playlist, "\n#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,HOLD-BACK=12.000,PART-HOLD-BACK=3.000\n") {
+		t.Errorf("server control tag missing in playlist:\n%s", playlist)
+	}
+}
@@ -0,0 +1,41 @@
+package hls
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// clock ticks per second used by /proc, fixed on linux
+const clockTicks = 100
+
+// processCPU returns cpu seconds consumed by running process and its
+// waited children, or zero when it can not be read.
+func processCPU(pid int) float64 {
+	stat, err := os.ReadFile(path.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0
+	}
+
+	// command is in parentheses and may contain spaces
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0
+	}
+
+	// fields after command: state, ppid, ..., utime, stime, cutime, cstime
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 15 {
+		return 0
+	}
+
+	ticks := 0
+	for _, field := range fields[11:15] {
+		n, _ := strconv.Atoi(field)
+		ticks += n
+	}
+
+	return float64(ticks) / clockTicks
+}
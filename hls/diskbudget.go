@@ -0,0 +1,184 @@
+package hls
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ErrDiskBudgetExceeded = fmt.Errorf("%w: disk budget of tempdirs exceeded", ErrTooManyTranscodes)
+
+// DiskBudget limits aggregate size of tempdirs of managers sharing it,
+// nil is unlimited.
+type DiskBudget struct {
+	mu sync.Mutex
+	// bytes of all tempdirs, and space expected to be taken by new one
+	max     int64
+	reserve int64
+	// last measured size by tempdir
+	sizes map[string]int64
+}
+
+func NewDiskBudget(max int64, reserve int64) *DiskBudget {
+	if max <= 0 {
+		return nil
+	}
+
+	return &DiskBudget{
+		max:     max,
+		reserve: reserve,
+		sizes:   map[string]int64{},
+	}
+}
+
+// Usage returns measured size of all tempdirs in bytes.
+func (b *DiskBudget) Usage() int64 {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.usage()
+}
+
+// usage must be called with lock held.
+func (b *DiskBudget) usage() int64 {
+	var usage int64
+	for _, size := range b.sizes {
+		usage += size
+	}
+	return usage
+}
+
+// allow reports whether transcode can be started. Transcodes already
+// holding tempdir are restarting and always allowed.
+func (b *DiskBudget) allow(tempdir string) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.sizes[tempdir]; ok && tempdir != "" {
+		return true
+	}
+
+	return b.usage()+b.reserve <= b.max
+}
+
+func (b *DiskBudget) set(tempdir string, size int64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sizes[tempdir] = size
+}
+
+func (b *DiskBudget) remove(tempdir string) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.sizes, tempdir)
+}
+
+// checkTempDir removes segments of running cmd, that fell out of its
+// playlist, and measures size of its tempdir.
+func (m *ManagerCtx) checkTempDir(cmd *exec.Cmd) {
+	m.mu.Lock()
+	if m.cmd != cmd || m.tempdir == "" {
+		m.mu.Unlock()
+		return
+	}
+	tempdir, playlist := m.tempdir, m.playlist
+	// abr variants have their own playlists, memory store its own files
+	prune := !m.config.ABR && !m.memory()
+	m.mu.Unlock()
+
+	if prune {
+		if removed := pruneSegments(tempdir, playlist); removed > 0 {
+			m.logger.Debug().Int("removed", removed).Msg("removed segments beyond playlist window")
+		}
+	}
+
+	size := dirSize(tempdir)
+	m.config.DiskBudget.set(tempdir, size)
+
+	m.mu.Lock()
+	if m.tempdir == tempdir {
+		m.tempdirSize = size
+	}
+	m.mu.Unlock()
+}
+
+// pruneSegments removes segment files older than first segment of
+// playlist, that are not referenced by it. Returns number of removed files.
+func pruneSegments(tempdir string, playlist string) int {
+	segments := parsePlaylist(playlist).segments
+	if len(segments) == 0 {
+		return 0
+	}
+
+	first, err := os.Stat(filepath.Join(tempdir, path.Base(segments[0].uri)))
+	if err != nil {
+		return 0
+	}
+
+	exts := map[string]bool{}
+	for _, s := range segments {
+		exts[path.Ext(s.uri)] = true
+	}
+
+	files, err := ioutil.ReadDir(tempdir)
+	if err != nil {
+		return 0
+	}
+
+	removed := 0
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !exts[path.Ext(name)] || strings.Contains(playlist, name) {
+			continue
+		}
+
+		// newest segment is being written, before it is listed
+		if !file.ModTime().Before(first.ModTime()) {
+			continue
+		}
+
+		if os.Remove(filepath.Join(tempdir, name)) == nil {
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// dirSize returns size of files in dir and its subdirectories.
+func dirSize(dir string) int64 {
+	var size int64
+
+	//nolint
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
@@ -0,0 +1,62 @@
+package hls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/m1k1o/go-transcode/drm"
+)
+
+var testContentProtection = []drm.System{
+	{SystemID: drm.Widevine, LicenseURL: "https://license.example.com/widevine"},
+	{SystemID: drm.FairPlay, LicenseURL: "https://license.example.com/fairplay", CertificateURL: "https://license.example.com/fairplay.cer", KeyURI: "skd://example"},
+}
+
+func TestContentProtectionMaster(t *testing.T) {
+	m := New(nil, Config{
+		SegmentDuration:   4,
+		ABR:               true,
+		ContentProtection: testContentProtection,
+	})
+	m.playlist = "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-STREAM-INF:BANDWIDTH=2000000\nvariant_0.m3u8\n"
+
+	playlist, _ := m.render()
+	for _, want := range []string{
+		"\n#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI=\"https://license.example.com/widevine\",KEYFORMAT=\"urn:uuid:" + drm.Widevine + "\",KEYFORMATVERSIONS=\"1\"\n",
+		"\n#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI=\"skd://example\",KEYFORMAT=\"com.apple.streamingkeydelivery\",KEYFORMATVERSIONS=\"1\"\n",
+		"\n#EXT-X-SESSION-DATA:DATA-ID=\"com.apple.streamingkeydelivery.certificate\",URI=\"https://license.example.com/fairplay.cer\"\n",
+	} {
+		if !strings.Contains(playlist, want) {
+			t.Errorf("%q missing in master playlist:\n%s", strings.TrimSpace(want), playlist)
+		}
+	}
+
+	// tags belong to header, before variants
+	if strings.Index(playlist, "#EXT-X-SESSION-KEY") > strings.Index(playlist, "#EXT-X-STREAM-INF") {
+		t.Errorf("session keys follow variants:\n%s", playlist)
+	}
+}
+
+func TestContentProtectionMedia(t *testing.T) {
+	var starts int32
+	m := New(testLiveCmd(t, 3, &starts), Config{
+		SegmentDuration:   4,
+		TempRoot:          t.TempDir(),
+		ContentProtection: testContentProtection,
+	})
+	defer m.Shutdown()
+
+	w := httptest.NewRecorder()
+	m.ServePlaylist(w, httptest.NewRequest(http.MethodGet, "/h264_720p/cam/index.m3u8", nil))
+
+	// segments are not encrypted by server
+	playlist := w.Body.String()
+	if !strings.Contains(playlist, "#EXTINF") {
+		t.Fatalf("got status %d, want media playlist:\n%s", w.Code, playlist)
+	}
+	if strings.Contains(playlist, "#EXT-X-KEY") || strings.Contains(playlist, "#EXT-X-SESSION-KEY") {
+		t.Errorf("media playlist signals keys:\n%s", playlist)
+	}
+}
@@ -0,0 +1,77 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// environment setting playlist length and segment file names cycle of
+// profile, that defaults to live edge only
+const (
+	listSizeEnv = "TRANSCODE_HLS_LIST_SIZE"
+	wrapEnv     = "TRANSCODE_HLS_WRAP"
+)
+
+// request parameter asking to start playback given seconds behind live edge
+const rewindParam = "rewind"
+
+// dvr reports whether segments of dvr window are kept, abr profiles write
+// variant playlists on their own and keep live edge only.
+func (m *ManagerCtx) dvr() bool {
+	return m.config.DVRWindow > 0 && !m.config.ABR
+}
+
+// dvrSegments returns number of segments covering dvr window.
+func (c *Config) dvrSegments() int {
+	return int(math.Ceil(c.DVRWindow.Seconds() / c.SegmentDuration))
+}
+
+// dvrEnv points cmd to keep segments of dvr window in its playlist.
+func (m *ManagerCtx) dvrEnv(cmd *exec.Cmd) {
+	segments := m.config.dvrSegments()
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env,
+		listSizeEnv+"="+strconv.Itoa(segments),
+		// segments being downloaded must not be overwritten
+		wrapEnv+"="+strconv.Itoa(2*segments),
+	)
+}
+
+// rewindParams returns requested seconds behind live edge, or zero.
+func rewindParams(r *http.Request) (float64, error) {
+	value := r.URL.Query().Get(rewindParam)
+	if value == "" {
+		return 0, nil
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return 0, errors.New("invalid rewind")
+	}
+
+	return seconds, nil
+}
+
+// playlistRewind asks players to start playback given seconds behind live
+// edge, at most at first segment of playlist. Playlist itself is kept, so
+// that its reloads carrying the same uri continue seamlessly.
+func playlistRewind(playlist string, seconds float64) string {
+	available := 0.0
+	for _, segment := range parsePlaylist(playlist).segments {
+		available += segment.duration()
+	}
+
+	if seconds > available {
+		seconds = available
+	}
+
+	return playlistInsertTags(playlist, fmt.Sprintf("#EXT-X-START:TIME-OFFSET=-%.3f,PRECISE=YES", seconds))
+}
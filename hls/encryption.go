@@ -0,0 +1,164 @@
+package hls
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keyinfo file read by hls muxer on every segment, in tempdir
+const keyInfoFile = "key.keyinfo"
+
+// environment pointing profile to keyinfo file, relative to its working
+// directory
+const keyInfoEnv = "TRANSCODE_HLS_KEY_INFO_FILE"
+
+var keyURIRegex = regexp.MustCompile(`URI="(key_[0-9]+\.key)"`)
+
+// Encryption of segments by profile using AES-128 keys, that are generated
+// and served by manager.
+type Encryption struct {
+	// how often is key rotated, zero disables encryption
+	KeyRotation time.Duration
+	// keys are served only to requests carrying session of current viewer
+	RequireSession bool
+}
+
+func (e Encryption) Enabled() bool {
+	return e.KeyRotation > 0
+}
+
+// keyName is file name of key, also used as its uri relative to playlist.
+func keyName(index int) string {
+	return fmt.Sprintf("key_%d.key", index)
+}
+
+// encrypted reports whether profile output is encrypted, abr profiles
+// write variant playlists on their own and are not.
+func (m *ManagerCtx) encrypted() bool {
+	return m.config.Encryption.Enabled() && !m.config.ABR
+}
+
+// rotateKey generates next key and points keyinfo file to it, segments
+// started afterwards are encrypted by it. Keys no longer referenced by
+// playlist are forgotten. Must be called with lock held.
+func (m *ManagerCtx) rotateKey(tempdir string) error {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	index := m.keyIndex + 1
+	keyPath := filepath.Join(tempdir, keyName(index))
+	if err := ioutil.WriteFile(keyPath, key, 0600); err != nil {
+		return err
+	}
+
+	// muxer must never read partially written keyinfo
+	info := keyName(index) + "\n" + keyPath + "\n"
+	infoPath := filepath.Join(tempdir, keyInfoFile)
+	if err := ioutil.WriteFile(infoPath+".tmp", []byte(info), 0600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(infoPath+".tmp", infoPath); err != nil {
+		return err
+	}
+
+	if m.keys == nil {
+		m.keys = map[int][]byte{}
+	}
+
+	// previous key might still encrypt segment being written
+	for i := range m.keys {
+		if i < m.keyIndex && !strings.Contains(m.playlist, `URI="`+keyName(i)+`"`) {
+			delete(m.keys, i)
+			os.Remove(filepath.Join(tempdir, keyName(i)))
+		}
+	}
+
+	m.keyIndex = index
+	m.keys[index] = key
+
+	m.logger.Debug().Int("key", index).Msg("rotated encryption key")
+	return nil
+}
+
+// rotate rotates key of running cmd.
+func (m *ManagerCtx) rotate(cmd *exec.Cmd) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd != cmd {
+		return
+	}
+
+	if err := m.rotateKey(m.tempdir); err != nil {
+		m.logger.Err(err).Msg("could not rotate encryption key")
+	}
+}
+
+// keyEnv points cmd to keyinfo file.
+func (m *ManagerCtx) keyEnv(cmd *exec.Cmd) {
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, keyInfoEnv+"="+keyInfoFile)
+}
+
+// playlistKeySession appends session to key uris, so that relative key
+// requests of viewer carry it.
+func playlistKeySession(playlist string, session string) string {
+	return keyURIRegex.ReplaceAllString(playlist, `URI="$1?`+viewerSessionParam+"="+url.QueryEscape(session)+`"`)
+}
+
+// ServeKey serves key of encrypted segments, e.g. key_1.key.
+func (m *ManagerCtx) ServeKey(w http.ResponseWriter, r *http.Request) {
+	if !m.verifySigned(w, r) {
+		return
+	}
+
+	name := path.Base(r.URL.Path)
+
+	index, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "key_"), ".key"))
+	if err != nil || keyName(index) != name {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 key not found"))
+		return
+	}
+
+	if m.config.Encryption.RequireSession {
+		session := r.URL.Query().Get(viewerSessionParam)
+		if session == "" || m.config.Viewers == nil || !m.config.Viewers.watching(session) {
+			m.logger.Debug().Str("key", name).Msg("key requested without session of viewer")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 session required"))
+			return
+		}
+	}
+
+	m.mu.Lock()
+	key, ok := m.keys[index]
+	m.mu.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 key not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(len(key)))
+	w.Write(key)
+}
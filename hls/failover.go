@@ -0,0 +1,74 @@
+package hls
+
+import (
+	"os/exec"
+	"time"
+)
+
+// backupRemains reports whether another backup source can be tried, must
+// be called with lock held.
+func (m *ManagerCtx) backupRemains() bool {
+	return m.source < len(m.config.BackupCmds)
+}
+
+// failover switches to next backup source, that is used once cmd is
+// restarted. Returns false when no backup remains. Must be called with
+// lock held.
+func (m *ManagerCtx) failover(reason string) bool {
+	if !m.backupRemains() {
+		return false
+	}
+
+	m.source++
+	m.logger.Warn().
+		Int("source", m.source).
+		Str("reason", reason).
+		Msg("switching to backup source")
+
+	return true
+}
+
+// probePrimary reports whether primary source can be opened, before
+// starting it. Must be called with lock held.
+func (m *ManagerCtx) probePrimary() {
+	if m.source != 0 || len(m.config.BackupCmds) == 0 || m.config.ProbePrimary == nil {
+		return
+	}
+
+	if err := m.config.ProbePrimary(); err != nil {
+		m.logger.Warn().Err(err).Msg("primary source probe failed")
+		m.failover("probe failed")
+	}
+}
+
+// watchPrimary probes primary source while backup source is used by cmd,
+// switching back once it recovers.
+func (m *ManagerCtx) watchPrimary(cmd *exec.Cmd, shutdown <-chan interface{}) {
+	ticker := time.NewTicker(m.config.FailbackPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+
+		if err := m.config.ProbePrimary(); err != nil {
+			m.logger.Debug().Err(err).Msg("primary source is still unavailable")
+			continue
+		}
+
+		m.mu.Lock()
+		if m.cmd == cmd {
+			m.logger.Info().Msg("primary source recovered, switching back")
+
+			m.source = 0
+			if err := m.restart("primary source recovered", m.audioOnly, m.fallback); err != nil {
+				m.logger.Warn().Err(err).Msg("transcode could not be restarted")
+			}
+		}
+		m.mu.Unlock()
+		return
+	}
+}
@@ -0,0 +1,144 @@
+package hls
+
+import (
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// period in which video decode errors are counted
+const videoErrorWindow = 10 * time.Second
+
+// errors reported by video decoders, e.g. [h264 @ 0x55d0c8] error while decoding MB 1 2
+var videoErrorRegex = regexp.MustCompile(`^\[(h264|hevc|mpeg2video|mpeg4|mjpeg|vp8|vp9|av1)\s@\s[^\]]+\].*(error|concealing|non-existing|no frame|missing picture|invalid)`)
+
+// audioOnlyEnv is passed to profiles to drop video output.
+const audioOnlyEnv = "TRANSCODE_AUDIO_ONLY=1"
+
+// continuity joins playlist of new run to playlist of previous run,
+// separated by discontinuity.
+type continuity struct {
+	// last media sequence number of previous run
+	last int
+	// discontinuities up to and including this run
+	discontinuities int
+
+	// set by first playlist of run
+	started bool
+	offset  int
+	first   int
+}
+
+func (c *continuity) apply(playlist string) string {
+	p := parsePlaylist(playlist)
+	if len(p.segments) == 0 {
+		return playlist
+	}
+
+	sequence := p.headerInt("#EXT-X-MEDIA-SEQUENCE")
+	if !c.started {
+		// sequence numbers must not go back, even if run starts from zero
+		if sequence <= c.last {
+			c.offset = c.last + 1 - sequence
+		}
+		c.first = sequence + c.offset
+		c.started = true
+	}
+
+	if c.offset > 0 {
+		p.addHeaderInt("#EXT-X-MEDIA-SEQUENCE", c.offset)
+		sequence += c.offset
+	}
+
+	// discontinuities preceding first segment in playlist
+	discontinuities := c.discontinuities
+	if i := c.first - sequence; i >= 0 && i < len(p.segments) {
+		p.segments[i].tags = append([]string{"#EXT-X-DISCONTINUITY"}, p.segments[i].tags...)
+		discontinuities--
+	}
+
+	if discontinuities > 0 {
+		p.addHeaderInt("#EXT-X-DISCONTINUITY-SEQUENCE", discontinuities)
+	}
+
+	return p.String()
+}
+
+// videoLog counts video decode errors of cmd, output is switched to audio
+// only once they reach threshold within error window.
+func (m *ManagerCtx) videoLog(cmd *exec.Cmd, message string) {
+	count := 0
+	for _, line := range strings.Split(message, "\n") {
+		if videoErrorRegex.MatchString(strings.TrimSpace(line)) {
+			count++
+		}
+	}
+
+	if count == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd != cmd || m.audioOnly {
+		return
+	}
+
+	now := time.Now()
+	recent := m.videoErrors[:0]
+	for _, t := range m.videoErrors {
+		if now.Sub(t) < videoErrorWindow {
+			recent = append(recent, t)
+		}
+	}
+	for i := 0; i < count; i++ {
+		recent = append(recent, now)
+	}
+	m.videoErrors = recent
+
+	if len(m.videoErrors) < m.config.AudioOnlyThreshold {
+		return
+	}
+
+	m.logger.Warn().
+		Int("errors", len(m.videoErrors)).
+		Dur("window", videoErrorWindow).
+		Msg("persistent video decode errors, switching to audio only")
+
+	// stderr is written from cmd goroutine, that must not be blocked
+	go m.switchOutput(cmd, true)
+}
+
+// switchOutput restarts cmd with or without video.
+func (m *ManagerCtx) switchOutput(cmd *exec.Cmd, audioOnly bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd != cmd || m.audioOnly == audioOnly {
+		return
+	}
+
+	reason := "video recovered"
+	if audioOnly {
+		reason = "video decode errors"
+	}
+
+	if err := m.restart(reason, audioOnly, m.fallback); err != nil {
+		m.logger.Err(err).Bool("audio_only", audioOnly).Msg("could not switch output")
+	}
+}
+
+// outputEnv sets environment of cmd for current output.
+func (m *ManagerCtx) outputEnv(cmd *exec.Cmd) {
+	if !m.audioOnly {
+		return
+	}
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, audioOnlyEnv)
+}
@@ -0,0 +1,82 @@
+package hls
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAudioOnlyFallback(t *testing.T) {
+	dir := t.TempDir()
+	playlist := testPlaylistFile(t, dir, "live.m3u8", testPlaylist(0, 2, false)+testPlaylist(0, 3, false))
+	runs := filepath.Join(dir, "runs")
+
+	// video fails to decode until it is dropped
+	script := "echo ${TRANSCODE_AUDIO_ONLY:-video} >> " + runs + "; cat " + playlist + "; " +
+		"if [ -z \"$TRANSCODE_AUDIO_ONLY\" ]; then for i in 1 2 3; do echo '[h264 @ 0x55d0c8] error while decoding MB 1 2' >&2; done; fi; " +
+		"exec sleep 30"
+
+	m := New(testCmd(script), Config{
+		SegmentDuration:    2,
+		TempRoot:           t.TempDir(),
+		AudioOnlyThreshold: 3,
+		AudioOnlyPeriod:    500 * time.Millisecond,
+	})
+	defer m.Shutdown()
+
+	readRuns := func() []string {
+		data, _ := os.ReadFile(runs)
+		return strings.Fields(string(data))
+	}
+
+	start := time.Now()
+	if err := m.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !waitFor(time.Second, func() bool { return m.Stats().AudioOnly }) {
+		t.Fatalf("output not switched to audio only, runs %v", readRuns())
+	}
+	if got := readRuns(); len(got) < 2 || got[0] != "video" || got[1] != "1" {
+		t.Errorf("got runs %v, want video followed by audio only", got)
+	}
+
+	// video is tried again after period
+	if !waitFor(2*time.Second, func() bool { return len(readRuns()) >= 3 }) {
+		t.Fatalf("video not tried again, runs %v", readRuns())
+	}
+	if elapsed := time.Since(start); elapsed < m.config.AudioOnlyPeriod {
+		t.Errorf("video tried again after %v, before period", elapsed)
+	}
+	if got := readRuns(); got[2] != "video" {
+		t.Errorf("got runs %v, want failback to video", got)
+	}
+}
+
+func TestVideoLogThreshold(t *testing.T) {
+	m := testRunningManager(t, Config{SegmentDuration: 1, AudioOnlyThreshold: 3}, testPlaylist(0, 3, false))
+	cmd := m.cmd
+
+	// unrelated lines are not counted
+	m.videoLog(cmd, "[aac @ 0x55d0c8] error while decoding\nframe=  100 fps=25")
+	m.videoLog(cmd, "[h264 @ 0x55d0c8] error while decoding MB 1 2\n[hevc @ 0x55d0c9] concealing 100 errors")
+
+	m.mu.Lock()
+	errors := len(m.videoErrors)
+	m.mu.Unlock()
+	if errors != 2 {
+		t.Errorf("got %d video errors, want 2", errors)
+	}
+
+	// errors of previous run are ignored
+	m.videoLog(nil, "[h264 @ 0x55d0c8] error while decoding MB 1 2")
+
+	m.mu.Lock()
+	errors = len(m.videoErrors)
+	m.mu.Unlock()
+	if errors != 2 {
+		t.Errorf("got %d video errors counted from previous run, want 2", errors)
+	}
+}
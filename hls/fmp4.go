@@ -0,0 +1,309 @@
+package hls
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+// part is a byte range of fragmented mp4 segment consisting of
+// one or more moof and mdat boxes.
+type part struct {
+	offset      int64
+	length      int64
+	duration    float64
+	independent bool
+}
+
+type track struct {
+	timescale uint32
+	video     bool
+}
+
+type box struct {
+	kind    string
+	offset  int64
+	size    int64
+	payload []byte
+}
+
+// readBoxes returns complete boxes in data, ignoring trailing incomplete one.
+func readBoxes(data []byte, offset int64) []box {
+	boxes := []box{}
+
+	for pos := int64(0); pos+8 <= int64(len(data)); {
+		size := int64(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		header := int64(8)
+
+		if size == 1 {
+			if pos+16 > int64(len(data)) {
+				break
+			}
+			size = int64(binary.BigEndian.Uint64(data[pos+8:]))
+			header = 16
+		}
+
+		if size < header || size > int64(len(data))-pos {
+			break
+		}
+
+		boxes = append(boxes, box{
+			kind:    kind,
+			offset:  offset + pos,
+			size:    size,
+			payload: data[pos+header : pos+size],
+		})
+
+		pos += size
+	}
+
+	return boxes
+}
+
+func findBox(boxes []box, kind string) (box, bool) {
+	for _, b := range boxes {
+		if b.kind == kind {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// readTracks returns tracks by their id, as found in init segment.
+func readTracks(initPath string) map[uint32]track {
+	tracks := map[uint32]track{}
+
+	data, err := os.ReadFile(initPath)
+	if err != nil {
+		return tracks
+	}
+
+	moov, ok := findBox(readBoxes(data, 0), "moov")
+	if !ok {
+		return tracks
+	}
+
+	for _, trak := range readBoxes(moov.payload, 0) {
+		if trak.kind != "trak" {
+			continue
+		}
+
+		children := readBoxes(trak.payload, 0)
+		tkhd, ok := findBox(children, "tkhd")
+		if !ok || len(tkhd.payload) < 24 {
+			continue
+		}
+
+		// version 1 uses 64bit times
+		offset := 12
+		if tkhd.payload[0] == 1 {
+			offset = 20
+		}
+		id := binary.BigEndian.Uint32(tkhd.payload[offset:])
+
+		mdia, ok := findBox(children, "mdia")
+		if !ok {
+			continue
+		}
+
+		t := track{}
+		mdiaChildren := readBoxes(mdia.payload, 0)
+
+		if mdhd, ok := findBox(mdiaChildren, "mdhd"); ok && len(mdhd.payload) >= 24 {
+			offset := 12
+			if mdhd.payload[0] == 1 {
+				offset = 20
+			}
+			t.timescale = binary.BigEndian.Uint32(mdhd.payload[offset:])
+		}
+
+		if hdlr, ok := findBox(mdiaChildren, "hdlr"); ok && len(hdlr.payload) >= 12 {
+			t.video = string(hdlr.payload[8:12]) == "vide"
+		}
+
+		tracks[id] = t
+	}
+
+	return tracks
+}
+
+// scanParts returns complete parts of fragmented mp4 segment,
+// that might be still being written.
+func scanParts(path string, tracks map[uint32]track) []part {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	parts := []part{}
+	start := int64(0)
+	var pending *part
+
+	for _, b := range readBoxes(data, 0) {
+		switch b.kind {
+		case "moof":
+			duration, independent := fragmentInfo(b.payload, tracks)
+			pending = &part{
+				offset:      start,
+				duration:    duration,
+				independent: independent,
+			}
+		case "mdat":
+			if pending == nil {
+				continue
+			}
+
+			pending.length = b.offset + b.size - start
+			parts = append(parts, *pending)
+			start = b.offset + b.size
+			pending = nil
+		}
+	}
+
+	return parts
+}
+
+// fragmentInfo returns duration of moof fragment in seconds and whether
+// it starts with sync sample. Video track is preferred when present.
+func fragmentInfo(moof []byte, tracks map[uint32]track) (float64, bool) {
+	duration, independent := 0.0, true
+	hasVideo := false
+
+	for _, traf := range readBoxes(moof, 0) {
+		if traf.kind != "traf" {
+			continue
+		}
+
+		children := readBoxes(traf.payload, 0)
+		tfhd, ok := findBox(children, "tfhd")
+		if !ok || len(tfhd.payload) < 8 {
+			continue
+		}
+
+		flags := binary.BigEndian.Uint32(tfhd.payload[0:]) & 0xffffff
+		id := binary.BigEndian.Uint32(tfhd.payload[4:])
+
+		t, ok := tracks[id]
+		if !ok || t.timescale == 0 || (hasVideo && !t.video) {
+			continue
+		}
+
+		// read optional tfhd fields
+		pos := 8
+		var defaultDuration, defaultFlags uint32
+		for _, field := range []uint32{0x01, 0x02, 0x08, 0x10, 0x20} {
+			if flags&field == 0 {
+				continue
+			}
+
+			size := 4
+			if field == 0x01 {
+				size = 8
+			}
+
+			if pos+size > len(tfhd.payload) {
+				break
+			}
+
+			switch field {
+			case 0x08:
+				defaultDuration = binary.BigEndian.Uint32(tfhd.payload[pos:])
+			case 0x20:
+				defaultFlags = binary.BigEndian.Uint32(tfhd.payload[pos:])
+			}
+
+			pos += size
+		}
+
+		var ticks uint64
+		first := true
+		sync := true
+
+		for _, trun := range children {
+			if trun.kind != "trun" || len(trun.payload) < 8 {
+				continue
+			}
+
+			samples, sampleFlags := trunSamples(trun.payload, defaultDuration, defaultFlags)
+			ticks += samples
+
+			if first {
+				// is_non_sync_sample flag
+				sync = sampleFlags&0x10000 == 0
+				first = false
+			}
+		}
+
+		trackDuration := float64(ticks) / float64(t.timescale)
+
+		// prefer video track values
+		if t.video && !hasVideo {
+			hasVideo = true
+			duration, independent = trackDuration, sync
+			continue
+		}
+
+		if trackDuration > duration {
+			duration = trackDuration
+		}
+
+		if !hasVideo {
+			independent = independent && sync
+		}
+	}
+
+	return duration, independent
+}
+
+// trunSamples returns total duration of samples in trun box
+// and flags of its first sample.
+func trunSamples(trun []byte, defaultDuration uint32, defaultFlags uint32) (uint64, uint32) {
+	flags := binary.BigEndian.Uint32(trun[0:]) & 0xffffff
+	count := binary.BigEndian.Uint32(trun[4:])
+
+	pos := 8
+	if flags&0x01 != 0 {
+		pos += 4
+	}
+
+	firstFlags := defaultFlags
+	if flags&0x04 != 0 {
+		if pos+4 > len(trun) {
+			return 0, firstFlags
+		}
+		firstFlags = binary.BigEndian.Uint32(trun[pos:])
+		pos += 4
+	}
+
+	sampleSize := 0
+	for _, field := range []uint32{0x100, 0x200, 0x400, 0x800} {
+		if flags&field != 0 {
+			sampleSize += 4
+		}
+	}
+
+	var ticks uint64
+	for i := uint32(0); i < count; i++ {
+		if pos+sampleSize > len(trun) {
+			break
+		}
+
+		field := pos
+		sampleDuration := defaultDuration
+		if flags&0x100 != 0 {
+			sampleDuration = binary.BigEndian.Uint32(trun[field:])
+			field += 4
+		}
+		if flags&0x200 != 0 {
+			field += 4
+		}
+		if flags&0x400 != 0 && i == 0 && flags&0x04 == 0 {
+			firstFlags = binary.BigEndian.Uint32(trun[field:])
+		}
+
+		ticks += uint64(sampleDuration)
+		pos += sampleSize
+	}
+
+	return ticks, firstFlags
+}
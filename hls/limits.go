@@ -0,0 +1,88 @@
+package hls
+
+import (
+	"context"
+	"errors"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+var ErrTooManyTranscodes = errors.New("too many transcodes")
+
+// acquire takes slot of every limit, in order, or none of them.
+func (m *ManagerCtx) acquire(ctx context.Context) error {
+	if err := utils.AcquireAll(ctx, m.config.Limits); err != nil {
+		return ErrTooManyTranscodes
+	}
+
+	return nil
+}
+
+func (m *ManagerCtx) releaseLimits() {
+	utils.ReleaseAll(m.config.Limits)
+}
+
+// admit waits up to limit timeout for slots of transcode, that is about
+// to be started. They are held until it stops.
+func (m *ManagerCtx) admit(ctx context.Context) error {
+	if len(m.config.Limits) == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	admitted := m.admitted || m.cmd != nil
+	m.mu.Unlock()
+
+	if admitted {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.config.LimitTimeout)
+	defer cancel()
+
+	if err := m.acquire(ctx); err != nil {
+		m.logger.Warn().Dur("timeout", m.config.LimitTimeout).Msg("transcode limit reached")
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// admitted or started concurrently
+	if m.admitted || m.cmd != nil {
+		m.releaseLimits()
+		return nil
+	}
+
+	m.admitted = true
+	return nil
+}
+
+// admitNow takes slots of transcode without waiting, unless they are
+// already held. Must be called with lock held.
+func (m *ManagerCtx) admitNow() error {
+	if m.admitted || len(m.config.Limits) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := m.acquire(ctx); err != nil {
+		m.logger.Warn().Msg("transcode limit reached")
+		return err
+	}
+
+	m.admitted = true
+	return nil
+}
+
+// release frees slots of transcode, must be called with lock held.
+func (m *ManagerCtx) release() {
+	if !m.admitted {
+		return
+	}
+
+	m.releaseLimits()
+	m.admitted = false
+}
@@ -0,0 +1,279 @@
+package hls
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how many latest segments advertise their partial segments
+const partSegments = 3
+
+var segmentNumberRegex = regexp.MustCompile(`^(.*?)([0-9]+)(\.[0-9A-Za-z]+)$`)
+
+var openRangeRegex = regexp.MustCompile(`^bytes=([0-9]+)-$`)
+
+// playlistState describes rendered playlist for blocking reloads.
+type playlistState struct {
+	// media sequence number of last complete segment
+	msn int
+	// complete parts of segment being written
+	parts   int
+	endlist bool
+}
+
+// satisfies reports whether playlist contains segment msn, or its part
+// when part is not negative.
+func (s playlistState) satisfies(msn int, part int) bool {
+	if s.endlist || s.msn >= msn {
+		return true
+	}
+
+	return part >= 0 && s.msn+1 == msn && s.parts > part
+}
+
+// nextSegmentURI guesses uri of segment following given one.
+func nextSegmentURI(uri string) (string, bool) {
+	match := segmentNumberRegex.FindStringSubmatch(uri)
+	if match == nil {
+		return "", false
+	}
+
+	number, err := strconv.Atoi(match[2])
+	if err != nil {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s%0*d%s", match[1], len(match[2]), number+1, match[3]), true
+}
+
+func (p *mediaPlaylist) headerInt(tag string) int {
+	for _, line := range p.header {
+		if strings.HasPrefix(line, tag+":") {
+			value, _ := strconv.Atoi(strings.TrimPrefix(line, tag+":"))
+			return value
+		}
+	}
+	return 0
+}
+
+// mapURI returns uri of init segment, if playlist uses fragmented mp4.
+func (p *mediaPlaylist) mapURI() (string, bool) {
+	lines := append([]string{}, p.header...)
+	if len(p.segments) > 0 {
+		lines = append(lines, p.segments[0].tags...)
+	}
+
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#EXT-X-MAP:") {
+			continue
+		}
+
+		for _, attr := range strings.Split(strings.TrimPrefix(line, "#EXT-X-MAP:"), ",") {
+			if strings.HasPrefix(attr, "URI=") {
+				return strings.Trim(strings.TrimPrefix(attr, "URI="), `"`), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func (p *mediaPlaylist) state() playlistState {
+	state := playlistState{
+		msn: p.headerInt("#EXT-X-MEDIA-SEQUENCE") + len(p.segments) - 1,
+	}
+
+	for _, line := range p.footer {
+		if line == "#EXT-X-ENDLIST" {
+			state.endlist = true
+		}
+	}
+
+	return state
+}
+
+// partCache keeps parts of segments by their path, so that segments are
+// scanned again only once their size or modification time changes.
+type partCache struct {
+	mu      sync.Mutex
+	entries map[string]partCacheEntry
+}
+
+type partCacheEntry struct {
+	size    int64
+	modTime time.Time
+	parts   []part
+}
+
+// scan returns parts of segment, from cache while segment is unchanged.
+func (c *partCache) scan(segmentPath string, tracks map[uint32]track) []part {
+	stat, err := os.Stat(segmentPath)
+	if err != nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[segmentPath]
+	c.mu.Unlock()
+
+	if ok && entry.size == stat.Size() && entry.modTime.Equal(stat.ModTime()) {
+		return entry.parts
+	}
+
+	// segment growing meanwhile is keyed by older size and scanned again
+	parts := scanParts(segmentPath, tracks)
+
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = map[string]partCacheEntry{}
+	}
+	c.entries[segmentPath] = partCacheEntry{stat.Size(), stat.ModTime(), parts}
+	c.mu.Unlock()
+
+	return parts
+}
+
+// retain drops segments, that are no longer advertised.
+func (c *partCache) retain(segmentPaths map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for segmentPath := range c.entries {
+		if !segmentPaths[segmentPath] {
+			delete(c.entries, segmentPath)
+		}
+	}
+}
+
+func partTags(uri string, parts []part) []string {
+	tags := make([]string, 0, len(parts))
+	for _, part := range parts {
+		tag := fmt.Sprintf(`#EXT-X-PART:DURATION=%.5f,URI="%s",BYTERANGE="%d@%d"`, part.duration, uri, part.length, part.offset)
+		if part.independent {
+			tag += ",INDEPENDENT=YES"
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// renderParts advertises partial segments of latest segments and of
+// segment being currently written, as byte ranges of fragmented mp4.
+func (m *ManagerCtx) renderParts(playlist string, tempdir string) (string, playlistState) {
+	p := parsePlaylist(playlist)
+	state := p.state()
+
+	mapURI, ok := p.mapURI()
+	if !ok || len(p.segments) == 0 {
+		return playlist, state
+	}
+
+	tracks := readTracks(path.Join(tempdir, path.Base(mapURI)))
+
+	from := len(p.segments) - partSegments
+	if from < 0 {
+		from = 0
+	}
+
+	scanned := map[string]bool{}
+	defer m.parts.retain(scanned)
+
+	for i := from; i < len(p.segments); i++ {
+		segment := &p.segments[i]
+		segmentPath := path.Join(tempdir, path.Base(segment.uri))
+		scanned[segmentPath] = true
+
+		parts := m.parts.scan(segmentPath, tracks)
+		if len(parts) == 0 {
+			continue
+		}
+
+		// parts precede EXTINF of their parent segment
+		tags := []string{}
+		for _, tag := range segment.tags {
+			if strings.HasPrefix(tag, "#EXTINF:") {
+				tags = append(tags, partTags(segment.uri, parts)...)
+			}
+			tags = append(tags, tag)
+		}
+		segment.tags = tags
+	}
+
+	if !state.endlist {
+		uri, ok := nextSegmentURI(p.segments[len(p.segments)-1].uri)
+		if ok {
+			segmentPath := path.Join(tempdir, path.Base(uri))
+			scanned[segmentPath] = true
+
+			parts := m.parts.scan(segmentPath, tracks)
+			p.footer = append(p.footer, partTags(uri, parts)...)
+			state.parts = len(parts)
+
+			// next part is expected right after the last complete one
+			start := int64(0)
+			if len(parts) > 0 {
+				last := parts[len(parts)-1]
+				start = last.offset + last.length
+			}
+			p.footer = append(p.footer, fmt.Sprintf(`#EXT-X-PRELOAD-HINT:TYPE=PART,URI="%s",BYTERANGE-START=%d`, uri, start))
+		}
+	}
+
+	p.header = append(p.header, fmt.Sprintf("#EXT-X-PART-INF:PART-TARGET=%.5f", m.config.PartDuration))
+	return p.String(), state
+}
+
+// openRangeStart returns start of open ended byte range, as requested
+// for hinted parts.
+func openRangeStart(r *http.Request) (int64, bool) {
+	match := openRangeRegex.FindStringSubmatch(r.Header.Get("Range"))
+	if match == nil {
+		return 0, false
+	}
+
+	start, err := strconv.ParseInt(match[1], 10, 64)
+	return start, err == nil
+}
+
+// waitForPart blocks until part starting at given offset is complete,
+// at most for segment duration.
+func (m *ManagerCtx) waitForPart(r *http.Request, segmentPath string, start int64) (part, bool) {
+	m.mu.Lock()
+	playlist, tempdir := m.playlist, m.tempdir
+	m.mu.Unlock()
+
+	mapURI, ok := parsePlaylist(playlist).mapURI()
+	if !ok {
+		return part{}, false
+	}
+	tracks := readTracks(path.Join(tempdir, path.Base(mapURI)))
+
+	timeout := time.NewTimer(time.Duration(m.config.SegmentDuration * float64(time.Second)))
+	defer timeout.Stop()
+
+	ticker := time.NewTicker(blockingReloadPeriod)
+	defer ticker.Stop()
+
+	for {
+		for _, p := range m.parts.scan(segmentPath, tracks) {
+			if p.offset == start {
+				return p, true
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return part{}, false
+		case <-timeout.C:
+			return part{}, false
+		case <-ticker.C:
+		}
+	}
+}
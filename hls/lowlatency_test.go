@@ -0,0 +1,337 @@
+package hls
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testBox(kind string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+
+	b := binary.BigEndian.AppendUint32(nil, uint32(size))
+	b = append(b, kind...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func testUint32s(values ...uint32) []byte {
+	b := []byte{}
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// testInit renders init segment with single video track.
+func testInit(id uint32, timescale uint32) []byte {
+	tkhd := testBox("tkhd", testUint32s(0, 0, 0, id, 0, 0))
+	mdhd := testBox("mdhd", testUint32s(0, 0, 0, timescale, 0, 0))
+	hdlr := testBox("hdlr", testUint32s(0, 0), []byte("vide"), testUint32s(0, 0, 0))
+	return testBox("moov", testBox("trak", tkhd, testBox("mdia", mdhd, hdlr)))
+}
+
+// testFragment renders moof and mdat boxes of samples with same duration.
+func testFragment(id uint32, samples uint32, duration uint32, sync bool) []byte {
+	flags := uint32(0)
+	if !sync {
+		// is_non_sync_sample
+		flags = 0x10000
+	}
+
+	// default sample duration and flags present
+	tfhd := testBox("tfhd", testUint32s(0x08|0x20, id, duration, flags))
+	trun := testBox("trun", testUint32s(0, samples))
+	moof := testBox("moof", testBox("traf", tfhd, trun))
+	return append(moof, testBox("mdat", make([]byte, 100))...)
+}
+
+func testFile(t *testing.T, path string, data ...[]byte) {
+	t.Helper()
+
+	var b []byte
+	for _, d := range data {
+		b = append(b, d...)
+	}
+
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanParts(t *testing.T) {
+	dir := t.TempDir()
+	testFile(t, filepath.Join(dir, "init.mp4"), testInit(1, 1000))
+
+	first := testFragment(1, 10, 50, true)
+	second := testFragment(1, 5, 40, false)
+	// fragment still being written is not a part yet
+	incomplete := testFragment(1, 5, 40, false)[:60]
+	testFile(t, filepath.Join(dir, "index0.m4s"), first, second, incomplete)
+
+	parts := scanParts(filepath.Join(dir, "index0.m4s"), readTracks(filepath.Join(dir, "init.mp4")))
+
+	want := []part{
+		{offset: 0, length: int64(len(first)), duration: 0.5, independent: true},
+		{offset: int64(len(first)), length: int64(len(second)), duration: 0.2, independent: false},
+	}
+
+	if len(parts) != len(want) {
+		t.Fatalf("got %d parts, want %d", len(parts), len(want))
+	}
+
+	for i := range want {
+		if parts[i] != want[i] {
+			t.Errorf("part %d: got %+v, want %+v", i, parts[i], want[i])
+		}
+	}
+}
+
+func TestReadBoxesLargeSize(t *testing.T) {
+	ftyp := testBox("ftyp", []byte("iso5"))
+
+	for _, size := range []uint64{math.MaxInt64 - 4, math.MaxInt64, math.MaxUint64} {
+		// 64-bit size of box exceeding data must not overflow its end
+		large := append(testUint32s(1), []byte("mdat")...)
+		large = append(large, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(large[8:], size)
+		data := append(append([]byte{}, ftyp...), append(large, make([]byte, 16)...)...)
+
+		boxes := readBoxes(data, 0)
+		if len(boxes) != 1 || boxes[0].kind != "ftyp" {
+			t.Errorf("size %d: got %d boxes, want only complete ftyp", size, len(boxes))
+		}
+	}
+}
+
+func TestRenderParts(t *testing.T) {
+	m := New(nil, Config{SegmentDuration: 1, LowLatency: true, PartDuration: 0.5})
+	dir := t.TempDir()
+
+	fragment := testFragment(1, 10, 50, true)
+	testFile(t, filepath.Join(dir, "init.mp4"), testInit(1, 1000))
+	testFile(t, filepath.Join(dir, "index0.m4s"), fragment, fragment)
+	testFile(t, filepath.Join(dir, "index1.m4s"), fragment)
+
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-MAP:URI=\"init.mp4\"\n" +
+		"#EXTINF:1.000000,\nindex0.m4s\n"
+
+	rendered, state := m.renderParts(playlist, dir)
+
+	length := len(fragment)
+	for _, tag := range []string{
+		`#EXT-X-PART:DURATION=0.50000,URI="index0.m4s",BYTERANGE="` + strconv.Itoa(length) + `@0",INDEPENDENT=YES`,
+		`#EXT-X-PART:DURATION=0.50000,URI="index0.m4s",BYTERANGE="` + strconv.Itoa(length) + `@` + strconv.Itoa(length) + `",INDEPENDENT=YES`,
+		`#EXT-X-PART:DURATION=0.50000,URI="index1.m4s",BYTERANGE="` + strconv.Itoa(length) + `@0",INDEPENDENT=YES`,
+		`#EXT-X-PRELOAD-HINT:TYPE=PART,URI="index1.m4s",BYTERANGE-START=` + strconv.Itoa(length),
+		`#EXT-X-PART-INF:PART-TARGET=0.50000`,
+	} {
+		if !strings.Contains(rendered, tag+"\n") {
+			t.Errorf("tag %q missing:\n%s", tag, rendered)
+		}
+	}
+
+	// parts precede their parent segment
+	if strings.Index(rendered, `URI="index0.m4s"`) > strings.Index(rendered, "#EXTINF:") {
+		t.Errorf("parts follow their segment:\n%s", rendered)
+	}
+
+	if want := (playlistState{msn: 0, parts: 1}); state != want {
+		t.Errorf("got state %+v, want %+v", state, want)
+	}
+}
+
+func TestPlaylistStateSatisfies(t *testing.T) {
+	state := playlistState{msn: 5, parts: 2}
+
+	tests := []struct {
+		msn  int
+		part int
+		want bool
+	}{
+		{5, -1, true},
+		{6, -1, false},
+		{6, 1, true},
+		{6, 2, false},
+		{7, 0, false},
+	}
+
+	for _, tt := range tests {
+		if got := state.satisfies(tt.msn, tt.part); got != tt.want {
+			t.Errorf("satisfies(%d, %d): got %v, want %v", tt.msn, tt.part, got, tt.want)
+		}
+	}
+
+	if !(playlistState{msn: 5, endlist: true}).satisfies(10, -1) {
+		t.Error("ended playlist must satisfy any request")
+	}
+}
+
+// testRunningManager returns manager serving given playlist, as if its
+// transcode was running.
+func testRunningManager(t *testing.T, config Config, playlist string) *ManagerCtx {
+	m := New(nil, config)
+	m.cmd = &exec.Cmd{}
+	m.tempdir = t.TempDir()
+	m.receive(playlist, 1, m.tempdir, nil, m.playlistLoad)
+	m.active = true
+	return m
+}
+
+func TestBlockingReload(t *testing.T) {
+	m := testRunningManager(t, Config{SegmentDuration: 1, LowLatency: true, ServerControl: ServerControl{CanBlockReload: true}}, testPlaylist(0, 3, false))
+
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		m.receive(testPlaylist(0, 4, false), 1, m.tempdir, nil, m.playlistLoad)
+	}()
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	m.ServePlaylist(rec, httptest.NewRequest(http.MethodGet, "/index.m3u8?_HLS_msn=3", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	if !strings.Contains(rec.Body.String(), "index3.ts") {
+		t.Errorf("requested segment missing:\n%s", rec.Body.String())
+	}
+
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("returned after %v, before segment was available", elapsed)
+	}
+
+	// already available segment is served right away
+	rec = httptest.NewRecorder()
+	m.ServePlaylist(rec, httptest.NewRequest(http.MethodGet, "/index.m3u8?_HLS_msn=1&_HLS_part=0", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestBlockingReloadNotAdvertised(t *testing.T) {
+	m := testRunningManager(t, Config{SegmentDuration: 1, LowLatency: true}, testPlaylist(0, 3, false))
+
+	// parameters are ignored, current playlist is served right away
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	m.ServePlaylist(rec, httptest.NewRequest(http.MethodGet, "/index.m3u8?_HLS_msn=3", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if elapsed := time.Since(start); elapsed >= blockingReloadPeriod {
+		t.Errorf("blocked for %v without CAN-BLOCK-RELOAD advertised", elapsed)
+	}
+	if strings.Contains(rec.Body.String(), "CAN-BLOCK-RELOAD") {
+		t.Errorf("got blocking reload advertised:\n%s", rec.Body.String())
+	}
+}
+
+func TestBlockingReloadInvalid(t *testing.T) {
+	m := testRunningManager(t, Config{SegmentDuration: 1, LowLatency: true, ServerControl: ServerControl{CanBlockReload: true}}, testPlaylist(0, 3, false))
+
+	for _, query := range []string{
+		// too far in future
+		"_HLS_msn=5",
+		"_HLS_msn=-1",
+		"_HLS_msn=x",
+		"_HLS_part=0",
+		"_HLS_msn=3&_HLS_part=-1",
+	} {
+		rec := httptest.NewRecorder()
+		m.ServePlaylist(rec, httptest.NewRequest(http.MethodGet, "/index.m3u8?"+query, nil))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestBlockingReloadCap(t *testing.T) {
+	m := testRunningManager(t, Config{SegmentDuration: 0.2, LowLatency: true, ServerControl: ServerControl{CanBlockReload: true}, MaxBlockingReloads: 2}, testPlaylist(0, 3, false))
+
+	held := func() int {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.blockingReloads
+	}
+
+	serve := func(ctx context.Context, msn int) chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			m.ServePlaylist(rec, httptest.NewRequest(http.MethodGet, "/index.m3u8?_HLS_msn="+strconv.Itoa(msn), nil).WithContext(ctx))
+			done <- rec
+		}()
+		return done
+	}
+
+	// client gone and deadline passed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := serve(ctx, 3)
+	timeouted := serve(context.Background(), 3)
+	if !waitFor(time.Second, func() bool { return held() == 2 }) {
+		t.Fatalf("got %d blocking reloads held, want 2", held())
+	}
+
+	// over the cap, current playlist is served right away
+	start := time.Now()
+	rec := <-serve(context.Background(), 3)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("over the cap answered after %v, want right away", elapsed)
+	}
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "index3.ts") {
+		t.Errorf("got status %d, want current playlist:\n%s", rec.Code, rec.Body.String())
+	}
+
+	cancel()
+	<-cancelled
+	if rec := <-timeouted; rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d after deadline", rec.Code, http.StatusServiceUnavailable)
+	}
+	if n := held(); n != 0 {
+		t.Fatalf("got %d blocking reloads held after cancel and deadline, want 0", n)
+	}
+
+	// satisfied by new segment
+	satisfied := serve(context.Background(), 3)
+	if !waitFor(time.Second, func() bool { return held() == 1 }) {
+		t.Fatal("blocking reload not held")
+	}
+	m.receive(testPlaylist(0, 4, false), 1, m.tempdir, nil, m.playlistLoad)
+	if rec := <-satisfied; rec.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d once satisfied", rec.Code, http.StatusOK)
+	}
+	if n := held(); n != 0 {
+		t.Fatalf("got %d blocking reloads held once satisfied, want 0", n)
+	}
+
+	// stream stopped while waiting
+	stopped := serve(context.Background(), 4)
+	if !waitFor(time.Second, func() bool { return held() == 1 }) {
+		t.Fatal("blocking reload not held")
+	}
+	m.Stop()
+	if rec := <-stopped; rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d once stopped", rec.Code, http.StatusNotFound)
+	}
+	if n := held(); n != 0 {
+		t.Errorf("got %d blocking reloads held once stopped, want 0", n)
+	}
+}
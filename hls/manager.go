@@ -2,11 +2,14 @@ package hls
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
@@ -14,150 +17,723 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"github.com/m1k1o/go-transcode/drm"
 	"github.com/m1k1o/go-transcode/internal/utils"
 )
 
-// how often should be cleanup called
-const cleanupPeriod = 4 * time.Second
-
-// timeot for first playlist, when it waits for new data
-const playlistTimeout = 20 * time.Second
-
-// minimum segments available to consider stream as active
-const hlsMinimumSegments = 2
+// defaults of manager config
+const (
+	defaultCleanupPeriod       = 4 * time.Second
+	defaultPlaylistTimeout     = 20 * time.Second
+	defaultMinimumSegments     = 2
+	defaultActiveIdleTimeout   = 12 * time.Second
+	defaultInactiveIdleTimeout = 24 * time.Second
+)
 
-// how long must be active stream idle to be considered as dead
-const activeIdleTimeout = 12 * time.Second
+// how often is playlist checked when blocking reload is requested
+const blockingReloadPeriod = 100 * time.Millisecond
 
-// how long must be iactive stream idle to be considered as dead
-const inactiveIdleTimeout = 24 * time.Second
+var ErrAlreadyStarted = errors.New("has already started")
 
 type ManagerCtx struct {
 	logger     zerolog.Logger
 	mu         sync.Mutex
-	cmdFactory func() *exec.Cmd
+	cmdFactory func() (*exec.Cmd, error)
+	config     Config
+	breaker    breaker
 	active     bool
 	events     struct {
 		onStart  func()
 		onCmdLog func(message string)
 		onStop   func()
+		onAlert  func(message string)
+		// called with lock held, except of playlist event
+		onPlaylist func(sequence int)
+		onRestart  func(reason string)
+		onError    func(message string)
 	}
 
-	cmd         *exec.Cmd
-	tempdir     string
+	cmd     *exec.Cmd
+	tempdir string
+	// last measured size of tempdir of running cmd
+	tempdirSize int64
+	startedAt   time.Time
 	lastRequest time.Time
 
+	// pending removal of tempdir after stop
+	removal   *time.Timer
+	stoppedAt time.Time
+
 	sequence int
 	playlist string
+	// rendered playlist, cleared on every update
+	rendered *renderedPlaylist
+	// parts of latest segments, rescanned once they change
+	parts partCache
+
+	playlistLoad chan struct{}
+	// playlist load of start, whose timeout was already recorded as
+	// failure, waiters of the same start do not record it again
+	playlistLoadTimedOut chan struct{}
+	shutdown             chan interface{}
+	// closed when stream did not warm up within cold start or startup
+	// timeout, all waiting clients are failed with its reason
+	coldStartFailed chan struct{}
+	coldStartReason string
+	// stopped after reaching max duration, must be started explicitly
+	expired bool
+	// pending stop after max duration, cancelled on stop
+	maxDuration *time.Timer
+	// stopped by watchdog, must be started explicitly
+	frozen bool
+
+	lastError *StreamError
+
+	uploader *uploader
+
+	// currently held blocking playlist reloads
+	blockingReloads int
 
-	playlistLoad chan string
-	shutdown     chan interface{}
+	// output switched to audio only after video decode errors
+	audioOnly   bool
+	videoErrors []time.Time
+	// joins playlist to previous run after switching output
+	continuity *continuity
+
+	// last playlist update and consecutive freezes seen by watchdog
+	lastUpdate time.Time
+	lastFreeze time.Time
+	freezes    int
+	// fallback source is used after repeated freezes
+	fallback bool
+	// source is transcoded with reconnect options after stall
+	reconnect bool
+	// backup source counted from 1, primary when zero
+	source int
+	// holds slots of transcode limits
+	admitted bool
+
+	// served bytes, nil when accounting is disabled
+	bandwidth *bandwidth
+
+	// encryption keys referenced by playlist, by their index
+	keys     map[int][]byte
+	keyIndex int
+
+	// consecutive exits of transcode restarted with backoff
+	exits int
+
+	// totals over manager lifetime
+	segments         int
+	playlistRequests int
+	restarts         int
+	// cpu seconds of exited transcodes
+	cpuSeconds float64
+
+	// last progress reported by transcode
+	progress    *Progress
+	progressCmd *exec.Cmd
 }
 
-func New(cmdFactory func() *exec.Cmd) *ManagerCtx {
-	return &ManagerCtx{
+func New(cmdFactory func() (*exec.Cmd, error), config Config) *ManagerCtx {
+	config.Manager = config.Manager.withDefaults()
+
+	m := &ManagerCtx{
 		logger:     log.With().Str("module", "hls").Str("submodule", "manager").Logger(),
 		cmdFactory: cmdFactory,
+		config:     config,
+		breaker: breaker{
+			threshold: config.BreakerThreshold,
+			cooldown:  config.BreakerCooldown,
+		},
 
-		playlistLoad: make(chan string),
-		shutdown:     make(chan interface{}),
+		playlistLoad:    make(chan struct{}),
+		shutdown:        make(chan interface{}),
+		coldStartFailed: make(chan struct{}),
 	}
+
+	if config.BandwidthWindow > 0 {
+		m.bandwidth = newBandwidth(config.BandwidthWindow)
+	}
+
+	if config.Store != nil {
+		m.uploader = newUploader(m, config.Store, config.StoreWorkers)
+	}
+
+	return m
 }
 
 func (m *ManagerCtx) Start() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.exits = 0
+	return m.start()
+}
+
+// Restart stops running transcode and starts new one, continuing its
+// playlist. Stopped stream is just started.
+func (m *ManagerCtx) Restart() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil {
+		return m.start()
+	}
+
+	return m.restart("requested", false, false)
+}
+
+// start must be called with lock held.
+func (m *ManagerCtx) start() error {
 	if m.cmd != nil {
-		return errors.New("has already started")
+		return ErrAlreadyStarted
 	}
 
+	if !m.breaker.allow() {
+		return ErrCircuitOpen
+	}
+
+	if !m.config.DiskBudget.allow(m.tempdir) {
+		m.logger.Warn().Int64("usage", m.config.DiskBudget.Usage()).Msg("disk budget of tempdirs exceeded")
+		return ErrDiskBudgetExceeded
+	}
+
+	if err := m.admitNow(); err != nil {
+		return err
+	}
+
+	if err := m.launch(); err != nil {
+		m.release()
+		return err
+	}
+
+	return nil
+}
+
+// launch starts admitted transcode, must be called with lock held.
+func (m *ManagerCtx) launch() error {
+
 	m.logger.Debug().Msg("performing start")
 
-	var err error
-	m.tempdir, err = os.MkdirTemp("", "go-transcode-hls")
+	m.probePrimary()
+
+	cmdFactory := m.cmdFactory
+	if m.fallback && m.config.FallbackCmd != nil {
+		cmdFactory = m.config.FallbackCmd
+	} else if m.source > 0 {
+		cmdFactory = m.config.BackupCmds[m.source-1]
+	} else if m.reconnect && m.config.ReconnectCmd != nil {
+		cmdFactory = m.config.ReconnectCmd
+	}
+
+	cmd, err := cmdFactory()
 	if err != nil {
+		// exhausted resources are not failure of stream
+		if !errors.Is(err, ErrTooManyTranscodes) {
+			m.failure(err)
+		}
+		return err
+	}
+
+	tempdir, reused, err := m.prepareTempDir()
+	if err != nil {
+		m.discard(cmd)
+		m.lastError = &StreamError{err.Error(), time.Now()}
 		return err
 	}
 
-	m.cmd = m.cmdFactory()
-	m.cmd.Dir = m.tempdir
+	if m.encrypted() {
+		if err := m.rotateKey(tempdir); err != nil {
+			m.discard(cmd)
+			m.failure(err)
+			os.RemoveAll(tempdir)
+			return err
+		}
+		m.keyEnv(cmd)
+	}
 
+	if m.memory() {
+		if err := m.memoryEnv(cmd, tempdir); err != nil {
+			m.discard(cmd)
+			m.failure(err)
+			os.RemoveAll(tempdir)
+			return err
+		}
+	}
+
+	if m.dvr() {
+		m.dvrEnv(cmd)
+	}
+
+	cmd.Dir = tempdir
+	m.outputEnv(cmd)
+
+	progressRead, progressWrite, err := m.progressPipe(cmd)
+	if err != nil {
+		m.discard(cmd)
+		m.failure(err)
+		m.removeTempDir(tempdir)
+		return err
+	}
+
+	utils.ExpandArgs(cmd)
+
+	var stderr io.Writer
 	if m.events.onCmdLog != nil {
-		m.cmd.Stderr = utils.LogEvent(m.events.onCmdLog)
+		stderr = utils.LogEvent(m.events.onCmdLog)
 	} else {
-		m.cmd.Stderr = utils.LogWriter(m.logger)
+		stderr = utils.LogWriter(m.logger)
+	}
+
+	if m.config.CmdLog != nil {
+		stderr = io.MultiWriter(stderr, m.config.CmdLog)
+	}
+
+	if m.config.AudioOnlyThreshold > 0 {
+		stderr = io.MultiWriter(stderr, utils.LogEvent(func(message string) {
+			m.videoLog(cmd, message)
+		}))
 	}
 
-	read, write := io.Pipe()
-	m.cmd.Stdout = write
+	// releases everything taken for transcode, that is not going to run
+	abort := func(err error) error {
+		m.discard(cmd)
+		m.failure(err)
+		if progressRead != nil {
+			progressRead.Close()
+			progressWrite.Close()
+		}
+		m.removeTempDir(tempdir)
+		return err
+	}
+
+	// output and log are passed to process directly, so that Wait returns
+	// once it exits, even if its children keep them open
+	stderrWrite, err := logPipe(stderr)
+	if err != nil {
+		return abort(err)
+	}
+	cmd.Stderr = stderrWrite
+
+	read, write, err := os.Pipe()
+	if err != nil {
+		stderrWrite.Close()
+		return abort(err)
+	}
+	cmd.Stdout = write
 
 	//create a new process group
-	m.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: !m.config.SingleProcess}
 
-	m.active = false
-	m.lastRequest = time.Now()
+	err = cmd.Start()
+
+	// readers receive EOF once every process holding pipes exits
+	write.Close()
+	stderrWrite.Close()
+
+	if err != nil {
+		read.Close()
+		return abort(err)
+	}
 
-	m.sequence = 0
-	m.playlist = ""
+	if err := m.config.ProcessLimits.Apply(cmd.Process.Pid); err != nil {
+		m.logger.Warn().Err(err).Msg("unable to apply process limits")
+	}
 
-	m.playlistLoad = make(chan string)
+	// reader receives EOF once cmd exits
+	if progressRead != nil {
+		progressWrite.Close()
+		go m.readProgress(cmd, progressRead)
+	}
+
+	m.cmd = cmd
+	m.tempdir = tempdir
+	m.startedAt = time.Now()
+	m.lastRequest = time.Now()
+	m.lastUpdate = time.Now()
+
+	m.playlistLoad = make(chan struct{})
 	m.shutdown = make(chan interface{})
+	m.coldStartFailed = make(chan struct{})
+	m.coldStartReason = ""
+
+	// warm segments of previous run are served until new playlist arrives
+	if reused {
+		m.logger.Info().Str("tempdir", tempdir).Msg("reusing tempdir of previous run")
+		if m.active {
+			close(m.playlistLoad)
+		}
+	} else {
+		m.active = false
+		m.sequence = 0
+		m.playlist = ""
+		m.rendered = nil
+	}
+
+	readDone := make(chan struct{})
+	shutdown := m.shutdown
+	playlistLoad := m.playlistLoad
+	continuity := m.continuity
+
+	// video is tried again after a while
+	if m.audioOnly && m.config.AudioOnlyPeriod > 0 {
+		time.AfterFunc(m.config.AudioOnlyPeriod, func() {
+			m.switchOutput(cmd, false)
+		})
+	}
+
+	m.expired = false
+	m.frozen = false
+	if m.config.MaxDuration > 0 {
+		m.maxDuration = time.AfterFunc(m.config.MaxDuration, func() {
+			m.maxDurationReached(cmd)
+		})
+	}
+
+	if !m.active && m.config.StartupTimeout > 0 {
+		time.AfterFunc(m.config.StartupTimeout, func() {
+			m.startupTimeout(cmd)
+		})
+	}
+
+	if !m.active && m.config.ColdStartTimeout > 0 {
+		time.AfterFunc(m.config.ColdStartTimeout, func() {
+			m.coldStartTimeout(cmd)
+		})
+	}
 
 	go func() {
-		buf := make([]byte, 1024)
+		defer close(readDone)
+
+		buf := make([]byte, 32*1024)
+
+		// playlist updates may be split across reads or share one
+		var framer playlistFramer
 
 		for {
 			n, err := read.Read(buf)
 			if n != 0 {
-				m.playlist = string(buf[:n])
-				m.sequence = m.sequence + 1
-
-				m.logger.Info().
-					Int("sequence", m.sequence).
-					Str("playlist", m.playlist).
-					Msg("received playlist")
-
-				if m.sequence == hlsMinimumSegments {
-					m.active = true
-					m.playlistLoad <- m.playlist
-					close(m.playlistLoad)
+				if chunk, updates, ok := framer.write(string(buf[:n])); ok {
+					m.receive(chunk, updates, tempdir, continuity, playlistLoad)
 				}
 			}
 
 			if err != nil {
-				m.logger.Err(err).Msg("cmd read failed")
+				// pipe is closed on stop or once cmd exits, neither is a failure
+				if errors.Is(err, io.EOF) || errors.Is(err, os.ErrClosed) {
+					m.logger.Debug().Err(err).Msg("cmd output closed")
+				} else {
+					m.logger.Err(err).Msg("cmd read failed")
+				}
 				return
 			}
 		}
 	}()
 
 	go func() {
-		ticker := time.NewTicker(cleanupPeriod)
+		ticker := time.NewTicker(m.config.Manager.CleanupPeriod)
 		defer ticker.Stop()
 
+		// frozen stream is detected within quarter of freeze timeout
+		var freeze <-chan time.Time
+		if m.config.Watchdog.FreezeTimeout > 0 {
+			freezeTicker := time.NewTicker(m.config.Watchdog.FreezeTimeout / 4)
+			defer freezeTicker.Stop()
+			freeze = freezeTicker.C
+		}
+
+		// stalled stream is detected within quarter of stall timeout
+		var stall <-chan time.Time
+		if m.config.Watchdog.StallTimeout > 0 {
+			stallTicker := time.NewTicker(m.config.Watchdog.StallTimeout / 4)
+			defer stallTicker.Stop()
+			stall = stallTicker.C
+		}
+
+		var rotation <-chan time.Time
+		if m.encrypted() {
+			rotationTicker := time.NewTicker(m.config.Encryption.KeyRotation)
+			defer rotationTicker.Stop()
+			rotation = rotationTicker.C
+		}
+
 		for {
 			select {
-			case <-m.shutdown:
-				write.Close()
+			case <-shutdown:
+				read.Close()
 				return
 			case <-ticker.C:
 				m.Cleanup()
+				m.checkTempDir(cmd)
+			case <-freeze:
+				m.checkFreeze(cmd)
+			case <-stall:
+				m.checkStall(cmd)
+			case <-rotation:
+				m.rotate(cmd)
 			}
 		}
 	}()
 
+	// abr profiles write playlists into tempdir
+	if m.config.ABR {
+		go m.watchVariants(cmd, tempdir, shutdown, playlistLoad)
+	}
+
+	if m.source > 0 && m.config.ProbePrimary != nil && m.config.FailbackPeriod > 0 {
+		go m.watchPrimary(cmd, shutdown)
+	}
+
+	go m.drain(cmd, read, readDone)
+
 	if m.events.onStart != nil {
 		m.events.onStart()
 	}
 
-	return m.cmd.Start()
+	return nil
+}
+
+// receive records playlist received from output of transcode started in
+// tempdir, updates counts new segments. Stream is activated once it has
+// enough of them, by closing playlist load of its start.
+func (m *ManagerCtx) receive(chunk string, updates int, tempdir string, continuity *continuity, playlistLoad chan struct{}) {
+	if continuity != nil {
+		chunk = continuity.apply(chunk)
+	}
+
+	if m.config.MaxSegments > 0 || m.config.MaxPlaylistSize > 0 {
+		playlist := parsePlaylist(chunk)
+		if dropped := playlist.trim(m.config.MaxSegments, m.config.MaxPlaylistSize); dropped > 0 {
+			m.logger.Warn().
+				Int("dropped", dropped).
+				Int("size", len(chunk)).
+				Msg("playlist exceeded limits, trimmed to live window")
+			chunk = playlist.String()
+		}
+	}
+
+	if m.uploader != nil {
+		m.uploader.enqueue(chunk, tempdir)
+	}
+
+	m.mu.Lock()
+	m.playlist = chunk
+	m.rendered = nil
+	m.lastUpdate = time.Now()
+	m.sequence = m.sequence + updates
+	m.segments += updates
+
+	// dvr playlists carry whole window, logged only when debugging
+	m.logger.Info().
+		Int("sequence", m.sequence).
+		Int("size", len(m.playlist)).
+		Msg("received playlist")
+	m.logger.Debug().
+		Str("playlist", m.playlist).
+		Msg("received playlist content")
+
+	// activate only once, even if sequence skipped past threshold
+	activate := !m.active && m.sequence >= m.config.Manager.MinimumSegments
+	if activate {
+		m.active = true
+		m.breaker.success()
+		m.lastError = nil
+	}
+	sequence := m.sequence
+	m.mu.Unlock()
+
+	if activate {
+		close(playlistLoad)
+	}
+
+	if m.events.onPlaylist != nil && updates > 0 {
+		m.events.onPlaylist(sequence)
+	}
+}
+
+// logPipe returns write end of pipe, whose output is copied to w until
+// every process holding it exits. Unlike writer set as output of cmd, it
+// is not awaited by Wait.
+func logPipe(w io.Writer) (*os.File, error) {
+	read, write, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer read.Close()
+		//nolint
+		io.Copy(w, read)
+	}()
+
+	return write, nil
+}
+
+// discard releases input of cmd, that is not going to run, e.g.
+// subscription of rtmp ingest, and calls its exit hook.
+func (m *ManagerCtx) discard(cmd *exec.Cmd) {
+	if closer, ok := cmd.Stdin.(io.Closer); ok {
+		closer.Close()
+	}
+	m.cmdExited(cmd)
+}
+
+// cmdExited calls exit hook of cmd, that exited or failed to start.
+func (m *ManagerCtx) cmdExited(cmd *exec.Cmd) {
+	if m.config.Exited != nil {
+		m.config.Exited(cmd)
+	}
+}
+
+// drain waits for cmd to exit and lets reader consume remaining output,
+// so that the final playlist (e.g. with endlist) is recorded. Output kept
+// open by children of cmd is closed after exit grace.
+func (m *ManagerCtx) drain(cmd *exec.Cmd, read *os.File, readDone <-chan struct{}) {
+	err := cmd.Wait()
+	m.logger.Info().Err(err).Msg("cmd exited")
+	m.cmdExited(cmd)
+
+	if cmd.ProcessState != nil {
+		m.mu.Lock()
+		m.cpuSeconds += (cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()).Seconds()
+		m.mu.Unlock()
+	}
+
+	select {
+	case <-readDone:
+	case <-time.After(m.config.ExitGrace):
+		m.logger.Warn().Dur("grace", m.config.ExitGrace).Msg("reader did not finish within exit grace")
+		read.Close()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// stopped or replaced
+	if m.cmd != cmd {
+		return
+	}
+
+	// exited on its own before warming up
+	if !m.active {
+		if err == nil {
+			err = errors.New("exited before warming up")
+		}
+		m.failure(err)
+	}
+
+	m.exited(cmd)
+}
+
+// coldStartTimeout fails all clients waiting for stream warm up
+// together and tears down stillborn process.
+func (m *ManagerCtx) coldStartTimeout(cmd *exec.Cmd) {
+	m.mu.Lock()
+	if m.cmd != cmd || m.active {
+		m.mu.Unlock()
+		return
+	}
+
+	m.logger.Warn().Dur("timeout", m.config.ColdStartTimeout).Msg("stream did not warm up within cold start timeout")
+	m.failure(errors.New("cold start timeout"))
+	m.failWaiters("cold start timeout")
+	m.mu.Unlock()
+
+	m.Stop()
+}
+
+// failWaiters fails all clients waiting for stream warm up with reason,
+// must be called with lock held.
+func (m *ManagerCtx) failWaiters(reason string) {
+	select {
+	case <-m.coldStartFailed:
+	default:
+		m.coldStartReason = reason
+		close(m.coldStartFailed)
+	}
+}
+
+// serveColdStartFailed answers client, whose stream failed to warm up.
+func (m *ManagerCtx) serveColdStartFailed(w http.ResponseWriter) {
+	m.mu.Lock()
+	reason := m.coldStartReason
+	m.mu.Unlock()
+
+	m.retryAfter(w)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte("503 " + reason))
+}
+
+// startupTimeout tears down process that was launched, but did not
+// produce any segments.
+func (m *ManagerCtx) startupTimeout(cmd *exec.Cmd) {
+	m.mu.Lock()
+	if m.cmd != cmd || m.active {
+		m.mu.Unlock()
+		return
+	}
+
+	m.logger.Warn().Dur("timeout", m.config.StartupTimeout).Msg("stream did not produce segments within startup timeout")
+	m.failure(fmt.Errorf("no segments produced within startup timeout %s", m.config.StartupTimeout))
+
+	if m.failover("startup timeout") {
+		if err := m.restart("startup timeout, using backup source", m.audioOnly, m.fallback); err != nil {
+			m.logger.Warn().Err(err).Msg("transcode could not be restarted")
+		}
+		m.mu.Unlock()
+		return
+	}
+	m.failWaiters("startup timeout")
+	m.mu.Unlock()
+
+	m.Stop()
+}
+
+func (m *ManagerCtx) maxDurationReached(cmd *exec.Cmd) {
+	m.mu.Lock()
+	if m.cmd != cmd {
+		m.mu.Unlock()
+		return
+	}
+
+	m.logger.Info().Dur("max_duration", m.config.MaxDuration).Msg("stream reached max duration")
+	m.expired = true
+	m.mu.Unlock()
+
+	m.Stop()
 }
 
 func (m *ManagerCtx) Stop() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.exits = 0
+	m.stop()
+}
+
+// Shutdown stops transcode and removes its tempdir right away, instead of
+// after delay, unless it is stable. Manager must not be used afterwards.
+func (m *ManagerCtx) Shutdown() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stop()
+
+	if m.removal != nil {
+		m.removal.Stop()
+		m.removal = nil
+	}
+
+	if m.config.TempDir == "" && m.tempdir != "" {
+		m.removeTempDir(m.tempdir)
+	}
+}
+
+// stop must be called with lock held.
+func (m *ManagerCtx) stop() {
+	m.release()
+
 	if m.cmd == nil {
 		return
 	}
@@ -165,7 +741,20 @@ func (m *ManagerCtx) Stop() {
 	m.logger.Debug().Msg("performing stop")
 	close(m.shutdown)
 
-	if m.cmd.Process != nil {
+	if m.maxDuration != nil {
+		m.maxDuration.Stop()
+		m.maxDuration = nil
+	}
+
+	if m.config.WarmPool != nil {
+		m.config.WarmPool.remove(m)
+	}
+
+	if m.cmd.Process != nil && m.config.SingleProcess {
+		err := m.cmd.Process.Kill()
+		m.logger.Err(err).Msg("killing proccess")
+		m.cmd = nil
+	} else if m.cmd.Process != nil {
 		pgid, err := syscall.Getpgid(m.cmd.Process.Pid)
 		if err == nil {
 			err := syscall.Kill(-pgid, syscall.SIGKILL)
@@ -178,22 +767,112 @@ func (m *ManagerCtx) Stop() {
 		m.cmd = nil
 	}
 
-	time.AfterFunc(2*time.Second, func() {
-		err := os.RemoveAll(m.tempdir)
-		m.logger.Err(err).Msg("removing tempdir")
-	})
+	m.stoppedAt = time.Now()
+
+	m.audioOnly = false
+	m.fallback = false
+	m.reconnect = false
+	m.source = 0
+	m.videoErrors = nil
+	m.continuity = nil
+	m.tempdirSize = 0
+
+	// stable tempdir is kept for external tools until next start
+	if m.config.TempDir == "" {
+		delay := 2 * time.Second
+		if m.config.RestartGrace > delay {
+			delay = m.config.RestartGrace
+		}
+
+		// cancelled by start reusing tempdir
+		tempdir := m.tempdir
+		m.removal = time.AfterFunc(delay, func() {
+			m.removeTempDir(tempdir)
+		})
+	}
 
 	if m.events.onStop != nil {
 		m.events.onStop()
 	}
 }
 
+// restart replaces running cmd, continuing previous playlist after
+// discontinuity. Must be called with lock held.
+func (m *ManagerCtx) restart(reason string, audioOnly bool, fallback bool) error {
+	m.restarts++
+
+	if m.events.onRestart != nil {
+		m.events.onRestart(reason)
+	}
+
+	c := &continuity{
+		last:            parsePlaylist(m.playlist).state().msn,
+		discontinuities: 1,
+	}
+	if m.continuity != nil {
+		c.discontinuities = m.continuity.discontinuities + 1
+	}
+
+	// source and its reconnect options are kept until stream stops, as
+	// well as slots of transcode limits
+	reconnect, source, admitted := m.reconnect, m.source, m.admitted
+	m.admitted = false
+	m.stop()
+	m.admitted = admitted
+
+	m.audioOnly = audioOnly
+	m.fallback = fallback
+	m.reconnect = reconnect
+	m.source = source
+	m.continuity = c
+
+	return m.start()
+}
+
+// Pause suspends transcode process group until resumed.
+func (m *ManagerCtx) Pause() {
+	m.signal(syscall.SIGSTOP)
+}
+
+func (m *ManagerCtx) Resume() {
+	m.signal(syscall.SIGCONT)
+}
+
+func (m *ManagerCtx) signal(sig syscall.Signal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil || m.cmd.Process == nil {
+		return
+	}
+
+	if m.config.SingleProcess {
+		err := m.cmd.Process.Signal(sig)
+		m.logger.Err(err).Str("signal", sig.String()).Msg("signaling proccess")
+		return
+	}
+
+	pgid, err := syscall.Getpgid(m.cmd.Process.Pid)
+	if err != nil {
+		m.logger.Err(err).Msg("could not get proccess group id")
+		return
+	}
+
+	err = syscall.Kill(-pgid, sig)
+	m.logger.Err(err).Str("signal", sig.String()).Msg("signaling proccess group")
+}
+
 func (m *ManagerCtx) Cleanup() {
 	m.mu.Lock()
 	diff := time.Since(m.lastRequest)
-	stop := m.active && diff > activeIdleTimeout || !m.active && diff > inactiveIdleTimeout
+	stop := m.active && diff > m.config.Manager.ActiveIdleTimeout || !m.active && diff > m.config.Manager.InactiveIdleTimeout
+	stop = stop && !m.config.KeepAlive
 	m.mu.Unlock()
 
+	if stop && m.config.WarmPool != nil && m.config.WarmPool.contains(m) {
+		stop = false
+	}
+
 	m.logger.Debug().
 		Time("last_request", m.lastRequest).
 		Dur("diff", diff).
@@ -206,15 +885,231 @@ func (m *ManagerCtx) Cleanup() {
 	}
 }
 
+func (m *ManagerCtx) Active() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.cmd != nil && m.active
+}
+
+// failure records failed start, must be called with lock held.
+func (m *ManagerCtx) failure(err error) {
+	m.breaker.failure()
+	m.lastError = &StreamError{err.Error(), time.Now()}
+
+	if m.events.onError != nil {
+		m.events.onError(err.Error())
+	}
+}
+
+func (m *ManagerCtx) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := Stats{
+		Running:   m.cmd != nil,
+		Active:    m.cmd != nil && m.active,
+		Sequence:  m.sequence,
+		LastError: m.lastError,
+		Expired:   m.expired,
+		Frozen:    m.frozen,
+		Freezes:   m.freezes,
+		Source:    m.source,
+		AudioOnly: m.audioOnly,
+
+		Segments:         m.segments,
+		PlaylistRequests: m.playlistRequests,
+		Restarts:         m.restarts,
+		CPUSeconds:       m.cpuSeconds,
+	}
+
+	if m.cmd != nil && m.cmd.Process != nil {
+		stats.Pid = m.cmd.Process.Pid
+		stats.CPUSeconds += processCPU(stats.Pid)
+	}
+
+	if m.cmd != nil && m.progressCmd == m.cmd {
+		progress := *m.progress
+		stats.Progress = &progress
+	}
+
+	if m.cmd != nil {
+		stats.TempDirSize = m.tempdirSize
+	}
+
+	if m.cmd != nil {
+		stats.Uptime = time.Since(m.startedAt).Seconds()
+		lastUpdate := m.lastUpdate
+		stats.LastUpdate = &lastUpdate
+	}
+
+	if !m.lastRequest.IsZero() {
+		lastRequest := m.lastRequest
+		stats.LastRequest = &lastRequest
+	}
+
+	if m.bandwidth != nil {
+		bandwidth := m.bandwidth.stats()
+		stats.Bandwidth = &bandwidth
+	}
+
+	return stats
+}
+
+// Segments lists segments of current playlist, reports false when
+// stream is not active.
+func (m *ManagerCtx) Segments() ([]Segment, bool) {
+	m.mu.Lock()
+	active := m.cmd != nil && m.active
+	playlist, tempdir := m.playlist, m.tempdir
+	m.mu.Unlock()
+
+	if !active {
+		return nil, false
+	}
+
+	p := parsePlaylist(playlist)
+	sequence := p.headerInt("#EXT-X-MEDIA-SEQUENCE")
+
+	segments := make([]Segment, 0, len(p.segments))
+	for i, s := range p.segments {
+		segment := Segment{
+			Name:     s.uri,
+			Duration: s.duration(),
+			Sequence: sequence + i,
+		}
+
+		// segment might have been already removed by muxer
+		if file, ok := m.memoryFile(tempdir, s.uri); ok {
+			segment.ModTime = file.modTime
+			segment.Size = int64(len(file.data))
+		} else if info, err := os.Stat(path.Join(tempdir, path.Base(s.uri))); err == nil {
+			segment.ModTime = info.ModTime()
+			segment.Size = info.Size()
+		}
+
+		segments = append(segments, segment)
+	}
+
+	return segments, true
+}
+
+// ReadSegment returns contents of segment of current playlist, as listed by
+// Segments.
+func (m *ManagerCtx) ReadSegment(name string) ([]byte, error) {
+	m.mu.Lock()
+	tempdir := m.tempdir
+	m.mu.Unlock()
+
+	if file, ok := m.memoryFile(tempdir, name); ok {
+		return file.data, nil
+	}
+
+	return ioutil.ReadFile(path.Join(tempdir, path.Base(name)))
+}
+
+// Pid returns transcode process id, or zero when not running.
+func (m *ManagerCtx) Pid() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil || m.cmd.Process == nil {
+		return 0
+	}
+
+	return m.cmd.Process.Pid
+}
+
 func (m *ManagerCtx) ServePlaylist(w http.ResponseWriter, r *http.Request) {
+	if !m.verifySigned(w, r) {
+		return
+	}
+
+	rewind, err := rewindParams(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 invalid rewind"))
+		return
+	}
+
+	head := r.Method == http.MethodHead
+
 	m.mu.Lock()
-	m.lastRequest = time.Now()
+	// availability checks do not keep stream alive
+	if !head {
+		m.lastRequest = time.Now()
+		m.playlistRequests++
+	}
+	running, ready, expired, frozen := m.cmd != nil, m.cmd != nil && m.active, m.expired, m.frozen
 	m.mu.Unlock()
 
-	playlist := m.playlist
+	if !head && m.config.WarmPool != nil {
+		m.config.WarmPool.touch(m)
+	}
+
+	if !head && m.config.Viewers != nil {
+		m.config.Viewers.touch(r)
+	}
+
+	// availability checks do not cause cold start, unless configured
+	if head && !ready && !m.config.HeadColdStart {
+		w.Header().Set("Content-Type", m.config.mimeType("index.m3u8"))
+		w.Header().Set("Cache-Control", "no-cache")
+		m.retryAfter(w)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if !running && expired {
+		m.logger.Debug().Msg("transcode reached max duration and must be started explicitly")
+		m.retryAfter(w)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 stream reached max duration"))
+		return
+	}
+
+	if !running && frozen {
+		m.logger.Debug().Msg("transcode stopped by watchdog and must be started explicitly")
+		m.retryAfter(w)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 stream stopped by watchdog"))
+		return
+	}
+
+	if !running && m.config.ExplicitStart {
+		m.logger.Debug().Msg("transcode not running and must be started explicitly")
+		m.retryAfter(w)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 stream not running"))
+		return
+	}
+
+	if !running {
+		err := m.admit(r.Context())
+		if err == nil {
+			err = m.Start()
+		}
+
+		// concurrent cold starts wait for the same process
+		if errors.Is(err, ErrAlreadyStarted) {
+			err = nil
+		}
+
+		if errors.Is(err, ErrTooManyTranscodes) {
+			m.retryAfter(w)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("503 too many transcodes"))
+			return
+		}
+
+		if errors.Is(err, ErrCircuitOpen) {
+			m.logger.Debug().Msg("transcode start short-circuited")
+			m.retryAfter(w)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("503 stream unavailable"))
+			return
+		}
 
-	if m.cmd == nil {
-		err := m.Start()
 		if err != nil {
 			m.logger.Warn().Err(err).Msg("transcode could not be started")
 			w.WriteHeader(http.StatusInternalServerError)
@@ -223,45 +1118,302 @@ func (m *ManagerCtx) ServePlaylist(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if !m.active {
+	m.mu.Lock()
+	active, playlistLoad, shutdown, coldStartFailed := m.active, m.playlistLoad, m.shutdown, m.coldStartFailed
+	m.mu.Unlock()
+
+	if !active {
 		select {
-		case playlist = <-m.playlistLoad:
-		case <-m.shutdown:
+		case <-playlistLoad:
+		case <-coldStartFailed:
+			m.serveColdStartFailed(w)
+			return
+		case <-shutdown:
+			// shutdown caused by cold start or startup timeout
+			select {
+			case <-coldStartFailed:
+				m.serveColdStartFailed(w)
+				return
+			default:
+			}
+
 			m.logger.Warn().Msg("playlist load failed because of shutdown")
 			w.WriteHeader(http.StatusNotFound)
 			w.Write([]byte("404 playlist not found"))
 			return
-		case <-time.After(playlistTimeout):
+		case <-time.After(m.config.Manager.PlaylistTimeout):
 			m.logger.Warn().Msg("playlist load channel timeouted")
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("500 not available"))
+
+			m.mu.Lock()
+			if m.playlistLoadTimedOut != playlistLoad {
+				m.playlistLoadTimedOut = playlistLoad
+				m.failure(errors.New("playlist load timeout"))
+			}
+			open := !m.breaker.allow()
+			m.mu.Unlock()
+
+			// do not keep stillborn transcode running
+			if open {
+				m.Stop()
+			}
+			return
+		}
+	}
+
+	playlist, state := m.render()
+
+	// blocking playlist reload, only when advertised
+	if msn, part, ok, err := blockingReloadParams(r); m.config.LowLatency && m.config.ServerControl.CanBlockReload && ok && !head {
+		if err != nil || msn > state.msn+2 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid blocking reload parameters"))
 			return
 		}
+
+		// over the cap, current playlist is served right away
+		blocking := m.holdBlockingReload()
+		if blocking {
+			defer m.releaseBlockingReload()
+		} else {
+			m.logger.Debug().Int("max", m.config.MaxBlockingReloads).Msg("too many blocking reloads held, not blocking")
+		}
+
+		deadline := time.After(time.Duration(3 * m.config.SegmentDuration * float64(time.Second)))
+
+		for blocking && !state.satisfies(msn, part) {
+			select {
+			case <-time.After(blockingReloadPeriod):
+				playlist, state = m.render()
+			case <-r.Context().Done():
+				return
+			case <-shutdown:
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("404 playlist not found"))
+				return
+			case <-deadline:
+				m.retryAfter(w)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("503 blocking reload timeouted"))
+				return
+			}
+		}
+	}
+
+	if rewind > 0 && !m.config.ABR {
+		playlist = playlistRewind(playlist, rewind)
+	}
+
+	// relative key requests of viewer carry its session
+	if session := r.URL.Query().Get(viewerSessionParam); session != "" && m.encrypted() {
+		playlist = playlistKeySession(playlist, session)
+	}
+
+	if m.config.URIQuery != "" {
+		playlist = playlistAppendQuery(playlist, m.config.URIQuery)
 	}
 
-	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	playlist = m.signPlaylist(playlist, r)
+
+	w.Header().Set("Content-Type", m.config.mimeType("index.m3u8"))
 	w.Header().Set("Cache-Control", "no-cache")
-	w.Write([]byte(playlist))
+	w.Header().Set("Content-Length", strconv.Itoa(len(playlist)))
+
+	if head {
+		return
+	}
+
+	m.accounted(w).Write([]byte(playlist))
+}
+
+// render prepares current playlist to be served.
+type renderedPlaylist struct {
+	playlist string
+	state    playlistState
+}
+
+func (m *ManagerCtx) render() (string, playlistState) {
+	cache := m.config.PlaylistCache && !m.config.LowLatency
+
+	m.mu.Lock()
+	playlist, tempdir, rendered := m.playlist, m.tempdir, m.rendered
+	m.mu.Unlock()
+
+	if cache && rendered != nil {
+		return rendered.playlist, rendered.state
+	}
+
+	source := playlist
+
+	state := parsePlaylist(playlist).state()
+
+	// master playlist is served as written by profile, signaling content
+	// protection for all of its variants
+	if m.config.ABR {
+		if len(m.config.ContentProtection) > 0 {
+			playlist = playlistInsertTags(playlist, drm.HLSSessionTags(m.config.ContentProtection)...)
+		}
+		return playlist, state
+	}
+
+	// segments are kept for whole dvr window, players can seek within it
+	if m.dvr() {
+		playlist = playlistInsertTags(playlist, "#EXT-X-PLAYLIST-TYPE:EVENT")
+	}
+
+	if m.config.LowLatency {
+		playlist, state = m.renderParts(playlist, tempdir)
+
+		targetDuration := playlistTargetDuration(playlist)
+		if targetDuration == 0 {
+			targetDuration = m.config.SegmentDuration
+		}
+
+		playlist = playlistInsertTags(playlist, m.config.serverControlTag(targetDuration))
+	}
+
+	if m.uploader != nil {
+		playlist = m.uploader.rewrite(playlist)
+	}
+
+	if cache {
+		m.mu.Lock()
+		// playlist might have been updated meanwhile
+		if m.playlist == source {
+			m.rendered = &renderedPlaylist{playlist, state}
+		}
+		m.mu.Unlock()
+	}
+
+	return playlist, state
+}
+
+// retryAfter asks client to retry in about one segment duration.
+func (m *ManagerCtx) retryAfter(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", m.config.RetryAfter())
+}
+
+// holdBlockingReload reports whether another blocking reload can be held.
+func (m *ManagerCtx) holdBlockingReload() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.config.MaxBlockingReloads > 0 && m.blockingReloads >= m.config.MaxBlockingReloads {
+		return false
+	}
+
+	m.blockingReloads++
+	return true
+}
+
+func (m *ManagerCtx) releaseBlockingReload() {
+	m.mu.Lock()
+	m.blockingReloads--
+	m.mu.Unlock()
+}
+
+// blockingReloadParams parses _HLS_msn and _HLS_part query parameters,
+// part is negative when not specified.
+func blockingReloadParams(r *http.Request) (msn int, part int, ok bool, err error) {
+	query := r.URL.Query()
+
+	part = -1
+	if value := query.Get("_HLS_part"); value != "" {
+		part, err = strconv.Atoi(value)
+		if err != nil || part < 0 {
+			return 0, 0, true, errors.New("invalid _HLS_part")
+		}
+	}
+
+	value := query.Get("_HLS_msn")
+	if value == "" {
+		if part >= 0 {
+			return 0, 0, true, errors.New("_HLS_part requires _HLS_msn")
+		}
+		return 0, 0, false, nil
+	}
+
+	msn, err = strconv.Atoi(value)
+	if err != nil || msn < 0 {
+		return 0, 0, true, errors.New("invalid _HLS_msn")
+	}
+
+	return msn, part, true, nil
 }
 
 func (m *ManagerCtx) ServeMedia(w http.ResponseWriter, r *http.Request) {
-	fileName := path.Base(r.URL.RequestURI())
-	path := path.Join(m.tempdir, fileName)
+	if !m.verifySigned(w, r) {
+		return
+	}
+
+	fileName := path.Base(r.URL.Path)
 
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	m.mu.Lock()
+	tempdir := m.tempdir
+	path := path.Join(tempdir, fileName)
+	m.mu.Unlock()
+
+	// hinted part is served once complete, as its exact byte range
+	if start, ok := openRangeStart(r); ok && m.config.LowLatency {
+		if part, ok := m.waitForPart(r, path, start); ok {
+			r.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", part.offset, part.offset+part.length-1))
+		}
+	}
+
+	file, inMemory := m.memoryFile(tempdir, fileName)
+	if _, err := os.Stat(path); !inMemory && os.IsNotExist(err) {
 		m.logger.Warn().Str("path", path).Msg("media file not found")
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("404 media not found"))
 		return
 	}
 
-	m.mu.Lock()
-	m.lastRequest = time.Now()
-	m.mu.Unlock()
+	// availability checks do not keep stream alive
+	if r.Method != http.MethodHead {
+		m.mu.Lock()
+		m.lastRequest = time.Now()
+		m.mu.Unlock()
+
+		if m.config.Viewers != nil {
+			m.config.Viewers.touch(r)
+		}
+	}
+
+	if inMemory {
+		m.serveMemory(m.accounted(w), r, fileName, file)
+		return
+	}
+
+	// removed by muxer meanwhile
+	media, err := os.Open(path)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 media not found"))
+		return
+	}
+	defer media.Close()
+
+	info, err := media.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 media not found"))
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	// segment names repeat, so that cached ones are revalidated
+	w.Header().Set("Content-Type", m.config.fileMimeType(path))
 	w.Header().Set("Cache-Control", "no-cache")
-	http.ServeFile(w, r, path)
+	w.Header().Set("ETag", mediaETag(info.ModTime(), info.Size()))
+
+	// ranges and conditional requests, file is sent using sendfile
+	http.ServeContent(m.accounted(w), r, fileName, info.ModTime(), media)
+}
+
+// mediaETag identifies content of media file by its modification time
+// and size, that change whenever muxer rewrites it.
+func mediaETag(modTime time.Time, size int64) string {
+	return `"` + strconv.FormatInt(modTime.UnixNano(), 16) + "-" + strconv.FormatInt(size, 16) + `"`
 }
 
 func (m *ManagerCtx) OnStart(event func()) {
@@ -275,3 +1427,19 @@ func (m *ManagerCtx) OnCmdLog(event func(message string)) {
 func (m *ManagerCtx) OnStop(event func()) {
 	m.events.onStop = event
 }
+
+func (m *ManagerCtx) OnAlert(event func(message string)) {
+	m.events.onAlert = event
+}
+
+func (m *ManagerCtx) OnPlaylist(event func(sequence int)) {
+	m.events.onPlaylist = event
+}
+
+func (m *ManagerCtx) OnRestart(event func(reason string)) {
+	m.events.onRestart = event
+}
+
+func (m *ManagerCtx) OnError(event func(message string)) {
+	m.events.onError = event
+}
@@ -0,0 +1,622 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// testCmd returns factory of fake transcode running shell script.
+func testCmd(script string) func() (*exec.Cmd, error) {
+	return func() (*exec.Cmd, error) {
+		return exec.Command("sh", "-c", script), nil
+	}
+}
+
+// testPlaylistFile writes playlist into dir, to be printed by fake
+// transcode, and returns its path.
+func testPlaylistFile(t *testing.T, dir string, name string, playlist string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(playlist), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// waitFor polls condition until it holds or timeout passes.
+func waitFor(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return condition()
+}
+
+func TestExitGrace(t *testing.T) {
+	dir := t.TempDir()
+	first := testPlaylistFile(t, dir, "first.m3u8", testPlaylist(0, 3, false))
+	last := testPlaylistFile(t, dir, "last.m3u8", testPlaylist(0, 5, true))
+
+	for _, tt := range []struct {
+		name     string
+		grace    time.Duration
+		captured bool
+	}{
+		{"within grace", 2 * time.Second, true},
+		{"without grace", 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// final update is written by child, after transcode itself exited
+			m := New(testCmd("cat "+first+"; (sleep 0.3; cat "+last+") & exit 0"), Config{
+				SegmentDuration: 1,
+				ExitGrace:       tt.grace,
+				TempRoot:        t.TempDir(),
+			})
+			defer m.Shutdown()
+
+			if err := m.Start(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			captured := waitFor(time.Second, func() bool {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				return strings.Contains(m.playlist, "#EXT-X-ENDLIST")
+			})
+
+			m.mu.Lock()
+			playlist := m.playlist
+			m.mu.Unlock()
+
+			if captured != tt.captured {
+				t.Fatalf("got final playlist captured %v, want %v:\n%s", captured, tt.captured, playlist)
+			}
+			if captured && !strings.Contains(playlist, "index4.ts\n#EXT-X-ENDLIST") {
+				t.Errorf("final segments missing in playlist:\n%s", playlist)
+			}
+		})
+	}
+}
+
+func TestExitGraceBound(t *testing.T) {
+	dir := t.TempDir()
+	first := testPlaylistFile(t, dir, "first.m3u8", testPlaylist(0, 3, false))
+
+	// child keeps output open long after transcode exited
+	m := New(testCmd("cat "+first+"; sleep 2 & exit 0"), Config{
+		SegmentDuration: 1,
+		ExitGrace:       200 * time.Millisecond,
+		TempRoot:        t.TempDir(),
+	})
+	defer m.Shutdown()
+
+	logs := &testLog{}
+	m.logger = zerolog.New(logs)
+
+	if err := m.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !waitFor(time.Second, func() bool { return strings.Contains(logs.String(), "reader did not finish within exit grace") }) {
+		t.Errorf("output not closed after exit grace, while child keeps it open:\n%s", logs)
+	}
+}
+
+// testLiveCmd returns factory of fake transcode printing playlist
+// updates, each of them adding segment, and running until stopped. It
+// counts its starts.
+func testLiveCmd(t *testing.T, segments int, starts *int32) func() (*exec.Cmd, error) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	script := ""
+	for i := 1; i <= segments; i++ {
+		playlist := testPlaylistFile(t, dir, fmt.Sprintf("live%d.m3u8", i), testPlaylist(0, i, false))
+		script += "cat " + playlist + "; sleep 0.1; "
+	}
+
+	cmd := testCmd(script + "exec sleep 30")
+
+	return func() (*exec.Cmd, error) {
+		atomic.AddInt32(starts, 1)
+		return cmd()
+	}
+}
+
+func TestExplicitStart(t *testing.T) {
+	var starts int32
+	m := New(testLiveCmd(t, 3, &starts), Config{
+		SegmentDuration: 2,
+		TempRoot:        t.TempDir(),
+		ExplicitStart:   true,
+	})
+	defer m.Shutdown()
+
+	w := httptest.NewRecorder()
+	m.ServePlaylist(w, httptest.NewRequest(http.MethodGet, "/h264_720p/cam/index.m3u8", nil))
+
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("got status %d with Retry-After %q, want 503 with 2", w.Code, w.Header().Get("Retry-After"))
+	}
+	if n := atomic.LoadInt32(&starts); n != 0 {
+		t.Fatalf("process started %d times by playlist request", n)
+	}
+
+	if err := m.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	m.ServePlaylist(w, httptest.NewRequest(http.MethodGet, "/h264_720p/cam/index.m3u8", nil))
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "index1.ts") {
+		t.Errorf("got status %d, want playlist of started stream:\n%s", w.Code, w.Body.String())
+	}
+	if n := atomic.LoadInt32(&starts); n != 1 {
+		t.Errorf("process started %d times, want once", n)
+	}
+}
+
+func TestReceiveActivatesOnce(t *testing.T) {
+	m := New(nil, Config{SegmentDuration: 1, Manager: ManagerConfig{MinimumSegments: 2}})
+	playlistLoad := m.playlistLoad
+
+	// single read carrying three updates jumps sequence past threshold
+	var framer playlistFramer
+	chunk, updates, ok := framer.write(testPlaylist(0, 1, false) + testPlaylist(0, 2, false) + testPlaylist(0, 3, false))
+	if !ok || updates != 3 {
+		t.Fatalf("got %d updates, want 3", updates)
+	}
+
+	m.receive(chunk, updates, "", nil, playlistLoad)
+
+	select {
+	case <-playlistLoad:
+	default:
+		t.Fatal("stream not activated")
+	}
+
+	if !m.active || m.sequence != 3 {
+		t.Errorf("got active %v at sequence %d, want active at 3", m.active, m.sequence)
+	}
+
+	// activating again would close playlist load twice and panic
+	m.receive(testPlaylist(1, 3, false), 1, "", nil, playlistLoad)
+
+	if !m.active || m.sequence != 4 {
+		t.Errorf("got active %v at sequence %d, want active at 4", m.active, m.sequence)
+	}
+}
+
+func TestReceiveTrimsPlaylist(t *testing.T) {
+	m := New(nil, Config{SegmentDuration: 1, MaxSegments: 4})
+
+	m.receive(testPlaylist(0, 10, false), 1, "", nil, m.playlistLoad)
+
+	if got, want := m.playlist, testPlaylist(6, 4, false); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRenderCache(t *testing.T) {
+	m := New(nil, Config{SegmentDuration: 1, PlaylistCache: true})
+	m.receive(testPlaylist(0, 3, false), 1, "", nil, m.playlistLoad)
+
+	first, _ := m.render()
+	if m.rendered == nil {
+		t.Fatal("rendered playlist not cached")
+	}
+
+	if cached, _ := m.render(); cached != first {
+		t.Errorf("cached playlist differs:\n%s", cached)
+	}
+
+	// cache is dropped once sequence advances
+	m.receive(testPlaylist(1, 3, false), 1, "", nil, m.playlistLoad)
+
+	second, _ := m.render()
+	if second == first {
+		t.Fatalf("cached playlist served after update:\n%s", second)
+	}
+	if !strings.Contains(second, "#EXT-X-MEDIA-SEQUENCE:1\n") || !strings.Contains(second, "index3.ts") {
+		t.Errorf("updated playlist not rendered:\n%s", second)
+	}
+}
+
+func BenchmarkRender(b *testing.B) {
+	for _, cache := range []bool{false, true} {
+		name := "uncached"
+		if cache {
+			name = "cached"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			m := New(nil, Config{SegmentDuration: 1, PlaylistCache: cache, DVRWindow: 1})
+			m.playlist = testPlaylist(0, 1000, false)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.render()
+			}
+		})
+	}
+}
+
+func TestLastError(t *testing.T) {
+	m := New(testCmd("exit 3"), Config{SegmentDuration: 1, TempRoot: t.TempDir()})
+	defer m.Shutdown()
+
+	before := time.Now()
+	if err := m.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ok := waitFor(2*time.Second, func() bool { return m.Stats().LastError != nil })
+	if !ok {
+		t.Fatal("exit error not recorded")
+	}
+
+	lastError := m.Stats().LastError
+	if lastError.Message != "exit status 3" {
+		t.Errorf("got message %q, want %q", lastError.Message, "exit status 3")
+	}
+	if lastError.Time.Before(before) || lastError.Time.After(time.Now()) {
+		t.Errorf("got time %v, want after %v", lastError.Time, before)
+	}
+
+	// cleared once stream warms up
+	m.receive(testPlaylist(0, 2, false), 2, "", nil, m.playlistLoad)
+	if lastError := m.Stats().LastError; lastError != nil {
+		t.Errorf("got last error %+v after warm up", lastError)
+	}
+}
+
+func TestColdStartTimeout(t *testing.T) {
+	var starts int32
+	// transcode never producing any playlist
+	m := New(func() (*exec.Cmd, error) {
+		atomic.AddInt32(&starts, 1)
+		return exec.Command("sh", "-c", "exec sleep 30"), nil
+	}, Config{
+		SegmentDuration:  2,
+		TempRoot:         t.TempDir(),
+		ColdStartTimeout: 300 * time.Millisecond,
+	})
+	defer m.Shutdown()
+
+	const waiters = 5
+
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, waiters)
+	done := make([]time.Time, waiters)
+
+	start := time.Now()
+	for i := range recs {
+		recs[i] = httptest.NewRecorder()
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.ServePlaylist(recs[i], httptest.NewRequest(http.MethodGet, "/index.m3u8", nil))
+			done[i] = time.Now()
+		}(i)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&starts); n != 1 {
+		t.Errorf("process started %d times, want once", n)
+	}
+
+	for i, rec := range recs {
+		if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "503 cold start timeout" {
+			t.Errorf("waiter %d: got status %d with %q, want cold start timeout", i, rec.Code, rec.Body.String())
+		}
+
+		// all waiters fail together at timeout
+		if elapsed := done[i].Sub(start); elapsed < m.config.ColdStartTimeout || elapsed > m.config.ColdStartTimeout+200*time.Millisecond {
+			t.Errorf("waiter %d: failed after %v, want %v", i, elapsed, m.config.ColdStartTimeout)
+		}
+	}
+
+	if m.Pid() != 0 {
+		t.Error("stillborn process kept running")
+	}
+	if lastError := m.Stats().LastError; lastError == nil || lastError.Message != "cold start timeout" {
+		t.Errorf("got last error %+v, want cold start timeout", lastError)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name            string
+		segmentDuration float64
+		retryAfterMin   time.Duration
+		want            string
+	}{
+		{"segment duration", 4, 0, "4"},
+		{"rounded up", 2.5, 0, "3"},
+		{"floor", 2, 5 * time.Second, "5"},
+		{"floor rounded up", 1, 1500 * time.Millisecond, "2"},
+		{"segment duration over floor", 6, time.Second, "6"},
+		{"at least one second", 0.2, 0, "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, Config{SegmentDuration: tt.segmentDuration, RetryAfterMin: tt.retryAfterMin})
+
+			w := httptest.NewRecorder()
+			m.retryAfter(w)
+
+			if got := w.Header().Get("Retry-After"); got != tt.want {
+				t.Errorf("got Retry-After %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxDuration(t *testing.T) {
+	var starts int32
+	m := New(testLiveCmd(t, 3, &starts), Config{
+		SegmentDuration: 2,
+		TempRoot:        t.TempDir(),
+		MaxDuration:     300 * time.Millisecond,
+	})
+	defer m.Shutdown()
+
+	if err := m.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if m.Pid() == 0 {
+		t.Fatal("stream stopped before max duration")
+	}
+
+	stopped := waitFor(time.Second, func() bool { return m.Pid() == 0 })
+	if !stopped || !m.Stats().Expired {
+		t.Fatal("stream not stopped after max duration")
+	}
+
+	// expired stream is not started by viewers
+	w := httptest.NewRecorder()
+	m.ServePlaylist(w, httptest.NewRequest(http.MethodGet, "/index.m3u8", nil))
+	if n := atomic.LoadInt32(&starts); n != 1 {
+		t.Errorf("expired stream started %d times, want once", n)
+	}
+
+	// timer of stopped run is cancelled
+	if err := m.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m.mu.Lock()
+	timer := m.maxDuration
+	m.mu.Unlock()
+
+	m.Stop()
+	if timer.Stop() {
+		t.Error("max duration timer still pending after stop")
+	}
+
+	m.mu.Lock()
+	pending := m.maxDuration
+	m.mu.Unlock()
+	if pending != nil {
+		t.Error("max duration timer kept after stop")
+	}
+}
+
+func TestSegments(t *testing.T) {
+	m := New(nil, Config{SegmentDuration: 1})
+	if _, ok := m.Segments(); ok {
+		t.Error("got segments of stopped stream")
+	}
+
+	m = testRunningManager(t, Config{SegmentDuration: 1}, testPlaylist(5, 3, false))
+	testFile(t, filepath.Join(m.tempdir, "index5.ts"), make([]byte, 1024))
+	testFile(t, filepath.Join(m.tempdir, "index6.ts"), make([]byte, 512))
+
+	segments, ok := m.Segments()
+	if !ok {
+		t.Fatal("segments of active stream missing")
+	}
+
+	want := []struct {
+		name     string
+		sequence int
+		size     int64
+	}{
+		{"index5.ts", 5, 1024},
+		{"index6.ts", 6, 512},
+		// already removed by muxer
+		{"index7.ts", 7, 0},
+	}
+
+	if len(segments) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(segments), len(want))
+	}
+
+	for i, w := range want {
+		s := segments[i]
+		if s.Name != w.name || s.Sequence != w.sequence || s.Size != w.size || s.Duration != 1 {
+			t.Errorf("segment %d: got %+v, want %+v", i, s, w)
+		}
+		if w.size > 0 && s.ModTime.IsZero() {
+			t.Errorf("segment %d: modification time missing", i)
+		}
+	}
+}
+
+// testLog is log output safe for concurrent writes.
+type testLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *testLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *testLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+func TestStopClosesOutput(t *testing.T) {
+	var starts int32
+	m := New(testLiveCmd(t, 3, &starts), Config{SegmentDuration: 2, TempRoot: t.TempDir()})
+	defer m.Shutdown()
+
+	logs := &testLog{}
+	m.logger = zerolog.New(logs)
+
+	if err := m.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !waitFor(time.Second, m.Active) {
+		t.Fatal("stream did not warm up")
+	}
+
+	m.Stop()
+
+	// output is closed while being read
+	if !waitFor(time.Second, func() bool { return strings.Contains(logs.String(), "cmd output closed") }) {
+		t.Fatalf("output not closed:\n%s", logs)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if out := logs.String(); strings.Contains(out, `"level":"error"`) || strings.Contains(out, "cmd read failed") {
+		t.Errorf("got error logged on stop:\n%s", out)
+	}
+	if n := atomic.LoadInt32(&starts); n != 1 || m.Stats().Restarts != 0 || m.Pid() != 0 {
+		t.Errorf("got %d starts and %d restarts after stop, want clean stop", n, m.Stats().Restarts)
+	}
+}
+
+// testProcessAlive reports whether process is running, not being zombie.
+func testProcessAlive(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+
+	// state follows command name in parentheses
+	i := strings.LastIndexByte(string(stat), ')')
+	return i >= 0 && i+2 < len(stat) && stat[i+2] != 'Z'
+}
+
+func TestStopProcessGroup(t *testing.T) {
+	tests := []struct {
+		name          string
+		singleProcess bool
+		childAlive    bool
+	}{
+		{"group", false, false},
+		{"single process", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			playlist := testPlaylistFile(t, dir, "live.m3u8", testPlaylist(0, 2, false)+testPlaylist(0, 3, false))
+			pidfile := filepath.Join(dir, "child.pid")
+
+			// transcode spawning child of its own
+			m := New(testCmd("sleep 30 & echo $! > "+pidfile+"; cat "+playlist+"; wait"), Config{
+				SegmentDuration: 2,
+				TempRoot:        t.TempDir(),
+				SingleProcess:   tt.singleProcess,
+			})
+			defer m.Shutdown()
+
+			if err := m.Start(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !waitFor(time.Second, m.Active) {
+				t.Fatal("stream did not warm up")
+			}
+
+			m.mu.Lock()
+			setpgid := m.cmd.SysProcAttr.Setpgid
+			m.mu.Unlock()
+			if setpgid != !tt.singleProcess {
+				t.Errorf("got setpgid %v, want %v", setpgid, !tt.singleProcess)
+			}
+
+			data, err := os.ReadFile(pidfile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			child, err := strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer syscall.Kill(child, syscall.SIGKILL)
+
+			m.Stop()
+
+			// group is killed right away
+			time.Sleep(100 * time.Millisecond)
+			if alive := testProcessAlive(child); alive != tt.childAlive {
+				t.Errorf("got child alive %v, want %v", alive, tt.childAlive)
+			}
+		})
+	}
+}
+
+func TestStartupTimeout(t *testing.T) {
+	var starts int32
+	// transcode never producing any segment
+	m := New(func() (*exec.Cmd, error) {
+		atomic.AddInt32(&starts, 1)
+		return exec.Command("sh", "-c", "exec sleep 30"), nil
+	}, Config{
+		SegmentDuration: 2,
+		TempRoot:        t.TempDir(),
+		StartupTimeout:  300 * time.Millisecond,
+	})
+	defer m.Shutdown()
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	m.ServePlaylist(w, httptest.NewRequest(http.MethodGet, "/index.m3u8", nil))
+
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "503 startup timeout" {
+		t.Errorf("got status %d with %q, want startup timeout", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Retry-After") != "2" {
+		t.Errorf("got Retry-After %q, want %q", w.Header().Get("Retry-After"), "2")
+	}
+	if elapsed := time.Since(start); elapsed < m.config.StartupTimeout || elapsed > m.config.StartupTimeout+200*time.Millisecond {
+		t.Errorf("failed after %v, want %v", elapsed, m.config.StartupTimeout)
+	}
+
+	if m.Pid() != 0 {
+		t.Error("process without segments kept running")
+	}
+	if lastError := m.Stats().LastError; lastError == nil || !strings.Contains(lastError.Message, "startup timeout") {
+		t.Errorf("got last error %+v, want startup timeout", lastError)
+	}
+}
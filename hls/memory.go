@@ -0,0 +1,246 @@
+package hls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// environment pointing profile to upload segments into memory store
+const (
+	segmentFilenameEnv = "TRANSCODE_HLS_SEGMENT_FILENAME"
+	methodEnv          = "TRANSCODE_HLS_METHOD"
+)
+
+// largest segment accepted by memory store
+const maxMemoryFileSize = 256 << 20
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+type memoryDir struct {
+	// unguessable path of uploads, so that only its profile writes there
+	token string
+	files map[string]memoryFile
+}
+
+// MemoryStore keeps segments of profiles in memory instead of tempdir.
+// Hls muxer uploads them over loopback http using PUT, and removes them
+// using DELETE once they leave playlist.
+type MemoryStore struct {
+	logger   zerolog.Logger
+	listener net.Listener
+	server   *http.Server
+
+	mu sync.Mutex
+	// by tempdir of manager, keeping them across reused tempdirs
+	dirs   map[string]*memoryDir
+	tokens map[string]string
+}
+
+// NewMemoryStore starts memory store listening on loopback interface.
+func NewMemoryStore() (*MemoryStore, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &MemoryStore{
+		logger:   log.With().Str("module", "hls").Str("submodule", "memory").Logger(),
+		listener: listener,
+		dirs:     map[string]*memoryDir{},
+		tokens:   map[string]string{},
+	}
+
+	s.server = &http.Server{Handler: s}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Err(err).Msg("memory store failed")
+		}
+	}()
+
+	s.logger.Info().Str("addr", listener.Addr().String()).Msg("memory store listening")
+	return s, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return s.server.Close()
+}
+
+// open returns url, where files of dir are uploaded.
+func (s *MemoryStore) open(dir string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.dirs[dir]
+	if !ok {
+		token := make([]byte, 16)
+		if _, err := rand.Read(token); err != nil {
+			return "", err
+		}
+
+		d = &memoryDir{
+			token: hex.EncodeToString(token),
+			files: map[string]memoryFile{},
+		}
+
+		s.dirs[dir] = d
+		s.tokens[d.token] = dir
+	}
+
+	return "http://" + s.listener.Addr().String() + "/" + d.token + "/", nil
+}
+
+// remove forgets files of dir, uploads of its profile are refused
+// afterwards.
+func (s *MemoryStore) remove(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.dirs[dir]
+	if !ok {
+		return
+	}
+
+	delete(s.tokens, d.token)
+	delete(s.dirs, dir)
+}
+
+func (s *MemoryStore) file(dir string, name string) (memoryFile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.dirs[dir]
+	if !ok {
+		return memoryFile{}, false
+	}
+
+	file, ok := d.files[name]
+	return file, ok
+}
+
+func (s *MemoryStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, name := path.Split(strings.TrimPrefix(r.URL.Path, "/"))
+	token = strings.TrimSuffix(token, "/")
+
+	s.mu.Lock()
+	dir, ok := s.tokens[token]
+	s.mu.Unlock()
+
+	if !ok || name == "" || strings.Contains(token, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		// partial uploads of killed profile are dropped
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxMemoryFileSize+1))
+		if err != nil {
+			s.logger.Debug().Err(err).Str("name", name).Msg("upload failed")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if len(data) > maxMemoryFileSize {
+			s.logger.Warn().Str("name", name).Msg("upload too large")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		s.mu.Lock()
+		if d, ok := s.dirs[dir]; ok {
+			d.files[name] = memoryFile{data, time.Now()}
+		}
+		s.mu.Unlock()
+	case http.MethodDelete:
+		s.mu.Lock()
+		if d, ok := s.dirs[dir]; ok {
+			delete(d.files, name)
+		}
+		s.mu.Unlock()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// memory reports whether segments are kept in memory store, stable
+// tempdirs are meant for external tools and abr profiles write variant
+// playlists on their own.
+func (m *ManagerCtx) memory() bool {
+	return m.config.Memory != nil && m.config.TempDir == "" && !m.config.ABR
+}
+
+// memoryEnv points cmd to upload segments of tempdir into memory store.
+func (m *ManagerCtx) memoryEnv(cmd *exec.Cmd, tempdir string) error {
+	prefix, err := m.config.Memory.open(tempdir)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env,
+		segmentFilenameEnv+"="+prefix+"live_%03d.ts",
+		methodEnv+"="+http.MethodPut,
+	)
+	return nil
+}
+
+// removeTempDir removes tempdir along with its files in memory store.
+func (m *ManagerCtx) removeTempDir(tempdir string) {
+	err := os.RemoveAll(tempdir)
+	m.logger.Err(err).Msg("removing tempdir")
+	m.config.DiskBudget.remove(tempdir)
+
+	if m.config.Memory != nil {
+		m.config.Memory.remove(tempdir)
+	}
+}
+
+// memoryFile returns file of tempdir kept in memory store.
+func (m *ManagerCtx) memoryFile(tempdir string, name string) (memoryFile, bool) {
+	if !m.memory() {
+		return memoryFile{}, false
+	}
+
+	return m.config.Memory.file(tempdir, path.Base(name))
+}
+
+// serveMemory serves file of memory store.
+func (m *ManagerCtx) serveMemory(w http.ResponseWriter, r *http.Request, fileName string, file memoryFile) {
+	contentType := m.config.mimeType(fileName)
+	if _, ok := m.config.extMimeType(fileName); !ok && m.config.SniffMimeTypes {
+		head := file.data
+		if len(head) > 2*tsPacketSize {
+			head = head[:2*tsPacketSize]
+		}
+		contentType = m.config.sniffMimeType(head)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", mediaETag(file.modTime, int64(len(file.data))))
+	http.ServeContent(w, r, fileName, file.modTime, bytes.NewReader(file.data))
+}
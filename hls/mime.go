@@ -0,0 +1,89 @@
+package hls
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// standard content types of files served by manager
+var defaultMimeTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".vtt":  "text/vtt",
+}
+
+// mimeType returns content type for file name, configured overrides
+// take precedence over standard types.
+func (c *Config) mimeType(fileName string) string {
+	if value, ok := c.extMimeType(fileName); ok {
+		return value
+	}
+
+	return "application/octet-stream"
+}
+
+func (c *Config) extMimeType(fileName string) (string, bool) {
+	ext := strings.ToLower(path.Ext(fileName))
+
+	for key, value := range c.MimeTypes {
+		if "."+strings.TrimPrefix(strings.ToLower(key), ".") == ext {
+			return value, true
+		}
+	}
+
+	value, ok := defaultMimeTypes[ext]
+	return value, ok
+}
+
+// fileMimeType returns content type for file by its extension, falling
+// back to sniffing its content when enabled and extension is unknown.
+func (c *Config) fileMimeType(filePath string) string {
+	if _, ok := c.extMimeType(filePath); ok || !c.SniffMimeTypes {
+		return c.mimeType(filePath)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return c.mimeType(filePath)
+	}
+	defer file.Close()
+
+	// two mpeg-ts packets
+	head := make([]byte, 2*tsPacketSize)
+	n, _ := io.ReadFull(file, head)
+
+	return c.sniffMimeType(head[:n])
+}
+
+const tsPacketSize = 188
+
+// sniffMimeType detects media type by leading bytes, standard types are
+// subject to configured overrides.
+func (c *Config) sniffMimeType(head []byte) string {
+	ext := ""
+	switch {
+	case len(head) > 0 && head[0] == 0x47 && (len(head) <= tsPacketSize || head[tsPacketSize] == 0x47):
+		// sync byte of every packet
+		ext = ".ts"
+	case len(head) >= 8 && bytes.Equal(head[4:8], []byte("ftyp")):
+		ext = ".mp4"
+	case len(head) >= 8 && (bytes.Equal(head[4:8], []byte("styp")) || bytes.Equal(head[4:8], []byte("moof"))):
+		ext = ".m4s"
+	case bytes.HasPrefix(head, []byte("#EXTM3U")):
+		ext = ".m3u8"
+	case bytes.HasPrefix(head, []byte("WEBVTT")):
+		ext = ".vtt"
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xF6 == 0xF0:
+		// adts syncword
+		ext = ".aac"
+	}
+
+	return c.mimeType(ext)
+}
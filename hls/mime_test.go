@@ -0,0 +1,97 @@
+package hls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testServeMedia serves file of given content from tempdir of manager.
+func testServeMedia(t *testing.T, m *ManagerCtx, name string, content []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	if m.tempdir == "" {
+		m.tempdir = t.TempDir()
+	}
+
+	if err := os.WriteFile(filepath.Join(m.tempdir, name), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	m.ServeMedia(w, httptest.NewRequest(http.MethodGet, "/h264_720p/cam/"+name, nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+	return w
+}
+
+func TestServeMediaMimeTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		mimeTypes map[string]string
+		want      string
+	}{
+		{"ts default", "index0.ts", nil, "video/mp2t"},
+		{"m4s default", "index0.m4s", nil, "video/iso.segment"},
+		{"mp4 default", "init.mp4", nil, "video/mp4"},
+		{"vtt default", "subs0.vtt", nil, "text/vtt"},
+		{"m4s override", "index0.m4s", map[string]string{"m4s": "video/mp4"}, "video/mp4"},
+		{"ts override with dot", "index0.ts", map[string]string{".ts": "video/MP2T"}, "video/MP2T"},
+		{"vtt override case insensitive", "subs0.VTT", map[string]string{"VTT": "text/plain"}, "text/plain"},
+		{"override of other extension", "index0.ts", map[string]string{"m4s": "video/mp4"}, "video/mp2t"},
+		{"unknown", "index0.bin", nil, "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, Config{SegmentDuration: 1, MimeTypes: tt.mimeTypes})
+
+			w := testServeMedia(t, m, tt.file, []byte("media"))
+			if got := w.Header().Get("Content-Type"); got != tt.want {
+				t.Errorf("got Content-Type %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeMediaSniffing(t *testing.T) {
+	ts := make([]byte, 2*tsPacketSize)
+	ts[0], ts[tsPacketSize] = 0x47, 0x47
+
+	tests := []struct {
+		name      string
+		file      string
+		content   []byte
+		sniff     bool
+		mimeTypes map[string]string
+		want      string
+	}{
+		{"ts", "index0.seg", ts, true, nil, "video/mp2t"},
+		{"ts single packet", "index0.seg", ts[:tsPacketSize], true, nil, "video/mp2t"},
+		{"not ts", "index0.seg", append([]byte{0x47}, make([]byte, tsPacketSize)...), true, nil, "application/octet-stream"},
+		{"fmp4 init", "init.seg", testBox("ftyp", []byte("iso5")), true, nil, "video/mp4"},
+		{"fmp4 segment", "index0.seg", testBox("moof"), true, nil, "video/iso.segment"},
+		{"fmp4 segment type", "index0.seg", testBox("styp", []byte("msdh")), true, nil, "video/iso.segment"},
+		{"unrecognised", "index0.seg", []byte("media"), true, nil, "application/octet-stream"},
+		{"empty", "index0.seg", []byte{}, true, nil, "application/octet-stream"},
+		{"override of sniffed type", "index0.seg", testBox("moof"), true, map[string]string{"m4s": "video/mp4"}, "video/mp4"},
+		{"known extension not sniffed", "index0.ts", testBox("moof"), true, nil, "video/mp2t"},
+		{"disabled", "index0.seg", ts, false, nil, "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, Config{SegmentDuration: 1, MimeTypes: tt.mimeTypes, SniffMimeTypes: tt.sniff})
+
+			w := testServeMedia(t, m, tt.file, tt.content)
+			if got := w.Header().Get("Content-Type"); got != tt.want {
+				t.Errorf("got Content-Type %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
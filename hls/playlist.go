@@ -0,0 +1,347 @@
+package hls
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var playlistURIRegex = regexp.MustCompile(`URI="([^"]*)"`)
+
+// playlistTargetDuration returns value of EXT-X-TARGETDURATION tag, or zero.
+func playlistTargetDuration(playlist string) float64 {
+	for _, line := range strings.Split(playlist, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#EXT-X-TARGETDURATION:") {
+			continue
+		}
+
+		value := strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:")
+		duration, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0
+		}
+
+		return duration
+	}
+
+	return 0
+}
+
+// playlistInsertTags inserts tags right after playlist header
+// (EXTM3U and optional EXT-X-VERSION tag).
+func playlistInsertTags(playlist string, tags ...string) string {
+	lines := strings.Split(playlist, "\n")
+
+	pos := 0
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "#EXTM3U" || strings.HasPrefix(line, "#EXT-X-VERSION:") {
+			pos = i + 1
+		}
+	}
+
+	result := make([]string, 0, len(lines)+len(tags))
+	result = append(result, lines[:pos]...)
+	result = append(result, tags...)
+	result = append(result, lines[pos:]...)
+	return strings.Join(result, "\n")
+}
+
+// playlistAppendQuery appends query to relative uris of playlist, both
+// uri lines and URI attributes of tags. Absolute uris, e.g. of segment
+// store, are kept.
+func playlistAppendQuery(playlist string, query string) string {
+	appendQuery := func(uri string) string {
+		if uri == "" || strings.Contains(uri, "://") || strings.HasPrefix(uri, "/") {
+			return uri
+		}
+
+		if strings.Contains(uri, "?") {
+			return uri + "&" + query
+		}
+		return uri + "?" + query
+	}
+
+	lines := strings.Split(playlist, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			lines[i] = playlistURIRegex.ReplaceAllStringFunc(line, func(attr string) string {
+				return `URI="` + appendQuery(playlistURIRegex.FindStringSubmatch(attr)[1]) + `"`
+			})
+			continue
+		}
+
+		lines[i] = appendQuery(strings.TrimSpace(line))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+type segment struct {
+	// tags preceding segment uri, e.g. EXTINF
+	tags []string
+	uri  string
+}
+
+type mediaPlaylist struct {
+	// lines before first segment
+	header   []string
+	segments []segment
+	// lines after last segment, e.g. EXT-X-ENDLIST
+	footer []string
+}
+
+func parsePlaylist(playlist string) *mediaPlaylist {
+	p := &mediaPlaylist{}
+
+	var pending []string
+	for _, line := range strings.Split(strings.TrimRight(playlist, "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		// segment uri
+		if !strings.HasPrefix(line, "#") {
+			p.segments = append(p.segments, segment{
+				tags: pending,
+				uri:  line,
+			})
+			pending = nil
+			continue
+		}
+
+		// segment tags
+		if len(p.segments) > 0 || isSegmentTag(line) {
+			pending = append(pending, line)
+			continue
+		}
+
+		p.header = append(p.header, line)
+	}
+
+	p.footer = pending
+	return p
+}
+
+// isSegmentTag reports whether tag applies to following segment.
+func isSegmentTag(line string) bool {
+	for _, prefix := range []string{
+		"#EXTINF:",
+		"#EXT-X-BYTERANGE:",
+		"#EXT-X-DISCONTINUITY",
+		"#EXT-X-PROGRAM-DATE-TIME:",
+		"#EXT-X-KEY:",
+		"#EXT-X-MAP:",
+		"#EXT-X-GAP",
+	} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *mediaPlaylist) String() string {
+	var b strings.Builder
+
+	for _, line := range p.header {
+		b.WriteString(line + "\n")
+	}
+
+	for _, segment := range p.segments {
+		for _, line := range segment.tags {
+			b.WriteString(line + "\n")
+		}
+		b.WriteString(segment.uri + "\n")
+	}
+
+	for _, line := range p.footer {
+		b.WriteString(line + "\n")
+	}
+
+	return b.String()
+}
+
+// addHeaderInt adds delta to integer value of header tag.
+func (p *mediaPlaylist) addHeaderInt(tag string, delta int) {
+	for i, line := range p.header {
+		if !strings.HasPrefix(line, tag+":") {
+			continue
+		}
+
+		value, err := strconv.Atoi(strings.TrimPrefix(line, tag+":"))
+		if err != nil {
+			return
+		}
+
+		p.header[i] = tag + ":" + strconv.Itoa(value+delta)
+		return
+	}
+
+	p.header = append(p.header, tag+":"+strconv.Itoa(delta))
+}
+
+// trim removes oldest segments, so that playlist contains at most
+// maxSegments segments and its size is at most maxBytes. Zero means
+// unlimited. Returns number of removed segments.
+func (p *mediaPlaylist) trim(maxSegments int, maxBytes int) int {
+	drop := 0
+	if maxSegments > 0 && len(p.segments) > maxSegments {
+		drop = len(p.segments) - maxSegments
+	}
+
+	if maxBytes > 0 {
+		size := len(p.String())
+		for i := 0; i < drop; i++ {
+			size -= segmentSize(p.segments[i])
+		}
+
+		// keep at least one segment
+		for size > maxBytes && drop < len(p.segments)-1 {
+			size -= segmentSize(p.segments[drop])
+			drop++
+		}
+	}
+
+	if drop == 0 {
+		return 0
+	}
+
+	discontinuities := 0
+	// init segment and key apply to all following segments
+	carried := map[string]string{}
+	for _, segment := range p.segments[:drop] {
+		for _, tag := range segment.tags {
+			if tag == "#EXT-X-DISCONTINUITY" {
+				discontinuities++
+			}
+			if strings.HasPrefix(tag, "#EXT-X-MAP:") {
+				carried["#EXT-X-MAP:"] = tag
+			}
+			if strings.HasPrefix(tag, "#EXT-X-KEY:") {
+				carried["#EXT-X-KEY:"] = tag
+			}
+		}
+	}
+
+	p.segments = p.segments[drop:]
+
+	first := &p.segments[0]
+	for _, prefix := range []string{"#EXT-X-KEY:", "#EXT-X-MAP:"} {
+		tag, ok := carried[prefix]
+		if !ok {
+			continue
+		}
+
+		overridden := false
+		for _, t := range first.tags {
+			overridden = overridden || strings.HasPrefix(t, prefix)
+		}
+
+		if !overridden {
+			first.tags = append([]string{tag}, first.tags...)
+		}
+	}
+
+	p.addHeaderInt("#EXT-X-MEDIA-SEQUENCE", drop)
+	if discontinuities > 0 {
+		p.addHeaderInt("#EXT-X-DISCONTINUITY-SEQUENCE", discontinuities)
+	}
+
+	return drop
+}
+
+// duration returns value of EXTINF tag, or zero.
+func (s segment) duration() float64 {
+	for _, tag := range s.tags {
+		if !strings.HasPrefix(tag, "#EXTINF:") {
+			continue
+		}
+
+		value := strings.TrimPrefix(tag, "#EXTINF:")
+		if i := strings.Index(value, ","); i >= 0 {
+			value = value[:i]
+		}
+
+		duration, _ := strconv.ParseFloat(value, 64)
+		return duration
+	}
+	return 0
+}
+
+func segmentSize(s segment) int {
+	size := len(s.uri) + 1
+	for _, tag := range s.tags {
+		size += len(tag) + 1
+	}
+	return size
+}
+
+// playlists written to output longer than this without being completed
+// are dropped, output is not a playlist then
+const playlistFrameMax = 16 << 20
+
+// playlistFramer reassembles whole playlist updates from output of cmd,
+// that arrive split across reads. Every update starts with its header.
+type playlistFramer struct {
+	buf string
+	// latest playlist was already returned, while more of it may arrive
+	returned bool
+}
+
+// write appends output and returns latest complete playlist, with number
+// of updates completed by this output. Playlist extended by output is
+// returned again, without counting it as new update.
+func (f *playlistFramer) write(output string) (string, int, bool) {
+	f.buf += output
+
+	updates := 0
+	latest := ""
+
+	// playlist is complete, once next one starts
+	for len(f.buf) > 0 {
+		i := strings.Index(f.buf[1:], "#EXTM3U")
+		if i < 0 {
+			break
+		}
+		previous := f.buf[:i+1]
+		f.buf = f.buf[i+1:]
+
+		// output preceding first header is not playlist
+		if f.returned || strings.HasPrefix(previous, "#EXTM3U") || playlistComplete(previous) {
+			if !f.returned {
+				updates++
+			}
+			latest = previous
+		}
+		f.returned = false
+	}
+
+	// latest playlist is complete, once it ends by segment or endlist
+	if playlistComplete(f.buf) {
+		if !f.returned {
+			updates++
+		}
+		latest = f.buf
+		f.returned = true
+	} else if len(f.buf) > playlistFrameMax {
+		f.buf = ""
+		f.returned = false
+	}
+
+	return latest, updates, latest != ""
+}
+
+// playlistComplete reports whether playlist ends by complete segment uri
+// or endlist tag.
+func playlistComplete(playlist string) bool {
+	if !strings.HasSuffix(playlist, "\n") {
+		return false
+	}
+
+	lines := strings.Split(strings.TrimSpace(playlist), "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	return last != "" && (!strings.HasPrefix(last, "#") || last == "#EXT-X-ENDLIST")
+}
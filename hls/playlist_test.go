@@ -0,0 +1,83 @@
+package hls
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testPlaylist renders live playlist of segments numbered from sequence,
+// each of them one second long.
+func testPlaylist(sequence int, segments int, endlist bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+	for i := sequence; i < sequence+segments; i++ {
+		fmt.Fprintf(&b, "#EXTINF:1.000000,\nindex%d.ts\n", i)
+	}
+	if endlist {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.String()
+}
+
+func TestPlaylistTrimSegments(t *testing.T) {
+	p := parsePlaylist(testPlaylist(5, 10, false))
+
+	if dropped := p.trim(3, 0); dropped != 7 {
+		t.Errorf("got %d dropped, want 7", dropped)
+	}
+
+	if got, want := p.String(), testPlaylist(12, 3, false); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPlaylistTrimBytes(t *testing.T) {
+	p := parsePlaylist(testPlaylist(0, 10, false))
+
+	// header with two segments
+	maxBytes := len(testPlaylist(8, 2, false))
+	if dropped := p.trim(0, maxBytes); dropped != 8 {
+		t.Errorf("got %d dropped, want 8", dropped)
+	}
+
+	if got, want := p.String(), testPlaylist(8, 2, false); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// at least one segment is kept
+	p = parsePlaylist(testPlaylist(0, 3, false))
+	if dropped := p.trim(0, 1); dropped != 2 {
+		t.Errorf("got %d dropped, want 2", dropped)
+	}
+}
+
+func TestPlaylistTrimWithinLimits(t *testing.T) {
+	playlist := testPlaylist(0, 3, true)
+	p := parsePlaylist(playlist)
+
+	if dropped := p.trim(3, len(playlist)); dropped != 0 {
+		t.Errorf("got %d dropped, want 0", dropped)
+	}
+
+	if got := p.String(); got != playlist {
+		t.Errorf("got %q, want %q", got, playlist)
+	}
+}
+
+func TestPlaylistTrimCarriesTags(t *testing.T) {
+	p := parsePlaylist("#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:1.000000,\nindex0.m4s\n" +
+		"#EXT-X-DISCONTINUITY\n#EXTINF:1.000000,\nindex1.m4s\n" +
+		"#EXTINF:1.000000,\nindex2.m4s\n")
+
+	if dropped := p.trim(1, 0); dropped != 2 {
+		t.Errorf("got %d dropped, want 2", dropped)
+	}
+
+	want := "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:2\n#EXT-X-DISCONTINUITY-SEQUENCE:1\n" +
+		"#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:1.000000,\nindex2.m4s\n"
+	if got := p.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package hls
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// profiles pass progress options to ffmpeg, so that it reports progress
+// to descriptor 3, being first extra file of cmd
+const (
+	progressEnv     = "TRANSCODE_PROGRESS_OPTIONS"
+	progressOptions = "-progress pipe:3"
+)
+
+// Progress of running transcode, as reported by ffmpeg.
+type Progress struct {
+	Frame int64   `json:"frame"`
+	FPS   float64 `json:"fps"`
+	// output bitrate in kbit/s
+	Bitrate float64 `json:"bitrate"`
+	// encoding speed relative to realtime, transcode falls behind below 1
+	Speed      float64 `json:"speed"`
+	DupFrames  int64   `json:"dup_frames"`
+	DropFrames int64   `json:"drop_frames"`
+	// media time of output in seconds
+	OutTime float64   `json:"out_time"`
+	Updated time.Time `json:"updated"`
+}
+
+// progressPipe passes write end of progress pipe to cmd, whose profile
+// enables reporting using progress options. Returns its read end and
+// write end, that is closed once cmd is started.
+func (m *ManagerCtx) progressPipe(cmd *exec.Cmd) (*os.File, *os.File, error) {
+	// descriptor 3 is taken
+	if len(cmd.ExtraFiles) > 0 {
+		return nil, nil, nil
+	}
+
+	read, write, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, progressEnv+"="+progressOptions)
+	cmd.ExtraFiles = []*os.File{write}
+
+	return read, write, nil
+}
+
+// readProgress records progress blocks reported by cmd, until it exits.
+func (m *ManagerCtx) readProgress(cmd *exec.Cmd, read *os.File) {
+	defer read.Close()
+
+	progress := Progress{}
+	scanner := bufio.NewScanner(read)
+	for scanner.Scan() {
+		key, value, ok := cutString(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		switch key {
+		case "frame":
+			progress.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			progress.FPS, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			progress.Bitrate, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+		case "speed":
+			progress.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "dup_frames":
+			progress.DupFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "drop_frames":
+			progress.DropFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "out_time_us":
+			us, _ := strconv.ParseInt(value, 10, 64)
+			progress.OutTime = float64(us) / 1e6
+		case "progress":
+			// block ends by progress=continue or progress=end
+			progress.Updated = time.Now()
+
+			m.mu.Lock()
+			if m.cmd == cmd {
+				p := progress
+				m.progress = &p
+				m.progressCmd = cmd
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// cutString is strings.Cut, that is not available in go 1.17.
+func cutString(s string, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,92 @@
+package hls
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+)
+
+// request parameters of signed urls
+const (
+	signedExpiresParam = "expires"
+	signedTokenParam   = "token"
+)
+
+// Sign returns token of urls within stream directory (e.g. /h264_720p/cam1)
+// valid until expires, as hex encoded HMAC-SHA256 of directory and expiry
+// in unix seconds separated by newline.
+func Sign(secret string, dir string, expires time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(dir + "\n" + strconv.FormatInt(expires.Unix(), 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+var (
+	ErrSignatureRequired = errors.New("signed url required")
+	ErrInvalidSignature  = errors.New("invalid signature")
+	ErrSignatureExpired  = errors.New("url expired")
+)
+
+// VerifySigned returns error unless request carries valid token of its
+// directory, that has not expired yet. Any request is valid without
+// secret.
+func VerifySigned(secret string, r *http.Request) error {
+	if secret == "" {
+		return nil
+	}
+
+	query := r.URL.Query()
+	unix, err := strconv.ParseInt(query.Get(signedExpiresParam), 10, 64)
+	if err != nil {
+		return ErrSignatureRequired
+	}
+
+	expires := time.Unix(unix, 0)
+	token := Sign(secret, path.Dir(r.URL.Path), expires)
+	if !hmac.Equal([]byte(token), []byte(query.Get(signedTokenParam))) {
+		return ErrInvalidSignature
+	}
+
+	if time.Now().After(expires) {
+		return ErrSignatureExpired
+	}
+
+	return nil
+}
+
+// verifySigned reports whether request is signed, otherwise it is refused.
+func (m *ManagerCtx) verifySigned(w http.ResponseWriter, r *http.Request) bool {
+	err := VerifySigned(m.config.SigningSecret, r)
+	if err == nil {
+		return true
+	}
+
+	if errors.Is(err, ErrInvalidSignature) {
+		m.logger.Debug().Str("path", r.URL.Path).Msg("invalid url signature")
+	}
+
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte("403 " + err.Error()))
+	return false
+}
+
+// signPlaylist appends signature of request to relative uris of playlist,
+// so that its segments, keys and variants are served until it expires.
+// Absolute uris, e.g. of segment store, are kept.
+func (m *ManagerCtx) signPlaylist(playlist string, r *http.Request) string {
+	if m.config.SigningSecret == "" {
+		return playlist
+	}
+
+	query := r.URL.Query()
+	return playlistAppendQuery(playlist, url.Values{
+		signedExpiresParam: {query.Get(signedExpiresParam)},
+		signedTokenParam:   {query.Get(signedTokenParam)},
+	}.Encode())
+}
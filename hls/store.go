@@ -0,0 +1,157 @@
+package hls
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// how many segments can wait for upload
+const uploadQueueSize = 64
+
+// SegmentStore receives completed segments, so that they can be served
+// from elsewhere (e.g. CDN) while origin keeps serving them too.
+type SegmentStore interface {
+	// Put stores segment file under given name.
+	Put(name string, filePath string, contentType string) error
+	// URL returns url of stored segment, to be used in playlists.
+	URL(name string) string
+}
+
+type upload struct {
+	name     string
+	filePath string
+}
+
+// uploader pushes segments to store in background, so that serving
+// never waits for store.
+type uploader struct {
+	manager *ManagerCtx
+	store   SegmentStore
+
+	mu sync.Mutex
+	// uploaded and pending segments
+	uploaded map[string]bool
+	pending  map[string]bool
+	queue    chan upload
+}
+
+func newUploader(manager *ManagerCtx, store SegmentStore, workers int) *uploader {
+	u := &uploader{
+		manager:  manager,
+		store:    store,
+		uploaded: map[string]bool{},
+		pending:  map[string]bool{},
+		queue:    make(chan upload, uploadQueueSize),
+	}
+
+	if workers <= 0 {
+		workers = 1
+	}
+
+	for i := 0; i < workers; i++ {
+		go u.work()
+	}
+
+	return u
+}
+
+func (u *uploader) work() {
+	for item := range u.queue {
+		err := u.store.Put(item.name, item.filePath, u.manager.config.mimeType(item.name))
+
+		u.mu.Lock()
+		delete(u.pending, item.name)
+		if err == nil {
+			u.uploaded[item.name] = true
+		}
+		u.mu.Unlock()
+
+		if err != nil {
+			u.manager.logger.Warn().Err(err).Str("segment", item.name).Msg("segment upload failed")
+			continue
+		}
+
+		// playlist now references stored segment
+		u.manager.mu.Lock()
+		u.manager.rendered = nil
+		u.manager.mu.Unlock()
+	}
+}
+
+// enqueue schedules upload of complete segments of playlist, that are
+// not in store yet. Segments no longer in playlist are forgotten, so
+// that reused names are uploaded again.
+func (u *uploader) enqueue(playlist string, tempdir string) {
+	p := parsePlaylist(playlist)
+
+	names := map[string]bool{}
+	if uri, ok := p.mapURI(); ok {
+		names[path.Base(uri)] = true
+	}
+	for _, segment := range p.segments {
+		names[path.Base(segment.uri)] = true
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for name := range u.uploaded {
+		if !names[name] {
+			delete(u.uploaded, name)
+		}
+	}
+
+	for name := range names {
+		if u.uploaded[name] || u.pending[name] {
+			continue
+		}
+
+		select {
+		case u.queue <- upload{name, path.Join(tempdir, name)}:
+			u.pending[name] = true
+		default:
+			// retried on next playlist
+			u.manager.logger.Warn().Str("segment", name).Msg("upload queue full")
+			return
+		}
+	}
+}
+
+// rewrite references uploaded segments and init segment using store urls.
+func (u *uploader) rewrite(playlist string) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	p := parsePlaylist(playlist)
+	rewriteMap := func(tags []string) {
+		for i, tag := range tags {
+			if !strings.HasPrefix(tag, "#EXT-X-MAP:") {
+				continue
+			}
+
+			for _, attr := range strings.Split(strings.TrimPrefix(tag, "#EXT-X-MAP:"), ",") {
+				if !strings.HasPrefix(attr, "URI=") {
+					continue
+				}
+
+				uri := strings.Trim(strings.TrimPrefix(attr, "URI="), `"`)
+				if u.uploaded[path.Base(uri)] {
+					tags[i] = strings.Replace(tag, attr, `URI="`+u.store.URL(path.Base(uri))+`"`, 1)
+				}
+			}
+		}
+	}
+
+	rewriteMap(p.header)
+	for i := range p.segments {
+		segment := &p.segments[i]
+		rewriteMap(segment.tags)
+
+		if u.uploaded[path.Base(segment.uri)] {
+			segment.uri = u.store.URL(path.Base(segment.uri))
+		}
+	}
+
+	return p.String()
+}
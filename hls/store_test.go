@@ -0,0 +1,99 @@
+package hls
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStore holds uploads until released, failing given segments.
+type testStore struct {
+	mu      sync.Mutex
+	active  int
+	max     int
+	failing map[string]bool
+	release chan struct{}
+}
+
+func (s *testStore) Put(name string, filePath string, contentType string) error {
+	s.mu.Lock()
+	s.active++
+	if s.active > s.max {
+		s.max = s.active
+	}
+	s.mu.Unlock()
+
+	<-s.release
+
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+
+	if s.failing[name] {
+		return errors.New("upload failed")
+	}
+	return nil
+}
+
+func (s *testStore) URL(name string) string {
+	return "https://cdn.example.com/" + name
+}
+
+func (s *testStore) running() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+func TestUploader(t *testing.T) {
+	store := &testStore{
+		failing: map[string]bool{"index1.ts": true},
+		release: make(chan struct{}),
+	}
+
+	m := New(nil, Config{SegmentDuration: 1})
+	u := newUploader(m, store, 3)
+
+	playlist := testPlaylist(0, 3, false)
+	u.enqueue(playlist, t.TempDir())
+
+	if !waitFor(time.Second, func() bool { return store.running() == 3 }) {
+		t.Fatalf("got %d uploads running, want 3 in parallel", store.running())
+	}
+
+	// segments are served by origin while being uploaded
+	if got := u.rewrite(playlist); got != playlist {
+		t.Errorf("playlist rewritten before upload finished:\n%s", got)
+	}
+
+	close(store.release)
+
+	ok := waitFor(time.Second, func() bool {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		return len(u.pending) == 0
+	})
+	if !ok {
+		t.Fatal("uploads did not finish")
+	}
+
+	got := u.rewrite(playlist)
+	for _, uri := range []string{"https://cdn.example.com/index0.ts\n", "\nindex1.ts\n", "https://cdn.example.com/index2.ts\n"} {
+		if !strings.Contains(got, uri) {
+			t.Errorf("uri %q missing:\n%s", uri, got)
+		}
+	}
+
+	// failed segment is uploaded again with next playlist
+	store.failing = nil
+	u.enqueue(testPlaylist(0, 3, false), t.TempDir())
+
+	ok = waitFor(time.Second, func() bool {
+		return strings.Contains(u.rewrite(playlist), "https://cdn.example.com/index1.ts\n")
+	})
+	if !ok {
+		t.Errorf("failed segment not uploaded again:\n%s", u.rewrite(playlist))
+	}
+}
@@ -0,0 +1,79 @@
+package hls
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ErrTempDirInUse = errors.New("tempdir is already used by another stream")
+
+// owners of stable tempdirs, so that no two managers write to same one
+var tempdirs = struct {
+	sync.Mutex
+	owners map[string]*ManagerCtx
+}{
+	owners: map[string]*ManagerCtx{},
+}
+
+func claimTempDir(dir string, m *ManagerCtx) error {
+	tempdirs.Lock()
+	defer tempdirs.Unlock()
+
+	owner, ok := tempdirs.owners[dir]
+	if ok && owner != m {
+		return ErrTempDirInUse
+	}
+
+	tempdirs.owners[dir] = m
+	return nil
+}
+
+// prepareTempDir returns directory for transcode output, must be called
+// with lock held. Tempdir of previous run is reused when restarted within
+// grace period, otherwise it is empty.
+func (m *ManagerCtx) prepareTempDir() (string, bool, error) {
+	withinGrace := m.config.RestartGrace > 0 && !m.stoppedAt.IsZero() &&
+		time.Since(m.stoppedAt) < m.config.RestartGrace
+
+	if m.config.TempDir == "" {
+		// reuse only if pending removal was cancelled before it started
+		removal := m.removal
+		m.removal = nil
+		if withinGrace && removal != nil && removal.Stop() {
+			return m.tempdir, true, nil
+		}
+
+		// root of stream may not exist yet
+		if m.config.TempRoot != "" {
+			if err := os.MkdirAll(m.config.TempRoot, 0755); err != nil {
+				return "", false, err
+			}
+		}
+
+		dir, err := os.MkdirTemp(m.config.TempRoot, "go-transcode-hls")
+		return dir, false, err
+	}
+
+	dir, err := filepath.Abs(m.config.TempDir)
+	if err != nil {
+		return "", false, err
+	}
+
+	if err := claimTempDir(dir, m); err != nil {
+		return "", false, err
+	}
+
+	if withinGrace && dir == m.tempdir {
+		return dir, true, nil
+	}
+
+	// remove leftovers of previous run
+	if err := os.RemoveAll(dir); err != nil {
+		return "", false, err
+	}
+
+	return dir, false, os.MkdirAll(dir, 0755)
+}
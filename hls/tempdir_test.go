@@ -0,0 +1,88 @@
+package hls
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStableTempDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cam", "h264_720p")
+
+	m := New(nil, Config{TempDir: dir})
+	got, reused, err := m.prepareTempDir()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got != dir || reused {
+		t.Errorf("got %q (reused %v), want %q", got, reused, dir)
+	}
+
+	// leftovers of previous run are removed on next start
+	leftover := filepath.Join(dir, "index0.ts")
+	if err := os.WriteFile(leftover, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m.tempdir = got
+	if got, _, err := m.prepareTempDir(); err != nil || got != dir {
+		t.Fatalf("got %q (%v), want same path %q", got, err, dir)
+	}
+
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("leftover of previous run kept")
+	}
+
+	// relative path resolves to same directory
+	other := New(nil, Config{TempDir: dir + "/../h264_720p"})
+	if _, _, err := other.prepareTempDir(); !errors.Is(err, ErrTempDirInUse) {
+		t.Errorf("got error %v, want %v", err, ErrTempDirInUse)
+	}
+}
+
+func TestRestartGraceTempDir(t *testing.T) {
+	var starts int32
+	m := New(testLiveCmd(t, 3, &starts), Config{
+		SegmentDuration: 1,
+		TempRoot:        t.TempDir(),
+		RestartGrace:    500 * time.Millisecond,
+	})
+	defer m.Shutdown()
+
+	if err := m.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first := m.tempdir
+	m.Stop()
+
+	// restarted within grace
+	if err := m.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.tempdir != first {
+		t.Errorf("got tempdir %q, want reused %q", m.tempdir, first)
+	}
+	if _, err := os.Stat(first); err != nil {
+		t.Errorf("reused tempdir removed: %v", err)
+	}
+	m.Stop()
+
+	// removed once grace passes, at least after removal delay
+	ok := waitFor(3*time.Second, func() bool {
+		_, err := os.Stat(first)
+		return os.IsNotExist(err)
+	})
+	if !ok {
+		t.Fatal("tempdir not removed after grace")
+	}
+
+	if err := m.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.tempdir == first {
+		t.Errorf("removed tempdir %q reused after grace", first)
+	}
+}
@@ -1,16 +1,85 @@
 package hls
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
+
+// StreamError is last error of stream, cleared once it warms up.
+type StreamError struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+type Stats struct {
+	Running   bool         `json:"running"`
+	Active    bool         `json:"active"`
+	Pid       int          `json:"pid,omitempty"`
+	Sequence  int          `json:"sequence"`
+	LastError *StreamError `json:"last_error"`
+	// seconds since transcode started
+	Uptime      float64    `json:"uptime,omitempty"`
+	LastRequest *time.Time `json:"last_request,omitempty"`
+	// reached max duration, must be started explicitly
+	Expired bool `json:"expired,omitempty"`
+	// video failed, only audio is being output
+	AudioOnly bool `json:"audio_only,omitempty"`
+	// stopped by watchdog, must be started explicitly
+	Frozen bool `json:"frozen,omitempty"`
+	// consecutive freezes seen by watchdog
+	Freezes int `json:"freezes,omitempty"`
+	// backup source in use counted from 1, omitted for primary source
+	Source int `json:"source,omitempty"`
+	// served bytes, when accounting is enabled
+	Bandwidth *BandwidthStats `json:"bandwidth,omitempty"`
+	// last playlist update of running transcode
+	LastUpdate *time.Time `json:"last_update,omitempty"`
+	// progress reported by running transcode, when its profile enables it
+	Progress *Progress `json:"progress,omitempty"`
+	// bytes of tempdir of running transcode, measured every cleanup period
+	TempDirSize int64 `json:"tempdir_size,omitempty"`
+
+	// totals over manager lifetime
+	Segments         int     `json:"segments"`
+	PlaylistRequests int     `json:"playlist_requests"`
+	Restarts         int     `json:"restarts"`
+	CPUSeconds       float64 `json:"cpu_seconds"`
+}
+
+// Segment of current playlist, as stored in tempdir.
+type Segment struct {
+	Name     string    `json:"name"`
+	Duration float64   `json:"duration"`
+	Sequence int       `json:"sequence"`
+	ModTime  time.Time `json:"mtime"`
+	Size     int64     `json:"size"`
+}
 
 type Manager interface {
 	Start() error
 	Stop()
+	Shutdown()
+	Restart() error
+	Pause()
+	Resume()
 	Cleanup()
+	Active() bool
+	Pid() int
+	Stats() Stats
+	Segments() ([]Segment, bool)
+	ReadSegment(name string) ([]byte, error)
+	ResetBandwidth()
 
 	ServePlaylist(w http.ResponseWriter, r *http.Request)
 	ServeMedia(w http.ResponseWriter, r *http.Request)
+	ServeVariant(w http.ResponseWriter, r *http.Request)
+	ServeKey(w http.ResponseWriter, r *http.Request)
 
 	OnStart(event func())
 	OnCmdLog(event func(message string))
 	OnStop(event func())
+	OnAlert(event func(message string))
+	OnPlaylist(event func(sequence int))
+	OnRestart(event func(reason string))
+	OnError(event func(message string))
 }
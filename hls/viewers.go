@@ -0,0 +1,183 @@
+package hls
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// how long is viewer watching after its last request
+const viewerTimeout = 30 * time.Second
+
+// request parameter identifying viewer session
+const viewerSessionParam = "session"
+
+// ViewerStats of stream, since viewers tracking was created.
+type ViewerStats struct {
+	Current int        `json:"current"`
+	Peak    int        `json:"peak"`
+	PeakAt  *time.Time `json:"peak_at,omitempty"`
+	Since   time.Time  `json:"since"`
+}
+
+// Viewers tracks unique viewers by session request parameter, or by client
+// address and user agent. Shared by managers of the same stream, so that
+// viewer switching profiles is counted once.
+type Viewers struct {
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+	// session of client, whose relative segment and variant urls do not
+	// carry session parameter
+	sessions map[string]string
+	peak     int
+	peakAt   time.Time
+	since    time.Time
+
+	// called with lock held
+	events struct {
+		onFirst func()
+		onLast  func()
+		onJoin  func(id string)
+		onLeave func(id string)
+	}
+}
+
+func NewViewers() *Viewers {
+	return &Viewers{
+		lastSeen: map[string]time.Time{},
+		sessions: map[string]string{},
+		since:    time.Now(),
+	}
+}
+
+// viewerID returns session of request, or of its client when known, must
+// be called with lock held.
+func (v *Viewers) viewerID(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	client := "client:" + host + " " + r.UserAgent()
+
+	if session := r.URL.Query().Get(viewerSessionParam); session != "" {
+		v.sessions[client] = "session:" + session
+		return v.sessions[client]
+	}
+
+	if session, ok := v.sessions[client]; ok {
+		return session
+	}
+
+	return client
+}
+
+// expire forgets viewers gone for viewer timeout, must be called with lock
+// held.
+func (v *Viewers) expire(now time.Time) {
+	watching := len(v.lastSeen)
+
+	for id, lastSeen := range v.lastSeen {
+		if now.Sub(lastSeen) > viewerTimeout {
+			delete(v.lastSeen, id)
+
+			if v.events.onLeave != nil {
+				v.events.onLeave(id)
+			}
+		}
+	}
+
+	for client, session := range v.sessions {
+		if _, ok := v.lastSeen[session]; !ok {
+			delete(v.sessions, client)
+		}
+	}
+
+	if watching > 0 && len(v.lastSeen) == 0 && v.events.onLast != nil {
+		v.events.onLast()
+	}
+}
+
+// Expire forgets viewers gone for viewer timeout, so that last viewer
+// leaving is noticed without further requests.
+func (v *Viewers) Expire() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.expire(time.Now())
+}
+
+// touch records request of viewer.
+func (v *Viewers) touch(r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	v.expire(now)
+
+	watching := len(v.lastSeen)
+	id := v.viewerID(r)
+	_, seen := v.lastSeen[id]
+	v.lastSeen[id] = now
+
+	if watching == 0 && v.events.onFirst != nil {
+		v.events.onFirst()
+	}
+
+	if !seen && v.events.onJoin != nil {
+		v.events.onJoin(id)
+	}
+
+	if len(v.lastSeen) > v.peak {
+		v.peak = len(v.lastSeen)
+		v.peakAt = now
+	}
+}
+
+// watching reports whether viewer of session is currently watching.
+func (v *Viewers) watching(session string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	lastSeen, ok := v.lastSeen["session:"+session]
+	return ok && time.Since(lastSeen) <= viewerTimeout
+}
+
+func (v *Viewers) Stats() ViewerStats {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.expire(time.Now())
+
+	stats := ViewerStats{
+		Current: len(v.lastSeen),
+		Peak:    v.peak,
+		Since:   v.since,
+	}
+
+	if v.peak > 0 {
+		peakAt := v.peakAt
+		stats.PeakAt = &peakAt
+	}
+
+	return stats
+}
+
+func (v *Viewers) OnFirstViewer(event func()) {
+	v.events.onFirst = event
+}
+
+func (v *Viewers) OnLastViewer(event func()) {
+	v.events.onLast = event
+}
+
+// OnViewerJoin is called with id of every viewer starting to watch, it is
+// session or client address and user agent.
+func (v *Viewers) OnViewerJoin(event func(id string)) {
+	v.events.onJoin = event
+}
+
+// OnViewerLeave is called with id of every viewer gone for viewer timeout.
+func (v *Viewers) OnViewerLeave(event func(id string)) {
+	v.events.onLeave = event
+}
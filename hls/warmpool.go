@@ -0,0 +1,59 @@
+package hls
+
+import "sync"
+
+// WarmPool keeps most recently requested streams running beyond their
+// idle timeout. Least recently requested stream is evicted when another
+// one takes its slot, and is then torn down once idle.
+type WarmPool struct {
+	mu   sync.Mutex
+	size int
+	// most recently requested first
+	order []*ManagerCtx
+}
+
+func NewWarmPool(size int) *WarmPool {
+	return &WarmPool{
+		size:  size,
+		order: []*ManagerCtx{},
+	}
+}
+
+func (p *WarmPool) touch(m *ManagerCtx) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	order := []*ManagerCtx{m}
+	for _, other := range p.order {
+		if other != m && len(order) < p.size {
+			order = append(order, other)
+		}
+	}
+
+	p.order = order
+}
+
+func (p *WarmPool) contains(m *ManagerCtx) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, other := range p.order {
+		if other == m {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *WarmPool) remove(m *ManagerCtx) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, other := range p.order {
+		if other == m {
+			p.order = append(p.order[:i:i], p.order[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package hls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWarmPoolEviction(t *testing.T) {
+	pool := NewWarmPool(2)
+	first, second, third := New(nil, Config{}), New(nil, Config{}), New(nil, Config{})
+
+	pool.touch(first)
+	pool.touch(second)
+	// requested again, second becomes least recently used
+	pool.touch(first)
+	pool.touch(third)
+
+	for _, tt := range []struct {
+		name string
+		m    *ManagerCtx
+		want bool
+	}{
+		{"first", first, true},
+		{"second", second, false},
+		{"third", third, true},
+	} {
+		if got := pool.contains(tt.m); got != tt.want {
+			t.Errorf("%s: got pooled %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	pool.remove(first)
+	if pool.contains(first) || !pool.contains(third) {
+		t.Errorf("got order %v after removal", pool.order)
+	}
+}
+
+func TestWarmPoolCleanup(t *testing.T) {
+	pool := NewWarmPool(2)
+
+	managers := make([]*ManagerCtx, 3)
+	for i := range managers {
+		var starts int32
+		managers[i] = New(testLiveCmd(t, 3, &starts), Config{
+			SegmentDuration: 2,
+			TempRoot:        t.TempDir(),
+			WarmPool:        pool,
+		})
+		defer managers[i].Shutdown()
+
+		if err := managers[i].Start(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// last two requested streams take both slots
+	for _, m := range managers {
+		w := httptest.NewRecorder()
+		m.ServePlaylist(w, httptest.NewRequest(http.MethodGet, "/index.m3u8", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+		}
+	}
+
+	// all of them idle for longer than idle timeout
+	for _, m := range managers {
+		m.mu.Lock()
+		m.lastRequest = time.Now().Add(-time.Minute)
+		m.mu.Unlock()
+		m.Cleanup()
+	}
+
+	if managers[0].Pid() != 0 {
+		t.Error("least recently requested stream kept running past idle")
+	}
+	for i, m := range managers[1:] {
+		if m.Pid() == 0 {
+			t.Errorf("pooled stream %d stopped while idle", i+1)
+		}
+	}
+}
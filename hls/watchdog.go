@@ -0,0 +1,161 @@
+package hls
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"time"
+)
+
+// watchdog actions taken on frozen stream
+const (
+	WatchdogRestart  = "restart"
+	WatchdogFallback = "fallback"
+	WatchdogStop     = "stop"
+)
+
+type WatchdogStep struct {
+	// consecutive freezes needed to reach this step
+	Freezes int
+	Action  string
+}
+
+type Watchdog struct {
+	// stream is frozen when playlist is not updated for this long, zero
+	// disables watchdog
+	FreezeTimeout time.Duration
+	// freezes are forgotten after this long without freeze, zero never
+	Reset time.Duration
+	// escalation ladder, action of last step reached by consecutive
+	// freezes is taken, restart when none is reached
+	Steps []WatchdogStep
+	// stream is stalled when it is being watched, but no segments were
+	// produced for this long, zero disables
+	StallTimeout time.Duration
+}
+
+func (w *Watchdog) Validate() error {
+	if w.FreezeTimeout < 0 || w.Reset < 0 || w.StallTimeout < 0 {
+		return fmt.Errorf("watchdog durations must not be negative")
+	}
+
+	for _, step := range w.Steps {
+		if step.Freezes <= 0 {
+			return fmt.Errorf("watchdog step freezes must be positive")
+		}
+
+		switch step.Action {
+		case WatchdogRestart, WatchdogFallback, WatchdogStop:
+		default:
+			return fmt.Errorf("unknown watchdog action %q", step.Action)
+		}
+	}
+
+	return nil
+}
+
+// action returns action for given count of consecutive freezes.
+func (w *Watchdog) action(freezes int) string {
+	steps := append([]WatchdogStep{}, w.Steps...)
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].Freezes < steps[j].Freezes
+	})
+
+	action := WatchdogRestart
+	for _, step := range steps {
+		if step.Freezes <= freezes {
+			action = step.Action
+		}
+	}
+
+	return action
+}
+
+// checkFreeze escalates when cmd did not update playlist within freeze
+// timeout.
+func (m *ManagerCtx) checkFreeze(cmd *exec.Cmd) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	watchdog := m.config.Watchdog
+	if m.cmd != cmd || !m.active || time.Since(m.lastUpdate) < watchdog.FreezeTimeout {
+		return
+	}
+
+	if watchdog.Reset > 0 && time.Since(m.lastFreeze) > watchdog.Reset {
+		m.freezes = 0
+	}
+
+	m.freezes++
+	m.lastFreeze = time.Now()
+
+	action := watchdog.action(m.freezes)
+	m.logger.Warn().
+		Int("freezes", m.freezes).
+		Dur("timeout", watchdog.FreezeTimeout).
+		Str("action", action).
+		Msg("stream frozen")
+
+	var err error
+	switch action {
+	case WatchdogRestart:
+		err = m.restart("frozen", m.audioOnly, m.fallback)
+	case WatchdogFallback:
+		if m.config.FallbackCmd == nil {
+			err = fmt.Errorf("no fallback source")
+			break
+		}
+		err = m.restart("frozen, using fallback source", m.audioOnly, true)
+	case WatchdogStop:
+		m.alert(fmt.Sprintf("stream stopped after %d consecutive freezes", m.freezes))
+		m.stop()
+		m.frozen = true
+		m.freezes = 0
+	}
+
+	if err != nil {
+		m.logger.Err(err).Str("action", action).Msg("watchdog action failed")
+		m.alert(fmt.Sprintf("watchdog %s failed: %v", action, err))
+	}
+}
+
+// checkStall restarts cmd with reconnect options, when it did not produce
+// segments within stall timeout while being watched.
+func (m *ManagerCtx) checkStall(cmd *exec.Cmd) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	timeout := m.config.Watchdog.StallTimeout
+	if m.cmd != cmd || !m.active || time.Since(m.lastUpdate) < timeout {
+		return
+	}
+
+	// stream without recent requests is not watched, idle cleanup stops it
+	if time.Since(m.lastRequest) > timeout {
+		return
+	}
+
+	reason := "stalled"
+	if m.failover(reason) {
+		reason = "stalled, using backup source"
+	} else {
+		m.logger.Warn().
+			Dur("timeout", timeout).
+			Bool("reconnect", m.config.ReconnectCmd != nil).
+			Msg("stream stalled")
+
+		m.reconnect = true
+	}
+
+	if err := m.restart(reason, m.audioOnly, m.fallback); err != nil {
+		m.logger.Err(err).Msg("watchdog restart of stalled stream failed")
+		m.alert(fmt.Sprintf("watchdog restart of stalled stream failed: %v", err))
+	}
+}
+
+// alert must be called with lock held.
+func (m *ManagerCtx) alert(message string) {
+	if m.events.onAlert != nil {
+		m.events.onAlert(message)
+	}
+}
@@ -0,0 +1,124 @@
+package hls
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchdogAction(t *testing.T) {
+	w := Watchdog{Steps: []WatchdogStep{
+		{Freezes: 3, Action: WatchdogStop},
+		{Freezes: 2, Action: WatchdogFallback},
+	}}
+
+	tests := []struct {
+		freezes int
+		want    string
+	}{
+		{1, WatchdogRestart},
+		{2, WatchdogFallback},
+		{3, WatchdogStop},
+		{5, WatchdogStop},
+	}
+
+	for _, tt := range tests {
+		if got := w.action(tt.freezes); got != tt.want {
+			t.Errorf("action(%d): got %q, want %q", tt.freezes, got, tt.want)
+		}
+	}
+}
+
+func TestWatchdogLadder(t *testing.T) {
+	var starts int32
+	cmd := testLiveCmd(t, 3, &starts)
+
+	m := New(cmd, Config{
+		SegmentDuration: 2,
+		TempRoot:        t.TempDir(),
+		FallbackCmd:     cmd,
+		Watchdog: Watchdog{
+			// checked by test only
+			FreezeTimeout: time.Minute,
+			Reset:         300 * time.Millisecond,
+			Steps: []WatchdogStep{
+				{Freezes: 1, Action: WatchdogRestart},
+				{Freezes: 2, Action: WatchdogFallback},
+				{Freezes: 3, Action: WatchdogStop},
+			},
+		},
+	})
+	defer m.Shutdown()
+
+	var mu sync.Mutex
+	actions := []string{}
+	m.OnRestart(func(reason string) {
+		mu.Lock()
+		defer mu.Unlock()
+		actions = append(actions, reason)
+	})
+	m.OnAlert(func(message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		actions = append(actions, message)
+	})
+
+	taken := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, actions...)
+	}
+
+	// freeze makes playlist of running cmd outdated
+	freeze := func() {
+		t.Helper()
+
+		if !waitFor(time.Second, m.Active) {
+			t.Fatal("stream did not warm up")
+		}
+
+		m.mu.Lock()
+		cmd := m.cmd
+		m.lastUpdate = time.Now().Add(-time.Hour)
+		m.mu.Unlock()
+
+		m.checkFreeze(cmd)
+	}
+
+	if err := m.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	freeze()
+	freeze()
+	freeze()
+
+	want := []string{"frozen", "frozen, using fallback source", "stream stopped after 3 consecutive freezes"}
+	if got := taken(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got actions %q, want %q", got, want)
+	}
+	if !m.Stats().Frozen || m.Pid() != 0 {
+		t.Error("stream not stopped at last step")
+	}
+
+	// ladder starts over after healthy period
+	mu.Lock()
+	actions = actions[:0]
+	mu.Unlock()
+	if err := m.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	freeze()
+	time.Sleep(2 * m.config.Watchdog.Reset)
+	freeze()
+
+	want = []string{"frozen", "frozen"}
+	if got := taken(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got actions %q after healthy period, want %q", got, want)
+	}
+	if m.Stats().Freezes != 1 {
+		t.Errorf("got %d freezes, want 1", m.Stats().Freezes)
+	}
+}
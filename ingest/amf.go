@@ -0,0 +1,204 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sort"
+)
+
+// amf0 markers
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0a
+	amfDate        = 0x0b
+	amfLongString  = 0x0c
+)
+
+var errAMF = errors.New("invalid amf0 data")
+
+type amfObjectMap map[string]interface{}
+
+// amfUndefinedValue is encoded as undefined, nil is encoded as null.
+type amfUndefinedValue struct{}
+
+func amfDecodeAll(data []byte) ([]interface{}, error) {
+	r := bytes.NewReader(data)
+
+	values := []interface{}{}
+	for r.Len() > 0 {
+		value, err := amfDecode(r)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func amfDecode(r *bytes.Reader) (interface{}, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker {
+	case amfNumber:
+		var bits uint64
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(bits), nil
+	case amfBoolean:
+		b, err := r.ReadByte()
+		return b != 0, err
+	case amfString:
+		return amfDecodeString(r)
+	case amfObject:
+		return amfDecodeProperties(r)
+	case amfNull:
+		return nil, nil
+	case amfUndefined:
+		return amfUndefinedValue{}, nil
+	case amfECMAArray:
+		// count is only a hint, properties are terminated as in object
+		if _, err := r.Seek(4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		return amfDecodeProperties(r)
+	case amfStrictArray:
+		var count uint32
+		if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+			return nil, err
+		}
+		values := []interface{}{}
+		for i := uint32(0); i < count; i++ {
+			value, err := amfDecode(r)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case amfDate:
+		// milliseconds and timezone
+		var bits uint64
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, err
+		}
+		_, err := r.Seek(2, io.SeekCurrent)
+		return math.Float64frombits(bits), err
+	case amfLongString:
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, length)
+		_, err := io.ReadFull(r, buf)
+		return string(buf), err
+	}
+
+	return nil, errAMF
+}
+
+func amfDecodeString(r *bytes.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, length)
+	_, err := io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+func amfDecodeProperties(r *bytes.Reader) (amfObjectMap, error) {
+	object := amfObjectMap{}
+	for {
+		key, err := amfDecodeString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if key == "" {
+			marker, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if marker == amfObjectEnd {
+				return object, nil
+			}
+			if err := r.UnreadByte(); err != nil {
+				return nil, err
+			}
+		}
+
+		value, err := amfDecode(r)
+		if err != nil {
+			return nil, err
+		}
+		object[key] = value
+	}
+}
+
+func amfEncode(values ...interface{}) []byte {
+	var b bytes.Buffer
+	for _, value := range values {
+		amfEncodeValue(&b, value)
+	}
+	return b.Bytes()
+}
+
+func amfEncodeValue(b *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case float64:
+		b.WriteByte(amfNumber)
+		binary.Write(b, binary.BigEndian, math.Float64bits(v))
+	case int:
+		amfEncodeValue(b, float64(v))
+	case bool:
+		b.WriteByte(amfBoolean)
+		if v {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+	case string:
+		b.WriteByte(amfString)
+		amfEncodeString(b, v)
+	case amfObjectMap:
+		b.WriteByte(amfObject)
+
+		// stable order of properties
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			amfEncodeString(b, key)
+			amfEncodeValue(b, v[key])
+		}
+		b.Write([]byte{0, 0, amfObjectEnd})
+	case amfUndefinedValue:
+		b.WriteByte(amfUndefined)
+	default:
+		b.WriteByte(amfNull)
+	}
+}
+
+func amfEncodeString(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
@@ -0,0 +1,12 @@
+package ingest
+
+import "time"
+
+type Config struct {
+	// rtmp address to listen on, e.g. :1935, empty disables rtmp
+	Bind string
+	// whether stream can be published at app/key path
+	Allowed func(path string) bool
+	// connections without data for this long are closed
+	Timeout time.Duration
+}
@@ -0,0 +1,57 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var errFLV = errors.New("invalid flv data")
+
+// readFLVHeader reads flv header and first previous tag size.
+func readFLVHeader(r *bufio.Reader) error {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+
+	if string(header[:3]) != "FLV" {
+		return errFLV
+	}
+
+	// skip rest of header and first previous tag size
+	skip := int(binary.BigEndian.Uint32(header[5:9])) - 9 + 4
+	if skip < 4 {
+		return errFLV
+	}
+
+	_, err := r.Discard(skip)
+	return err
+}
+
+// readFLVTag reads tag followed by its previous tag size.
+func readFLVTag(r *bufio.Reader) (tag, error) {
+	header := make([]byte, 11)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return tag{}, err
+	}
+
+	size := uint32(header[1])<<16 | uint32(header[2])<<8 | uint32(header[3])
+	if size > maxMessageSize {
+		return tag{}, errFLV
+	}
+
+	t := tag{
+		kind:      header[0] & 0x1f,
+		timestamp: uint32(header[7])<<24 | uint32(header[4])<<16 | uint32(header[5])<<8 | uint32(header[6]),
+		data:      make([]byte, size),
+	}
+
+	if _, err := io.ReadFull(r, t.data); err != nil {
+		return tag{}, err
+	}
+
+	_, err := r.Discard(4)
+	return t, err
+}
@@ -0,0 +1,90 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// delay before relay command is started again
+const relayRestartDelay = time.Second
+
+// Relay publishes flv output of command at path, regardless of allowed
+// paths. Path is published once first tag is received. Command is started
+// again whenever it exits, e.g. after caller of listener disconnects,
+// until server is shut down.
+func (s *ServerCtx) Relay(path string, newCmd func(ctx context.Context) *exec.Cmd) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		logger := s.logger.With().Str("path", path).Logger()
+		for {
+			err := s.relay(path, newCmd(s.ctx))
+			if err != nil && !errors.Is(err, io.EOF) && s.ctx.Err() == nil {
+				logger.Warn().Err(err).Msg("relay failed")
+			}
+
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(relayRestartDelay):
+			}
+		}
+	}()
+}
+
+func (s *ServerCtx) relay(path string, cmd *exec.Cmd) error {
+	logger := s.logger.With().Str("path", path).Logger()
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	cmd.Stderr = utils.LogWriter(logger)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var published *stream
+	defer func() {
+		//nolint
+		cmd.Process.Kill()
+		//nolint
+		cmd.Wait()
+
+		if published != nil {
+			s.unregister(path)
+			published.close()
+			logger.Info().Msg("relay ended")
+		}
+	}()
+
+	reader := bufio.NewReader(stdout)
+	if err := readFLVHeader(reader); err != nil {
+		return err
+	}
+
+	for {
+		t, err := readFLVTag(reader)
+		if err != nil {
+			return err
+		}
+
+		if published == nil {
+			published, err = s.claim(path)
+			if err != nil {
+				return err
+			}
+			logger.Info().Msg("relay started")
+		}
+
+		published.publish(t)
+	}
+}
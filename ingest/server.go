@@ -0,0 +1,186 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrNotAllowed = errors.New("publishing to path is not allowed")
+var ErrAlreadyPublished = errors.New("path is already being published")
+
+// ServerCtx accepts rtmp connections of encoders and runs relays, every
+// published path can be subscribed to as flv stream.
+type ServerCtx struct {
+	logger zerolog.Logger
+	config Config
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	sessions map[*session]struct{}
+	streams  map[string]*stream
+}
+
+func New(config Config) *ServerCtx {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &ServerCtx{
+		logger:   log.With().Str("module", "ingest").Logger(),
+		config:   config,
+		ctx:      ctx,
+		cancel:   cancel,
+		sessions: map[*session]struct{}{},
+		streams:  map[string]*stream{},
+	}
+}
+
+// Start listens for rtmp connections, unless bind address is empty.
+func (s *ServerCtx) Start() error {
+	if s.config.Bind == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", s.config.Bind)
+	if err != nil {
+		return err
+	}
+
+	s.listener = listener
+	s.logger.Info().Str("bind", s.config.Bind).Msg("rtmp ingest listening")
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					s.logger.Err(err).Msg("accept failed")
+				}
+				return
+			}
+
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+
+	return nil
+}
+
+func (s *ServerCtx) Shutdown() error {
+	s.cancel()
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+
+	s.mu.Lock()
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *ServerCtx) serve(conn net.Conn) {
+	sess := &session{
+		logger: s.logger.With().Str("remote", conn.RemoteAddr().String()).Logger(),
+		server: s,
+		conn:   conn,
+	}
+
+	s.mu.Lock()
+	s.sessions[sess] = struct{}{}
+	s.mu.Unlock()
+
+	sess.logger.Debug().Msg("connection accepted")
+
+	err := sess.run()
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		sess.logger.Warn().Err(err).Msg("connection failed")
+	}
+
+	conn.Close()
+
+	s.mu.Lock()
+	delete(s.sessions, sess)
+	s.mu.Unlock()
+
+	if sess.publish != nil {
+		s.unregister(sess.path)
+		sess.publish.close()
+		sess.logger.Info().Str("path", sess.path).Msg("publish ended")
+	}
+}
+
+// register claims path for publishing session.
+func (s *ServerCtx) register(path string) (*stream, error) {
+	if s.config.Allowed != nil && !s.config.Allowed(path) {
+		return nil, ErrNotAllowed
+	}
+
+	return s.claim(path)
+}
+
+// claim path for publishing, unless it is already published.
+func (s *ServerCtx) claim(path string) (*stream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.streams[path]; ok {
+		return nil, ErrAlreadyPublished
+	}
+
+	published := newStream()
+	s.streams[path] = published
+	return published, nil
+}
+
+func (s *ServerCtx) unregister(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.streams, path)
+}
+
+// Subscribe returns flv stream of published path, starting at keyframe.
+// Stream ends, when publisher disconnects.
+func (s *ServerCtx) Subscribe(path string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	published, ok := s.streams[path]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotPublished)
+	}
+
+	return published.subscribe()
+}
+
+// Published returns whether path is currently being published.
+func (s *ServerCtx) Published(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.streams[path]
+	return ok
+}
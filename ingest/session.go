@@ -0,0 +1,433 @@
+package ingest
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const handshakeSize = 1536
+
+// rtmp message types of protocol control and commands
+const (
+	typeSetChunkSize     = 1
+	typeAbort            = 2
+	typeAcknowledgement  = 3
+	typeUserControl      = 4
+	typeWindowAckSize    = 5
+	typeSetPeerBandwidth = 6
+	typeCommand          = 20
+)
+
+// chunk size and acknowledgement window announced to clients
+const (
+	outChunkSize = 4096
+	ackWindow    = 2500000
+)
+
+// chunk streams used for responses
+const (
+	csidControl = 2
+	csidCommand = 3
+	csidStatus  = 5
+)
+
+// id of the only message stream created for publishing
+const publishStreamID = 1
+
+// maximum accepted message size
+const maxMessageSize = 16 << 20
+
+type chunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	kind      byte
+	streamID  uint32
+	extended  bool
+
+	buf []byte
+}
+
+type message struct {
+	kind      byte
+	timestamp uint32
+	streamID  uint32
+	data      []byte
+}
+
+// session of single rtmp connection, that can publish one stream.
+type session struct {
+	logger zerolog.Logger
+	server *ServerCtx
+	conn   net.Conn
+	reader *bufio.Reader
+
+	inChunkSize uint32
+	chunks      map[uint32]*chunkStream
+
+	// bytes received and acknowledgement window requested by client
+	received  uint32
+	acked     uint32
+	ackWindow uint32
+
+	app     string
+	path    string
+	publish *stream
+}
+
+func (s *session) Read(p []byte) (int, error) {
+	if s.server.config.Timeout > 0 {
+		s.conn.SetReadDeadline(time.Now().Add(s.server.config.Timeout))
+	}
+	n, err := s.conn.Read(p)
+	s.received += uint32(n)
+	return n, err
+}
+
+func (s *session) run() error {
+	s.reader = bufio.NewReader(s)
+	s.inChunkSize = 128
+	s.chunks = map[uint32]*chunkStream{}
+
+	if err := s.handshake(); err != nil {
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+
+	for {
+		msg, err := s.readMessage()
+		if err != nil {
+			return err
+		}
+
+		if err := s.acknowledge(); err != nil {
+			return err
+		}
+
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handshake performs simple handshake, without digest.
+func (s *session) handshake() error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(s.reader, c0c1); err != nil {
+		return err
+	}
+
+	if c0c1[0] != 3 {
+		return fmt.Errorf("unsupported rtmp version %d", c0c1[0])
+	}
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	s0s1s2[0] = 3
+	if _, err := rand.Read(s0s1s2[9 : 1+handshakeSize]); err != nil {
+		return err
+	}
+	copy(s0s1s2[1+handshakeSize:], c0c1[1:])
+
+	if _, err := s.conn.Write(s0s1s2); err != nil {
+		return err
+	}
+
+	c2 := make([]byte, handshakeSize)
+	_, err := io.ReadFull(s.reader, c2)
+	return err
+}
+
+func (s *session) readMessage() (message, error) {
+	for {
+		basic, err := s.reader.ReadByte()
+		if err != nil {
+			return message{}, err
+		}
+
+		format := basic >> 6
+		csid := uint32(basic & 0x3f)
+		switch csid {
+		case 0:
+			b, err := s.reader.ReadByte()
+			if err != nil {
+				return message{}, err
+			}
+			csid = 64 + uint32(b)
+		case 1:
+			b := make([]byte, 2)
+			if _, err := io.ReadFull(s.reader, b); err != nil {
+				return message{}, err
+			}
+			csid = 64 + uint32(b[0]) + uint32(b[1])*256
+		}
+
+		cs, ok := s.chunks[csid]
+		if !ok {
+			if format != 0 {
+				return message{}, fmt.Errorf("chunk stream %d starts with format %d", csid, format)
+			}
+			cs = &chunkStream{}
+			s.chunks[csid] = cs
+		}
+
+		fresh := len(cs.buf) == 0
+
+		var header []byte
+		switch format {
+		case 0:
+			header = make([]byte, 11)
+		case 1:
+			header = make([]byte, 7)
+		case 2:
+			header = make([]byte, 3)
+		}
+
+		if _, err := io.ReadFull(s.reader, header); err != nil {
+			return message{}, err
+		}
+
+		if format < 3 {
+			ts := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+			cs.extended = ts == 0xffffff
+			if cs.extended {
+				b := make([]byte, 4)
+				if _, err := io.ReadFull(s.reader, b); err != nil {
+					return message{}, err
+				}
+				ts = binary.BigEndian.Uint32(b)
+			}
+
+			if format == 0 {
+				cs.timestamp = ts
+				cs.delta = 0
+			} else {
+				cs.delta = ts
+				cs.timestamp += ts
+			}
+
+			if format < 2 {
+				cs.length = uint32(header[3])<<16 | uint32(header[4])<<8 | uint32(header[5])
+				cs.kind = header[6]
+			}
+
+			if format == 0 {
+				cs.streamID = binary.LittleEndian.Uint32(header[7:11])
+			}
+		} else {
+			if cs.extended {
+				b := make([]byte, 4)
+				if _, err := io.ReadFull(s.reader, b); err != nil {
+					return message{}, err
+				}
+			}
+
+			// new message continuing previous one in chunk stream
+			if fresh {
+				cs.timestamp += cs.delta
+			}
+		}
+
+		if cs.length > maxMessageSize {
+			return message{}, fmt.Errorf("message of %d bytes exceeds limit", cs.length)
+		}
+
+		size := cs.length - uint32(len(cs.buf))
+		if size > s.inChunkSize {
+			size = s.inChunkSize
+		}
+
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(s.reader, chunk); err != nil {
+			return message{}, err
+		}
+		cs.buf = append(cs.buf, chunk...)
+
+		if uint32(len(cs.buf)) < cs.length {
+			continue
+		}
+
+		msg := message{
+			kind:      cs.kind,
+			timestamp: cs.timestamp,
+			streamID:  cs.streamID,
+			data:      cs.buf,
+		}
+		cs.buf = nil
+		return msg, nil
+	}
+}
+
+// acknowledge reports received bytes, once client window is exceeded.
+func (s *session) acknowledge() error {
+	if s.ackWindow == 0 || s.received-s.acked < s.ackWindow {
+		return nil
+	}
+
+	s.acked = s.received
+	return s.writeMessage(csidControl, message{kind: typeAcknowledgement, data: uint32Bytes(s.received)})
+}
+
+func uint32Bytes(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return b
+}
+
+func (s *session) writeMessage(csid byte, msg message) error {
+	var buf []byte
+
+	header := make([]byte, 12)
+	header[0] = csid
+	header[1], header[2], header[3] = byte(msg.timestamp>>16), byte(msg.timestamp>>8), byte(msg.timestamp)
+	header[4], header[5], header[6] = byte(len(msg.data)>>16), byte(len(msg.data)>>8), byte(len(msg.data))
+	header[7] = msg.kind
+	binary.LittleEndian.PutUint32(header[8:], msg.streamID)
+	buf = append(buf, header...)
+
+	for i := 0; i < len(msg.data); i += outChunkSize {
+		if i > 0 {
+			buf = append(buf, 0xc0|csid)
+		}
+
+		end := i + outChunkSize
+		if end > len(msg.data) {
+			end = len(msg.data)
+		}
+		buf = append(buf, msg.data[i:end]...)
+	}
+
+	_, err := s.conn.Write(buf)
+	return err
+}
+
+func (s *session) writeCommand(csid byte, streamID uint32, values ...interface{}) error {
+	return s.writeMessage(csid, message{kind: typeCommand, streamID: streamID, data: amfEncode(values...)})
+}
+
+func (s *session) handle(msg message) error {
+	switch msg.kind {
+	case typeSetChunkSize:
+		if len(msg.data) < 4 {
+			return errors.New("invalid chunk size message")
+		}
+		s.inChunkSize = binary.BigEndian.Uint32(msg.data) & 0x7fffffff
+		if s.inChunkSize == 0 {
+			return errors.New("invalid chunk size")
+		}
+	case typeWindowAckSize:
+		if len(msg.data) >= 4 {
+			s.ackWindow = binary.BigEndian.Uint32(msg.data)
+		}
+	case typeAbort, typeAcknowledgement, typeUserControl, typeSetPeerBandwidth:
+		// nothing to do
+	case typeCommand:
+		return s.command(msg)
+	case typeAudio, typeVideo, typeData:
+		if s.publish == nil {
+			return nil
+		}
+
+		data := msg.data
+		if msg.kind == typeData {
+			data = stripSetDataFrame(data)
+		}
+
+		s.publish.publish(tag{kind: msg.kind, timestamp: msg.timestamp, data: data})
+	}
+
+	return nil
+}
+
+// stripSetDataFrame removes @setDataFrame, that precedes stored metadata.
+func stripSetDataFrame(data []byte) []byte {
+	name := "@setDataFrame"
+	prefix := append([]byte{amfString, 0, byte(len(name))}, name...)
+
+	if len(data) > len(prefix) && string(data[:len(prefix)]) == string(prefix) {
+		return data[len(prefix):]
+	}
+	return data
+}
+
+func (s *session) command(msg message) error {
+	values, err := amfDecodeAll(msg.data)
+	if err != nil && len(values) < 2 {
+		return fmt.Errorf("invalid command: %w", err)
+	}
+
+	name, _ := values[0].(string)
+	transaction, _ := values[1].(float64)
+
+	switch name {
+	case "connect":
+		if len(values) > 2 {
+			if object, ok := values[2].(amfObjectMap); ok {
+				s.app, _ = object["app"].(string)
+			}
+		}
+
+		if err := s.writeMessage(csidControl, message{kind: typeWindowAckSize, data: uint32Bytes(ackWindow)}); err != nil {
+			return err
+		}
+
+		// dynamic limit type
+		peerBandwidth := append(uint32Bytes(ackWindow), 2)
+		if err := s.writeMessage(csidControl, message{kind: typeSetPeerBandwidth, data: peerBandwidth}); err != nil {
+			return err
+		}
+
+		if err := s.writeMessage(csidControl, message{kind: typeSetChunkSize, data: uint32Bytes(outChunkSize)}); err != nil {
+			return err
+		}
+
+		return s.writeCommand(csidCommand, 0, "_result", transaction,
+			amfObjectMap{"fmsVer": "FMS/3,0,1,123", "capabilities": 31},
+			amfObjectMap{"level": "status", "code": "NetConnection.Connect.Success", "description": "Connection succeeded.", "objectEncoding": 0},
+		)
+	case "releaseStream", "FCPublish", "FCUnpublish":
+		return s.writeCommand(csidCommand, 0, "_result", transaction, nil, amfUndefinedValue{})
+	case "createStream":
+		return s.writeCommand(csidCommand, 0, "_result", transaction, nil, publishStreamID)
+	case "publish":
+		key := ""
+		if len(values) > 3 {
+			key, _ = values[3].(string)
+		}
+
+		// query parameters, e.g. tokens, are not part of path
+		if i := strings.Index(key, "?"); i >= 0 {
+			key = key[:i]
+		}
+
+		path := strings.Trim(s.app, "/") + "/" + key
+		published, err := s.server.register(path)
+		if err != nil {
+			s.logger.Warn().Err(err).Str("path", path).Msg("publish rejected")
+			//nolint
+			s.writeCommand(csidStatus, msg.streamID, "onStatus", 0, nil,
+				amfObjectMap{"level": "error", "code": "NetStream.Publish.BadName", "description": err.Error()},
+			)
+			return err
+		}
+
+		s.path = path
+		s.publish = published
+		s.logger.Info().Str("path", path).Msg("publish started")
+
+		return s.writeCommand(csidStatus, msg.streamID, "onStatus", 0, nil,
+			amfObjectMap{"level": "status", "code": "NetStream.Publish.Start", "description": "Publishing " + path + "."},
+		)
+	case "deleteStream", "closeStream":
+		return io.EOF
+	}
+
+	return nil
+}
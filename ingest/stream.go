@@ -0,0 +1,213 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// rtmp message types carrying media
+const (
+	typeAudio = 8
+	typeVideo = 9
+	typeData  = 18
+)
+
+// how many tags can be queued for subscriber, before it is dropped
+const subscriberBuffer = 512
+
+var ErrNotPublished = errors.New("stream is not being published")
+
+// tag of published stream, as in flv
+type tag struct {
+	kind      byte
+	timestamp uint32
+	data      []byte
+}
+
+func (t tag) keyframe() bool {
+	return t.kind == typeVideo && len(t.data) > 0 && t.data[0]>>4 == 1
+}
+
+// sequenceHeader reports whether tag carries decoder configuration of
+// avc video or aac audio, that must precede media of new subscribers.
+func (t tag) sequenceHeader() bool {
+	switch t.kind {
+	case typeVideo:
+		return len(t.data) > 1 && t.data[0]&0x0f == 7 && t.data[1] == 0
+	case typeAudio:
+		return len(t.data) > 1 && t.data[0]>>4 == 10 && t.data[1] == 0
+	}
+	return false
+}
+
+// flv encodes tag with previous tag size, timestamp is rebased.
+func (t tag) flv(offset uint32) []byte {
+	timestamp := uint32(0)
+	if t.timestamp > offset {
+		timestamp = t.timestamp - offset
+	}
+
+	buf := make([]byte, 11+len(t.data)+4)
+	buf[0] = t.kind
+	buf[1], buf[2], buf[3] = byte(len(t.data)>>16), byte(len(t.data)>>8), byte(len(t.data))
+	buf[4], buf[5], buf[6] = byte(timestamp>>16), byte(timestamp>>8), byte(timestamp)
+	buf[7] = byte(timestamp >> 24)
+	copy(buf[11:], t.data)
+	binary.BigEndian.PutUint32(buf[11+len(t.data):], uint32(11+len(t.data)))
+	return buf
+}
+
+// flv header announcing audio and video, followed by zero previous tag size
+var flvHeader = []byte{'F', 'L', 'V', 0x01, 0x05, 0, 0, 0, 9, 0, 0, 0, 0}
+
+type stream struct {
+	mu sync.Mutex
+	// latest metadata and sequence headers
+	metadata *tag
+	video    *tag
+	audio    *tag
+	hasVideo bool
+
+	subscribers map[*subscriber]struct{}
+	closed      bool
+}
+
+func newStream() *stream {
+	return &stream{
+		subscribers: map[*subscriber]struct{}{},
+	}
+}
+
+func (s *stream) publish(t tag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case t.kind == typeData:
+		s.metadata = &t
+		return
+	case t.sequenceHeader() && t.kind == typeVideo:
+		s.video = &t
+		s.hasVideo = true
+		return
+	case t.sequenceHeader() && t.kind == typeAudio:
+		s.audio = &t
+		return
+	case t.kind == typeVideo:
+		s.hasVideo = true
+	}
+
+	for sub := range s.subscribers {
+		// subscribers join at keyframe, audio only streams right away
+		if !sub.joined {
+			if s.hasVideo && !t.keyframe() {
+				continue
+			}
+
+			sub.joined = true
+			sub.offset = t.timestamp
+
+			if !s.send(sub, flvHeader) {
+				continue
+			}
+
+			for _, header := range []*tag{s.metadata, s.video, s.audio} {
+				if header != nil {
+					h := *header
+					h.timestamp = t.timestamp
+					s.send(sub, h.flv(sub.offset))
+				}
+			}
+		}
+
+		s.send(sub, t.flv(sub.offset))
+	}
+}
+
+// send queues data for subscriber, slow subscribers are dropped. Must be
+// called with lock held.
+func (s *stream) send(sub *subscriber, data []byte) bool {
+	if _, ok := s.subscribers[sub]; !ok {
+		return false
+	}
+
+	select {
+	case sub.data <- data:
+		return true
+	default:
+		s.drop(sub)
+		return false
+	}
+}
+
+// drop must be called with lock held.
+func (s *stream) drop(sub *subscriber) {
+	if _, ok := s.subscribers[sub]; !ok {
+		return
+	}
+
+	delete(s.subscribers, sub)
+	close(sub.data)
+}
+
+func (s *stream) subscribe() (*subscriber, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrNotPublished
+	}
+
+	sub := &subscriber{
+		stream: s,
+		data:   make(chan []byte, subscriberBuffer),
+	}
+
+	s.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// close ends stream of all subscribers.
+func (s *stream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for sub := range s.subscribers {
+		s.drop(sub)
+	}
+}
+
+// subscriber reads published stream as flv, starting with its headers.
+type subscriber struct {
+	stream  *stream
+	data    chan []byte
+	pending []byte
+
+	joined bool
+	offset uint32
+}
+
+func (sub *subscriber) Read(p []byte) (int, error) {
+	if len(sub.pending) == 0 {
+		data, ok := <-sub.data
+		if !ok {
+			return 0, io.EOF
+		}
+		sub.pending = data
+	}
+
+	n := copy(p, sub.pending)
+	sub.pending = sub.pending[n:]
+	return n, nil
+}
+
+func (sub *subscriber) Close() error {
+	sub.stream.mu.Lock()
+	defer sub.stream.mu.Unlock()
+
+	sub.stream.drop(sub)
+	return nil
+}
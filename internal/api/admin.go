@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/hls"
+)
+
+// maximum number of streams stopped by single request
+const adminStopLimit = 100
+
+type adminStopResult struct {
+	// stopped <profile>/<stream> ids
+	Stopped []string `json:"stopped"`
+}
+
+func (a *ApiManagerCtx) Admin(r chi.Router) {
+	// stops running hls streams with name matching glob pattern
+	r.Post("/admin/stop", func(w http.ResponseWriter, r *http.Request) {
+		match := r.URL.Query().Get("match")
+		if match == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 match pattern is required"))
+			return
+		}
+
+		if _, err := path.Match(match, ""); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid match pattern"))
+			return
+		}
+
+		ids, managers := a.hlsManagersMatching(match)
+		if len(ids) > adminStopLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("400 pattern matches %d streams, at most %d can be stopped at once", len(ids), adminStopLimit)))
+			return
+		}
+
+		for i, manager := range managers {
+			log.Info().Str("id", ids[i]).Str("match", match).Msg("stopping stream")
+			manager.Stop()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint
+		json.NewEncoder(w).Encode(adminStopResult{ids})
+	})
+}
+
+// hlsManagersMatching returns running hls managers, whose stream name
+// matches pattern, sorted by id.
+func (a *ApiManagerCtx) hlsManagersMatching(pattern string) ([]string, []hls.Manager) {
+	all := a.hlsManagersOf("")
+
+	ids := []string{}
+	for id, manager := range all {
+		_, input := splitManagerID(id)
+		if ok, _ := path.Match(pattern, input); ok && manager.Stats().Running {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	managers := make([]hls.Manager, 0, len(ids))
+	for _, id := range ids {
+		managers = append(managers, all[id])
+	}
+
+	return ids, managers
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+
+	"github.com/m1k1o/go-transcode/hls"
+)
+
+func TestAdminStop(t *testing.T) {
+	a := newTestApi()
+	managers := map[string]*testStatsManager{
+		"h264_720p/cam-1": {stats: hls.Stats{Running: true}},
+		"h264_360p/cam-2": {stats: hls.Stats{Running: true}},
+		"h264_720p/cam-3": {},
+		"h264_720p/lobby": {stats: hls.Stats{Running: true}},
+	}
+	for id, manager := range managers {
+		a.hlsManagers[id] = manager
+	}
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/stop?match=cam-*", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	want := `{"stopped":["h264_360p/cam-2","h264_720p/cam-1"]}` + "\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	for id, wantStopped := range map[string]int{
+		"h264_720p/cam-1": 1,
+		"h264_360p/cam-2": 1,
+		// not running
+		"h264_720p/cam-3": 0,
+		"h264_720p/lobby": 0,
+	} {
+		if got := managers[id].stopped; got != wantStopped {
+			t.Errorf("%s: stopped %d times, want %d", id, got, wantStopped)
+		}
+	}
+}
+
+func TestAdminStopInvalid(t *testing.T) {
+	a := newTestApi()
+	manager := &testStatsManager{stats: hls.Stats{Running: true}}
+	a.hlsManagers["h264_720p/cam"] = manager
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	for _, query := range []string{"", "?match=", "?match=cam[", "?match=%5C"} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/stop"+query, nil))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: got status %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+
+	if manager.stopped != 0 {
+		t.Errorf("stopped %d times by invalid request", manager.stopped)
+	}
+}
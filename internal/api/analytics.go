@@ -0,0 +1,80 @@
+package api
+
+import (
+	"io"
+	"net"
+	"net/http"
+)
+
+// countingWriter reports bytes written to response.
+type countingWriter struct {
+	http.ResponseWriter
+	written func(n int64)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written(int64(n))
+	return n, err
+}
+
+// ReadFrom keeps sendfile of served files, e.g. segments, while counting.
+func (w *countingWriter) ReadFrom(src io.Reader) (int64, error) {
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err := rf.ReadFrom(src)
+		w.written(n)
+		return n, err
+	}
+
+	// plain writer, so that copy does not call ReadFrom again
+	return io.Copy(struct{ io.Writer }{w}, src)
+}
+
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// served accounts response to session of request based stream.
+func (a *ApiManagerCtx) served(w http.ResponseWriter, r *http.Request, profile string, input string) http.ResponseWriter {
+	if a.analytics == nil || r.Method == http.MethodHead {
+		return w
+	}
+
+	client := a.clientIP(r)
+	a.analytics.Served(input, profile, client, 0)
+
+	return &countingWriter{
+		ResponseWriter: w,
+		written: func(n int64) {
+			a.analytics.Served(input, profile, client, n)
+		},
+	}
+}
+
+// session opens session of long lived stream, that must be closed.
+func (a *ApiManagerCtx) session(w http.ResponseWriter, r *http.Request, profile string, input string) (http.ResponseWriter, func()) {
+	if a.analytics == nil {
+		return w, func() {}
+	}
+
+	session := a.analytics.Open(input, profile, a.clientIP(r))
+
+	return &countingWriter{
+		ResponseWriter: w,
+		written: func(n int64) {
+			a.analytics.Add(session, n)
+		},
+	}, func() {
+		a.analytics.Close(session)
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m1k1o/go-transcode/analytics"
+)
+
+func TestServedClientIP(t *testing.T) {
+	for _, proxy := range []bool{false, true} {
+		a := newTestApi()
+		a.config.Proxy = proxy
+
+		sink := make(analytics.ChanSink, 10)
+		a.analytics = analytics.New(sink, analytics.Config{IdleTimeout: time.Minute})
+		a.analytics.Start()
+
+		r := httptest.NewRequest(http.MethodGet, "/h264_720p/cam/index.m3u8", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", "192.168.0.1, 10.0.0.2")
+
+		w := a.served(httptest.NewRecorder(), r, "h264_720p", "cam")
+		w.Write(make([]byte, 100))
+		w.Write(make([]byte, 20))
+		a.analytics.Stop()
+
+		want := "10.0.0.1"
+		if proxy {
+			// address appended by proxy
+			want = "10.0.0.2"
+		}
+
+		start, end := <-sink, <-sink
+		if start.Type != analytics.EventSessionStart || end.Type != analytics.EventSessionEnd {
+			t.Fatalf("proxy %v: got events %q, %q", proxy, start.Type, end.Type)
+		}
+
+		if end.Client != want || end.Bytes != 120 {
+			t.Errorf("proxy %v: got client %q with %d bytes, want %q with 120", proxy, end.Client, end.Bytes, want)
+		}
+	}
+}
+
+func TestServedHead(t *testing.T) {
+	a := newTestApi()
+
+	sink := make(analytics.ChanSink, 10)
+	a.analytics = analytics.New(sink, analytics.Config{IdleTimeout: time.Minute})
+	a.analytics.Start()
+
+	r := httptest.NewRequest(http.MethodHead, "/h264_720p/cam/index.m3u8", nil)
+	a.served(httptest.NewRecorder(), r, "h264_720p", "cam")
+	a.analytics.Stop()
+
+	if len(sink) != 0 {
+		t.Errorf("got %d events for availability check, want none", len(sink))
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/m1k1o/go-transcode/broadcast"
+	"github.com/m1k1o/go-transcode/hls"
+	"github.com/m1k1o/go-transcode/internal/config"
+)
+
+// newTestApi returns api manager without any streams or managers running.
+func newTestApi() *ApiManagerCtx {
+	return &ApiManagerCtx{
+		config:            &config.Server{},
+		profilesAvailable: true,
+		hlsManagers:       make(map[string]hls.Manager),
+		hlsStreams:        make(map[string]StreamConf),
+		hlsViewers:        make(map[string]*hls.Viewers),
+		broadcastManagers: make(map[string]broadcast.Manager),
+		broadcastStreams:  make(map[string]StreamConf),
+		streamLogs:        make(map[string]*streamLog),
+		probes:            make(map[string]probeCacheEntry),
+		probing:           make(map[string]*probeCall),
+	}
+}
+
+// testHLSManager is hls manager, whose transcode is not run. Methods not
+// overridden panic.
+type testHLSManager struct {
+	hls.Manager
+
+	mu      sync.Mutex
+	started int
+	stopped int
+	paused  bool
+	active  bool
+}
+
+func (m *testHLSManager) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started++
+	return nil
+}
+
+func (m *testHLSManager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped++
+	m.active = false
+}
+
+func (m *testHLSManager) Pause() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.paused = true
+}
+
+func (m *testHLSManager) Resume() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.paused = false
+}
+
+func (m *testHLSManager) Pid() int {
+	return 0
+}
+
+func (m *testHLSManager) ServePlaylist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Write([]byte("#EXTM3U\n"))
+}
+
+func (m *testHLSManager) Active() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.active
+}
+
+func (m *testHLSManager) setActive(active bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active = active
+}
+
+// waitFor polls condition until it holds or timeout passes.
+func waitFor(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return condition()
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/auth"
+)
+
+// query parameter carrying token of clients unable to set headers
+const accessTokenParam = "access_token"
+
+// authenticate requires bearer token, when configured. Routes of stream
+// require token listing it in streams claim.
+func (a *ApiManagerCtx) authenticate(next http.Handler) http.Handler {
+	if a.auth == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := a.verifyToken(w, r)
+		if !ok {
+			return
+		}
+
+		if input := chi.URLParam(r, "input"); input != "" && !claims.Allows(input) {
+			log.Debug().Str("subject", claims.Subject).Str("input", input).Msg("stream not allowed by token")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 stream not allowed"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateAdmin requires bearer token allowing all streams, when
+// configured.
+func (a *ApiManagerCtx) authenticateAdmin(next http.Handler) http.Handler {
+	if a.auth == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := a.verifyToken(w, r)
+		if !ok {
+			return
+		}
+
+		if !claims.Allows("*") {
+			log.Debug().Str("subject", claims.Subject).Msg("admin not allowed by token")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 admin not allowed"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifyToken returns claims of request token, otherwise it is refused.
+func (a *ApiManagerCtx) verifyToken(w http.ResponseWriter, r *http.Request) (*auth.Claims, bool) {
+	token := r.URL.Query().Get(accessTokenParam)
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+
+	claims, err := a.auth.Verify(token)
+	if err != nil {
+		log.Debug().Err(err).Str("path", r.URL.Path).Msg("unauthorized request")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("401 unauthorized"))
+		return nil, false
+	}
+
+	return claims, true
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os/exec"
+	"reflect"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/broadcast"
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// serveShared serves raw http stream from transcode shared among all its
+// clients, that is started with first client and stopped with last one.
+func (a *ApiManagerCtx) serveShared(w http.ResponseWriter, r *http.Request, profile string, input string, format string) {
+	// check early, so that client gets proper status and managers are
+	// created only for known streams
+	err := profileAllowed(profile, input)
+	if _, ok := currentConf().stream(input); err == nil && !ok {
+		err = ErrStreamNotFound
+	}
+	if err == nil {
+		var profilePath string
+		profilePath, err = a.profilePath(profileModeHTTP, profile)
+		if err == nil && format == broadcast.FormatMP4 && !profileSupportsFormat(profilePath) {
+			err = fmt.Errorf("profile %s does not support mp4 output", profile)
+		}
+	}
+
+	if err != nil {
+		log.Warn().Err(err).Str("path", r.URL.Path).Msg("transcode could not be started")
+		w.WriteHeader(transcodeErrorStatus(err))
+		w.Write([]byte(fmt.Sprintf("%v", err)))
+		return
+	}
+
+	if format == broadcast.FormatMP4 {
+		w.Header().Set("Content-Type", "video/mp4")
+	} else {
+		w.Header().Set("Content-Type", "video/mp2t")
+	}
+
+	w, closeSession := a.session(w, r, profile, input)
+	defer closeSession()
+
+	manager := a.broadcastManager(profile, input, format)
+	manager.ServeStream(w, r)
+}
+
+// broadcastManager returns existing manager or creates new one. Manager
+// created with config of stream, that changed meanwhile, is replaced once
+// it has no clients.
+func (a *ApiManagerCtx) broadcastManager(profile string, input string, format string) broadcast.Manager {
+	ID := fmt.Sprintf("%s/%s/%s", profile, input, format)
+	stream, _ := currentConf().stream(input)
+	a.removeStaleBroadcastManager(ID, stream)
+
+	a.broadcastMu.Lock()
+	defer a.broadcastMu.Unlock()
+
+	manager, ok := a.broadcastManagers[ID]
+	if ok {
+		return manager
+	}
+
+	manager = broadcast.New(func() (*exec.Cmd, error) {
+		cmd, err := a.transcodeStart(profileModeHTTP, profile, input)
+		if err != nil {
+			return nil, err
+		}
+
+		if format == broadcast.FormatMP4 {
+			cmd.Env = append(cmd.Env, mp4StreamEnv...)
+		}
+
+		utils.ExpandArgs(cmd)
+		return cmd, nil
+	}, broadcast.Config{
+		Format:        format,
+		SingleProcess: !a.config.ProcessGroup,
+		CmdLog:        a.cmdLog(profileModeHTTP, profile, input),
+		ProcessLimits: a.profileProcessLimits(profileModeHTTP, profile, input, stream),
+		Limits:        a.transcodeLimits(profile),
+		LimitTimeout:  a.hlsConfig.LimitTimeout,
+		ErrorStatus:   transcodeErrorStatus,
+		RetryAfter:    a.hlsConfig.RetryAfter(),
+		Exited:        a.transcodeExited,
+	})
+
+	a.broadcastManagers[ID] = manager
+	a.broadcastStreams[ID] = stream
+	return manager
+}
+
+// removeStaleBroadcastManager stops manager, whose stream config changed
+// since it was created, unless it has clients.
+func (a *ApiManagerCtx) removeStaleBroadcastManager(ID string, stream StreamConf) {
+	a.broadcastMu.Lock()
+	manager, ok := a.broadcastManagers[ID]
+	created, known := a.broadcastStreams[ID]
+	a.broadcastMu.Unlock()
+
+	if !ok || !known || reflect.DeepEqual(created, stream) {
+		return
+	}
+
+	// manager takes its own lock, it is queried once global lock is released
+	if manager.Clients() > 0 {
+		return
+	}
+
+	a.removeBroadcastManager(ID, manager)
+}
+
+// removeBroadcastManager stops manager and removes it, unless it was
+// replaced meanwhile.
+func (a *ApiManagerCtx) removeBroadcastManager(ID string, manager broadcast.Manager) {
+	a.broadcastMu.Lock()
+	if a.broadcastManagers[ID] != manager {
+		a.broadcastMu.Unlock()
+		return
+	}
+	delete(a.broadcastManagers, ID)
+	delete(a.broadcastStreams, ID)
+	a.broadcastMu.Unlock()
+
+	manager.Stop()
+}
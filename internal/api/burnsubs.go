@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidBurnSubtitles = errors.New("subtitles can not be burned")
+
+// request parameter selecting subtitles track burned into video, counted
+// from one
+const burnSubtitlesParam = "burnsubs"
+
+// output label of complex filtergraph overlaying bitmap subtitles
+const burnedVideoLabel = "burned"
+
+// bitmap subtitles, that are overlaid over video. Teletext is decoded to
+// bitmaps by default.
+var bitmapSubtitlesCodecs = map[string]bool{
+	"dvb_subtitle":      true,
+	"dvd_subtitle":      true,
+	"hdmv_pgs_subtitle": true,
+	"xsub":              true,
+	"dvb_teletext":      true,
+}
+
+// burnSubtitles hardcodes subtitles track of source into video, for
+// players that can not render WebVTT.
+type burnSubtitles struct {
+	// subtitles track of source, from zero
+	Track int
+	// bitmap subtitles are overlaid using complex filtergraph, text ones
+	// are rendered by subtitles filter reading source file on its own
+	Bitmap bool
+	Source string
+}
+
+// burnSubtitlesParams returns subtitles track to be burned selected by
+// request, from one, or zero when none.
+func burnSubtitlesParams(r *http.Request) (int, error) {
+	value := r.URL.Query().Get(burnSubtitlesParam)
+	if value == "" {
+		return 0, nil
+	}
+
+	track, err := strconv.Atoi(value)
+	if err != nil || track < 1 || track > maxSubtitlesTracks {
+		return 0, errors.New("invalid burned subtitles track")
+	}
+
+	return track, nil
+}
+
+// filter returns filter rendering text subtitles. Timestamps of seeked
+// transcode start at zero, so they are shifted to match cues meanwhile.
+func (b *burnSubtitles) filter(seek time.Duration) string {
+	filter := fmt.Sprintf("subtitles=filename=%s:si=%d", escapeFilterValue(b.Source), b.Track)
+	if seek > 0 {
+		offset := formatClipTime(seek)
+		filter = "setpts=PTS+" + offset + "/TB," + filter + ",setpts=PTS-" + offset + "/TB"
+	}
+	return filter
+}
+
+// escapeFilterValue escapes value of filter option, first as option value
+// and then within filtergraph description.
+func escapeFilterValue(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(value)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(value)
+}
+
+// burnSubtitles returns subtitles track of source to be burned, from one,
+// once it is probed. Text subtitles need source to be local file.
+func (a *ApiManagerCtx) burnSubtitles(source string, track int) (*burnSubtitles, error) {
+	probe, err := a.probeCmd(source)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := probe.Tracks("subtitle")
+	if track > len(tracks) {
+		return nil, fmt.Errorf("%w: source has no subtitles track %d", ErrInvalidBurnSubtitles, track)
+	}
+
+	codec := tracks[track-1].CodecName
+	burn := &burnSubtitles{
+		Track:  track - 1,
+		Bitmap: bitmapSubtitlesCodecs[codec],
+		Source: source,
+	}
+
+	if !burn.Bitmap && !textSubtitlesCodecs[codec] {
+		return nil, fmt.Errorf("%w: unsupported subtitles codec %s", ErrInvalidBurnSubtitles, codec)
+	}
+
+	if info, err := os.Stat(source); !burn.Bitmap && (err != nil || !info.Mode().IsRegular()) {
+		return nil, fmt.Errorf("%w: text subtitles can be burned only from files", ErrInvalidBurnSubtitles)
+	}
+
+	return burn, nil
+}
+
+// profileBurnSubtitles returns subtitles track of source to be burned by
+// profile, from one.
+func (a *ApiManagerCtx) profileBurnSubtitles(profile string, profilePath string, source string, track int) (*burnSubtitles, error) {
+	burn, err := a.burnSubtitles(source, track)
+	if err != nil {
+		return nil, err
+	}
+
+	if !profileSupportsBurnSubtitles(profilePath, burn) {
+		return nil, fmt.Errorf("%w: profile %s does not support them", ErrInvalidBurnSubtitles, profile)
+	}
+
+	return burn, nil
+}
+
+// profileSupportsBurnSubtitles reports whether profile script renders
+// text subtitles, bitmap ones need complex filtergraph of yaml profiles.
+func profileSupportsBurnSubtitles(profilePath string, burn *burnSubtitles) bool {
+	if isYAMLProfile(profilePath) {
+		return true
+	}
+
+	if burn.Bitmap {
+		return false
+	}
+
+	script, err := os.ReadFile(profilePath)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Contains(script, []byte("TRANSCODE_SUBTITLES_FILTER"))
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidClip = errors.New("invalid clip")
+
+// clipRange bounds output of vod source.
+type clipRange struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// inputOptions seek in decoded source, so that clip is frame accurate
+// and not aligned to keyframes.
+func (c clipRange) inputOptions() []string {
+	return []string{
+		"-ss", formatClipTime(c.Start),
+		"-t", formatClipTime(c.End - c.Start),
+	}
+}
+
+// parseClip returns clip requested by start and end request parameters,
+// or nil when none was requested.
+func parseClip(r *http.Request) (*clipRange, error) {
+	query := r.URL.Query()
+	if query.Get("start") == "" && query.Get("end") == "" {
+		return nil, nil
+	}
+
+	start, err := parseClipTime(query.Get("start"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: start: %v", ErrInvalidClip, err)
+	}
+
+	if query.Get("end") == "" {
+		return nil, fmt.Errorf("%w: end is required", ErrInvalidClip)
+	}
+
+	end, err := parseClipTime(query.Get("end"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: end: %v", ErrInvalidClip, err)
+	}
+
+	if start >= end {
+		return nil, fmt.Errorf("%w: start must be before end", ErrInvalidClip)
+	}
+
+	return &clipRange{Start: start, End: end}, nil
+}
+
+// parseClipTime parses seconds, e.g. 90.5, or [hh:]mm:ss[.fff], empty
+// value is beginning of source.
+func parseClipTime(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	var seconds float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 || (i > 0 && n >= 60) || (i < len(parts)-1 && strings.Contains(part, ".")) {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		seconds = seconds*60 + n
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func formatClipTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// validateClip checks, that clip lies within duration of stream source.
+// Live sources without duration can not be clipped.
+func (a *ApiManagerCtx) validateClip(ctx context.Context, input string, clip clipRange) error {
+	stream, ok := currentConf().stream(input)
+	if !ok {
+		return ErrStreamNotFound
+	}
+
+	if stream.ingestPath(input) != "" {
+		return fmt.Errorf("%w: ingested streams can not be clipped", ErrInvalidClip)
+	}
+
+	probe, err := a.probeSource(ctx, stream.Source)
+	if err != nil {
+		return err
+	}
+
+	duration := probe.Duration()
+	if duration <= 0 {
+		return fmt.Errorf("%w: source has no duration, only vod sources can be clipped", ErrInvalidClip)
+	}
+
+	if clip.End > duration {
+		return fmt.Errorf("%w: end exceeds source duration %s", ErrInvalidClip, formatClipTime(duration))
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m1k1o/go-transcode/internal/ffprobe"
+)
+
+func TestParseClipTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{"", 0, false},
+		{"90", 90 * time.Second, false},
+		{"90.5", 90500 * time.Millisecond, false},
+		{"01:30", 90 * time.Second, false},
+		{"1:02:03.250", time.Hour + 2*time.Minute + 3250*time.Millisecond, false},
+		{"-1", 0, true},
+		{"1:60", 0, true},
+		{"1.5:00", 0, true},
+		{"1:2:3:4", 0, true},
+		{"later", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseClipTime(tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: got error %v, want error %v", tt.value, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseClip(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  *clipRange
+		err   bool
+	}{
+		{"not requested", "", nil, false},
+		{"range", "start=10&end=00:40", &clipRange{Start: 10 * time.Second, End: 40 * time.Second}, false},
+		{"from beginning", "end=5.5", &clipRange{End: 5500 * time.Millisecond}, false},
+		{"end missing", "start=10", nil, true},
+		{"end before start", "start=40&end=10", nil, true},
+		{"empty range", "start=10&end=10", nil, true},
+		{"invalid start", "start=x&end=10", nil, true},
+		{"invalid end", "start=1&end=x", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseClip(httptest.NewRequest(http.MethodGet, "/h264_720p/movie/mp4?"+tt.query, nil))
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidClip) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidClip)
+			}
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClipInputOptions(t *testing.T) {
+	clip := clipRange{Start: 90500 * time.Millisecond, End: 2 * time.Minute}
+
+	// duration of clip follows its start
+	want := "-ss 90.500 -t 29.500"
+	if got := strings.Join(clip.inputOptions(), " "); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestValidateClip(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{
+		"movie":  {Source: "/media/movie.mp4"},
+		"cam":    {Source: "rtsp://camera/stream"},
+		"ingest": {Ingest: "ingest"},
+	}})
+
+	a := newTestApi()
+	// sources are already probed
+	expires := time.Now().Add(time.Hour)
+	a.probes["/media/movie.mp4"] = probeCacheEntry{result: &ffprobe.Result{Format: ffprobe.Format{Duration: "120.5"}}, expires: expires}
+	a.probes["rtsp://camera/stream"] = probeCacheEntry{result: &ffprobe.Result{}, expires: expires}
+
+	tests := []struct {
+		name  string
+		input string
+		clip  clipRange
+		want  error
+	}{
+		{"within source", "movie", clipRange{Start: 10 * time.Second, End: 2 * time.Minute}, nil},
+		{"up to end of source", "movie", clipRange{Start: 2 * time.Minute, End: 120500 * time.Millisecond}, nil},
+		{"beyond source", "movie", clipRange{Start: 10 * time.Second, End: 121 * time.Second}, ErrInvalidClip},
+		{"live source", "cam", clipRange{End: time.Second}, ErrInvalidClip},
+		{"ingested stream", "ingest", clipRange{End: time.Second}, ErrInvalidClip},
+		{"unknown stream", "lobby", clipRange{End: time.Second}, ErrStreamNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := a.validateClip(context.Background(), tt.input, tt.clip)
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
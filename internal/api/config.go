@@ -1,26 +1,781 @@
 package api
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
+
+	"github.com/m1k1o/go-transcode/hls"
+	"github.com/m1k1o/go-transcode/recording"
+)
+
+const (
+	AudioAuto      = "auto"
+	AudioCopy      = "copy"
+	AudioTranscode = "transcode"
+)
+
+const (
+	DeinterlaceAuto = "auto"
+	DeinterlaceOn   = "on"
+	DeinterlaceOff  = "off"
+)
+
+const (
+	DispositionInline     = "inline"
+	DispositionAttachment = "attachment"
 )
 
+type StreamConf struct {
+	Source string `yaml:"source"`
+	// app/key path published to rtmp ingest, instead of source
+	Ingest string `yaml:"ingest"`
+	// srt listener published by its caller, instead of source
+	SRT           *SRTConf `yaml:"srt"`
+	Audio         string   `yaml:"audio"`
+	Preload       []string `yaml:"preload"`
+	ExplicitStart *bool    `yaml:"explicit_start"`
+	// remux compatible source using copy profile
+	Passthrough *bool  `yaml:"passthrough"`
+	Deinterlace string `yaml:"deinterlace"`
+	Disposition string `yaml:"disposition"`
+	// allowed profiles, all when empty
+	Profiles []string `yaml:"profiles"`
+	// source reconnection, http only
+	Reconnect         bool `yaml:"reconnect"`
+	ReconnectDelayMax int  `yaml:"reconnect_delay_max"`
+	// rtsp lower transport protocol
+	RTSPTransport string `yaml:"rtsp_transport"`
+	// scaler algorithm and post-scale sharpening amount
+	ScaleAlgorithm string  `yaml:"scale_algorithm"`
+	Sharpen        float64 `yaml:"sharpen"`
+	// duration strings, e.g. 15s
+	ColdStartTimeout string `yaml:"cold_start_timeout"`
+	StartupTimeout   string `yaml:"startup_timeout"`
+	MaxDuration      string `yaml:"max_duration"`
+	// hls manager timing overrides
+	CleanupPeriod       string `yaml:"cleanup_period"`
+	PlaylistTimeout     string `yaml:"playlist_timeout"`
+	MinimumSegments     int    `yaml:"minimum_segments"`
+	ActiveIdleTimeout   string `yaml:"active_idle_timeout"`
+	InactiveIdleTimeout string `yaml:"inactive_idle_timeout"`
+	// stable tempdir, profiles use its subdirectories
+	TempDir string `yaml:"tempdir"`
+	// parent of random tempdirs of stream, overrides global one
+	TempRoot string `yaml:"temp_root"`
+	// custom variables of profile templates
+	Vars map[string]string `yaml:"vars"`
+	// sources used in order when preceding one fails
+	BackupSources []string `yaml:"backup_sources"`
+	// source used by watchdog after repeated freezes
+	FallbackSource string        `yaml:"fallback_source"`
+	Watchdog       *WatchdogConf `yaml:"watchdog"`
+	// recording of transcoded output, regardless of viewers
+	Record *RecordConf `yaml:"record"`
+}
+
+type SRTConf struct {
+	// address of listener, e.g. :9000
+	Listen     string `yaml:"listen"`
+	Passphrase string `yaml:"passphrase"`
+	StreamID   string `yaml:"streamid"`
+	// receiver latency in milliseconds
+	Latency int `yaml:"latency"`
+}
+
+type RecordConf struct {
+	// http profile of recorded output, copy when empty
+	Profile string `yaml:"profile"`
+	Format  string `yaml:"format"`
+	// duration strings, e.g. 24h
+	SegmentDuration string `yaml:"segment_duration"`
+	MaxAge          string `yaml:"max_age"`
+	// in megabytes
+	MaxSize int `yaml:"max_size"`
+}
+
+type WatchdogConf struct {
+	// duration strings, e.g. 10s
+	FreezeTimeout string `yaml:"freeze_timeout"`
+	Reset         string `yaml:"reset"`
+	StallTimeout  string `yaml:"stall_timeout"`
+	Steps         []struct {
+		Freezes int    `yaml:"freezes"`
+		Action  string `yaml:"action"`
+	} `yaml:"steps"`
+}
+
+// UnmarshalYAML allows stream to be specified only by its source url.
+func (s *StreamConf) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var source string
+	if err := unmarshal(&source); err == nil {
+		s.Source = source
+		return nil
+	}
+
+	type plain StreamConf
+	return unmarshal((*plain)(s))
+}
+
+func (s *StreamConf) validate() error {
+	inputs := 0
+	for _, set := range []bool{s.Source != "", s.Ingest != "", s.SRT != nil} {
+		if set {
+			inputs++
+		}
+	}
+
+	if inputs == 0 {
+		return fmt.Errorf("source, ingest or srt is required")
+	}
+
+	if inputs > 1 {
+		return fmt.Errorf("only one of source, ingest and srt can be set")
+	}
+
+	if s.SRT != nil {
+		if err := s.SRT.validate(); err != nil {
+			return fmt.Errorf("srt: %w", err)
+		}
+	}
+
+	if len(s.BackupSources) > 0 && s.Source == "" {
+		return fmt.Errorf("backup sources are supported only for sources, not ingested streams")
+	}
+
+	for _, backup := range s.BackupSources {
+		if backup == "" {
+			return fmt.Errorf("backup source must not be empty")
+		}
+	}
+
+	if s.Ingest != "" && !ingestPathRegex.MatchString(s.Ingest) {
+		return fmt.Errorf("invalid ingest path %q, expected app/key", s.Ingest)
+	}
+
+	switch s.Audio {
+	case "", AudioAuto, AudioCopy, AudioTranscode:
+	default:
+		return fmt.Errorf("unknown audio mode %q", s.Audio)
+	}
+
+	switch s.Deinterlace {
+	case "", DeinterlaceAuto, DeinterlaceOn, DeinterlaceOff:
+	default:
+		return fmt.Errorf("unknown deinterlace mode %q", s.Deinterlace)
+	}
+
+	switch s.Disposition {
+	case "", DispositionInline, DispositionAttachment:
+	default:
+		return fmt.Errorf("unknown disposition %q", s.Disposition)
+	}
+
+	switch s.ScaleAlgorithm {
+	case "", "fast_bilinear", "bilinear", "bicubic", "experimental", "neighbor", "area", "bicublin", "gauss", "sinc", "lanczos", "spline":
+	default:
+		return fmt.Errorf("unknown scale algorithm %q", s.ScaleAlgorithm)
+	}
+
+	// maximum amount supported by unsharp filter
+	if s.Sharpen < 0 || s.Sharpen > 1.5 {
+		return fmt.Errorf("sharpen must be between 0 and 1.5")
+	}
+
+	if _, err := s.coldStartTimeout(); err != nil {
+		return err
+	}
+
+	if _, err := s.startupTimeout(); err != nil {
+		return err
+	}
+
+	if _, err := s.maxDuration(); err != nil {
+		return err
+	}
+
+	if _, err := s.manager(hls.ManagerConfig{}); err != nil {
+		return err
+	}
+
+	watchdog, err := s.watchdog(hls.Watchdog{})
+	if err != nil {
+		return err
+	}
+
+	for _, step := range watchdog.Steps {
+		if step.Action == hls.WatchdogFallback && s.FallbackSource == "" {
+			return fmt.Errorf("watchdog fallback requires fallback source")
+		}
+	}
+
+	if s.Record != nil {
+		if err := s.Record.validate(); err != nil {
+			return fmt.Errorf("record: %w", err)
+		}
+	}
+
+	scheme := s.scheme()
+	if (s.Reconnect || s.ReconnectDelayMax != 0) && scheme != "http" && scheme != "https" {
+		return fmt.Errorf("reconnect is supported only for http sources")
+	}
+
+	if s.ReconnectDelayMax < 0 {
+		return fmt.Errorf("reconnect delay must not be negative")
+	}
+
+	if s.RTSPTransport != "" {
+		if scheme != "rtsp" && scheme != "rtsps" {
+			return fmt.Errorf("rtsp transport is supported only for rtsp sources")
+		}
+
+		switch s.RTSPTransport {
+		case "tcp", "udp", "udp_multicast", "http", "https":
+		default:
+			return fmt.Errorf("unknown rtsp transport %q", s.RTSPTransport)
+		}
+	}
+
+	re := regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+	for _, profile := range s.Profiles {
+		if !re.MatchString(profile) {
+			return fmt.Errorf("invalid allowed profile %q", profile)
+		}
+	}
+
+	for _, profile := range s.Preload {
+		if !re.MatchString(profile) {
+			return fmt.Errorf("invalid preload profile %q", profile)
+		}
+		if !s.allows(profile) {
+			return fmt.Errorf("preload profile %q is not allowed", profile)
+		}
+	}
+
+	return nil
+}
+
+func (c *SRTConf) validate() error {
+	if _, port, err := net.SplitHostPort(c.Listen); err != nil || port == "" {
+		return fmt.Errorf("invalid listen address %q", c.Listen)
+	}
+
+	// limits of srt protocol
+	if c.Passphrase != "" && (len(c.Passphrase) < 10 || len(c.Passphrase) > 79) {
+		return fmt.Errorf("passphrase must have 10 to 79 characters")
+	}
+
+	if len(c.StreamID) > 512 {
+		return fmt.Errorf("streamid must have at most 512 characters")
+	}
+
+	if c.Latency < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+
+	return nil
+}
+
+func (c *RecordConf) validate() error {
+	if c.Profile != "" && !regexp.MustCompile(`^[0-9A-Za-z_-]+$`).MatchString(c.Profile) {
+		return fmt.Errorf("invalid profile %q", c.Profile)
+	}
+
+	switch c.Format {
+	case "", recording.FormatMP4, recording.FormatMPEGTS:
+	default:
+		return fmt.Errorf("unknown format %q", c.Format)
+	}
+
+	if c.MaxSize < 0 {
+		return fmt.Errorf("max size must not be negative")
+	}
+
+	_, err := c.recording(recording.Config{})
+	return err
+}
+
+// profile returns http profile of recorded output.
+func (c *RecordConf) profile() string {
+	if c.Profile == "" {
+		return passthroughProfile
+	}
+	return c.Profile
+}
+
+// recording returns recording config with stream overrides applied on
+// defaults.
+func (c *RecordConf) recording(defaults recording.Config) (recording.Config, error) {
+	config := defaults
+
+	if c.Format != "" {
+		config.Format = c.Format
+	}
+
+	segmentDuration, err := parseDuration("segment duration", c.SegmentDuration)
+	if err != nil {
+		return config, err
+	}
+	if segmentDuration > 0 {
+		config.SegmentDuration = segmentDuration
+	}
+
+	maxAge, err := parseDuration("max age", c.MaxAge)
+	if err != nil {
+		return config, err
+	}
+	if maxAge > 0 {
+		config.MaxAge = maxAge
+	}
+
+	if c.MaxSize > 0 {
+		config.MaxSize = int64(c.MaxSize) << 20
+	}
+
+	return config, nil
+}
+
+// url returns ffmpeg url of srt listener.
+func (c *SRTConf) url() string {
+	host, port, _ := net.SplitHostPort(c.Listen)
+	if host == "" {
+		host = "0.0.0.0"
+	}
+
+	query := url.Values{}
+	query.Set("mode", "listener")
+	if c.Passphrase != "" {
+		query.Set("passphrase", c.Passphrase)
+	}
+	if c.StreamID != "" {
+		query.Set("streamid", c.StreamID)
+	}
+	// in microseconds
+	if c.Latency > 0 {
+		query.Set("latency", strconv.Itoa(c.Latency*1000))
+	}
+
+	return "srt://" + net.JoinHostPort(host, port) + "?" + query.Encode()
+}
+
+var ingestPathRegex = regexp.MustCompile(`^[0-9A-Za-z_-]+/[0-9A-Za-z_.-]+$`)
+
+// ingestPath returns path published to ingest server, or empty when
+// stream is not ingested.
+func (s *StreamConf) ingestPath(name string) string {
+	if s.SRT != nil {
+		return "srt/" + name
+	}
+	return s.Ingest
+}
+
+// scheme returns lowercased scheme of source url.
+func (s *StreamConf) scheme() string {
+	i := strings.Index(s.Source, "://")
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(s.Source[:i])
+}
+
+// coldStartTimeout returns cold start timeout override, or zero.
+func (s *StreamConf) coldStartTimeout() (time.Duration, error) {
+	return parseDuration("cold start timeout", s.ColdStartTimeout)
+}
+
+// startupTimeout returns startup timeout override, or zero.
+func (s *StreamConf) startupTimeout() (time.Duration, error) {
+	return parseDuration("startup timeout", s.StartupTimeout)
+}
+
+// maxDuration returns max duration override, or zero.
+func (s *StreamConf) maxDuration() (time.Duration, error) {
+	return parseDuration("max duration", s.MaxDuration)
+}
+
+// manager returns hls manager config with stream overrides applied on
+// defaults.
+func (s *StreamConf) manager(defaults hls.ManagerConfig) (hls.ManagerConfig, error) {
+	manager := defaults
+
+	for _, override := range []struct {
+		name  string
+		value string
+		field *time.Duration
+	}{
+		{"cleanup period", s.CleanupPeriod, &manager.CleanupPeriod},
+		{"playlist timeout", s.PlaylistTimeout, &manager.PlaylistTimeout},
+		{"active idle timeout", s.ActiveIdleTimeout, &manager.ActiveIdleTimeout},
+		{"inactive idle timeout", s.InactiveIdleTimeout, &manager.InactiveIdleTimeout},
+	} {
+		duration, err := parseDuration(override.name, override.value)
+		if err != nil {
+			return manager, err
+		}
+		if duration > 0 {
+			*override.field = duration
+		}
+	}
+
+	if s.MinimumSegments < 0 {
+		return manager, fmt.Errorf("invalid minimum segments %d", s.MinimumSegments)
+	}
+	if s.MinimumSegments > 0 {
+		manager.MinimumSegments = s.MinimumSegments
+	}
+
+	return manager, nil
+}
+
+func parseDuration(name string, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+
+	return duration, nil
+}
+
+// watchdog returns watchdog with stream overrides applied on defaults.
+func (s *StreamConf) watchdog(defaults hls.Watchdog) (hls.Watchdog, error) {
+	watchdog := defaults
+	if s.Watchdog == nil {
+		return watchdog, nil
+	}
+
+	timeout, err := parseDuration("watchdog freeze timeout", s.Watchdog.FreezeTimeout)
+	if err != nil {
+		return watchdog, err
+	}
+	if timeout > 0 {
+		watchdog.FreezeTimeout = timeout
+	}
+
+	reset, err := parseDuration("watchdog reset", s.Watchdog.Reset)
+	if err != nil {
+		return watchdog, err
+	}
+	if reset > 0 {
+		watchdog.Reset = reset
+	}
+
+	stall, err := parseDuration("watchdog stall timeout", s.Watchdog.StallTimeout)
+	if err != nil {
+		return watchdog, err
+	}
+	if stall > 0 {
+		watchdog.StallTimeout = stall
+	}
+
+	if len(s.Watchdog.Steps) > 0 {
+		watchdog.Steps = make([]hls.WatchdogStep, 0, len(s.Watchdog.Steps))
+		for _, step := range s.Watchdog.Steps {
+			watchdog.Steps = append(watchdog.Steps, hls.WatchdogStep{
+				Freezes: step.Freezes,
+				Action:  step.Action,
+			})
+		}
+	}
+
+	return watchdog, watchdog.Validate()
+}
+
+// inputOptions returns ffmpeg options preceding source input.
+func (s *StreamConf) inputOptions() []string {
+	options := []string{}
+
+	if s.Reconnect {
+		options = append(options, "-reconnect", "1", "-reconnect_streamed", "1")
+		if s.ReconnectDelayMax > 0 {
+			options = append(options, "-reconnect_delay_max", strconv.Itoa(s.ReconnectDelayMax))
+		}
+	}
+
+	if s.RTSPTransport != "" {
+		options = append(options, "-rtsp_transport", s.RTSPTransport)
+	}
+
+	return options
+}
+
+// allows reports whether profile can be used with this stream.
+func (s *StreamConf) allows(profile string) bool {
+	if len(s.Profiles) == 0 {
+		return true
+	}
+
+	for _, p := range s.Profiles {
+		if p == profile {
+			return true
+		}
+	}
+
+	return false
+}
+
+// preloads reports whether hls profile is preloaded for this stream.
+func (s *StreamConf) preloads(profile string) bool {
+	for _, p := range s.Preload {
+		if p == profile {
+			return true
+		}
+	}
+
+	return false
+}
+
 type YamlConf struct {
-	Streams map[string]string `yaml:"streams"`
+	Streams map[string]StreamConf `yaml:"streams"`
+	// allowlists of placeholders in names of templated streams
+	Placeholders map[string]PlaceholderConf `yaml:"placeholders"`
+
+	// streams with placeholders in names, split from listed ones
+	templates []*streamTemplate
+}
+
+// prefix of environment variables defining streams
+const streamsEnvPrefix = "TRANSCODE_STREAMS_"
+
+// streamEnvFields maps keys of environment variables to indexes of fields
+// of StreamConf, named by their yaml keys. Keys of nested configs are
+// prefixed by key of their config.
+var streamEnvFields = envFieldsOf(reflect.TypeOf(StreamConf{}), "", nil)
+
+// keys of streamEnvFields, longest first, so that longer key is not
+// matched as its suffix, e.g. SOURCE of stream named *_FALLBACK
+var streamEnvKeys []string
+
+func init() {
+	// shorthand for recorded profile
+	streamEnvFields["RECORD"] = streamEnvFields["RECORD_PROFILE"]
+	streamEnvKeys = sortedEnvKeys(streamEnvFields)
+}
+
+func envFieldsOf(t reflect.Type, prefix string, index []int) map[string][]int {
+	fields := map[string][]int{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		key := prefix + strings.ToUpper(tag)
+		fieldIndex := append(append([]int{}, index...), i)
+
+		typ := field.Type
+		if typ.Kind() == reflect.Ptr && typ.Elem().Kind() == reflect.Struct {
+			typ = typ.Elem()
+		}
+
+		if typ.Kind() == reflect.Struct {
+			for nested, nestedIndex := range envFieldsOf(typ, key+"_", fieldIndex) {
+				fields[nested] = nestedIndex
+			}
+			continue
+		}
+
+		// lists of structs, e.g. watchdog steps, only in yaml
+		if envSettable(typ) {
+			fields[key] = fieldIndex
+		}
+	}
+	return fields
+}
+
+func envSettable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Float64:
+		return true
+	case reflect.Ptr:
+		return t.Elem().Kind() == reflect.Bool
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+func sortedEnvKeys(fields map[string][]int) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// setEnvField sets field of stream at index, nested configs are copied
+// first, so that file config shared by pointer is not modified.
+func setEnvField(stream *StreamConf, index []int, value string) error {
+	v := reflect.ValueOf(stream).Elem()
+	for n, i := range index {
+		v = v.Field(i)
+		if n < len(index)-1 && v.Kind() == reflect.Ptr {
+			nested := reflect.New(v.Type().Elem())
+			if !v.IsNil() {
+				nested.Elem().Set(v.Elem())
+			}
+			v.Set(nested)
+			v = nested.Elem()
+		}
+	}
+	return setEnvValue(v, value)
+}
+
+func setEnvValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Ptr:
+		ptr := reflect.New(v.Type().Elem())
+		if err := setEnvValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		v.Set(ptr)
+	case reflect.Slice:
+		v.Set(reflect.ValueOf(strings.Split(value, ",")))
+	case reflect.Map:
+		vars := map[string]string{}
+		for _, pair := range strings.Split(value, ",") {
+			j := strings.Index(pair, "=")
+			if j <= 0 {
+				return fmt.Errorf("invalid variable %q", pair)
+			}
+			vars[pair[:j]] = pair[j+1:]
+		}
+		v.Set(reflect.ValueOf(vars))
+	}
+	return nil
+}
+
+// mergeEnv applies streams defined as environment variables on top of
+// file config, in form TRANSCODE_STREAMS_<NAME>[_<FIELD>]=<value>.
+func (c *YamlConf) mergeEnv(environ []string) error {
+	for _, env := range environ {
+		if !strings.HasPrefix(env, streamsEnvPrefix) {
+			continue
+		}
+
+		i := strings.Index(env, "=")
+		if i < 0 {
+			continue
+		}
+
+		key, value := env[len(streamsEnvPrefix):i], env[i+1:]
+
+		field := "SOURCE"
+		for _, f := range streamEnvKeys {
+			if strings.HasSuffix(key, "_"+f) {
+				key, field = strings.TrimSuffix(key, "_"+f), f
+				break
+			}
+		}
+
+		if key == "" {
+			continue
+		}
+
+		// match existing stream regardless of case
+		name := strings.ToLower(key)
+		for existing := range c.Streams {
+			if strings.EqualFold(existing, key) {
+				name = existing
+				break
+			}
+		}
+
+		stream := c.Streams[name]
+		if err := setEnvField(&stream, streamEnvFields[field], value); err != nil {
+			return fmt.Errorf("%s: %w", env[:i], err)
+		}
+
+		c.Streams[name] = stream
+	}
+
+	return nil
 }
 
 func loadConf(path string) (*YamlConf, error) {
+	conf := &YamlConf{}
+
 	yamlFile, err := ioutil.ReadFile(path)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
 
-	conf := &YamlConf{}
-	err = yaml.Unmarshal(yamlFile, conf)
-	if err != nil {
+	// streams can be specified only using environment variables
+	if err == nil {
+		err = yaml.Unmarshal(yamlFile, conf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.Streams == nil {
+		conf.Streams = map[string]StreamConf{}
+	}
+
+	if err := conf.splitTemplates(); err != nil {
+		return nil, err
+	}
+
+	if err := conf.mergeEnv(os.Environ()); err != nil {
 		return nil, err
 	}
 
+	listeners := map[string]string{}
+	for name, stream := range conf.Streams {
+		if err := stream.validate(); err != nil {
+			return nil, fmt.Errorf("stream %s: %w", name, err)
+		}
+
+		if stream.SRT != nil {
+			if other, ok := listeners[stream.SRT.Listen]; ok {
+				return nil, fmt.Errorf("stream %s: srt listen address is already used by stream %s", name, other)
+			}
+			listeners[stream.SRT.Listen] = name
+		}
+	}
+
 	return conf, nil
 }
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestMergeEnv(t *testing.T) {
+	file := `
+streams:
+  my_cam:
+    source: rtsp://camera/stream
+    audio: copy
+    deinterlace: "off"
+`
+
+	conf := &YamlConf{}
+	if err := yaml.Unmarshal([]byte(file), conf); err != nil {
+		t.Fatal(err)
+	}
+
+	err := conf.mergeEnv([]string{
+		"PATH=/usr/bin",
+		// overrides yaml stream, matched regardless of case
+		"TRANSCODE_STREAMS_MY_CAM_AUDIO=transcode",
+		// longest key wins, this is not SOURCE of stream my_cam_fallback
+		"TRANSCODE_STREAMS_MY_CAM_FALLBACK_SOURCE=http://fallback/stream",
+		// new stream, its source is set without field suffix
+		"TRANSCODE_STREAMS_LOBBY_SIDE_DOOR=rtsp://door/stream",
+		"TRANSCODE_STREAMS_LOBBY_SIDE_DOOR_PROFILES=h264_360p,h264_720p",
+		"TRANSCODE_STREAMS_LOBBY_SIDE_DOOR_RECORD_MAX_SIZE=512",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(conf.Streams) != 2 {
+		t.Fatalf("got streams %v, want my_cam and lobby_side_door", conf.Streams)
+	}
+
+	cam := conf.Streams["my_cam"]
+	if cam.Source != "rtsp://camera/stream" || cam.Audio != AudioTranscode || cam.Deinterlace != DeinterlaceOff {
+		t.Errorf("yaml stream not overridden: %+v", cam)
+	}
+	if cam.FallbackSource != "http://fallback/stream" {
+		t.Errorf("got fallback source %q, want http://fallback/stream", cam.FallbackSource)
+	}
+
+	door, ok := conf.Streams["lobby_side_door"]
+	if !ok {
+		t.Fatalf("stream lobby_side_door not added: %v", conf.Streams)
+	}
+	if door.Source != "rtsp://door/stream" {
+		t.Errorf("got source %q, want rtsp://door/stream", door.Source)
+	}
+	if want := []string{"h264_360p", "h264_720p"}; !reflect.DeepEqual(door.Profiles, want) {
+		t.Errorf("got profiles %v, want %v", door.Profiles, want)
+	}
+	if door.Record == nil || door.Record.MaxSize != 512 {
+		t.Errorf("got record %+v, want max size 512", door.Record)
+	}
+}
+
+func TestMergeEnvInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+	}{
+		{"int", "TRANSCODE_STREAMS_MY_CAM_MINIMUM_SEGMENTS=two"},
+		{"bool", "TRANSCODE_STREAMS_MY_CAM_EXPLICIT_START=maybe"},
+		{"float", "TRANSCODE_STREAMS_MY_CAM_SHARPEN=much"},
+		{"map", "TRANSCODE_STREAMS_MY_CAM_VARS=novalue"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &YamlConf{Streams: map[string]StreamConf{}}
+			if err := conf.mergeEnv([]string{tt.env}); err == nil {
+				t.Errorf("expected error, got streams %+v", conf.Streams)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+
+	"github.com/go-chi/chi"
+
+	"github.com/m1k1o/go-transcode/dash"
+)
+
+func (a *ApiManagerCtx) DASH(r chi.Router) {
+	getHead(r.With(a.refuseDraining), "/{profile}/{input}/dash/index.mpd", func(w http.ResponseWriter, r *http.Request) {
+		profile := chi.URLParam(r, "profile")
+		input := chi.URLParam(r, "input")
+
+		re := regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+		if !re.MatchString(profile) || !re.MatchString(input) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid parameters"))
+			return
+		}
+
+		if err := profileAllowed(profile, input); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 " + err.Error()))
+			return
+		}
+
+		manager := a.dashManager(profile, input)
+
+		// transcode is started holding lock of manager
+		if r.Method != http.MethodHead && manager.Pid() == 0 {
+			a.warmProbe(r.Context(), profileModeDASH, profile, input)
+		}
+
+		manager.ServeManifest(a.served(w, r, profile, input), r)
+	})
+
+	getHead(r, "/{profile}/{input}/dash/{file}.m4s", func(w http.ResponseWriter, r *http.Request) {
+		profile := chi.URLParam(r, "profile")
+		input := chi.URLParam(r, "input")
+
+		a.dashMu.Lock()
+		manager, ok := a.dashManagers[fmt.Sprintf("%s/%s", profile, input)]
+		a.dashMu.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 transcode not found"))
+			return
+		}
+
+		manager.ServeMedia(a.served(w, r, profile, input), r)
+	})
+}
+
+// dashManager returns existing manager or creates new one.
+func (a *ApiManagerCtx) dashManager(profile string, input string) dash.Manager {
+	a.dashMu.Lock()
+	defer a.dashMu.Unlock()
+
+	ID := fmt.Sprintf("%s/%s", profile, input)
+	manager, ok := a.dashManagers[ID]
+	if ok {
+		return manager
+	}
+
+	config := a.dashConfig
+	config.CmdLog = a.cmdLog(profileModeDASH, profile, input)
+	stream, _ := currentConf().stream(input)
+	config.ProcessLimits = a.profileProcessLimits(profileModeDASH, profile, input, stream)
+	config.Limits = a.transcodeLimits(profile)
+	config.Exited = a.transcodeExited
+	if stream.TempRoot != "" {
+		config.TempRoot = stream.TempRoot
+	}
+
+	manager = dash.New(func() (*exec.Cmd, error) {
+		// get transcode cmd
+		return a.transcodeStart(profileModeDASH, profile, input)
+	}, config)
+
+	a.dashManagers[ID] = manager
+	return manager
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/m1k1o/go-transcode/hls"
+	"github.com/m1k1o/go-transcode/internal/hwaccel"
+)
+
+// dashboardPage shows status of streams and controls their transcodes,
+// polling status of dashboard
+//
+//go:embed dashboard.html
+var dashboardPage []byte
+
+type dashboardSystem struct {
+	CPUs int `json:"cpus"`
+	// load averages over 1, 5 and 15 minutes, omitted when unknown
+	Load []float64 `json:"load,omitempty"`
+	// sessions of detected hardware encoders
+	HWAccel []hwaccel.Sessions `json:"hwaccel"`
+}
+
+type dashboardStream struct {
+	Name    string          `json:"name"`
+	Viewers hls.ViewerStats `json:"viewers"`
+	// hls profiles allowed for stream, that can be started
+	Profiles   []string        `json:"profiles"`
+	Transcodes []managedStream `json:"transcodes"`
+}
+
+type dashboardStatus struct {
+	System  dashboardSystem   `json:"system"`
+	Streams []dashboardStream `json:"streams"`
+}
+
+// Dashboard serves admin dashboard and its status.
+func (a *ApiManagerCtx) Dashboard(r chi.Router) {
+	// relative urls of dashboard need trailing slash
+	r.Get("/admin", func(w http.ResponseWriter, r *http.Request) {
+		target := "/admin/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+
+	r.Get("/admin/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardPage)
+	})
+
+	r.Get("/admin/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint
+		json.NewEncoder(w).Encode(a.dashboardStatus())
+	})
+}
+
+func (a *ApiManagerCtx) dashboardStatus() dashboardStatus {
+	status := dashboardStatus{
+		System: dashboardSystem{
+			CPUs:    runtime.NumCPU(),
+			Load:    loadAverage(),
+			HWAccel: a.encoders.Sessions(),
+		},
+		Streams: []dashboardStream{},
+	}
+
+	names := make([]string, 0, len(currentConf().Streams))
+	for name := range currentConf().Streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// dashboard is shown while profiles are unavailable
+	profiles, _ := a.hlsProfiles()
+
+	transcodes := map[string][]managedStream{}
+	for _, transcode := range a.managedStreams("") {
+		transcodes[transcode.Stream] = append(transcodes[transcode.Stream], transcode)
+	}
+
+	a.hlsMu.Lock()
+	defer a.hlsMu.Unlock()
+
+	for _, name := range names {
+		stream := dashboardStream{
+			Name:       name,
+			Profiles:   []string{},
+			Transcodes: transcodes[name],
+		}
+
+		conf, _ := currentConf().stream(name)
+		for _, profile := range profiles {
+			if conf.allows(profile) {
+				stream.Profiles = append(stream.Profiles, profile)
+			}
+		}
+
+		// viewers are not created for streams never requested
+		if viewers, ok := a.hlsViewers[name]; ok {
+			stream.Viewers = viewers.Stats()
+		}
+
+		if stream.Transcodes == nil {
+			stream.Transcodes = []managedStream{}
+		}
+
+		status.Streams = append(status.Streams, stream)
+	}
+
+	return status
+}
+
+// loadAverage returns system load averages, or nil when unknown.
+func loadAverage() []float64 {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return nil
+	}
+
+	load := []float64{}
+	for _, field := range fields[:3] {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil
+		}
+		load = append(load, value)
+	}
+
+	return load
+}
@@ -0,0 +1,329 @@
+package api
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/m1k1o/go-transcode/broadcast"
+	"github.com/m1k1o/go-transcode/dash"
+	"github.com/m1k1o/go-transcode/recording"
+	"github.com/m1k1o/go-transcode/subtitles"
+	"github.com/m1k1o/go-transcode/thumbnails"
+	"github.com/m1k1o/go-transcode/vod"
+	"github.com/m1k1o/go-transcode/whep"
+)
+
+type debugManager struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Running bool   `json:"running"`
+	Active  bool   `json:"active,omitempty"`
+	Pid     int    `json:"pid,omitempty"`
+}
+
+type debugProcess struct {
+	Pid     int    `json:"pid"`
+	Command string `json:"command"`
+}
+
+type debugInfo struct {
+	Goroutines struct {
+		Total     int `json:"total"`
+		Transcode int `json:"transcode"`
+	} `json:"goroutines"`
+	Pipes    int            `json:"pipes"`
+	Children []debugProcess `json:"children"`
+	Managers []debugManager `json:"managers"`
+}
+
+// debugAuth requires debug token, when configured.
+func (a *ApiManagerCtx) debugAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if a.config.DebugToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.config.DebugToken)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("401 unauthorized"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Debug reports managers, their processes and resources, to help
+// diagnosing leaks.
+func (a *ApiManagerCtx) Debug(w http.ResponseWriter, r *http.Request) {
+	info := debugInfo{
+		Pipes:    openPipes(),
+		Children: childProcesses(),
+		Managers: []debugManager{},
+	}
+
+	info.Goroutines.Total, info.Goroutines.Transcode = goroutines()
+
+	// maps are copied under global locks, managers are queried after
+	// releasing them, since they take their own locks
+	dashManagers := map[string]dash.Manager{}
+	a.dashMu.Lock()
+	for id, manager := range a.dashManagers {
+		dashManagers[id] = manager
+	}
+	a.dashMu.Unlock()
+
+	whepManagers := map[string]whep.Manager{}
+	a.whepMu.Lock()
+	for id, manager := range a.whepManagers {
+		whepManagers[id] = manager
+	}
+	a.whepMu.Unlock()
+
+	thumbnailsManagers := map[string]thumbnails.Manager{}
+	a.thumbnailsMu.Lock()
+	for id, manager := range a.thumbnailsManagers {
+		thumbnailsManagers[id] = manager
+	}
+	a.thumbnailsMu.Unlock()
+
+	subtitlesManagers := map[string]subtitles.Manager{}
+	a.subtitlesMu.Lock()
+	for id, manager := range a.subtitlesManagers {
+		subtitlesManagers[id] = manager
+	}
+	a.subtitlesMu.Unlock()
+
+	vodManagers := map[string]vod.Manager{}
+	a.vodMu.Lock()
+	for id, manager := range a.vodManagers {
+		vodManagers[id] = manager
+	}
+	a.vodMu.Unlock()
+
+	storyboards := map[string]*thumbnails.StoryboardCtx{}
+	a.storyboardsMu.Lock()
+	for id, storyboard := range a.storyboards {
+		storyboards[id] = storyboard
+	}
+	a.storyboardsMu.Unlock()
+
+	broadcastManagers := map[string]broadcast.Manager{}
+	a.broadcastMu.Lock()
+	for id, manager := range a.broadcastManagers {
+		broadcastManagers[id] = manager
+	}
+	a.broadcastMu.Unlock()
+
+	recordManagers := map[string]recording.Manager{}
+	a.recordMu.Lock()
+	for id, manager := range a.recordManagers {
+		recordManagers[id] = manager
+	}
+	a.recordMu.Unlock()
+
+	for id, manager := range a.hlsManagersOf("") {
+		pid := manager.Pid()
+		info.Managers = append(info.Managers, debugManager{
+			Type:    "hls",
+			ID:      id,
+			Running: pid != 0,
+			Active:  manager.Active(),
+			Pid:     pid,
+		})
+	}
+
+	for id, manager := range dashManagers {
+		pid := manager.Pid()
+		info.Managers = append(info.Managers, debugManager{
+			Type:    "dash",
+			ID:      id,
+			Running: pid != 0,
+			Active:  manager.Active(),
+			Pid:     pid,
+		})
+	}
+
+	for id, manager := range whepManagers {
+		pid := manager.Pid()
+		info.Managers = append(info.Managers, debugManager{
+			Type:    "whep",
+			ID:      id,
+			Running: pid != 0,
+			Active:  manager.Peers() > 0,
+			Pid:     pid,
+		})
+	}
+
+	for id, manager := range thumbnailsManagers {
+		pid := manager.Pid()
+		info.Managers = append(info.Managers, debugManager{
+			Type:    "thumbnails",
+			ID:      id,
+			Running: pid != 0,
+			Pid:     pid,
+		})
+	}
+
+	for id, manager := range subtitlesManagers {
+		pid := manager.Pid()
+		info.Managers = append(info.Managers, debugManager{
+			Type:    "subtitles",
+			ID:      id,
+			Running: pid != 0,
+			Pid:     pid,
+		})
+	}
+
+	for id, manager := range vodManagers {
+		pid := manager.Pid()
+		info.Managers = append(info.Managers, debugManager{
+			Type:    "vod",
+			ID:      id,
+			Running: pid != 0,
+			Pid:     pid,
+		})
+	}
+
+	for id, storyboard := range storyboards {
+		pid := storyboard.Pid()
+		info.Managers = append(info.Managers, debugManager{
+			Type:    "storyboard",
+			ID:      id,
+			Running: pid != 0,
+			Pid:     pid,
+		})
+	}
+
+	for id, manager := range broadcastManagers {
+		pid := manager.Pid()
+		info.Managers = append(info.Managers, debugManager{
+			Type:    "broadcast",
+			ID:      id,
+			Running: pid != 0,
+			Active:  manager.Clients() > 0,
+			Pid:     pid,
+		})
+	}
+
+	for id, manager := range recordManagers {
+		info.Managers = append(info.Managers, debugManager{
+			Type:    "recording",
+			ID:      id,
+			Running: manager.Running(),
+			Pid:     manager.Pid(),
+		})
+	}
+
+	sort.Slice(info.Managers, func(i, j int) bool {
+		if info.Managers[i].Type != info.Managers[j].Type {
+			return info.Managers[i].Type < info.Managers[j].Type
+		}
+		return info.Managers[i].ID < info.Managers[j].ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+
+	//nolint
+	json.NewEncoder(w).Encode(info)
+}
+
+// goroutines returns total goroutine count and count of those
+// running code of this module.
+func goroutines() (int, int) {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	total, transcode := 0, 0
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		total++
+
+		// exclude this handler
+		if bytes.Contains(stack, []byte("go-transcode/internal/api.goroutines")) {
+			continue
+		}
+
+		if bytes.Contains(stack, []byte("github.com/m1k1o/go-transcode/")) {
+			transcode++
+		}
+	}
+
+	return total, transcode
+}
+
+// openPipes counts open pipe file descriptors of this process.
+func openPipes() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, entry := range entries {
+		link, err := os.Readlink(path.Join("/proc/self/fd", entry.Name()))
+		if err == nil && strings.HasPrefix(link, "pipe:") {
+			count++
+		}
+	}
+
+	return count
+}
+
+// childProcesses lists direct children of this process.
+func childProcesses() []debugProcess {
+	children := []debugProcess{}
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return children
+	}
+
+	ppid := os.Getpid()
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		stat, err := os.ReadFile(path.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+
+		// command is in parentheses and may contain spaces
+		i := bytes.LastIndexByte(stat, ')')
+		if i < 0 {
+			continue
+		}
+
+		// fields after command: state, ppid, ...
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) < 2 || fields[1] != strconv.Itoa(ppid) {
+			continue
+		}
+
+		command := ""
+		if cmdline, err := os.ReadFile(path.Join("/proc", entry.Name(), "cmdline")); err == nil {
+			command = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+		}
+
+		children = append(children, debugProcess{
+			Pid:     pid,
+			Command: command,
+		})
+	}
+
+	return children
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/m1k1o/go-transcode/broadcast"
+)
+
+func TestDebug(t *testing.T) {
+	tests := []struct {
+		name  string
+		debug bool
+		token string
+		want  int
+	}{
+		{"disabled", false, "secret", http.StatusNotFound},
+		{"without token", true, "", http.StatusUnauthorized},
+		{"wrong token", true, "guess", http.StatusUnauthorized},
+		{"token", true, "secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApi()
+			a.config.Debug = tt.debug
+			a.config.DebugToken = "secret"
+
+			r := chi.NewRouter()
+			a.Mount(r)
+
+			req := httptest.NewRequest(http.MethodGet, "/debug/transcode", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugInfo(t *testing.T) {
+	a := newTestApi()
+	a.hlsManagers["h264_720p/cam"] = &testHLSManager{active: true}
+
+	// child process of server
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	rec := httptest.NewRecorder()
+	a.Debug(rec, httptest.NewRequest(http.MethodGet, "/debug/transcode", nil))
+
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("got content type %q, want application/json", got)
+	}
+
+	info := debugInfo{}
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Goroutines.Total == 0 {
+		t.Error("goroutines not counted")
+	}
+
+	found := false
+	for _, child := range info.Children {
+		found = found || child.Pid == cmd.Process.Pid && child.Command == "sleep 10"
+	}
+	if !found {
+		t.Errorf("child process %d not listed: %+v", cmd.Process.Pid, info.Children)
+	}
+
+	want := debugManager{Type: "hls", ID: "h264_720p/cam", Active: true}
+	if len(info.Managers) != 1 || info.Managers[0] != want {
+		t.Errorf("got managers %+v, want %+v", info.Managers, want)
+	}
+}
+
+// blockingBroadcastManager blocks in Pid until it is given.
+type blockingBroadcastManager struct {
+	broadcast.Manager
+
+	called chan struct{}
+	pid    chan int
+}
+
+func (m *blockingBroadcastManager) Pid() int {
+	close(m.called)
+	return <-m.pid
+}
+
+func (m *blockingBroadcastManager) Clients() int {
+	return 1
+}
+
+func TestDebugUnlocked(t *testing.T) {
+	a := newTestApi()
+	manager := &blockingBroadcastManager{called: make(chan struct{}), pid: make(chan int)}
+	a.broadcastManagers["h264_720p/cam/ts"] = manager
+
+	done := make(chan struct{})
+	rec := httptest.NewRecorder()
+	go func() {
+		a.Debug(rec, httptest.NewRequest(http.MethodGet, "/debug/transcode", nil))
+		close(done)
+	}()
+
+	<-manager.called
+
+	// managers are created meanwhile
+	locked := make(chan struct{})
+	go func() {
+		a.broadcastMu.Lock()
+		a.broadcastMu.Unlock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Error("broadcast managers locked while querying manager")
+	}
+
+	manager.pid <- 42
+	<-done
+
+	info := debugInfo{}
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+
+	want := debugManager{Type: "broadcast", ID: "h264_720p/cam/ts", Running: true, Active: true, Pid: 42}
+	if len(info.Managers) != 1 || info.Managers[0] != want {
+		t.Errorf("got managers %+v, want %+v", info.Managers, want)
+	}
+}
@@ -0,0 +1,299 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+)
+
+var streamNameRegex = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+var (
+	ErrStreamNotFound = errors.New("stream not found")
+	ErrStreamExists   = errors.New("stream already exists")
+	ErrStreamStatic   = errors.New("stream is defined in config")
+)
+
+// dynamicStream is stream registered at runtime using api.
+type dynamicStream struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	// allowed profiles, all when empty
+	Profiles []string `json:"profiles,omitempty"`
+}
+
+func (s dynamicStream) conf() StreamConf {
+	return StreamConf{
+		Source:   s.Source,
+		Profiles: s.Profiles,
+	}
+}
+
+func (s dynamicStream) validate() error {
+	if !streamNameRegex.MatchString(s.Name) {
+		return fmt.Errorf("invalid stream name %q", s.Name)
+	}
+
+	if s.Source == "" {
+		return errors.New("source is required")
+	}
+
+	for _, profile := range s.Profiles {
+		if !profileNameRegex.MatchString(profile) {
+			return fmt.Errorf("invalid profile %q", profile)
+		}
+	}
+
+	stream := s.conf()
+	return stream.validate()
+}
+
+// dynamicStreams are merged into streams config, also on reload. They
+// are persisted to state file, kept only in memory without it.
+type dynamicStreams struct {
+	mu      sync.Mutex
+	path    string
+	streams map[string]dynamicStream
+}
+
+func loadDynamicStreams(path string) (*dynamicStreams, error) {
+	d := &dynamicStreams{
+		path:    path,
+		streams: map[string]dynamicStream{},
+	}
+
+	if path == "" {
+		return d, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []dynamicStream
+	if err := json.Unmarshal(data, &streams); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, stream := range streams {
+		if err := stream.validate(); err != nil {
+			return nil, fmt.Errorf("%s: stream %s: %w", path, stream.Name, err)
+		}
+		d.streams[stream.Name] = stream
+	}
+
+	return d, nil
+}
+
+// list returns streams sorted by name, must be called with lock held.
+func (d *dynamicStreams) list() []dynamicStream {
+	streams := make([]dynamicStream, 0, len(d.streams))
+	for _, stream := range d.streams {
+		streams = append(streams, stream)
+	}
+
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].Name < streams[j].Name
+	})
+
+	return streams
+}
+
+// save writes state file, replacing it at once. Must be called with lock
+// held.
+func (d *dynamicStreams) save() error {
+	if d.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(d.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(d.path), ".streams-state")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), d.path)
+}
+
+// merge returns config with dynamic streams added. Streams of config file
+// replace registered ones of the same name, that are forgotten. Must be
+// called with lock held.
+func (d *dynamicStreams) merge(c *YamlConf) *YamlConf {
+	merged := &YamlConf{
+		Streams:      make(map[string]StreamConf, len(c.Streams)+len(d.streams)),
+		Placeholders: c.Placeholders,
+		templates:    c.templates,
+	}
+	for name, stream := range c.Streams {
+		merged.Streams[name] = stream
+	}
+
+	replaced := false
+	for name, stream := range d.streams {
+		if _, ok := merged.Streams[name]; ok {
+			log.Warn().Str("stream", name).Msg("registered stream is replaced by stream of config")
+			delete(d.streams, name)
+			replaced = true
+			continue
+		}
+		merged.Streams[name] = stream.conf()
+	}
+
+	if replaced {
+		if err := d.save(); err != nil {
+			log.Error().Err(err).Str("path", d.path).Msg("unable to save registered streams")
+		}
+	}
+
+	return merged
+}
+
+// register adds stream and persists it, config is swapped in once saved.
+func (d *dynamicStreams) register(stream dynamicStream) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := currentConf().stream(stream.Name); ok {
+		return ErrStreamExists
+	}
+
+	d.streams[stream.Name] = stream
+	if err := d.save(); err != nil {
+		delete(d.streams, stream.Name)
+		return err
+	}
+
+	c := d.merge(currentConf())
+	conf.Store(c)
+	return nil
+}
+
+// unregister removes stream registered at runtime and its persisted state.
+func (d *dynamicStreams) unregister(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stream, ok := d.streams[name]
+	if !ok {
+		if _, ok := currentConf().stream(name); ok {
+			return ErrStreamStatic
+		}
+		return ErrStreamNotFound
+	}
+
+	delete(d.streams, name)
+	if err := d.save(); err != nil {
+		d.streams[name] = stream
+		return err
+	}
+
+	current := currentConf()
+	c := &YamlConf{
+		Streams:      map[string]StreamConf{},
+		Placeholders: current.Placeholders,
+		templates:    current.templates,
+	}
+	for other, s := range current.Streams {
+		if other != name {
+			c.Streams[other] = s
+		}
+	}
+	conf.Store(c)
+	return nil
+}
+
+// DynamicStreams registers and removes streams at runtime.
+func (a *ApiManagerCtx) DynamicStreams(r chi.Router) {
+	r.Post("/api/streams", func(w http.ResponseWriter, r *http.Request) {
+		var stream dynamicStream
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&stream); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid stream: " + err.Error()))
+			return
+		}
+
+		if err := stream.validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid stream: " + err.Error()))
+			return
+		}
+
+		if err := a.dynamic.register(stream); err != nil {
+			if errors.Is(err, ErrStreamExists) {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte("409 " + err.Error()))
+				return
+			}
+
+			log.Error().Err(err).Str("stream", stream.Name).Msg("unable to register stream")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		log.Info().Str("stream", stream.Name).Str("source", stream.Source).Msg("stream registered")
+		a.syncRecordings()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+
+		//nolint
+		json.NewEncoder(w).Encode(stream)
+	})
+
+	r.Delete("/api/streams/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+
+		if err := a.dynamic.unregister(name); err != nil {
+			switch {
+			case errors.Is(err, ErrStreamNotFound):
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("404 stream not found"))
+			case errors.Is(err, ErrStreamStatic):
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte("409 " + err.Error()))
+			default:
+				log.Error().Err(err).Str("stream", name).Msg("unable to remove stream")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+			}
+			return
+		}
+
+		log.Info().Str("stream", name).Msg("stream removed")
+		a.syncRecordings()
+
+		// running transcodes of removed stream are stopped
+		a.removeStreamManagers(name)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
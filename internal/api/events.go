@@ -0,0 +1,285 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/hls"
+)
+
+// events buffered for every subscriber, further are dropped
+const eventsBuffer = 64
+
+// how often are idle subscribers sent keep-alive comment
+const eventsKeepAlive = 15 * time.Second
+
+// how often are viewers of streams expired
+const viewersCheckPeriod = 5 * time.Second
+
+// events of transcode output lines, sent only to subscribers requesting
+// them by type
+const logEventType = "log"
+
+type streamEvent struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Stream   string    `json:"stream"`
+	Profile  string    `json:"profile,omitempty"`
+	Sequence int       `json:"sequence,omitempty"`
+	Message  string    `json:"message,omitempty"`
+	// hashed id of viewer joining or leaving
+	Viewer string `json:"viewer,omitempty"`
+}
+
+type eventSubscriber struct {
+	stream string
+	// accepted event types, all but log events when empty
+	types   map[string]bool
+	events  chan streamEvent
+	dropped int
+}
+
+func (s *eventSubscriber) accepts(event streamEvent) bool {
+	if s.stream != "" && s.stream != event.Stream {
+		return false
+	}
+
+	if len(s.types) == 0 {
+		return event.Type != logEventType
+	}
+
+	return s.types[event.Type]
+}
+
+// eventBroker fans out manager events to subscribers, events of slow
+// subscribers are dropped and reported as count once they catch up.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subscribers: map[*eventSubscriber]struct{}{},
+	}
+}
+
+func (b *eventBroker) publish(event streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		if !sub.accepts(event) {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+func (b *eventBroker) subscribe(stream string, types []string) *eventSubscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &eventSubscriber{
+		stream: stream,
+		types:  map[string]bool{},
+		events: make(chan streamEvent, eventsBuffer),
+	}
+	for _, t := range types {
+		sub.types[t] = true
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (b *eventBroker) unsubscribe(sub *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers, sub)
+}
+
+// takeDropped returns and resets count of events dropped for subscriber.
+func (b *eventBroker) takeDropped(sub *eventSubscriber) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	dropped := sub.dropped
+	sub.dropped = 0
+	return dropped
+}
+
+// publishEvent passes event to subscribers and webhooks.
+func (a *ApiManagerCtx) publishEvent(event streamEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if a.events != nil {
+		a.events.publish(event)
+	}
+
+	if a.webhooks != nil {
+		a.webhooks.Send(event.Type, event)
+	}
+}
+
+// watchEvents publishes events of hls manager.
+func (a *ApiManagerCtx) watchEvents(manager hls.Manager, profile string, input string) {
+	publish := func(event streamEvent) {
+		event.Stream = input
+		event.Profile = profile
+		a.publishEvent(event)
+	}
+
+	manager.OnStart(func() {
+		publish(streamEvent{Type: "start"})
+	})
+	manager.OnStop(func() {
+		publish(streamEvent{Type: "stop"})
+	})
+	manager.OnPlaylist(func(sequence int) {
+		publish(streamEvent{Type: "segment", Sequence: sequence})
+	})
+	manager.OnRestart(func(reason string) {
+		publish(streamEvent{Type: "restart", Message: reason})
+	})
+	manager.OnError(func(message string) {
+		publish(streamEvent{Type: "error", Message: message})
+	})
+
+	// output is no longer logged by manager itself
+	logger := log.With().Str("module", "hls").Str("submodule", "manager").Str("profile", profile).Str("input", input).Logger()
+	manager.OnCmdLog(func(message string) {
+		logger.Warn().Msg(message)
+
+		for _, line := range strings.Split(message, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				publish(streamEvent{Type: logEventType, Message: line})
+			}
+		}
+	})
+}
+
+// watchViewerEvents publishes viewers of stream joining and leaving, their
+// ids are hashed so that sessions and client addresses are not exposed.
+func (a *ApiManagerCtx) watchViewerEvents(viewers *hls.Viewers, input string) {
+	publish := func(eventType string, id string) {
+		sum := sha256.Sum256([]byte(input + "\n" + id))
+		a.publishEvent(streamEvent{
+			Type:   eventType,
+			Stream: input,
+			Viewer: hex.EncodeToString(sum[:8]),
+		})
+	}
+
+	viewers.OnViewerJoin(func(id string) {
+		publish("viewer_join", id)
+	})
+	viewers.OnViewerLeave(func(id string) {
+		publish("viewer_leave", id)
+	})
+}
+
+// watchViewers expires viewers of all streams periodically, viewers
+// leaving are otherwise noticed only on next request.
+func (a *ApiManagerCtx) watchViewers() {
+	ticker := time.NewTicker(viewersCheckPeriod)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.hlsMu.Lock()
+		viewers := make([]*hls.Viewers, 0, len(a.hlsViewers))
+		for _, v := range a.hlsViewers {
+			viewers = append(viewers, v)
+		}
+		a.hlsMu.Unlock()
+
+		for _, v := range viewers {
+			v.Expire()
+		}
+	}
+}
+
+// Events streams manager events as server-sent events, optionally only
+// of stream given by stream parameter and of types given by comma
+// separated types parameter.
+func (a *ApiManagerCtx) Events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 streaming not supported"))
+		return
+	}
+
+	stream := r.URL.Query().Get("stream")
+	if _, ok := currentConf().stream(stream); stream != "" && !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 stream not found"))
+		return
+	}
+
+	var types []string
+	if value := r.URL.Query().Get("types"); value != "" {
+		types = strings.Split(value, ",")
+	}
+
+	sub := a.events.subscribe(stream, types)
+	defer a.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+		case event := <-sub.events:
+			if dropped := a.events.takeDropped(sub); dropped > 0 {
+				if !writeEvent(w, streamEvent{Type: "dropped", Time: time.Now(), Message: strconv.Itoa(dropped)}) {
+					return
+				}
+			}
+
+			if !writeEvent(w, event) {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event streamEvent) bool {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return false
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err == nil
+}
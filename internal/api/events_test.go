@@ -0,0 +1,129 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// testSubscribers returns count of subscribers of broker.
+func testSubscribers(b *eventBroker) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+func TestEvents(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{
+		"cam":   {Source: "rtsp://camera/stream"},
+		"lobby": {Source: "rtsp://lobby/stream"},
+	}})
+
+	a := newTestApi()
+	a.events = newEventBroker()
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events?stream=cam", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if got := res.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("got content type %q, want %q", got, "text/event-stream")
+	}
+	if !waitFor(time.Second, func() bool { return testSubscribers(a.events) == 1 }) {
+		t.Fatal("client not subscribed")
+	}
+
+	// other stream and log events are filtered out
+	a.publishEvent(streamEvent{Type: "start", Stream: "lobby", Profile: "h264_720p"})
+	a.publishEvent(streamEvent{Type: logEventType, Stream: "cam", Message: "frame=100"})
+	a.publishEvent(streamEvent{Type: "segment", Stream: "cam", Profile: "h264_720p", Sequence: 5})
+
+	body := bufio.NewReader(res.Body)
+	lines := make([]string, 3)
+	for i := range lines {
+		if lines[i], err = body.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if lines[0] != "event: segment\n" {
+		t.Errorf("got %q, want event line", lines[0])
+	}
+	if lines[2] != "\n" {
+		t.Errorf("got %q, want blank line closing event", lines[2])
+	}
+
+	event := streamEvent{}
+	if !strings.HasPrefix(lines[1], "data: ") {
+		t.Fatalf("got %q, want data line", lines[1])
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "segment" || event.Stream != "cam" || event.Sequence != 5 || event.Time.IsZero() {
+		t.Errorf("got event %+v", event)
+	}
+
+	// client disconnect unsubscribes it
+	cancel()
+	if !waitFor(time.Second, func() bool { return testSubscribers(a.events) == 0 }) {
+		t.Error("client not unsubscribed after disconnect")
+	}
+}
+
+// testNoFlushWriter is response writer not supporting streaming.
+type testNoFlushWriter struct {
+	http.ResponseWriter
+}
+
+func TestEventsNotStreaming(t *testing.T) {
+	a := newTestApi()
+	a.events = newEventBroker()
+
+	rec := httptest.NewRecorder()
+	a.Events(testNoFlushWriter{rec}, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if n := testSubscribers(a.events); n != 0 {
+		t.Errorf("got %d subscribers, want 0", n)
+	}
+}
+
+func TestEventsUnknownStream(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Source: "rtsp://camera/stream"}}})
+
+	a := newTestApi()
+	a.events = newEventBroker()
+
+	rec := httptest.NewRecorder()
+	a.Events(rec, httptest.NewRequest(http.MethodGet, "/events?stream=lobby", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/hls"
+)
+
+const (
+	StatusStarting = "starting"
+	StatusWarming  = "warming"
+	StatusOK       = "ok"
+	// shutting down, new playlists are refused
+	StatusDraining = "draining"
+)
+
+// how long to wait for preloaded streams to become active
+const preloadTimeout = 60 * time.Second
+
+// how often should be preloaded streams checked
+const preloadCheckPeriod = 500 * time.Millisecond
+
+func (a *ApiManagerCtx) setStatus(status string) {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+
+	// draining is final
+	if a.status == StatusDraining {
+		return
+	}
+	a.status = status
+}
+
+// Drain refuses new playlist, manifest and webrtc requests, so that
+// clients move to other servers, while in-flight requests are finished.
+func (a *ApiManagerCtx) Drain() {
+	a.setStatus(StatusDraining)
+}
+
+// refuseDraining responds with 503 to requests of new streams while
+// draining.
+func (a *ApiManagerCtx) refuseDraining(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.Status() == StatusDraining {
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("503 server is shutting down"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *ApiManagerCtx) Status() string {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	return a.status
+}
+
+func (a *ApiManagerCtx) Health(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	//nolint
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": a.Status(),
+	})
+}
+
+// Ready responds with 503 until server is warmed up and able to start streams.
+func (a *ApiManagerCtx) Ready(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	res := map[string]string{
+		"status": a.Status(),
+	}
+
+	ready := res["status"] == StatusOK
+	if !a.ProfilesAvailable() {
+		res["reason"] = ErrProfilesUnavailable.Error()
+		ready = false
+	}
+
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	//nolint
+	json.NewEncoder(w).Encode(res)
+}
+
+// Preload starts all preloaded streams and waits until they become
+// active, meanwhile server reports warming status.
+func (a *ApiManagerCtx) Preload() {
+	logger := log.With().Str("module", "preload").Logger()
+	a.setStatus(StatusWarming)
+
+	pending := map[string]hls.Manager{}
+	for input, stream := range currentConf().Streams {
+		for _, profile := range stream.Preload {
+			a.warmProbe(context.Background(), profileModeHLS, profile, input)
+
+			manager := a.hlsManager(profile, input)
+			if err := manager.Start(); err != nil {
+				logger.Warn().Err(err).Str("input", input).Str("profile", profile).Msg("preload could not be started")
+				continue
+			}
+
+			pending[profile+"/"+input] = manager
+		}
+	}
+
+	ticker := time.NewTicker(preloadCheckPeriod)
+	defer ticker.Stop()
+
+	timeout := time.After(preloadTimeout)
+
+	for len(pending) > 0 {
+		select {
+		case <-ticker.C:
+			for ID, manager := range pending {
+				if manager.Active() {
+					logger.Info().Str("id", ID).Msg("preload active")
+					delete(pending, ID)
+				}
+			}
+		case <-timeout:
+			logger.Warn().Int("pending", len(pending)).Msg("preload timeouted")
+			pending = nil
+		}
+	}
+
+	a.setStatus(StatusOK)
+}
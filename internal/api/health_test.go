@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// testStatus returns status and its http code reported by endpoint.
+func testStatus(t *testing.T, url string) (string, int) {
+	t.Helper()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("endpoint not reachable: %v", err)
+	}
+	defer res.Body.Close()
+
+	body := map[string]string{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body["status"], res.StatusCode
+}
+
+func TestHealthWhilePreloading(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Preload: []string{"h264_720p"}}}})
+
+	a := newTestApi()
+	a.setStatus(StatusStarting)
+
+	manager := &testHLSManager{}
+	a.hlsManagers["h264_720p/cam"] = manager
+
+	r := chi.NewRouter()
+	r.Get("/healthz", a.Health)
+	r.Get("/readyz", a.Ready)
+
+	// listener is bound before preload starts
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		a.Preload()
+		close(done)
+	}()
+
+	// preloaded stream is not active yet
+	ok := waitFor(time.Second, func() bool { return a.Status() == StatusWarming })
+	if !ok {
+		t.Fatalf("got status %q, want %q", a.Status(), StatusWarming)
+	}
+
+	if status, code := testStatus(t, server.URL+"/healthz"); status != StatusWarming || code != http.StatusOK {
+		t.Errorf("healthz: got %q (%d), want %q (200)", status, code, StatusWarming)
+	}
+	if status, code := testStatus(t, server.URL+"/readyz"); status != StatusWarming || code != http.StatusServiceUnavailable {
+		t.Errorf("readyz: got %q (%d), want %q (503)", status, code, StatusWarming)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("preload finished before stream became active")
+	default:
+	}
+
+	manager.setActive(true)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("preload did not finish once stream became active")
+	}
+
+	if status, code := testStatus(t, server.URL+"/healthz"); status != StatusOK || code != http.StatusOK {
+		t.Errorf("healthz: got %q (%d), want %q (200)", status, code, StatusOK)
+	}
+	if status, code := testStatus(t, server.URL+"/readyz"); status != StatusOK || code != http.StatusOK {
+		t.Errorf("readyz: got %q (%d), want %q (200)", status, code, StatusOK)
+	}
+
+	if manager.started != 1 {
+		t.Errorf("preloaded stream started %d times, want once", manager.started)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/hls"
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// acquireHelper waits for slot to run helper command (e.g. ffprobe), excess
+// requests fail after helper queue timeout. Returned function releases it.
+func (a *ApiManagerCtx) acquireHelper(ctx context.Context) (func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, a.config.HelperQueueTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := a.helpers.Acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	if waited := time.Since(start); waited > time.Second {
+		log.Debug().Dur("waited", waited).Msg("helper command was queued")
+	}
+
+	return a.helpers.Release, nil
+}
+
+// transcodeLimits returns limits of concurrent transcodes of profile, shared
+// by all modes. Profile limit is waited for first, without holding global
+// one.
+func (a *ApiManagerCtx) transcodeLimits(profile string) []*utils.Semaphore {
+	limits := []*utils.Semaphore{}
+	for _, limit := range []*utils.Semaphore{a.profileTranscodes[profile], a.transcodes} {
+		if limit != nil {
+			limits = append(limits, limit)
+		}
+	}
+	return limits
+}
+
+// acquireTranscode waits up to transcode queue timeout for slots of raw
+// http transcode, that is not started by manager. Returned function
+// releases them.
+func (a *ApiManagerCtx) acquireTranscode(ctx context.Context, profile string) (func(), error) {
+	limits := a.transcodeLimits(profile)
+
+	ctx, cancel := context.WithTimeout(ctx, a.hlsConfig.LimitTimeout)
+	defer cancel()
+
+	if err := utils.AcquireAll(ctx, limits); err != nil {
+		return nil, hls.ErrTooManyTranscodes
+	}
+
+	return func() { utils.ReleaseAll(limits) }, nil
+}
+
+// refuseTranscode responds to transcode rejected because of limits.
+func (a *ApiManagerCtx) refuseTranscode(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", a.hlsConfig.RetryAfter())
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte("503 too many transcodes"))
+}
+
+// getHead routes GET and HEAD requests of pattern to handler, that answers
+// HEAD requests with headers only.
+func getHead(r chi.Router, pattern string, handler http.HandlerFunc) {
+	r.Get(pattern, handler)
+	r.Head(pattern, handler)
+}
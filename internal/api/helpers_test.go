@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+func TestAcquireHelper(t *testing.T) {
+	a := newTestApi()
+	a.config.HelperQueueTimeout = 100 * time.Millisecond
+	a.helpers = utils.NewSemaphore(2)
+
+	releases := []func(){}
+	for i := 0; i < 2; i++ {
+		release, err := a.acquireHelper(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		releases = append(releases, release)
+	}
+
+	// saturated queue is left after timeout
+	start := time.Now()
+	if _, err := a.acquireHelper(context.Background()); err != utils.ErrSemaphoreFull {
+		t.Errorf("got %v, want %v", err, utils.ErrSemaphoreFull)
+	}
+	if elapsed := time.Since(start); elapsed < a.config.HelperQueueTimeout || elapsed > a.config.HelperQueueTimeout+100*time.Millisecond {
+		t.Errorf("gave up after %v, want %v", elapsed, a.config.HelperQueueTimeout)
+	}
+
+	// queued helper runs once slot is released
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		releases[0]()
+	}()
+
+	release, err := a.acquireHelper(context.Background())
+	if err != nil {
+		t.Fatalf("got %v, want slot once released", err)
+	}
+	release()
+	releases[1]()
+}
@@ -1,25 +1,79 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os/exec"
+	"path"
+	"reflect"
 	"regexp"
+	"strings"
 
 	"github.com/go-chi/chi"
 	"github.com/rs/zerolog/log"
 
 	"github.com/m1k1o/go-transcode/hls"
+	"github.com/m1k1o/go-transcode/subtitles"
 )
 
-var hlsManagers map[string]hls.Manager = make(map[string]hls.Manager)
-
 func (a *ApiManagerCtx) HLS(r chi.Router) {
-	r.Get("/{profile}/{input}/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
-		logger := log.With().
-			Str("module", "m3u8").
-			Logger()
+	getHead(r.With(a.refuseDraining), "/{profile}/{input}/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		profile := chi.URLParam(r, "profile")
+		input := chi.URLParam(r, "input")
+
+		re := regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+		if !re.MatchString(profile) || !re.MatchString(input) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid parameters"))
+			return
+		}
+
+		if err := profileAllowed(profile, input); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 " + err.Error()))
+			return
+		}
+
+		// checked before transcode is probed or started
+		if err := hls.VerifySigned(a.hlsConfig.SigningSecret, r); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 " + err.Error()))
+			return
+		}
 
+		track, err := hlsTrackParams(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 " + err.Error()))
+			return
+		}
+
+		if !setDisposition(w, r, input, "index.m3u8") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid disposition"))
+			return
+		}
+
+		// managers are created only for known streams
+		if _, ok := currentConf().stream(input); !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 stream not found"))
+			return
+		}
+
+		manager := a.hlsTrackManager(profile, input, track)
+
+		// transcode is started holding lock of manager
+		if r.Method != http.MethodHead && manager.Pid() == 0 {
+			a.warmProbe(r.Context(), profileModeHLS, profile, input)
+		}
+
+		manager.ServePlaylist(a.served(w, r, profile, input), r)
+	})
+
+	// master playlist advertising audio and subtitles tracks of stream
+	r.With(a.refuseDraining).Get("/{profile}/{input}/"+tracksPlaylistName, func(w http.ResponseWriter, r *http.Request) {
 		profile := chi.URLParam(r, "profile")
 		input := chi.URLParam(r, "input")
 
@@ -30,28 +84,118 @@ func (a *ApiManagerCtx) HLS(r chi.Router) {
 			return
 		}
 
-		ID := fmt.Sprintf("%s/%s", profile, input)
+		if err := profileAllowed(profile, input); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 " + err.Error()))
+			return
+		}
+
+		a.serveTracks(w, r, input)
+	})
+
+	// webvtt playlists and segments of subtitles tracks
+	serveSubtitles := func(w http.ResponseWriter, r *http.Request) {
+		profile := chi.URLParam(r, "profile")
+		input := chi.URLParam(r, "input")
 
-		manager, ok := hlsManagers[ID]
+		re := regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+		if !re.MatchString(profile) || !re.MatchString(input) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid parameters"))
+			return
+		}
+
+		if err := profileAllowed(profile, input); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 " + err.Error()))
+			return
+		}
+
+		if err := hls.VerifySigned(a.hlsConfig.SigningSecret, r); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 " + err.Error()))
+			return
+		}
+
+		playlist := path.Base(r.URL.Path) == subtitlesPlaylistName
+		a.serveSubtitles(w, r, playlist, func(track int) (subtitles.Manager, error) {
+			return a.subtitlesManager(r.Context(), input, track)
+		})
+	}
+
+	r.With(a.refuseDraining).Get("/{profile}/{input}/"+subtitlesPlaylistName, serveSubtitles)
+	r.Get("/{profile}/{input}/{file}.vtt", serveSubtitles)
+
+	serveMedia := func(w http.ResponseWriter, r *http.Request) {
+		profile := chi.URLParam(r, "profile")
+		input := chi.URLParam(r, "input")
+		file := chi.URLParam(r, "file")
+
+		re := regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+		if !re.MatchString(profile) || !re.MatchString(input) || !re.MatchString(file) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid parameters"))
+			return
+		}
+
+		manager, ok := a.hlsRequestManager(r, profile, input)
 		if !ok {
-			// create new manager
-			manager = hls.New(func() *exec.Cmd {
-				// get transcode cmd
-				cmd, err := transcodeStart("profiles/hls", profile, input)
-				if err != nil {
-					logger.Panic().Err(err).Msg("transcode could not be started")
-				}
-
-				return cmd
-			})
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 transcode not found"))
+			return
+		}
+
+		if !setDisposition(w, r, input, path.Base(r.URL.Path)) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid disposition"))
+			return
+		}
 
-			hlsManagers[ID] = manager
+		manager.ServeMedia(a.served(w, r, profile, input), r)
+	}
+
+	// variant playlists of abr profiles
+	getHead(r.With(a.refuseDraining), "/{profile}/{input}/{file}.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		profile := chi.URLParam(r, "profile")
+		input := chi.URLParam(r, "input")
+		file := chi.URLParam(r, "file")
+
+		re := regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+		if !re.MatchString(profile) || !re.MatchString(input) || !re.MatchString(file) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid parameters"))
+			return
 		}
 
-		manager.ServePlaylist(w, r)
+		if err := profileAllowed(profile, input); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 " + err.Error()))
+			return
+		}
+
+		manager, ok := a.hlsRequestManager(r, profile, input)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 transcode not found"))
+			return
+		}
+
+		if !setDisposition(w, r, input, path.Base(r.URL.Path)) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid disposition"))
+			return
+		}
+
+		manager.ServeVariant(a.served(w, r, profile, input), r)
 	})
 
-	r.Get("/{profile}/{input}/{file}.ts", func(w http.ResponseWriter, r *http.Request) {
+	getHead(r, "/{profile}/{input}/{file}.ts", serveMedia)
+	// fragmented mp4 segments and init segment
+	getHead(r, "/{profile}/{input}/{file}.m4s", serveMedia)
+	getHead(r, "/{profile}/{input}/{file}.mp4", serveMedia)
+
+	// keys of encrypted segments
+	r.Get("/{profile}/{input}/{file}.key", func(w http.ResponseWriter, r *http.Request) {
 		profile := chi.URLParam(r, "profile")
 		input := chi.URLParam(r, "input")
 		file := chi.URLParam(r, "file")
@@ -63,16 +207,20 @@ func (a *ApiManagerCtx) HLS(r chi.Router) {
 			return
 		}
 
-		ID := fmt.Sprintf("%s/%s", profile, input)
+		if err := profileAllowed(profile, input); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 " + err.Error()))
+			return
+		}
 
-		manager, ok := hlsManagers[ID]
+		manager, ok := a.hlsRequestManager(r, profile, input)
 		if !ok {
 			w.WriteHeader(http.StatusNotFound)
 			w.Write([]byte("404 transcode not found"))
 			return
 		}
 
-		manager.ServeMedia(w, r)
+		manager.ServeKey(w, r)
 	})
 
 	r.Get("/{profile}/{input}/play.html", func(w http.ResponseWriter, r *http.Request) {
@@ -80,3 +228,245 @@ func (a *ApiManagerCtx) HLS(r chi.Router) {
 		http.ServeFile(w, r, "/app/data/play.html")
 	})
 }
+
+func (a *ApiManagerCtx) hlsManagerLookup(profile string, input string) (hls.Manager, bool) {
+	return a.hlsTrackManagerLookup(profile, input, hlsTrack{})
+}
+
+func (a *ApiManagerCtx) hlsTrackManagerLookup(profile string, input string, track hlsTrack) (hls.Manager, bool) {
+	a.hlsMu.Lock()
+	defer a.hlsMu.Unlock()
+
+	manager, ok := a.hlsManagers[track.id(profile, input)]
+	return manager, ok
+}
+
+// hlsRequestManager returns existing manager of track selected by request.
+func (a *ApiManagerCtx) hlsRequestManager(r *http.Request, profile string, input string) (hls.Manager, bool) {
+	track, err := hlsTrackParams(r)
+	if err != nil {
+		return nil, false
+	}
+
+	return a.hlsTrackManagerLookup(profile, input, track)
+}
+
+// hlsManager returns existing manager or creates new one.
+func (a *ApiManagerCtx) hlsManager(profile string, input string) hls.Manager {
+	return a.hlsTrackManager(profile, input, hlsTrack{})
+}
+
+// stableTempDir returns tempdir of stream at root, that is same for every
+// run. Names escaping root are rejected, transcode then writes to random
+// tempdir instead.
+func stableTempDir(root string, names ...string) string {
+	for _, name := range names {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			log.Warn().Str("root", root).Str("name", name).Msg("invalid tempdir name, using random tempdir")
+			return ""
+		}
+	}
+
+	return path.Join(append([]string{root}, names...)...)
+}
+
+// hlsTrackManager returns existing manager of track or creates new one.
+// Manager created with config of stream, that changed meanwhile, is
+// replaced once its transcode is not running.
+func (a *ApiManagerCtx) hlsTrackManager(profile string, input string, track hlsTrack) hls.Manager {
+	ID := track.id(profile, input)
+	stream, found := currentConf().stream(input)
+	a.removeStaleHLSManager(ID, stream)
+
+	a.hlsMu.Lock()
+	defer a.hlsMu.Unlock()
+
+	manager, ok := a.hlsManagers[ID]
+	if ok {
+		return manager
+	}
+
+	config := a.hlsConfig
+	config.Viewers = a.streamViewers(input)
+	config.CmdLog = a.cmdLog(profileModeHLS, track.name(profile), input)
+	config.URIQuery = track.params().Encode()
+	config.ProcessLimits = a.profileProcessLimits(profileModeHLS, profile, input, stream)
+
+	// tracks keep their own directories
+	profileDir := strings.ReplaceAll(track.name(profile), "@", "_")
+
+	if a.hlsStore != nil {
+		config.Store = a.hlsStore.WithPrefix(path.Join(input, profileDir))
+	}
+
+	if a.hlsTempDir != "" {
+		config.TempDir = stableTempDir(a.hlsTempDir, input, profileDir)
+	}
+
+	if profilePath, err := a.profilePath(profileModeHLS, profile); err == nil {
+		config.ABR = profileIsABR(profilePath)
+	}
+
+	config.Limits = a.transcodeLimits(profile)
+	config.Exited = a.transcodeExited
+
+	if found {
+		config.KeepAlive = stream.preloads(profile) && track == (hlsTrack{})
+
+		if stream.ExplicitStart != nil {
+			config.ExplicitStart = *stream.ExplicitStart
+		}
+
+		if timeout, _ := stream.coldStartTimeout(); timeout > 0 {
+			config.ColdStartTimeout = timeout
+		}
+
+		if timeout, _ := stream.startupTimeout(); timeout > 0 {
+			config.StartupTimeout = timeout
+		}
+
+		if duration, _ := stream.maxDuration(); duration > 0 {
+			config.MaxDuration = duration
+		}
+
+		if stream.TempDir != "" {
+			config.TempDir = stableTempDir(stream.TempDir, profileDir)
+		}
+
+		if stream.TempRoot != "" {
+			config.TempRoot = stream.TempRoot
+		}
+
+		config.Manager, _ = stream.manager(config.Manager)
+		config.Watchdog, _ = stream.watchdog(config.Watchdog)
+
+		if stream.FallbackSource != "" {
+			config.FallbackCmd = func() (*exec.Cmd, error) {
+				return a.transcodeFallbackStart(profileModeHLS, profile, input)
+			}
+		}
+
+		for i := range stream.BackupSources {
+			backup := i + 1
+			config.BackupCmds = append(config.BackupCmds, func() (*exec.Cmd, error) {
+				return a.transcodeBackupStart(profileModeHLS, profile, input, backup, track)
+			})
+		}
+
+		if len(stream.BackupSources) > 0 {
+			source := stream.Source
+			config.ProbePrimary = func() error {
+				return a.probeAvailable(context.Background(), source)
+			}
+		}
+
+		// reconnect options are supported only by http sources
+		if scheme := stream.scheme(); stream.ingestPath(input) == "" && (scheme == "http" || scheme == "https") {
+			config.ReconnectCmd = func() (*exec.Cmd, error) {
+				return a.transcodeReconnectStart(profileModeHLS, profile, input, track)
+			}
+		}
+	}
+
+	// create new manager
+	manager = hls.New(func() (*exec.Cmd, error) {
+		// get transcode cmd
+		return a.transcodeTrackStart(profileModeHLS, profile, input, track)
+	}, config)
+
+	if a.events != nil || a.webhooks != nil {
+		a.watchEvents(manager, profile, input)
+	}
+
+	manager.OnAlert(func(message string) {
+		log.Error().Str("profile", profile).Str("input", input).Msg(message)
+
+		a.publishEvent(streamEvent{
+			Type:    "alert",
+			Stream:  input,
+			Profile: profile,
+			Message: message,
+		})
+	})
+
+	a.hlsManagers[ID] = manager
+	a.hlsStreams[ID] = stream
+	return manager
+}
+
+// removeStaleHLSManager shuts down manager, whose stream config changed
+// since it was created, unless its transcode is running.
+func (a *ApiManagerCtx) removeStaleHLSManager(ID string, stream StreamConf) {
+	a.hlsMu.Lock()
+	manager, ok := a.hlsManagers[ID]
+	created, known := a.hlsStreams[ID]
+	a.hlsMu.Unlock()
+
+	if !ok || !known || reflect.DeepEqual(created, stream) {
+		return
+	}
+
+	// manager takes its own lock, it is queried once global lock is released
+	if manager.Pid() != 0 {
+		return
+	}
+
+	a.removeHLSManager(ID, manager)
+}
+
+// removeHLSManager shuts down manager and removes it, unless it was
+// replaced meanwhile.
+func (a *ApiManagerCtx) removeHLSManager(ID string, manager hls.Manager) {
+	a.hlsMu.Lock()
+	if a.hlsManagers[ID] != manager {
+		a.hlsMu.Unlock()
+		return
+	}
+	delete(a.hlsManagers, ID)
+	delete(a.hlsStreams, ID)
+	a.hlsMu.Unlock()
+
+	manager.Shutdown()
+}
+
+// streamViewers returns viewers of stream or creates new ones, must be
+// called with hls lock held.
+func (a *ApiManagerCtx) streamViewers(input string) *hls.Viewers {
+	viewers, ok := a.hlsViewers[input]
+	if !ok {
+		viewers = hls.NewViewers()
+		viewers.OnFirstViewer(func() {
+			a.publishEvent(streamEvent{Type: "first_viewer", Stream: input})
+		})
+		viewers.OnLastViewer(func() {
+			a.publishEvent(streamEvent{Type: "last_viewer", Stream: input})
+		})
+		if a.events != nil || a.webhooks != nil {
+			a.watchViewerEvents(viewers, input)
+		}
+		a.hlsViewers[input] = viewers
+	}
+	return viewers
+}
+
+// setDisposition sets Content-Disposition of playlist or segment, where
+// disposition request parameter takes precedence over stream config.
+// Returns false when requested disposition is invalid.
+func setDisposition(w http.ResponseWriter, r *http.Request, input string, fileName string) bool {
+	disposition := r.URL.Query().Get("disposition")
+	if disposition == "" {
+		stream, _ := currentConf().stream(input)
+		disposition = stream.Disposition
+	}
+
+	switch disposition {
+	case "", DispositionInline:
+		w.Header().Set("Content-Disposition", DispositionInline)
+	case DispositionAttachment:
+		w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", DispositionAttachment, fileName))
+	default:
+		return false
+	}
+
+	return true
+}
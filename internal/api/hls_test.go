@@ -0,0 +1,147 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestStableTempDir(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  string
+	}{
+		{"stream profile", []string{"cam", "h264_720p"}, "/tmp/hls/cam/h264_720p"},
+		{"track", []string{"cam", "h264_720p_audio"}, "/tmp/hls/cam/h264_720p_audio"},
+		{"parent", []string{"..", "h264_720p"}, ""},
+		{"current", []string{"cam", "."}, ""},
+		{"empty", []string{"", "h264_720p"}, ""},
+		{"slash", []string{"cam/../..", "h264_720p"}, ""},
+		{"backslash", []string{`..\cam`, "h264_720p"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stableTempDir("/tmp/hls", tt.names...); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetDisposition(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{
+		"cam":      {Source: "rtsp://camera/stream"},
+		"download": {Source: "rtsp://camera/stream", Disposition: DispositionAttachment},
+	}})
+
+	tests := []struct {
+		name  string
+		input string
+		query string
+		ok    bool
+		want  string
+	}{
+		{"default", "cam", "", true, "inline"},
+		{"stream config", "download", "", true, `attachment; filename="index0.ts"`},
+		{"query inline", "download", "?disposition=inline", true, "inline"},
+		{"query attachment", "cam", "?disposition=attachment", true, `attachment; filename="index0.ts"`},
+		{"invalid", "cam", "?disposition=download", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/h264_720p/"+tt.input+"/index0.ts"+tt.query, nil)
+
+			if ok := setDisposition(rec, r, tt.input, "index0.ts"); ok != tt.ok {
+				t.Fatalf("got %v, want %v", ok, tt.ok)
+			}
+
+			if got := rec.Header().Get("Content-Disposition"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaylistDisposition(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Source: "rtsp://camera/stream"}}})
+
+	a := newTestApi()
+	a.hlsManagers["h264_720p/cam"] = &testHLSManager{}
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/h264_720p/cam/index.m3u8?disposition=attachment", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got, want := rec.Header().Get("Content-Disposition"), `attachment; filename="index.m3u8"`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/h264_720p/cam/index.m3u8?disposition=download", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestPlaylistUnsigned(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Source: "rtsp://camera/stream"}}})
+
+	a := newTestApi()
+	a.config.Profiles = testProfiles(t, "h264_720p")
+	a.hlsConfig.SigningSecret = "secret"
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	for _, query := range []string{"", "?expires=4102444800&token=forged"} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/h264_720p/cam/index.m3u8"+query, nil))
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%q: got status %d, want %d", query, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	// refused before manager is created and source probed
+	if managers := a.hlsManagersOf(""); len(managers) > 0 {
+		t.Errorf("got %d managers, want none", len(managers))
+	}
+	if len(a.probing) > 0 || len(a.probes) > 0 {
+		t.Error("source probed for unsigned request")
+	}
+}
+
+func TestPlaylistUnknownStream(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Source: "rtsp://camera/stream"}}})
+
+	a := newTestApi()
+	a.config.Profiles = testProfiles(t, "h264_720p")
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/h264_720p/lobby/index.m3u8", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if managers := a.hlsManagersOf(""); len(managers) > 0 {
+		t.Errorf("got %d managers, want none for unknown stream", len(managers))
+	}
+	if len(a.hlsViewers) > 0 {
+		t.Error("got viewers of unknown stream")
+	}
+}
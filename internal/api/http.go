@@ -1,18 +1,25 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os/exec"
+	"strings"
 
 	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"github.com/m1k1o/go-transcode/broadcast"
+	"github.com/m1k1o/go-transcode/hls"
 	"github.com/m1k1o/go-transcode/internal/utils"
 )
 
 func (a *ApiManagerCtx) Http(r chi.Router) {
+	r.Use(a.httpHead)
+
 	r.Get("/test", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "video/mp2t")
 		logger := log.With().
@@ -34,11 +41,11 @@ func (a *ApiManagerCtx) Http(r chi.Router) {
 			write.Close()
 		}()
 
-		go cmd.Run()
+		go a.runTranscode(logger, cmd, a.processLimits)
 		io.Copy(w, read)
 	})
 
-	r.Get("/{profile}/{input}", func(w http.ResponseWriter, r *http.Request) {
+	getHead(r, "/{profile}/{input}", func(w http.ResponseWriter, r *http.Request) {
 		logger := log.With().
 			Str("path", r.URL.Path).
 			Str("module", "ffmpeg").
@@ -47,33 +54,55 @@ func (a *ApiManagerCtx) Http(r chi.Router) {
 		profile := chi.URLParam(r, "profile")
 		input := chi.URLParam(r, "input")
 
-		cmd, err := transcodeStart("profiles/http", profile, input)
+		if a.config.HTTPShared {
+			a.serveShared(w, r, profile, input, broadcast.FormatMPEGTS)
+			return
+		}
+
+		release, err := a.acquireTranscode(r.Context(), profile)
 		if err != nil {
+			logger.Warn().Msg("transcode limit reached")
+			a.refuseTranscode(w)
+			return
+		}
+
+		cmd, err := a.transcodeStart(profileModeHTTP, profile, input)
+		if err != nil {
+			release()
 			logger.Warn().Err(err).Msg("transcode could not be started")
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(transcodeErrorStatus(err))
 			w.Write([]byte(fmt.Sprintf("%v", err)))
 			return
 		}
 
+		utils.ExpandArgs(cmd)
+
 		logger.Info().Msg("command started")
 		w.Header().Set("Content-Type", "video/mp2t")
 
 		read, write := io.Pipe()
 		cmd.Stdout = write
-		cmd.Stderr = utils.LogWriter(logger)
+		cmd.Stderr = io.MultiWriter(utils.LogWriter(logger), a.cmdLog(profileModeHTTP, profile, input))
 
+		w, closeSession := a.session(w, r, profile, input)
 		defer func() {
 			logger.Info().Msg("command stopped")
+			closeSession()
 
 			read.Close()
 			write.Close()
 		}()
 
-		go cmd.Run()
+		go func() {
+			stream, _ := currentConf().stream(input)
+			a.runTranscode(logger, cmd, a.profileProcessLimits(profileModeHTTP, profile, input, stream))
+			write.Close()
+			release()
+		}()
 		io.Copy(w, read)
 	})
 
-	r.Get("/{profile}/{input}/buf", func(w http.ResponseWriter, r *http.Request) {
+	getHead(r, "/{profile}/{input}/mp4", func(w http.ResponseWriter, r *http.Request) {
 		logger := log.With().
 			Str("path", r.URL.Path).
 			Str("module", "ffmpeg").
@@ -82,24 +111,198 @@ func (a *ApiManagerCtx) Http(r chi.Router) {
 		profile := chi.URLParam(r, "profile")
 		input := chi.URLParam(r, "input")
 
-		cmd, err := transcodeStart("profiles", profile, input)
+		clip, err := parseClip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 " + err.Error()))
+			return
+		}
+
+		// clips are not shared, every one has its own range
+		if a.config.HTTPShared && clip == nil {
+			a.serveShared(w, r, profile, input, broadcast.FormatMP4)
+			return
+		}
+
+		release, err := a.acquireTranscode(r.Context(), profile)
+		if err != nil {
+			logger.Warn().Msg("transcode limit reached")
+			a.refuseTranscode(w)
+			return
+		}
+		defer release()
+
+		var cmd *exec.Cmd
+		if clip != nil {
+			err = a.validateClip(r.Context(), input, *clip)
+			if err == nil {
+				cmd, err = a.transcodeClipStart(profileModeHTTP, profile, input, *clip)
+			}
+		} else {
+			cmd, err = a.transcodeStart(profileModeHTTP, profile, input)
+		}
+
+		if err == nil {
+			var profilePath string
+			profilePath, err = a.profilePath(profileModeHTTP, profile)
+			if err == nil && !profileSupportsFormat(profilePath) {
+				err = fmt.Errorf("profile %s does not support mp4 output", profile)
+				a.transcodeExited(cmd)
+			}
+		}
+
 		if err != nil {
 			logger.Warn().Err(err).Msg("transcode could not be started")
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(transcodeErrorStatus(err))
 			w.Write([]byte(fmt.Sprintf("%v", err)))
 			return
 		}
 
+		cmd.Env = append(cmd.Env, mp4StreamEnv...)
+		utils.ExpandArgs(cmd)
+
+		logger.Info().Msg("command started")
+		w.Header().Set("Content-Type", "video/mp4")
+
+		read, write := io.Pipe()
+		cmd.Stdout = write
+		cmd.Stderr = io.MultiWriter(utils.LogWriter(logger), a.cmdLog(profileModeHTTP, profile, input))
+
+		w, closeSession := a.session(w, r, profile, input)
+		defer closeSession()
+
+		stream, _ := currentConf().stream(input)
+
+		// response must not be written once handler returns
+		copied := make(chan struct{})
+		go func() {
+			utils.IOPipeToHTTP(w, read)
+			close(copied)
+		}()
+
+		a.runTranscode(logger, cmd, a.profileProcessLimits(profileModeHTTP, profile, input, stream))
+		write.Close()
+		<-copied
+		logger.Info().Msg("command stopped")
+	})
+
+	getHead(r, "/{profile}/{input}/buf", func(w http.ResponseWriter, r *http.Request) {
+		logger := log.With().
+			Str("path", r.URL.Path).
+			Str("module", "ffmpeg").
+			Logger()
+
+		profile := chi.URLParam(r, "profile")
+		input := chi.URLParam(r, "input")
+
+		release, err := a.acquireTranscode(r.Context(), profile)
+		if err != nil {
+			logger.Warn().Msg("transcode limit reached")
+			a.refuseTranscode(w)
+			return
+		}
+		defer release()
+
+		cmd, err := a.transcodeStart(profileModeHTTP, profile, input)
+		if err != nil {
+			logger.Warn().Err(err).Msg("transcode could not be started")
+			w.WriteHeader(transcodeErrorStatus(err))
+			w.Write([]byte(fmt.Sprintf("%v", err)))
+			return
+		}
+
+		utils.ExpandArgs(cmd)
+
 		logger.Info().Msg("command started")
 		w.Header().Set("Content-Type", "video/mp2t")
 
 		read, write := io.Pipe()
 		cmd.Stdout = write
-		cmd.Stderr = utils.LogWriter(logger)
+		cmd.Stderr = io.MultiWriter(utils.LogWriter(logger), a.cmdLog(profileModeHTTP, profile, input))
+
+		w, closeSession := a.session(w, r, profile, input)
+		defer closeSession()
+
+		stream, _ := currentConf().stream(input)
 
-		go utils.IOPipeToHTTP(w, read)
-		cmd.Run()
+		// response must not be written once handler returns
+		copied := make(chan struct{})
+		go func() {
+			utils.IOPipeToHTTP(w, read)
+			close(copied)
+		}()
+
+		a.runTranscode(logger, cmd, a.profileProcessLimits(profileModeHTTP, profile, input, stream))
 		write.Close()
+		<-copied
 		logger.Info().Msg("command stopped")
 	})
 }
+
+// runTranscode runs raw http transcode, that gets limits of its process
+// applied once started.
+func (a *ApiManagerCtx) runTranscode(logger zerolog.Logger, cmd *exec.Cmd, limits utils.ProcessLimits) error {
+	defer a.transcodeExited(cmd)
+
+	if err := cmd.Start(); err != nil {
+		logger.Warn().Err(err).Msg("unable to start command")
+		return err
+	}
+
+	if err := limits.Apply(cmd.Process.Pid); err != nil {
+		logger.Warn().Err(err).Msg("unable to apply process limits")
+	}
+
+	return cmd.Wait()
+}
+
+// httpHead answers HEAD requests of streams without starting transcoding,
+// as streams have no length and would never finish.
+func (a *ApiManagerCtx) httpHead(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if input := chi.URLParam(r, "input"); input != "" {
+			if _, ok := currentConf().stream(input); !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			if err := profileAllowed(chi.URLParam(r, "profile"), input); err != nil {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			if _, err := a.profilePath(profileModeHTTP, chi.URLParam(r, "profile")); err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		}
+
+		if strings.HasSuffix(r.URL.Path, "/mp4") {
+			w.Header().Set("Content-Type", "video/mp4")
+		} else {
+			w.Header().Set("Content-Type", "video/mp2t")
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// transcodeErrorStatus returns http status of failed transcode start.
+func transcodeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrStreamNotFound), errors.Is(err, ErrProfileNotFound), errors.Is(err, ErrVODNotFound), errors.Is(err, ErrSubtitlesNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrProfileNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, ErrInvalidClip), errors.Is(err, ErrInvalidBurnSubtitles):
+		return http.StatusBadRequest
+	case errors.Is(err, hls.ErrTooManyTranscodes):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
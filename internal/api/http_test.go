@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestHeadNotStarting(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Source: "rtsp://camera/stream", Audio: AudioCopy}}})
+
+	// profiles leave marker once run
+	root, started := t.TempDir(), filepath.Join(t.TempDir(), "started")
+	for _, mode := range []string{profileModeHTTP, profileModeHLS} {
+		if err := os.MkdirAll(filepath.Join(root, mode), 0755); err != nil {
+			t.Fatal(err)
+		}
+		script := "#!/bin/sh\ntouch " + started + "\nsleep 10\n"
+		if err := os.WriteFile(filepath.Join(root, mode, "h264_720p.sh"), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := newTestApi()
+	a.config.Profiles = root
+	a.hlsConfig.SegmentDuration = 2
+	defer func() {
+		for _, manager := range a.hlsManagersOf("") {
+			manager.Shutdown()
+		}
+	}()
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	tests := []struct {
+		name        string
+		path        string
+		status      int
+		contentType string
+	}{
+		{"http", "/h264_720p/cam", http.StatusOK, "video/mp2t"},
+		{"http mp4", "/h264_720p/cam/mp4", http.StatusOK, "video/mp4"},
+		{"http buffered", "/h264_720p/cam/buf", http.StatusOK, "video/mp2t"},
+		{"http unknown stream", "/h264_720p/lobby", http.StatusNotFound, ""},
+		{"http unknown profile", "/h264_1080p/cam", http.StatusNotFound, ""},
+		{"hls", "/h264_720p/cam/index.m3u8", http.StatusServiceUnavailable, "application/vnd.apple.mpegurl"},
+		// other routes are not routed to their GET handlers
+		{"snapshot", "/api/streams/cam/snapshot.jpg", http.StatusMethodNotAllowed, ""},
+		// matched as unknown stream of http route instead
+		{"probe", "/api/probe?input=cam", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, tt.path, nil))
+
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("got content type %q, want %q", got, tt.contentType)
+			}
+			if rec.Body.Len() > 0 {
+				t.Errorf("got body %q, want headers only", rec.Body.String())
+			}
+		})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(started); !os.IsNotExist(err) {
+		t.Error("transcode started by HEAD request")
+	}
+
+	if manager, ok := a.hlsManagerLookup("h264_720p", "cam"); ok && manager.Pid() != 0 {
+		t.Error("hls transcode started by HEAD request")
+	}
+}
+
+func TestMP4Stream(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Source: "rtsp://camera/stream", Audio: AudioCopy}}})
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, profileModeHTTP), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// first fragment is written before transcode finishes
+	scripts := map[string]string{
+		"h264_720p": "#!/bin/sh\necho \"$TRANSCODE_HTTP_FORMAT $TRANSCODE_HTTP_FORMAT_OPTIONS\"\nsleep 0.5\necho done\n",
+		"fixed":     "#!/bin/sh\necho fixed\n",
+	}
+	for profile, script := range scripts {
+		if err := os.WriteFile(filepath.Join(root, profileModeHTTP, profile+".sh"), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := newTestApi()
+	a.config.Profiles = root
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	start := time.Now()
+	res, err := http.Get(srv.URL + "/h264_720p/cam/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if got := res.Header.Get("Content-Type"); got != "video/mp4" {
+		t.Errorf("got content type %q, want %q", got, "video/mp4")
+	}
+
+	body := bufio.NewReader(res.Body)
+	line, err := body.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "mp4 -movflags +frag_keyframe+empty_moov+default_base_moof"
+	if got := strings.TrimSpace(line); got != want {
+		t.Errorf("got format %q, want %q", got, want)
+	}
+
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("first fragment received after %v, once transcode finished", elapsed)
+	}
+
+	if rest, _ := io.ReadAll(body); string(rest) != "done\n" {
+		t.Errorf("got rest %q, want %q", rest, "done\n")
+	}
+
+	// profile with fixed output format can not be streamed as mp4
+	res, err = http.Get(srv.URL + "/fixed/cam/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", res.StatusCode, http.StatusInternalServerError)
+	}
+}
+
+func TestSharedUnknownStream(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Source: "rtsp://camera/stream"}}})
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, profileModeHTTP), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, profileModeHTTP, "h264_720p.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	a := newTestApi()
+	a.config.HTTPShared = true
+	a.config.Profiles = root
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/h264_720p/lobby", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if len(a.broadcastManagers) > 0 {
+		t.Errorf("got %d managers, want none for unknown stream", len(a.broadcastManagers))
+	}
+}
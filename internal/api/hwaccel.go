@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+
+	"github.com/m1k1o/go-transcode/hls"
+	"github.com/m1k1o/go-transcode/internal/hwaccel"
+)
+
+// pickEncoder returns h264 encoder of new transcode, that is rejected as
+// too many transcodes when hardware sessions are exhausted.
+func (a *ApiManagerCtx) pickEncoder() (hwaccel.Encoder, error) {
+	encoder, err := a.encoders.Pick()
+	if errors.Is(err, hwaccel.ErrSaturated) {
+		return encoder, fmt.Errorf("%w: %v", hls.ErrTooManyTranscodes, err)
+	}
+	return encoder, err
+}
+
+// holdEncoder keeps session of encoder picked for cmd, until transcodeExited
+// is called for it.
+func (a *ApiManagerCtx) holdEncoder(cmd *exec.Cmd, encoder hwaccel.Encoder) {
+	a.encoderSessionsMu.Lock()
+	defer a.encoderSessionsMu.Unlock()
+
+	a.encoderSessions[cmd] = encoder
+}
+
+// transcodeExited releases hardware encoder session of transcode, once its
+// process exits or it fails to start. Managers call it for every command
+// returned by their factory.
+func (a *ApiManagerCtx) transcodeExited(cmd *exec.Cmd) {
+	a.encoderSessionsMu.Lock()
+	encoder, ok := a.encoderSessions[cmd]
+	delete(a.encoderSessions, cmd)
+	a.encoderSessionsMu.Unlock()
+
+	if ok {
+		a.encoders.Release(encoder)
+	}
+}
+
+// HWAccel serves sessions of hardware encoder devices.
+func (a *ApiManagerCtx) HWAccel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	//nolint
+	json.NewEncoder(w).Encode(a.encoders.Sessions())
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// streamLogLine is line of transcode output of stream.
+type streamLogLine struct {
+	Time    time.Time `json:"time"`
+	Mode    string    `json:"mode"`
+	Profile string    `json:"profile"`
+	Message string    `json:"message"`
+}
+
+// streamLog keeps latest lines of output of all transcodes of stream,
+// optionally appended to rotated file.
+type streamLog struct {
+	mu    sync.Mutex
+	size  int
+	lines []streamLogLine
+	file  *utils.RotatingFile
+}
+
+func (l *streamLog) add(mode string, profile string, message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		l.lines = append(l.lines, streamLogLine{now, mode, profile, line})
+
+		if l.file != nil {
+			if _, err := fmt.Fprintf(l.file, "%s %s/%s %s\n", now.Format(time.RFC3339Nano), mode, profile, line); err != nil {
+				log.Warn().Err(err).Msg("stream log could not be written")
+			}
+		}
+	}
+
+	if len(l.lines) > l.size {
+		l.lines = append([]streamLogLine{}, l.lines[len(l.lines)-l.size:]...)
+	}
+}
+
+// get returns latest lines, oldest first, optionally only of given mode
+// and profile.
+func (l *streamLog) get(mode string, profile string) []streamLogLine {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lines := []streamLogLine{}
+	for _, line := range l.lines {
+		if (mode == "" || line.Mode == mode) && (profile == "" || line.Profile == profile) {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// streamLog returns log of stream or creates new one.
+func (a *ApiManagerCtx) streamLog(input string) *streamLog {
+	a.streamLogsMu.Lock()
+	defer a.streamLogsMu.Unlock()
+
+	l, ok := a.streamLogs[input]
+	if ok {
+		return l
+	}
+
+	l = &streamLog{size: a.config.StreamLogLines}
+	if a.config.StreamLogDir != "" {
+		l.file = utils.NewRotatingFile(
+			filepath.Join(a.config.StreamLogDir, url.PathEscape(input)+".log"),
+			int64(a.config.StreamLogMaxSize)*1024*1024,
+			a.config.StreamLogMaxFiles,
+		)
+	}
+
+	a.streamLogs[input] = l
+	return l
+}
+
+// cmdLog returns writer of transcode output into log of its stream.
+func (a *ApiManagerCtx) cmdLog(mode string, profile string, input string) io.Writer {
+	l := a.streamLog(input)
+	return utils.LogEvent(func(message string) {
+		l.add(mode, profile, message)
+	})
+}
+
+// StreamLogs serves latest transcode output of stream, optionally only of
+// mode and profile given by parameters.
+func (a *ApiManagerCtx) StreamLogs(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if _, ok := currentConf().stream(name); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 stream not found"))
+		return
+	}
+
+	query := r.URL.Query()
+	lines := a.streamLog(name).get(query.Get("mode"), query.Get("profile"))
+
+	if value := query.Get("lines"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid lines"))
+			return
+		}
+
+		if n < len(lines) {
+			lines = lines[len(lines)-n:]
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	//nolint
+	json.NewEncoder(w).Encode(lines)
+}
+
+// closeStreamLogs closes log files of streams.
+func (a *ApiManagerCtx) closeStreamLogs() {
+	a.streamLogsMu.Lock()
+	defer a.streamLogsMu.Unlock()
+
+	for _, l := range a.streamLogs {
+		if l.file != nil {
+			l.file.Close()
+		}
+	}
+}
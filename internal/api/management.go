@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi"
+
+	"github.com/m1k1o/go-transcode/hls"
+)
+
+type managedStream struct {
+	ID      string `json:"id"`
+	Stream  string `json:"stream"`
+	Profile string `json:"profile"`
+	hls.Stats
+}
+
+// streamUsage of all hls profiles of stream.
+type streamUsage struct {
+	Name    string          `json:"name"`
+	Viewers hls.ViewerStats `json:"viewers"`
+	// served bytes summed over profiles, when accounting is enabled
+	Bandwidth *hls.BandwidthStats `json:"bandwidth,omitempty"`
+}
+
+// Management lists hls managers and controls their lifecycle.
+func (a *ApiManagerCtx) Management(r chi.Router) {
+	r.Get("/api/probe", a.Probe)
+	r.Get("/api/hwaccel", a.HWAccel)
+
+	r.Get("/api/streams", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint
+		json.NewEncoder(w).Encode(a.managedStreams(""))
+	})
+
+	r.Get("/api/streams/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		if _, ok := currentConf().stream(name); !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 stream not found"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint
+		json.NewEncoder(w).Encode(a.managedStreams(name))
+	})
+
+	r.Get("/api/streams/{name}/stats", func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		if _, ok := currentConf().stream(name); !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 stream not found"))
+			return
+		}
+
+		usage := streamUsage{
+			Name:      name,
+			Bandwidth: a.streamStats(name).Bandwidth,
+		}
+
+		a.hlsMu.Lock()
+		usage.Viewers = a.streamViewers(name).Stats()
+		a.hlsMu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint
+		json.NewEncoder(w).Encode(usage)
+	})
+
+	r.Get("/api/streams/{name}/recordings", a.Recordings)
+
+	r.Get("/api/streams/{name}/logs", a.StreamLogs)
+
+	// live previews of dashboards
+	r.Get("/api/streams/{name}/snapshot.jpg", a.LiveSnapshot)
+
+	r.Post("/api/streams/{name}/{profile}/stop", a.managedStop)
+}
+
+// ManagementStart starts hls transcodes, requests wait for source to be
+// probed and transcode to be started, so they are not bound by request
+// timeout.
+func (a *ApiManagerCtx) ManagementStart(r chi.Router) {
+	r.Post("/api/streams/{name}/{profile}/start", a.managedStart)
+	r.Post("/api/streams/{name}/{profile}/restart", a.managedRestart)
+	r.Post("/streams/{name}/{profile}/start", a.explicitStart)
+}
+
+// managedStreams returns managers of given stream, or of all streams
+// when name is empty, sorted by their id.
+func (a *ApiManagerCtx) managedStreams(name string) []managedStream {
+	res := []managedStream{}
+	for id, manager := range a.hlsManagersOf(name) {
+		profile, input := splitManagerID(id)
+
+		res = append(res, managedStream{
+			ID:      id,
+			Stream:  input,
+			Profile: profile,
+			Stats:   manager.Stats(),
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+
+	return res
+}
+
+// managedStream returns manager of requested stream and profile, it is
+// created when requested. Otherwise only existing one is returned.
+func (a *ApiManagerCtx) managedStream(w http.ResponseWriter, r *http.Request, create bool) (hls.Manager, bool) {
+	name, profile := chi.URLParam(r, "name"), chi.URLParam(r, "profile")
+	if _, ok := currentConf().stream(name); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 stream not found"))
+		return nil, false
+	}
+
+	if !create {
+		manager, ok := a.hlsManagerLookup(profile, name)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 transcode not found"))
+		}
+		return manager, ok
+	}
+
+	if err := profileAllowed(profile, name); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 " + err.Error()))
+		return nil, false
+	}
+
+	if _, err := a.profilePath(profileModeHLS, profile); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return nil, false
+	}
+
+	return a.hlsManager(profile, name), true
+}
+
+// managedStart starts requested transcode, unless it is running.
+func (a *ApiManagerCtx) managedStart(w http.ResponseWriter, r *http.Request) {
+	manager, ok := a.managedStream(w, r, true)
+	if !ok {
+		return
+	}
+
+	if manager.Pid() == 0 {
+		a.warmProbe(r.Context(), profileModeHLS, chi.URLParam(r, "profile"), chi.URLParam(r, "name"))
+	}
+
+	err := manager.Start()
+	if err != nil && !errors.Is(err, hls.ErrAlreadyStarted) {
+		w.WriteHeader(managementErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// managedStop stops requested transcode.
+func (a *ApiManagerCtx) managedStop(w http.ResponseWriter, r *http.Request) {
+	manager, ok := a.managedStream(w, r, false)
+	if !ok {
+		return
+	}
+
+	manager.Stop()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// managedRestart restarts requested transcode, continuing its playlist.
+func (a *ApiManagerCtx) managedRestart(w http.ResponseWriter, r *http.Request) {
+	manager, ok := a.managedStream(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := manager.Restart(); err != nil {
+		w.WriteHeader(managementErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// managementErrorStatus returns http status of failed start or restart.
+func managementErrorStatus(err error) int {
+	if errors.Is(err, hls.ErrCircuitOpen) || errors.Is(err, hls.ErrTooManyTranscodes) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type metric struct {
+	name string
+	kind string
+	help string
+	// value of managed stream, false when not available
+	value func(s managedStream) (float64, bool)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var metrics = []metric{
+	{"transcode_running", "gauge", "Whether transcode process is running.", func(s managedStream) (float64, bool) {
+		return boolValue(s.Running), true
+	}},
+	{"transcode_active", "gauge", "Whether transcode is running and serving segments.", func(s managedStream) (float64, bool) {
+		return boolValue(s.Active), true
+	}},
+	{"transcode_uptime_seconds", "gauge", "Seconds since transcode was started.", func(s managedStream) (float64, bool) {
+		return s.Uptime, s.Running
+	}},
+	{"transcode_last_update_timestamp_seconds", "gauge", "Time of last playlist update of running transcode.", func(s managedStream) (float64, bool) {
+		if s.LastUpdate == nil {
+			return 0, false
+		}
+		return float64(s.LastUpdate.UnixNano()) / float64(time.Second), true
+	}},
+	{"transcode_segments_total", "counter", "Playlist updates, each announcing new segment.", func(s managedStream) (float64, bool) {
+		return float64(s.Segments), true
+	}},
+	{"transcode_playlist_requests_total", "counter", "Playlist requests, availability checks excluded.", func(s managedStream) (float64, bool) {
+		return float64(s.PlaylistRequests), true
+	}},
+	{"transcode_restarts_total", "counter", "Transcode restarts, e.g. by watchdog or output switch.", func(s managedStream) (float64, bool) {
+		return float64(s.Restarts), true
+	}},
+	{"transcode_served_bytes_total", "counter", "Bytes of playlists and segments written to clients.", func(s managedStream) (float64, bool) {
+		if s.Bandwidth == nil {
+			return 0, false
+		}
+		return float64(s.Bandwidth.Total), true
+	}},
+	{"transcode_cpu_seconds_total", "counter", "Cpu time consumed by transcode processes.", func(s managedStream) (float64, bool) {
+		return s.CPUSeconds, true
+	}},
+	{"transcode_speed_ratio", "gauge", "Encoding speed relative to realtime reported by running transcode, it falls behind below 1.", func(s managedStream) (float64, bool) {
+		if s.Progress == nil {
+			return 0, false
+		}
+		return s.Progress.Speed, true
+	}},
+	{"transcode_fps", "gauge", "Frames per second encoded by running transcode.", func(s managedStream) (float64, bool) {
+		if s.Progress == nil {
+			return 0, false
+		}
+		return s.Progress.FPS, true
+	}},
+	{"transcode_output_bitrate_bits_per_second", "gauge", "Output bitrate reported by running transcode.", func(s managedStream) (float64, bool) {
+		if s.Progress == nil {
+			return 0, false
+		}
+		return s.Progress.Bitrate * 1000, true
+	}},
+	{"transcode_dropped_frames", "gauge", "Frames dropped by running transcode.", func(s managedStream) (float64, bool) {
+		if s.Progress == nil {
+			return 0, false
+		}
+		return float64(s.Progress.DropFrames), true
+	}},
+	{"transcode_duplicated_frames", "gauge", "Frames duplicated by running transcode.", func(s managedStream) (float64, bool) {
+		if s.Progress == nil {
+			return 0, false
+		}
+		return float64(s.Progress.DupFrames), true
+	}},
+	{"transcode_tempdir_bytes", "gauge", "Size of tempdir of running transcode.", func(s managedStream) (float64, bool) {
+		return float64(s.TempDirSize), s.Running
+	}},
+}
+
+var metricLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Metrics reports hls transcodes in prometheus text format.
+func (a *ApiManagerCtx) Metrics(w http.ResponseWriter, r *http.Request) {
+	streams := a.managedStreams("")
+
+	var b strings.Builder
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+		for _, s := range streams {
+			value, ok := m.value(s)
+			if !ok {
+				continue
+			}
+
+			fmt.Fprintf(&b, "%s{stream=\"%s\",profile=\"%s\"} %s\n", m.name,
+				metricLabelReplacer.Replace(s.Stream), metricLabelReplacer.Replace(s.Profile),
+				strconv.FormatFloat(value, 'g', -1, 64))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
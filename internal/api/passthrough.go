@@ -0,0 +1,59 @@
+package api
+
+import (
+	"github.com/rs/zerolog/log"
+)
+
+// profile of every mode remuxing source without transcoding
+const passthroughProfile = "copy"
+
+// passthroughPath returns copy profile of mode, when passthrough is enabled
+// for stream and its source can be remuxed instead of being transcoded by
+// requested profile.
+func (a *ApiManagerCtx) passthroughPath(mode string, profilePath string, stream StreamConf, ingested bool, clip *clipRange) (string, bool) {
+	enabled := a.config.Passthrough
+	if stream.Passthrough != nil {
+		enabled = *stream.Passthrough
+	}
+
+	// ingested sources can not be probed beforehand
+	if !enabled || ingested || stream.Audio == AudioTranscode {
+		return "", false
+	}
+
+	switch mode {
+	case profileModeHTTP, profileModeDASH:
+	case profileModeHLS:
+		// low latency and abr playlists are not produced by copy profile
+		if a.hlsConfig.LowLatency || profileIsABR(profilePath) {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+
+	// filters and clips need decoded video
+	options := profileOptions{
+		Deinterlace:    stream.Deinterlace,
+		ScaleAlgorithm: stream.ScaleAlgorithm,
+		Sharpen:        stream.Sharpen,
+		Clip:           clip,
+	}
+	if len(options.videoFilterOptions()) > 0 {
+		return "", false
+	}
+
+	copyPath, err := a.profilePath(mode, passthroughProfile)
+	if err != nil || copyPath == profilePath {
+		return "", false
+	}
+
+	probe, err := a.probeCmd(stream.Source)
+	if err != nil {
+		log.Warn().Err(err).Str("source", stream.Source).Msg("unable to probe source, transcoding")
+		return "", false
+	}
+
+	// copy profiles map audio of source
+	return copyPath, sourceRemuxable(probe) && probe.Codec("audio") != ""
+}
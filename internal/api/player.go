@@ -0,0 +1,113 @@
+package api
+
+import (
+	_ "embed"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+)
+
+// playerPage plays hls stream in browser using hls.js, so that streams can
+// be verified without external frontend
+//
+//go:embed player.html
+var playerPage string
+
+var playerTemplate = template.Must(template.New("player").Parse(playerPage))
+
+// player profile, unless requested or not allowed for stream
+const playerDefaultProfile = "h264_720p"
+
+type playerData struct {
+	Stream   string
+	Profile  string
+	Profiles []string
+}
+
+func (a *ApiManagerCtx) Player(r chi.Router) {
+	r.Get("/player/{input}", func(w http.ResponseWriter, r *http.Request) {
+		input := chi.URLParam(r, "input")
+
+		stream, ok := currentConf().stream(input)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 stream not found"))
+			return
+		}
+
+		profiles, err := a.hlsProfiles()
+		if err != nil {
+			log.Warn().Err(err).Msg("hls profiles could not be listed")
+		}
+
+		allowed := []string{}
+		for _, profile := range profiles {
+			if stream.allows(profile) {
+				allowed = append(allowed, profile)
+			}
+		}
+
+		data := playerData{
+			Stream:   input,
+			Profiles: allowed,
+		}
+
+		for _, profile := range allowed {
+			if profile == r.URL.Query().Get("profile") {
+				data.Profile = profile
+				break
+			}
+			if profile == playerDefaultProfile || data.Profile == "" {
+				data.Profile = profile
+			}
+		}
+
+		if data.Profile == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 no hls profile available for stream"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := playerTemplate.Execute(w, data); err != nil {
+			log.Warn().Err(err).Msg("player could not be rendered")
+		}
+	})
+}
+
+// hlsProfiles returns names of hls profiles, sorted.
+func (a *ApiManagerCtx) hlsProfiles() ([]string, error) {
+	if !a.ProfilesAvailable() {
+		return nil, ErrProfilesUnavailable
+	}
+
+	files, err := ioutil.ReadDir(path.Join(a.config.Profiles, profileModeHLS))
+	if err != nil {
+		return nil, err
+	}
+
+	// profile can have both script and yaml
+	found := map[string]bool{}
+	for _, file := range files {
+		for _, ext := range []string{yamlProfileExt, yamlProfileTemplateExt, ".sh"} {
+			profile := strings.TrimSuffix(file.Name(), ext)
+			if profile != file.Name() && profileNameRegex.MatchString(profile) {
+				found[profile] = true
+			}
+		}
+	}
+
+	profiles := []string{}
+	for profile := range found {
+		profiles = append(profiles, profile)
+	}
+
+	sort.Strings(profiles)
+	return profiles, nil
+}
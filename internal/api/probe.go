@@ -0,0 +1,250 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/m1k1o/go-transcode/internal/ffprobe"
+)
+
+// how long are probe results of source reused, e.g. by audio codec and
+// video dimensions needed by the same transcode start
+const probeCacheTTL = 30 * time.Second
+
+// how long are expired probe results still reused by transcode commands,
+// that are built while their managers hold locks
+const probeStaleTTL = 10 * time.Minute
+
+type probeCacheEntry struct {
+	result  *ffprobe.Result
+	expires time.Time
+	stale   time.Time
+}
+
+// probeInfo is source summary served by probe endpoint.
+type probeInfo struct {
+	ffprobe.Info
+	// h264 video and aac audio, that can be remuxed without transcoding
+	Remux bool `json:"remux"`
+}
+
+// Probe serves summary of stream source, e.g. ?input=<stream-id>.
+func (a *ApiManagerCtx) Probe(w http.ResponseWriter, r *http.Request) {
+	input := r.URL.Query().Get("input")
+	stream, ok := currentConf().stream(input)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 stream not found"))
+		return
+	}
+
+	if stream.ingestPath(input) != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 ingested streams can not be probed"))
+		return
+	}
+
+	result, err := a.probeSource(r.Context(), stream.Source)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	//nolint
+	json.NewEncoder(w).Encode(probeInfo{
+		Info:  result.Info(),
+		Remux: sourceRemuxable(result),
+	})
+}
+
+// probeAvailable probes source using helper slot, bypassing cached results,
+// so that it reports whether source can be opened right now.
+func (a *ApiManagerCtx) probeAvailable(ctx context.Context, source string) error {
+	release, err := a.acquireHelper(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, err = ffprobe.Probe(ctx, source)
+	return err
+}
+
+// probeCall is probe of source in progress, shared by concurrent callers.
+type probeCall struct {
+	done   chan struct{}
+	result *ffprobe.Result
+	err    error
+}
+
+// probeSource probes source using helper slot, its result is reused for
+// a while. Failed probes are not reused, concurrent probes of the same
+// source are coalesced.
+func (a *ApiManagerCtx) probeSource(ctx context.Context, source string) (*ffprobe.Result, error) {
+	for {
+		now := time.Now()
+
+		a.probesMu.Lock()
+		entry, ok := a.probes[source]
+		if ok && now.Before(entry.expires) {
+			a.probesMu.Unlock()
+			return entry.result, nil
+		}
+
+		call, pending := a.probing[source]
+		if !pending {
+			call = &probeCall{done: make(chan struct{})}
+			a.probing[source] = call
+		}
+		a.probesMu.Unlock()
+
+		if !pending {
+			call.result, call.err = a.probeLeader(ctx, source, now)
+
+			a.probesMu.Lock()
+			delete(a.probing, source)
+			a.probesMu.Unlock()
+			close(call.done)
+
+			return call.result, call.err
+		}
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// probe was abandoned by its caller, not by source
+		if errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded) {
+			if ctx.Err() == nil {
+				continue
+			}
+		}
+
+		return call.result, call.err
+	}
+}
+
+// probeLeader probes source on behalf of all callers waiting for it, and
+// caches successful result.
+func (a *ApiManagerCtx) probeLeader(ctx context.Context, source string, now time.Time) (*ffprobe.Result, error) {
+	release, err := a.acquireHelper(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	result, err := ffprobe.Probe(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	a.probesMu.Lock()
+	for key, entry := range a.probes {
+		if now.After(entry.stale) {
+			delete(a.probes, key)
+		}
+	}
+	a.probes[source] = probeCacheEntry{result, now.Add(probeCacheTTL), now.Add(probeStaleTTL)}
+	a.probesMu.Unlock()
+
+	return result, nil
+}
+
+// probeCmd returns probe result of source for transcode command. Commands
+// are built while managers hold their locks, so results probed before
+// are reused even once expired, and source is probed only when missing.
+func (a *ApiManagerCtx) probeCmd(source string) (*ffprobe.Result, error) {
+	a.probesMu.Lock()
+	entry, ok := a.probes[source]
+	a.probesMu.Unlock()
+
+	if ok && time.Now().Before(entry.stale) {
+		return entry.result, nil
+	}
+
+	return a.probeSource(context.Background(), source)
+}
+
+// warmProbe probes source of stream before its transcode is started, bound
+// by request context, unless transcode command can reuse previous result
+// or does not depend on it. Callers warm only transcodes that are not
+// running yet, and not on HEAD requests.
+func (a *ApiManagerCtx) warmProbe(ctx context.Context, mode string, profile string, input string) {
+	stream, ok := currentConf().stream(input)
+	if !ok || stream.Source == "" || stream.ingestPath(input) != "" {
+		return
+	}
+
+	if !a.streamNeedsProbe(mode, profile, stream) {
+		return
+	}
+
+	a.probesMu.Lock()
+	entry, ok := a.probes[stream.Source]
+	a.probesMu.Unlock()
+
+	if ok && time.Now().Before(entry.stale) {
+		return
+	}
+
+	// failures are reported once transcode is started
+	//nolint
+	a.probeSource(ctx, stream.Source)
+}
+
+// streamNeedsProbe reports whether transcode command of stream depends
+// on probe of its source, deciding audio codec, passthrough, remux or
+// templated profile.
+func (a *ApiManagerCtx) streamNeedsProbe(mode string, profile string, stream StreamConf) bool {
+	if stream.Audio == "" || stream.Audio == AudioAuto {
+		return true
+	}
+
+	passthrough := a.config.Passthrough
+	if stream.Passthrough != nil {
+		passthrough = *stream.Passthrough
+	}
+
+	if passthrough && stream.Audio != AudioTranscode {
+		return true
+	}
+
+	profilePath, err := a.profilePath(mode, profile)
+	if err != nil || !isYAMLProfile(profilePath) {
+		return false
+	}
+
+	if strings.HasSuffix(profilePath, yamlProfileTemplateExt) {
+		return true
+	}
+
+	declared, err := loadYAMLProfile(profilePath)
+	return err == nil && declared.Video.Remux
+}
+
+// sourceRemuxable reports whether source fits all profiles, so that its
+// video and audio can be copied.
+func sourceRemuxable(result *ffprobe.Result) bool {
+	audio := result.Codec("audio")
+	return result.Codec("video") == "h264" && (audio == "" || audio == profileAudioCodec)
+}
+
+// sourceFitsVideo reports whether source video can be copied instead of
+// being transcoded by profile, when it is h264 not exceeding profile size.
+func sourceFitsVideo(result *ffprobe.Result, v yamlProfileVideo) bool {
+	if result.Codec("video") != v.Codec {
+		return false
+	}
+
+	width, height := result.Dimensions()
+	return v.Width == 0 || width > 0 && width <= v.Width && height <= v.Height
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m1k1o/go-transcode/internal/ffprobe"
+)
+
+// cacheProbe stores probe result of source having streams of given codecs.
+func (a *ApiManagerCtx) cacheProbe(source string, codecs map[string]string) {
+	result := &ffprobe.Result{}
+	for codecType, codecName := range codecs {
+		result.Streams = append(result.Streams, ffprobe.Stream{CodecType: codecType, CodecName: codecName})
+	}
+
+	now := time.Now()
+	a.probes[source] = probeCacheEntry{result, now.Add(probeCacheTTL), now.Add(probeStaleTTL)}
+}
+
+func TestStreamAudioCodec(t *testing.T) {
+	tests := []struct {
+		name  string
+		audio string
+		codec string
+		want  string
+	}{
+		{"aac source", AudioAuto, "aac", "-c:a copy"},
+		{"ac3 source", AudioAuto, "ac3", "-c:a aac"},
+		{"default is auto", "", "aac", "-c:a copy"},
+		{"forced copy", AudioCopy, "ac3", "-c:a copy"},
+		{"forced transcode", AudioTranscode, "aac", "-c:a aac"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApi()
+			a.cacheProbe("rtsp://source", map[string]string{"video": "h264", "audio": tt.codec})
+
+			options := a.streamProfileOptions(StreamConf{Source: "rtsp://source", Audio: tt.audio})
+			if got := strings.Join(yamlAudioArgs(yamlProfileAudio{}, options), " "); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeSourceCoalesced(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+
+	// fake ffprobe counting its calls, slow enough for callers to overlap
+	script := "#!/bin/sh\necho >> " + calls + "\nsleep 0.2\necho '{\"streams\":[{\"codec_type\":\"audio\",\"codec_name\":\"aac\"}]}'\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffprobe"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	a := newTestApi()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result, err := a.probeSource(context.Background(), "rtsp://source")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if codec := result.Codec("audio"); codec != "aac" {
+				t.Errorf("got audio codec %q, want aac", codec)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 1 {
+		t.Errorf("source probed %d times, want once", n)
+	}
+}
+
+func TestStreamNeedsProbe(t *testing.T) {
+	a := newTestApi()
+	passthrough := true
+
+	tests := []struct {
+		name   string
+		stream StreamConf
+		want   bool
+	}{
+		{"audio auto", StreamConf{Audio: AudioAuto}, true},
+		{"audio default", StreamConf{}, true},
+		{"audio copy", StreamConf{Audio: AudioCopy}, false},
+		{"audio transcode", StreamConf{Audio: AudioTranscode}, false},
+		{"passthrough", StreamConf{Audio: AudioCopy, Passthrough: &passthrough}, true},
+		{"passthrough with audio transcode", StreamConf{Audio: AudioTranscode, Passthrough: &passthrough}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.streamNeedsProbe(profileModeHLS, "missing", tt.stream); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/vod"
+)
+
+// audio codec produced by profiles when transcoding
+const profileAudioCodec = "aac"
+
+// profile modes, each has its own subdirectory of profiles root
+const (
+	profileModeHTTP = "http"
+	profileModeHLS  = "hls"
+	profileModeDASH = "dash"
+	profileModeWHEP = "webrtc"
+	profileModeVOD  = "vod"
+)
+
+var ErrProfileNotFound = errors.New("profile not found")
+
+var ErrProfileNotAllowed = errors.New("profile not allowed")
+
+var profileNameRegex = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+// profilePath resolves declarative profile as <root>/<mode>/<profile>.yaml,
+// its template as <root>/<mode>/<profile>.yaml.tmpl, or profile script as
+// <root>/<mode>/<profile>.sh. With merged roots, profile missing for mode
+// is resolved in <root> the same way.
+func (a *ApiManagerCtx) profilePath(mode string, profile string) (string, error) {
+	if !profileNameRegex.MatchString(profile) {
+		return "", fmt.Errorf("invalid profile path")
+	}
+
+	if !a.ProfilesAvailable() {
+		return "", ErrProfilesUnavailable
+	}
+
+	bases := []string{path.Join(a.config.Profiles, mode, profile)}
+	if a.config.ProfilesMerge {
+		bases = append(bases, path.Join(a.config.Profiles, profile))
+	}
+
+	for _, base := range bases {
+		for _, ext := range []string{yamlProfileExt, yamlProfileTemplateExt, ".sh"} {
+			_, err := os.Stat(base + ext)
+			if err == nil {
+				return base + ext, nil
+			}
+			if !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s profile %q does not exist at %s{%s,%s,.sh}", ErrProfileNotFound, mode, profile, bases[0], yamlProfileExt, yamlProfileTemplateExt)
+}
+
+// profileAllowed returns error if profile is disabled for stream.
+func profileAllowed(profile string, input string) error {
+	stream, ok := currentConf().stream(input)
+	if ok && !stream.allows(profile) {
+		return fmt.Errorf("%w: profile %q is not allowed for stream %q", ErrProfileNotAllowed, profile, input)
+	}
+	return nil
+}
+
+// profileOptions are passed to profile scripts as environment variables.
+type profileOptions struct {
+	// audio codec, or copy for passthrough
+	AudioCodec string
+	// hls partial segment duration in seconds
+	PartDuration float64
+	// deinterlace mode, empty keeps profile default
+	Deinterlace string
+	// ffmpeg options preceding source input
+	InputOptions []string
+	// scaler algorithm, e.g. lanczos, and post-scale sharpening amount
+	ScaleAlgorithm string
+	Sharpen        float64
+	// audio track of source, from zero, and whether video is dropped
+	AudioTrack int
+	AudioOnly  bool
+	// subtitles track hardcoded into video, nil when none
+	BurnSubtitles *burnSubtitles
+	// bounded output of vod source
+	Clip *clipRange
+	// segments of vod file, transcoded from their start
+	VOD *vod.Output
+}
+
+// videoFilterOptions returns names of set options that require
+// video to be decoded and filtered.
+func (o profileOptions) videoFilterOptions() []string {
+	names := []string{}
+	if o.Deinterlace == DeinterlaceOn || o.Deinterlace == DeinterlaceAuto {
+		names = append(names, "deinterlace")
+	}
+	if o.ScaleAlgorithm != "" {
+		names = append(names, "scale_algorithm")
+	}
+	if o.Sharpen > 0 {
+		names = append(names, "sharpen")
+	}
+	if o.Clip != nil {
+		// copied video could be cut only at keyframes
+		names = append(names, "clip")
+	}
+	if o.BurnSubtitles != nil {
+		names = append(names, "burnsubs")
+	}
+	return names
+}
+
+// inputOptions returns ffmpeg options preceding source input.
+func (o profileOptions) inputOptions() []string {
+	if o.Clip == nil && o.VOD == nil {
+		return o.InputOptions
+	}
+
+	options := append([]string{}, o.InputOptions...)
+	if o.Clip != nil {
+		options = append(options, o.Clip.inputOptions()...)
+	}
+	if o.VOD != nil {
+		options = append(options, "-ss", formatClipTime(o.VOD.Start))
+	}
+	return options
+}
+
+// seek returns position of source, where transcode starts.
+func (o profileOptions) seek() time.Duration {
+	switch {
+	case o.Clip != nil:
+		return o.Clip.Start
+	case o.VOD != nil:
+		return o.VOD.Start
+	}
+	return 0
+}
+
+// videoMap returns stream specifier of video, or output of complex
+// filtergraph overlaying bitmap subtitles.
+func (o profileOptions) videoMap() string {
+	if o.BurnSubtitles != nil && o.BurnSubtitles.Bitmap {
+		return "[" + burnedVideoLabel + "]"
+	}
+	return "0:v:0"
+}
+
+// audioMap returns stream specifier of audio track.
+func (o profileOptions) audioMap() string {
+	return "0:a:" + strconv.Itoa(o.AudioTrack)
+}
+
+// sharpenFilter returns post-scale sharpening filter, or empty string.
+func (o profileOptions) sharpenFilter() string {
+	if o.Sharpen <= 0 {
+		return ""
+	}
+	return "unsharp=5:5:" + strconv.FormatFloat(o.Sharpen, 'f', -1, 64)
+}
+
+// deinterlaceFilters are software deinterlacing filters by mode
+var deinterlaceFilters = map[string]string{
+	DeinterlaceOn: "yadif",
+	// idet marks detected interlaced frames, only those are deinterlaced
+	DeinterlaceAuto: "idet,yadif=deint=interlaced",
+}
+
+func (o profileOptions) env() []string {
+	env := []string{
+		"TRANSCODE_AUDIO_CODEC=" + o.AudioCodec,
+	}
+
+	if o.AudioTrack > 0 {
+		env = append(env, "TRANSCODE_AUDIO_MAP="+o.audioMap())
+	}
+
+	if o.AudioOnly {
+		env = append(env, audioOnlyEnv)
+	}
+
+	if o.PartDuration > 0 {
+		// fragment duration of mp4 muxer is in microseconds
+		env = append(env, "TRANSCODE_HLS_PART_DURATION_US="+strconv.Itoa(int(o.PartDuration*1e6)))
+	}
+
+	if inputOptions := o.inputOptions(); len(inputOptions) > 0 {
+		env = append(env, "TRANSCODE_INPUT_OPTIONS="+strings.Join(inputOptions, " "))
+	}
+
+	if o.ScaleAlgorithm != "" {
+		env = append(env, "TRANSCODE_SCALE_FLAGS="+o.ScaleAlgorithm)
+	}
+
+	if filter := o.sharpenFilter(); filter != "" {
+		env = append(env, "TRANSCODE_SHARPEN_FILTER="+filter)
+	}
+
+	if o.BurnSubtitles != nil && !o.BurnSubtitles.Bitmap {
+		env = append(env, "TRANSCODE_SUBTITLES_FILTER="+o.BurnSubtitles.filter(o.seek()))
+	}
+
+	switch o.Deinterlace {
+	case DeinterlaceOff:
+		env = append(env,
+			"TRANSCODE_DEINTERLACE_FILTER=",
+			"TRANSCODE_DEINTERLACE_CUDA_FILTER=hwupload_cuda",
+		)
+	case DeinterlaceOn:
+		env = append(env,
+			"TRANSCODE_DEINTERLACE_FILTER="+deinterlaceFilters[DeinterlaceOn],
+			"TRANSCODE_DEINTERLACE_CUDA_FILTER=hwupload_cuda,yadif_cuda=0:-1:0",
+		)
+	case DeinterlaceAuto:
+		env = append(env,
+			"TRANSCODE_DEINTERLACE_FILTER="+deinterlaceFilters[DeinterlaceAuto],
+			"TRANSCODE_DEINTERLACE_CUDA_FILTER=idet,hwupload_cuda,yadif_cuda=0:-1:1",
+		)
+	}
+
+	if o.VOD != nil {
+		env = append(env,
+			"TRANSCODE_VOD_START="+formatClipTime(o.VOD.Start),
+			"TRANSCODE_VOD_START_NUMBER="+strconv.Itoa(o.VOD.StartNumber),
+			"TRANSCODE_VOD_SEGMENT_DURATION="+formatClipTime(o.VOD.SegmentDuration),
+			"TRANSCODE_VOD_SEGMENT_FILENAME="+o.VOD.SegmentFilename,
+			"TRANSCODE_VOD_PLAYLIST="+o.VOD.Playlist,
+		)
+	}
+
+	return env
+}
+
+func (a *ApiManagerCtx) streamProfileOptions(stream StreamConf) profileOptions {
+	return profileOptions{
+		AudioCodec:     a.streamAudioCodec(stream),
+		Deinterlace:    stream.Deinterlace,
+		InputOptions:   stream.inputOptions(),
+		ScaleAlgorithm: stream.ScaleAlgorithm,
+		Sharpen:        stream.Sharpen,
+	}
+}
+
+// fragmented mp4 can be played while being downloaded, moov box is
+// empty and first, so faststart (requiring seekable output) is not needed
+var mp4StreamEnv = []string{
+	"TRANSCODE_HTTP_FORMAT=mp4",
+	"TRANSCODE_HTTP_FORMAT_OPTIONS=-movflags +frag_keyframe+empty_moov+default_base_moof",
+}
+
+// profileSupportsFormat reports whether http profile allows its output
+// format to be overridden.
+func profileSupportsFormat(profilePath string) bool {
+	if isYAMLProfile(profilePath) {
+		return true
+	}
+
+	script, err := os.ReadFile(profilePath)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Contains(script, []byte("TRANSCODE_HTTP_FORMAT"))
+}
+
+// profileIsABR reports whether hls profile transcodes abr ladder, writing
+// master playlist into its working directory.
+func profileIsABR(profilePath string) bool {
+	script, err := os.ReadFile(profilePath)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Contains(script, []byte("-master_pl_name"))
+}
+
+// profileEncodesH264 reports whether profile leaves choice of h264 encoder
+// to server, using hardware encoder when available.
+func profileEncodesH264(profilePath string) bool {
+	script, err := os.ReadFile(profilePath)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Contains(script, []byte("TRANSCODE_H264_ENCODER"))
+}
+
+var copyVideoRegex = regexp.MustCompile(`-(c:v|codec:v|vcodec)\s+"?copy\b`)
+
+// profileCopiesVideo reports whether profile passes video through without
+// decoding, so that no video filters can be applied.
+func profileCopiesVideo(profilePath string) bool {
+	script, err := os.ReadFile(profilePath)
+	if err != nil {
+		return false
+	}
+
+	return copyVideoRegex.Match(script)
+}
+
+// streamAudioCodec decides whether source audio can be copied.
+func (a *ApiManagerCtx) streamAudioCodec(stream StreamConf) string {
+	switch stream.Audio {
+	case AudioCopy:
+		return "copy"
+	case AudioTranscode:
+		return profileAudioCodec
+	}
+
+	probe, err := a.probeCmd(stream.Source)
+	if err != nil {
+		log.Warn().Err(err).Str("source", stream.Source).Msg("unable to probe audio codec, transcoding")
+		return profileAudioCodec
+	}
+
+	if probe.Codec("audio") == profileAudioCodec {
+		return "copy"
+	}
+
+	return profileAudioCodec
+}
@@ -0,0 +1,342 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+// testEnv returns value of variable in env, and whether it is set.
+func testEnv(env []string, key string) (string, bool) {
+	for _, e := range env {
+		if strings.HasPrefix(e, key+"=") {
+			return strings.TrimPrefix(e, key+"="), true
+		}
+	}
+	return "", false
+}
+
+func TestDeinterlaceEnv(t *testing.T) {
+	tests := []struct {
+		mode   string
+		filter string
+		cuda   string
+		set    bool
+	}{
+		// profile default is kept
+		{"", "", "", false},
+		{DeinterlaceOff, "", "hwupload_cuda", true},
+		{DeinterlaceOn, "yadif", "hwupload_cuda,yadif_cuda=0:-1:0", true},
+		{DeinterlaceAuto, "idet,yadif=deint=interlaced", "idet,hwupload_cuda,yadif_cuda=0:-1:1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			env := profileOptions{Deinterlace: tt.mode}.env()
+
+			filter, ok := testEnv(env, "TRANSCODE_DEINTERLACE_FILTER")
+			if filter != tt.filter || ok != tt.set {
+				t.Errorf("got filter %q (set %v), want %q (set %v)", filter, ok, tt.filter, tt.set)
+			}
+
+			cuda, ok := testEnv(env, "TRANSCODE_DEINTERLACE_CUDA_FILTER")
+			if cuda != tt.cuda || ok != tt.set {
+				t.Errorf("got cuda filter %q (set %v), want %q (set %v)", cuda, ok, tt.cuda, tt.set)
+			}
+		})
+	}
+}
+
+func TestDeinterlaceFilterOption(t *testing.T) {
+	for mode, want := range map[string]bool{
+		"":              false,
+		DeinterlaceOff:  false,
+		DeinterlaceOn:   true,
+		DeinterlaceAuto: true,
+	} {
+		names := profileOptions{Deinterlace: mode}.videoFilterOptions()
+		if got := len(names) == 1 && names[0] == "deinterlace"; got != want {
+			t.Errorf("%q: got video filter options %v", mode, names)
+		}
+	}
+}
+
+func TestDeinterlaceValidate(t *testing.T) {
+	for _, mode := range []string{"", DeinterlaceAuto, DeinterlaceOn, DeinterlaceOff} {
+		stream := StreamConf{Source: "rtsp://camera/stream", Deinterlace: mode}
+		if err := stream.validate(); err != nil {
+			t.Errorf("%q: unexpected error: %v", mode, err)
+		}
+	}
+
+	stream := StreamConf{Source: "rtsp://camera/stream", Deinterlace: "yes"}
+	if err := stream.validate(); err == nil {
+		t.Error("expected error for unknown deinterlace mode")
+	}
+}
+
+func TestSourceInputOptions(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{
+		"web":   {Source: "http://camera/stream", Audio: AudioCopy, Reconnect: true, ReconnectDelayMax: 5},
+		"flaky": {Source: "http://camera/stream", Audio: AudioCopy},
+		"cam": {
+			Source:         "rtsp://camera/stream",
+			Audio:          AudioCopy,
+			RTSPTransport:  "tcp",
+			FallbackSource: "rtsp://fallback/stream",
+			BackupSources:  []string{"rtsp://backup/stream", "http://backup/stream"},
+		},
+	}})
+
+	a := newTestApi()
+	a.config.Profiles = testProfiles(t, "h264_720p")
+
+	tests := []struct {
+		name  string
+		start func() (*exec.Cmd, error)
+		want  string
+	}{
+		{"http reconnect", func() (*exec.Cmd, error) {
+			return a.transcodeStart(profileModeHLS, "h264_720p", "web")
+		}, "-reconnect 1 -reconnect_streamed 1 -reconnect_delay_max 5"},
+		{"http restarted reconnecting", func() (*exec.Cmd, error) {
+			return a.transcodeReconnectStart(profileModeHLS, "h264_720p", "flaky", hlsTrack{})
+		}, "-reconnect 1 -reconnect_streamed 1"},
+		{"http", func() (*exec.Cmd, error) {
+			return a.transcodeStart(profileModeHLS, "h264_720p", "flaky")
+		}, ""},
+		{"rtsp transport", func() (*exec.Cmd, error) {
+			return a.transcodeStart(profileModeHLS, "h264_720p", "cam")
+		}, "-rtsp_transport tcp"},
+		{"rtsp backup", func() (*exec.Cmd, error) {
+			return a.transcodeBackupStart(profileModeHLS, "h264_720p", "cam", 1, hlsTrack{})
+		}, "-rtsp_transport tcp"},
+		{"http backup", func() (*exec.Cmd, error) {
+			return a.transcodeBackupStart(profileModeHLS, "h264_720p", "cam", 2, hlsTrack{})
+		}, ""},
+		{"fallback", func() (*exec.Cmd, error) {
+			return a.transcodeFallbackStart(profileModeHLS, "h264_720p", "cam")
+		}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := tt.start()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got, _ := testEnv(cmd.Env, "TRANSCODE_INPUT_OPTIONS"); got != tt.want {
+				t.Errorf("got input options %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceInputOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream StreamConf
+		valid  bool
+	}{
+		{"http reconnect", StreamConf{Source: "https://camera/stream", Reconnect: true, ReconnectDelayMax: 5}, true},
+		{"rtsp reconnect", StreamConf{Source: "rtsp://camera/stream", Reconnect: true}, false},
+		{"rtsp reconnect delay", StreamConf{Source: "rtsp://camera/stream", ReconnectDelayMax: 5}, false},
+		{"negative reconnect delay", StreamConf{Source: "http://camera/stream", Reconnect: true, ReconnectDelayMax: -1}, false},
+		{"rtsp transport", StreamConf{Source: "rtsps://camera/stream", RTSPTransport: "udp"}, true},
+		{"http rtsp transport", StreamConf{Source: "http://camera/stream", RTSPTransport: "tcp"}, false},
+		{"unknown rtsp transport", StreamConf{Source: "rtsp://camera/stream", RTSPTransport: "quic"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.stream.validate(); (err == nil) != tt.valid {
+				t.Errorf("got error %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestProfilePath(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"hls/h264_720p.sh",
+		"hls/yaml_720p.yaml",
+		"hls/yaml_720p.sh",
+		"h264_720p.sh",
+		"merged.sh",
+		"http/merged.sh",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		merge   bool
+		mode    string
+		profile string
+		want    string
+	}{
+		{"mode", false, profileModeHLS, "h264_720p", "hls/h264_720p.sh"},
+		{"yaml precedes script", false, profileModeHLS, "yaml_720p", "hls/yaml_720p.yaml"},
+		{"root not merged", false, profileModeHLS, "merged", ""},
+		{"mode precedes root", true, profileModeHLS, "h264_720p", "hls/h264_720p.sh"},
+		{"merged root", true, profileModeHLS, "merged", "merged.sh"},
+		{"merged mode precedes root", true, profileModeHTTP, "merged", "http/merged.sh"},
+		{"missing", true, profileModeHLS, "h264_1080p", ""},
+		{"traversal", true, profileModeHLS, "../hls/h264_720p", ""},
+		{"dot", true, profileModeHLS, "..", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApi()
+			a.config.Profiles = root
+			a.config.ProfilesMerge = tt.merge
+
+			got, err := a.profilePath(tt.mode, tt.profile)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("got %q, want error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := filepath.Join(root, tt.want); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+
+	a := newTestApi()
+	a.config.Profiles = root
+	if _, err := a.profilePath(profileModeHLS, "h264_1080p"); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrProfileNotFound)
+	}
+}
+
+func TestScalingEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		options profileOptions
+		flags   string
+		sharpen string
+	}{
+		{"default", profileOptions{}, "", ""},
+		{"algorithm", profileOptions{ScaleAlgorithm: "lanczos"}, "lanczos", ""},
+		{"sharpen", profileOptions{Sharpen: 0.8}, "", "unsharp=5:5:0.8"},
+		{"both", profileOptions{ScaleAlgorithm: "spline", Sharpen: 1.5}, "spline", "unsharp=5:5:1.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.options.env()
+
+			if got, _ := testEnv(env, "TRANSCODE_SCALE_FLAGS"); got != tt.flags {
+				t.Errorf("got scale flags %q, want %q", got, tt.flags)
+			}
+			if got, _ := testEnv(env, "TRANSCODE_SHARPEN_FILTER"); got != tt.sharpen {
+				t.Errorf("got sharpen filter %q, want %q", got, tt.sharpen)
+			}
+		})
+	}
+}
+
+func TestScalingValidate(t *testing.T) {
+	for _, algorithm := range []string{"", "fast_bilinear", "bilinear", "bicubic", "experimental", "neighbor", "area", "bicublin", "gauss", "sinc", "lanczos", "spline"} {
+		stream := StreamConf{Source: "rtsp://camera/stream", ScaleAlgorithm: algorithm}
+		if err := stream.validate(); err != nil {
+			t.Errorf("%q: unexpected error: %v", algorithm, err)
+		}
+	}
+
+	for _, algorithm := range []string{"nearest", "Lanczos", "lanczos:param0=3"} {
+		stream := StreamConf{Source: "rtsp://camera/stream", ScaleAlgorithm: algorithm}
+		if err := stream.validate(); err == nil {
+			t.Errorf("%q: expected error for unknown scale algorithm", algorithm)
+		}
+	}
+
+	for sharpen, valid := range map[float64]bool{0: true, 1.5: true, -0.1: false, 1.6: false} {
+		stream := StreamConf{Source: "rtsp://camera/stream", Sharpen: sharpen}
+		if err := stream.validate(); (err == nil) != valid {
+			t.Errorf("sharpen %g: got error %v, want valid %v", sharpen, err, valid)
+		}
+	}
+}
+
+func TestProfileNotAllowed(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{
+		"cam": {Source: "rtsp://camera/stream", Audio: AudioCopy, Profiles: []string{"h264_360p"}},
+	}})
+
+	root := t.TempDir()
+	for _, mode := range []string{profileModeHTTP, profileModeHLS} {
+		if err := os.MkdirAll(filepath.Join(root, mode), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, profile := range []string{"h264_360p", "h264_720p"} {
+			if err := os.WriteFile(filepath.Join(root, mode, profile+".sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	a := newTestApi()
+	a.config.Profiles = root
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/h264_720p/cam/index.m3u8"},
+		{http.MethodHead, "/h264_720p/cam/index.m3u8"},
+		{http.MethodGet, "/h264_720p/cam/" + tracksPlaylistName},
+		{http.MethodGet, "/h264_720p/cam/subs0.vtt"},
+		{http.MethodGet, "/h264_720p/cam/720p.m3u8"},
+		{http.MethodGet, "/h264_720p/cam/key0.key"},
+		{http.MethodGet, "/h264_720p/cam"},
+		{http.MethodHead, "/h264_720p/cam"},
+		{http.MethodGet, "/h264_720p/cam/mp4"},
+		{http.MethodGet, "/h264_720p/cam/buf"},
+		{http.MethodPost, "/streams/cam/h264_720p/start"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	if len(a.hlsManagersOf("cam")) != 0 {
+		t.Error("got manager of disallowed profile")
+	}
+
+	// allowed profile is not refused
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/h264_360p/cam", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d of allowed profile, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrProfilesUnavailable = errors.New("profiles unavailable")
+
+// how often should be profiles directory checked
+const profilesCheckPeriod = 5 * time.Second
+
+func (a *ApiManagerCtx) ProfilesAvailable() bool {
+	a.profilesMu.Lock()
+	defer a.profilesMu.Unlock()
+	return a.profilesAvailable
+}
+
+// watchProfiles detects profiles directory becoming unavailable (e.g.
+// unmounted share) and optionally pauses running streams meanwhile.
+func (a *ApiManagerCtx) watchProfiles() {
+	logger := log.With().Str("module", "profiles").Str("path", a.config.Profiles).Logger()
+
+	ticker := time.NewTicker(profilesCheckPeriod)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		a.checkProfilesAvailable(logger)
+	}
+}
+
+// checkProfilesAvailable records availability of profiles directory, running
+// streams are paused or resumed when it changes, if configured.
+func (a *ApiManagerCtx) checkProfilesAvailable(logger zerolog.Logger) {
+	stat, err := os.Stat(a.config.Profiles)
+	available := err == nil && stat.IsDir()
+
+	a.profilesMu.Lock()
+	changed := a.profilesAvailable != available
+	a.profilesAvailable = available
+	a.profilesMu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if available {
+		logger.Info().Msg("profiles available again")
+	} else {
+		logger.Error().Err(err).Msg("profiles unavailable")
+	}
+
+	if !a.config.ProfilesPause {
+		return
+	}
+
+	for _, manager := range a.hlsManagersOf("") {
+		if available {
+			manager.Resume()
+		} else {
+			manager.Pause()
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+)
+
+func TestProfilesUnavailable(t *testing.T) {
+	for _, pause := range []bool{false, true} {
+		a := newTestApi()
+		a.setStatus(StatusOK)
+		a.config.Profiles = filepath.Join(t.TempDir(), "profiles")
+		a.config.ProfilesPause = pause
+
+		manager := &testHLSManager{}
+		a.hlsManagers["h264_720p/cam"] = manager
+
+		if err := os.Mkdir(a.config.Profiles, 0755); err != nil {
+			t.Fatal(err)
+		}
+		a.checkProfilesAvailable(log.Logger)
+
+		if code, _ := testReady(t, a); code != http.StatusOK {
+			t.Errorf("pause %v: got status %d, want %d", pause, code, http.StatusOK)
+		}
+
+		if err := os.Remove(a.config.Profiles); err != nil {
+			t.Fatal(err)
+		}
+		a.checkProfilesAvailable(log.Logger)
+
+		code, reason := testReady(t, a)
+		if code != http.StatusServiceUnavailable || reason != ErrProfilesUnavailable.Error() {
+			t.Errorf("pause %v: got status %d (%q), want %d", pause, code, reason, http.StatusServiceUnavailable)
+		}
+
+		if manager.paused != pause {
+			t.Errorf("pause %v: got stream paused %v", pause, manager.paused)
+		}
+
+		if err := os.Mkdir(a.config.Profiles, 0755); err != nil {
+			t.Fatal(err)
+		}
+		a.checkProfilesAvailable(log.Logger)
+
+		if code, _ := testReady(t, a); code != http.StatusOK {
+			t.Errorf("pause %v: got status %d, want %d", pause, code, http.StatusOK)
+		}
+
+		if manager.paused {
+			t.Errorf("pause %v: stream not resumed", pause)
+		}
+	}
+}
+
+// testReady returns http code of readiness endpoint and reason reported.
+func testReady(t *testing.T, a *ApiManagerCtx) (int, string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	a.Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	body := map[string]string{}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, body["reason"]
+}
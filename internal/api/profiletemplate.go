@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"text/template"
+
+	"github.com/rs/zerolog/log"
+)
+
+// profileTemplateData are variables of profile templates, resolved when
+// transcode is started.
+type profileTemplateData struct {
+	Input   string
+	Profile string
+	Mode    string
+	// hls segment durations, in seconds
+	SegmentDuration float64
+	PartDuration    float64
+	// source video dimensions, zero when unknown (e.g. ingested stream)
+	Width  int
+	Height int
+	// custom variables of stream
+	Vars map[string]string
+}
+
+var profileTemplateFuncs = template.FuncMap{
+	"min": func(a, b int) int {
+		if a < b {
+			return a
+		}
+		return b
+	},
+	"max": func(a, b int) int {
+		if a > b {
+			return a
+		}
+		return b
+	},
+}
+
+// loadStreamYAMLProfile loads declarative profile, templates are rendered
+// for given stream. Source is probed only when template uses dimensions.
+func (a *ApiManagerCtx) loadStreamYAMLProfile(profilePath, mode, profile, input string, stream StreamConf, probe bool) (*yamlProfile, error) {
+	if !strings.HasSuffix(profilePath, yamlProfileTemplateExt) {
+		return loadYAMLProfile(profilePath)
+	}
+
+	text, err := ioutil.ReadFile(profilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(profile).Funcs(profileTemplateFuncs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, err
+	}
+
+	data := profileTemplateData{
+		Input:           input,
+		Profile:         profile,
+		Mode:            mode,
+		SegmentDuration: a.hlsConfig.SegmentDuration,
+		PartDuration:    a.hlsConfig.PartDuration,
+		Vars:            stream.Vars,
+	}
+
+	if data.Vars == nil {
+		data.Vars = map[string]string{}
+	}
+
+	if probe && (bytes.Contains(text, []byte(".Width")) || bytes.Contains(text, []byte(".Height"))) {
+		data.Width, data.Height = a.probeDimensions(stream.Source)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return nil, err
+	}
+
+	return parseYAMLProfile(profilePath, rendered.Bytes())
+}
+
+// probeDimensions returns dimensions of source video, or zeros when it
+// could not be probed.
+func (a *ApiManagerCtx) probeDimensions(source string) (int, int) {
+	probe, err := a.probeCmd(source)
+	if err != nil {
+		log.Warn().Err(err).Str("source", source).Msg("unable to probe video dimensions")
+		return 0, 0
+	}
+
+	return probe.Dimensions()
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// how often are states of idle clients forgotten
+const clientLimiterSweep = time.Minute
+
+// clientState is request budget and open requests of client.
+type clientState struct {
+	tokens  float64
+	updated time.Time
+	active  int
+}
+
+// clientLimiter limits request rate using token bucket, and open
+// requests of every client.
+type clientLimiter struct {
+	mu sync.Mutex
+	// requests per second and bucket size, zero rate is unlimited
+	rate  float64
+	burst float64
+	// concurrent requests, zero is unlimited
+	connections int
+
+	clients map[string]*clientState
+	swept   time.Time
+}
+
+func newClientLimiter(rate float64, burst int, connections int) *clientLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &clientLimiter{
+		rate:        rate,
+		burst:       float64(burst),
+		connections: connections,
+		clients:     map[string]*clientState{},
+		swept:       time.Now(),
+	}
+}
+
+// acquire takes token and connection of client, returning false with
+// delay after which request can be retried.
+func (l *clientLimiter) acquire(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.swept) > clientLimiterSweep {
+		l.sweep(now)
+	}
+
+	state, ok := l.clients[client]
+	if !ok {
+		state = &clientState{tokens: l.burst, updated: now}
+		l.clients[client] = state
+	}
+
+	if l.rate > 0 {
+		state.tokens = math.Min(l.burst, state.tokens+now.Sub(state.updated).Seconds()*l.rate)
+		state.updated = now
+
+		if state.tokens < 1 {
+			return false, time.Duration((1 - state.tokens) / l.rate * float64(time.Second))
+		}
+	}
+
+	if l.connections > 0 && state.active >= l.connections {
+		return false, time.Second
+	}
+
+	state.tokens--
+	state.active++
+	return true, 0
+}
+
+func (l *clientLimiter) release(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if state, ok := l.clients[client]; ok {
+		state.active--
+	}
+}
+
+// sweep forgets clients without open requests, whose bucket is refilled,
+// must be called with lock held.
+func (l *clientLimiter) sweep(now time.Time) {
+	for client, state := range l.clients {
+		refilled := l.rate <= 0 || state.tokens+now.Sub(state.updated).Seconds()*l.rate >= l.burst
+		if state.active == 0 && refilled {
+			delete(l.clients, client)
+		}
+	}
+
+	l.swept = now
+}
+
+// clientIP returns address of client, taken from X-Forwarded-For of
+// trusted reverse proxy when enabled.
+func (a *ApiManagerCtx) clientIP(r *http.Request) string {
+	if a.config.Proxy {
+		// proxy appends address it is connected from, preceding ones
+		// are set by clients
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			addresses := strings.Split(forwarded, ",")
+			if ip := strings.TrimSpace(addresses[len(addresses)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	return clientAddr(r)
+}
+
+// limitClients rejects stream requests of clients exceeding their
+// request rate or concurrent requests with 429.
+func (a *ApiManagerCtx) limitClients(next http.Handler) http.Handler {
+	if a.clients == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := a.clientIP(r)
+
+		ok, retry := a.clients.acquire(client)
+		if !ok {
+			log.Debug().Str("client", client).Str("path", r.URL.Path).Msg("client limit reached")
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("429 too many requests"))
+			return
+		}
+		defer a.clients.release(client)
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+	"github.com/m1k1o/go-transcode/recording"
+)
+
+// syncRecordings starts recordings of streams configured to be recorded,
+// restarts those whose config changed and stops those no longer
+// configured.
+func (a *ApiManagerCtx) syncRecordings() {
+	if a.recordConfig.Dir == "" {
+		return
+	}
+
+	streams := currentConf().Streams
+
+	a.recordMu.Lock()
+	defer a.recordMu.Unlock()
+
+	for input, manager := range a.recordManagers {
+		stream, ok := streams[input]
+		if ok && stream.Record != nil && *stream.Record == a.recordConfs[input] {
+			continue
+		}
+
+		manager.Shutdown()
+		delete(a.recordManagers, input)
+		delete(a.recordConfs, input)
+	}
+
+	for input, stream := range streams {
+		if stream.Record == nil {
+			continue
+		}
+
+		if _, ok := a.recordManagers[input]; ok {
+			continue
+		}
+
+		config, err := stream.Record.recording(a.recordConfig)
+		if err != nil {
+			log.Warn().Err(err).Str("stream", input).Msg("invalid recording config")
+			continue
+		}
+		config.Dir = filepath.Join(a.recordConfig.Dir, input)
+		config.Exited = a.transcodeExited
+
+		input, profile := input, stream.Record.profile()
+		manager := recording.New(func() (*exec.Cmd, error) {
+			cmd, err := a.transcodeStart(profileModeHTTP, profile, input)
+			if err != nil {
+				return nil, err
+			}
+
+			utils.ExpandArgs(cmd)
+			return cmd, nil
+		}, config)
+
+		// failed starts are retried by manager
+		//nolint
+		manager.Start()
+
+		a.recordManagers[input] = manager
+		a.recordConfs[input] = *stream.Record
+	}
+}
+
+// Recordings lists recorded files of stream.
+func (a *ApiManagerCtx) Recordings(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	a.recordMu.Lock()
+	manager, ok := a.recordManagers[name]
+	a.recordMu.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 stream not recorded"))
+		return
+	}
+
+	recordings, err := manager.Recordings()
+	if err != nil {
+		log.Err(err).Str("stream", name).Msg("could not list recordings")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 could not list recordings"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	//nolint
+	json.NewEncoder(w).Encode(recordings)
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/broadcast"
+	"github.com/m1k1o/go-transcode/hls"
+)
+
+// editors write files in several steps, changes are applied once they
+// settle for this long
+const configWatchDelay = 500 * time.Millisecond
+
+// how often are viewers of removed streams checked while draining
+const drainCheckPeriod = time.Second
+
+// watchConfig reloads streams config, once it is changed, and checks
+// changed profiles. Directories are watched, since editors replace files.
+func (a *ApiManagerCtx) watchConfig() {
+	logger := log.With().Str("module", "reload").Logger()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Err(err).Msg("unable to watch config")
+		return
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(confPath)); err != nil {
+		logger.Warn().Err(err).Str("path", confPath).Msg("unable to watch streams config")
+	}
+
+	// profiles of every mode are in their own directory
+	dirs := []string{a.config.Profiles}
+	for _, mode := range []string{profileModeHLS, profileModeHTTP, profileModeDASH, profileModeWHEP, profileModeVOD} {
+		dirs = append(dirs, path.Join(a.config.Profiles, mode))
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil && !os.IsNotExist(err) {
+			logger.Warn().Err(err).Str("path", dir).Msg("unable to watch profiles")
+		}
+	}
+
+	var reload, profiles <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			if filepath.Clean(event.Name) == filepath.Clean(confPath) {
+				reload = time.After(configWatchDelay)
+			} else if filepath.Dir(filepath.Clean(event.Name)) != filepath.Dir(filepath.Clean(confPath)) {
+				profiles = time.After(configWatchDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Warn().Err(err).Msg("config watch failed")
+		case <-reload:
+			reload = nil
+			logger.Info().Str("path", confPath).Msg("streams config changed, reloading")
+			//nolint
+			a.Reload()
+		case <-profiles:
+			profiles = nil
+			a.checkProfiles()
+		}
+	}
+}
+
+// checkProfiles reports yaml profiles, that fail to load after change.
+// Profiles are read on every start, so that changes apply to newly
+// started transcodes, while running ones are kept.
+func (a *ApiManagerCtx) checkProfiles() {
+	logger := log.With().Str("module", "reload").Str("path", a.config.Profiles).Logger()
+
+	paths, _ := filepath.Glob(path.Join(a.config.Profiles, "*", "*"+yamlProfileExt))
+	if a.config.ProfilesMerge {
+		merged, _ := filepath.Glob(path.Join(a.config.Profiles, "*"+yamlProfileExt))
+		paths = append(paths, merged...)
+	}
+
+	invalid := 0
+	for _, profilePath := range paths {
+		if _, err := loadYAMLProfile(profilePath); err != nil {
+			logger.Error().Err(err).Msg("changed profile is invalid")
+			invalid++
+		}
+	}
+
+	logger.Info().Int("invalid", invalid).Msg("profiles changed, applied to newly started transcodes")
+}
+
+// removedStreams returns names of streams missing in new config.
+func removedStreams(old *YamlConf, new *YamlConf) []string {
+	removed := []string{}
+	for name := range old.Streams {
+		if _, ok := new.Streams[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	return removed
+}
+
+// drainStreams removes hls managers of streams removed by reload, once
+// their viewers leave or drain timeout passes.
+func (a *ApiManagerCtx) drainStreams(names []string) {
+	if len(names) == 0 {
+		return
+	}
+
+	logger := log.With().Str("module", "reload").Logger()
+
+	ticker := time.NewTicker(drainCheckPeriod)
+	defer ticker.Stop()
+
+	deadline := time.Now().Add(a.config.ReloadDrainTimeout)
+	for len(names) > 0 {
+		timeout := !time.Now().Before(deadline)
+
+		pending := []string{}
+		for _, name := range names {
+			// added back meanwhile
+			if _, ok := currentConf().stream(name); ok {
+				continue
+			}
+
+			managers, viewers := a.streamManagers(name)
+			if viewers > 0 && !timeout {
+				pending = append(pending, name)
+				continue
+			}
+
+			a.removeStreamManagers(name)
+			if len(managers) > 0 {
+				logger.Info().Str("stream", name).Int("viewers", viewers).Msg("removed stream drained, stopping")
+			}
+		}
+
+		names = pending
+		if len(names) > 0 {
+			<-ticker.C
+		}
+	}
+}
+
+// streamManagers returns hls managers of stream and its current viewers.
+func (a *ApiManagerCtx) streamManagers(name string) ([]hls.Manager, int) {
+	managers := []hls.Manager{}
+	for _, manager := range a.hlsManagersOf(name) {
+		managers = append(managers, manager)
+	}
+
+	a.hlsMu.Lock()
+	defer a.hlsMu.Unlock()
+
+	viewers := 0
+	if v, ok := a.hlsViewers[name]; ok {
+		v.Expire()
+		viewers = v.Stats().Current
+	}
+
+	return managers, viewers
+}
+
+// removeStreamManagers shuts down and removes managers of removed stream,
+// so that they are not reused once stream is added back.
+func (a *ApiManagerCtx) removeStreamManagers(name string) {
+	for id, manager := range a.hlsManagersOf(name) {
+		a.removeHLSManager(id, manager)
+	}
+
+	a.hlsMu.Lock()
+	delete(a.hlsViewers, name)
+	a.hlsMu.Unlock()
+
+	a.broadcastMu.Lock()
+	managers := map[string]broadcast.Manager{}
+	for id, manager := range a.broadcastManagers {
+		// <profile>/<input>/<format>
+		if parts := strings.Split(id, "/"); len(parts) == 3 && parts[1] == name {
+			managers[id] = manager
+		}
+	}
+	a.broadcastMu.Unlock()
+
+	for id, manager := range managers {
+		a.removeBroadcastManager(id, manager)
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// testConfPath points streams config to temporary file of given content.
+func testConfPath(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "streams.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := confPath
+	confPath = path
+	t.Cleanup(func() { confPath = previous })
+	return path
+}
+
+func TestReload(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Source: "rtsp://camera/stream"}}})
+	path := testConfPath(t, "streams:\n  cam: rtsp://camera/stream\n  lobby: rtsp://lobby/stream\n")
+
+	logs := &bytes.Buffer{}
+	previous := log.Logger
+	log.Logger = zerolog.New(logs)
+	t.Cleanup(func() { log.Logger = previous })
+
+	a := newTestApi()
+	a.dynamic, _ = loadDynamicStreams("")
+
+	if err := a.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := currentConf().stream("lobby"); !ok {
+		t.Fatal("added stream missing after reload")
+	}
+
+	// invalid config keeps current one
+	for _, content := range []string{
+		"streams: [",
+		// stream without source
+		"streams:\n  cam:\n    profiles: [h264_720p]\n  lobby: rtsp://lobby/stream\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		logs.Reset()
+		if err := a.Reload(); err == nil {
+			t.Errorf("%q: got no error, want invalid config reported", content)
+		}
+
+		if _, ok := currentConf().stream("lobby"); !ok {
+			t.Errorf("%q: current config replaced by invalid one", content)
+		}
+		if out := logs.String(); !strings.Contains(out, `"level":"error"`) || !strings.Contains(out, "keeping current config") {
+			t.Errorf("%q: failed reload not logged:\n%s", content, out)
+		}
+	}
+}
+
+func TestReloadChangedStream(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Source: "rtsp://camera/stream", Audio: AudioCopy}}})
+	testConfPath(t, "streams:\n  cam:\n    source: rtsp://camera/stream\n    audio: copy\n    max_duration: 200ms\n")
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, profileModeHLS), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, profileModeHLS, "h264_720p.sh"), []byte("#!/bin/sh\nsleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	a := newTestApi()
+	a.dynamic, _ = loadDynamicStreams("")
+	a.config.Profiles = root
+	a.hlsConfig.SegmentDuration = 2
+	defer func() {
+		for _, manager := range a.hlsManagersOf("") {
+			manager.Shutdown()
+		}
+	}()
+
+	manager := a.hlsManager("h264_720p", "cam")
+	if err := manager.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := a.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// running transcode is kept
+	if got := a.hlsManager("h264_720p", "cam"); got != manager {
+		t.Fatal("running manager replaced by reload")
+	}
+
+	manager.Stop()
+
+	started := a.hlsManager("h264_720p", "cam")
+	if started == manager {
+		t.Fatal("stopped manager of changed stream reused")
+	}
+	if err := started.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !waitFor(2*time.Second, func() bool { return started.Stats().Expired }) {
+		t.Error("changed max duration not applied on next start")
+	}
+
+	// unchanged stream keeps its manager
+	if err := a.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := a.hlsManager("h264_720p", "cam"); got != started {
+		t.Error("manager of unchanged stream replaced")
+	}
+}
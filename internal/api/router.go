@@ -1,59 +1,1053 @@
 package api
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
-	"regexp"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/analytics"
+	"github.com/m1k1o/go-transcode/auth"
+	"github.com/m1k1o/go-transcode/broadcast"
+	"github.com/m1k1o/go-transcode/dash"
+	"github.com/m1k1o/go-transcode/hls"
+	"github.com/m1k1o/go-transcode/ingest"
+	"github.com/m1k1o/go-transcode/internal/config"
+	"github.com/m1k1o/go-transcode/internal/hwaccel"
+	"github.com/m1k1o/go-transcode/internal/utils"
+	"github.com/m1k1o/go-transcode/recording"
+	"github.com/m1k1o/go-transcode/storage"
+	"github.com/m1k1o/go-transcode/subtitles"
+	"github.com/m1k1o/go-transcode/thumbnails"
+	"github.com/m1k1o/go-transcode/vod"
+	"github.com/m1k1o/go-transcode/webhooks"
+	"github.com/m1k1o/go-transcode/whep"
 )
 
-var conf *YamlConf
+// path of streams config, changed by tests only
+var confPath = "/app/streams.yaml"
+
+// current *YamlConf, swapped as whole on reload
+var conf atomic.Value
+
+// error of loading streams config on start, reported by validation
+var confErr error
 
 func init() {
-	var err error
-	conf, err = loadConf("/app/streams.yaml")
+	c, err := loadConf(confPath)
 	if err != nil {
-		panic(err)
+		confErr = err
+		c = &YamlConf{Streams: map[string]StreamConf{}}
 	}
+	conf.Store(c)
+}
+
+func currentConf() *YamlConf {
+	return conf.Load().(*YamlConf)
 }
 
-type ApiManagerCtx struct{}
+func storeConf(c *YamlConf) {
+	conf.Store(c)
+}
+
+// Reload loads streams config again, it is swapped in only when whole
+// config is valid. Otherwise current config is kept. Running streams are
+// not affected, new config applies to newly started ones. Transcodes of
+// removed streams are stopped once drained.
+func (a *ApiManagerCtx) Reload() error {
+	c, err := loadConf(confPath)
+	if err != nil {
+		log.Error().Err(err).Str("path", confPath).Msg("config reload failed, keeping current config")
+		return err
+	}
 
-func New() *ApiManagerCtx {
+	a.dynamic.mu.Lock()
+	c = a.dynamic.merge(c)
+	old := currentConf()
+	conf.Store(c)
+	a.dynamic.mu.Unlock()
 
-	return &ApiManagerCtx{}
+	go a.drainStreams(removedStreams(old, c))
+
+	log.Info().Str("path", confPath).Int("streams", len(c.Streams)).Msg("config reloaded")
+
+	// recordings do not wait for viewers, they follow config instead
+	a.syncRecordings()
+	return nil
 }
 
-func (a *ApiManagerCtx) Mount(r *chi.Mux) {
-	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+type ApiManagerCtx struct {
+	config *config.Server
+
+	profilesAvailable bool
+	profilesMu        sync.Mutex
+
+	hlsConfig   hls.Config
+	hlsTempDir  string
+	hlsStore    *storage.S3
+	hlsManagers map[string]hls.Manager
+	// stream config of managers, they are replaced once it changes
+	hlsStreams map[string]StreamConf
+	// viewers by stream, shared by its managers
+	hlsViewers map[string]*hls.Viewers
+	hlsMu      sync.Mutex
+
+	dashConfig   dash.Config
+	dashManagers map[string]dash.Manager
+	dashMu       sync.Mutex
+
+	whepConfig   whep.Config
+	whepManagers map[string]whep.Manager
+	whepMu       sync.Mutex
+
+	thumbnailsConfig   thumbnails.Config
+	thumbnailsManagers map[string]thumbnails.Manager
+	thumbnailsMu       sync.Mutex
+
+	subtitlesConfig   subtitles.Config
+	subtitlesManagers map[string]subtitles.Manager
+	subtitlesMu       sync.Mutex
+
+	broadcastManagers map[string]broadcast.Manager
+	broadcastStreams  map[string]StreamConf
+	broadcastMu       sync.Mutex
+
+	vodConfig   vod.Config
+	vodManagers map[string]vod.Manager
+	// cache keys of managers, that are replaced when they change
+	vodKeys map[string]string
+	vodMu   sync.Mutex
+
+	// storyboards of vod files by their directory, kept next to vod
+	// cache or in tempdir removed on shutdown
+	storyboards map[string]*thumbnails.StoryboardCtx
+	// directories of storyboards by file, replaced once it is modified
+	storyboardDirs  map[string]string
+	storyboardsDir  string
+	storyboardsTemp bool
+	storyboardsMu   sync.Mutex
+
+	recordConfig   recording.Config
+	recordManagers map[string]recording.Manager
+	// configs of running recordings, that are restarted when they change
+	recordConfs map[string]RecordConf
+	recordMu    sync.Mutex
+
+	analytics *analytics.Tracker
+
+	// verifies tokens of stream endpoints, nil when disabled
+	auth *auth.Verifier
+
+	// rtmp ingest server and srt relays
+	ingest *ingest.ServerCtx
+
+	// broker of manager events, nil when disabled
+	events *eventBroker
+	// posts manager events, nil when disabled
+	webhooks *webhooks.Sender
+
+	// limits concurrent helper commands
+	helpers *utils.Semaphore
+	// limits request rate and concurrent requests of stream clients, nil
+	// when disabled
+	clients *clientLimiter
+
+	// limits concurrent transcodes of all modes, globally and by profile
+	transcodes        *utils.Semaphore
+	profileTranscodes map[string]*utils.Semaphore
+
+	// limits of transcode processes, overridden by yaml profiles
+	processLimits utils.ProcessLimits
+
+	// streams registered at runtime
+	dynamic *dynamicStreams
+
+	// recent probe results and probes in progress by source
+	probes   map[string]probeCacheEntry
+	probing  map[string]*probeCall
+	probesMu sync.Mutex
+
+	// snapshots of newest segments by stream and size
+	snapshots   map[string]snapshotCacheEntry
+	snapshotsMu sync.Mutex
+
+	// transcode output by stream
+	streamLogs   map[string]*streamLog
+	streamLogsMu sync.Mutex
+
+	// picks h264 encoder of profiles
+	encoders *hwaccel.Selector
+	// hardware encoders of transcodes, until their process exits
+	encoderSessions   map[*exec.Cmd]hwaccel.Encoder
+	encoderSessionsMu sync.Mutex
+
+	status   string
+	statusMu sync.Mutex
+}
+
+func New(conf *config.Server, hlsConf *config.HLS, thumbnailsConf *config.Thumbnails, analyticsConf *config.Analytics, webhooksConf *config.Webhooks) *ApiManagerCtx {
+	processLimits := utils.ProcessLimits{
+		Nice:      conf.ProcessNice,
+		CPUs:      conf.ProcessCPUs,
+		CgroupDir: conf.ProcessCgroupDir,
+		CPUMax:    conf.ProcessCPUMax,
+		MemoryMax: int64(conf.ProcessMemoryMax) << 20,
+	}
+
+	if confErr != nil {
+		log.Panic().Err(confErr).Str("path", confPath).Msg("invalid streams config")
+	}
+
+	if err := processLimits.Validate(); err != nil {
+		log.Panic().Err(err).Msg("invalid process limits")
+	}
+
+	tempRoot, err := utils.TempRoot(conf.TempRoot, conf.TempPreferTmpfs, conf.TempTmpfs)
+	if err != nil {
+		log.Panic().Err(err).Msg("unable to create temp root")
+	}
+	if tempRoot != "" {
+		log.Info().Str("dir", tempRoot).Msg("using temp root")
+	}
+
+	hlsConfig := hls.Config{
+		Manager: hls.ManagerConfig{
+			CleanupPeriod:       hlsConf.CleanupPeriod,
+			PlaylistTimeout:     hlsConf.PlaylistTimeout,
+			MinimumSegments:     hlsConf.MinimumSegments,
+			ActiveIdleTimeout:   hlsConf.ActiveIdleTimeout,
+			InactiveIdleTimeout: hlsConf.InactiveIdleTimeout,
+		},
+
+		LowLatency:      hlsConf.LowLatency,
+		SegmentDuration: hlsConf.SegmentDuration,
+		PartDuration:    hlsConf.PartDuration,
+		ServerControl: hls.ServerControl{
+			CanBlockReload: hlsConf.CanBlockReload,
+			HoldBack:       hlsConf.HoldBack,
+			PartHoldBack:   hlsConf.PartHoldBack,
+		},
+		MaxBlockingReloads: hlsConf.MaxBlockingReloads,
+		ExitGrace:          hlsConf.ExitGrace,
+		MimeTypes:          hlsConf.MimeTypes,
+		SniffMimeTypes:     hlsConf.SniffMimeTypes,
+
+		BreakerThreshold: hlsConf.BreakerThreshold,
+		BreakerCooldown:  hlsConf.BreakerCooldown,
+
+		ExplicitStart: hlsConf.ExplicitStart,
+
+		ContentProtection: hlsConf.DRM,
+		Encryption: hls.Encryption{
+			KeyRotation:    hlsConf.KeyRotation,
+			RequireSession: hlsConf.KeyRequireSession,
+		},
+		SigningSecret: hlsConf.SigningSecret,
+
+		DVRWindow: hlsConf.DVRWindow,
+
+		MaxSegments:     hlsConf.MaxSegments,
+		MaxPlaylistSize: hlsConf.MaxPlaylistSize,
+
+		RestartGrace:      hlsConf.RestartGrace,
+		RestartBackoff:    hlsConf.RestartBackoff,
+		RestartBackoffMax: hlsConf.RestartBackoffMax,
+		PlaylistCache:     hlsConf.PlaylistCache,
+		StoreWorkers:      hlsConf.StoreWorkers,
+		HeadColdStart:     hlsConf.HeadColdStart,
+
+		ColdStartTimeout: hlsConf.ColdStartTimeout,
+		StartupTimeout:   hlsConf.StartupTimeout,
+		RetryAfterMin:    hlsConf.RetryAfterMin,
+		MaxDuration:      hlsConf.MaxDuration,
+
+		TempRoot: tempRoot,
+
+		SingleProcess: !conf.ProcessGroup,
+		ProcessLimits: processLimits,
+
+		AudioOnlyThreshold: hlsConf.AudioOnlyThreshold,
+		AudioOnlyPeriod:    hlsConf.AudioOnlyPeriod,
+
+		BandwidthWindow: hlsConf.BandwidthWindow,
+
+		FailbackPeriod: hlsConf.FailbackPeriod,
+
+		LimitTimeout: hlsConf.TranscodeQueueTimeout,
+
+		Watchdog: hls.Watchdog{
+			FreezeTimeout: hlsConf.FreezeTimeout,
+			Reset:         hlsConf.FreezeReset,
+			StallTimeout:  hlsConf.StallTimeout,
+		},
+	}
+
+	// metrics report served bytes
+	if conf.Metrics && hlsConfig.BandwidthWindow == 0 {
+		hlsConfig.BandwidthWindow = time.Minute
+	}
+
+	if conf.SnapshotMaxWidth <= 0 || conf.SnapshotMaxHeight <= 0 {
+		log.Panic().Msg("snapshot maximum dimensions must be positive")
+	}
+
+	if conf.StreamLogLines < 0 || conf.StreamLogMaxSize < 0 || conf.StreamLogMaxFiles < 0 {
+		log.Panic().Msg("stream log limits must not be negative")
+	}
+
+	if conf.StreamLogDir != "" {
+		if err := os.MkdirAll(conf.StreamLogDir, 0755); err != nil {
+			log.Panic().Err(err).Msg("unable to create stream log dir")
+		}
+	}
+
+	if hlsConf.Memory {
+		// segment store and external tools read segments from disk
+		if hlsConf.StoreEndpoint != "" || hlsConf.TempDir != "" {
+			log.Panic().Msg("hls memory can not be combined with segment store or stable tempdir")
+		}
+
+		memory, err := hls.NewMemoryStore()
+		if err != nil {
+			log.Panic().Err(err).Msg("unable to start hls memory store")
+		}
+		hlsConfig.Memory = memory
+	}
+
+	if err := hlsConfig.Validate(); err != nil {
+		log.Panic().Err(err).Msg("invalid hls config")
+	}
+
+	if hlsConf.WarmPool > 0 {
+		hlsConfig.WarmPool = hls.NewWarmPool(hlsConf.WarmPool)
+	}
+
+	if hlsConf.DiskBudget < 0 || hlsConf.DiskReserve < 0 {
+		log.Panic().Msg("hls disk budget and reserve must not be negative")
+	}
+
+	hlsConfig.DiskBudget = hls.NewDiskBudget(int64(hlsConf.DiskBudget)<<20, int64(hlsConf.DiskReserve)<<20)
+
+	var hlsStore *storage.S3
+	if hlsConf.StoreEndpoint != "" {
+		storeConfig := storage.S3Config{
+			Endpoint:  hlsConf.StoreEndpoint,
+			Region:    hlsConf.StoreRegion,
+			Bucket:    hlsConf.StoreBucket,
+			AccessKey: hlsConf.StoreAccessKey,
+			SecretKey: hlsConf.StoreSecretKey,
+			PublicURL: hlsConf.StorePublicURL,
+			Prefix:    hlsConf.StorePrefix,
+		}
+
+		if err := storeConfig.Validate(); err != nil {
+			log.Panic().Err(err).Msg("invalid hls store config")
+		}
+
+		hlsStore = storage.NewS3(storeConfig)
+	}
+
+	thumbnailsConfig := thumbnails.Config{
+		Interval: thumbnailsConf.Interval,
+		Columns:  thumbnailsConf.Columns,
+		Rows:     thumbnailsConf.Rows,
+		Width:    thumbnailsConf.Width,
+		Sprites:  thumbnailsConf.Sprites,
+
+		SingleProcess: !conf.ProcessGroup,
+	}
+
+	if err := thumbnailsConfig.Validate(); err != nil {
+		log.Panic().Err(err).Msg("invalid thumbnails config")
+	}
+
+	subtitlesConfig := liveSubtitlesConfig(hlsConfig, !conf.ProcessGroup)
+	if err := subtitlesConfig.Validate(); err != nil {
+		log.Panic().Err(err).Msg("invalid subtitles config")
+	}
+
+	vodConfig := vod.Config{
+		SegmentDuration: conf.VODSegmentDuration,
+		BufferAhead:     conf.VODBufferAhead,
+		TempRoot:        tempRoot,
+
+		SingleProcess: !conf.ProcessGroup,
+		ProcessLimits: processLimits,
+		RetryAfter:    utils.RetryAfter(conf.VODSegmentDuration, hlsConf.RetryAfterMin),
+	}
+
+	if conf.VODDir != "" {
+		if err := vodConfig.Validate(); err != nil {
+			log.Panic().Err(err).Msg("invalid vod config")
+		}
+
+		if conf.VODCacheDir != "" {
+			if conf.VODCacheSize <= 0 {
+				log.Panic().Msg("vod cache size must be positive")
+			}
+
+			cache, err := vod.NewCache(conf.VODCacheDir, int64(conf.VODCacheSize)<<20)
+			if err != nil {
+				log.Panic().Err(err).Msg("unable to open vod cache")
+			}
+			vodConfig.Cache = cache
+		}
+	}
+
+	storyboardsDir, storyboardsTemp := "", false
+	if conf.VODDir != "" && conf.VODCacheDir != "" {
+		storyboardsDir = filepath.Join(conf.VODCacheDir, "storyboards")
+	} else if conf.VODDir != "" {
+		dir, err := ioutil.TempDir("", "go-transcode-storyboards")
+		if err != nil {
+			log.Panic().Err(err).Msg("unable to create storyboards tempdir")
+		}
+		storyboardsDir, storyboardsTemp = dir, true
+	}
+
+	recordConfig := recording.Config{
+		Dir:             conf.RecordDir,
+		Format:          conf.RecordFormat,
+		SegmentDuration: conf.RecordSegmentDuration,
+		MaxAge:          conf.RecordMaxAge,
+		MaxSize:         int64(conf.RecordMaxSize) << 20,
+		RestartDelay:    5 * time.Second,
+
+		SingleProcess: !conf.ProcessGroup,
+		ProcessLimits: processLimits,
+	}
+
+	if conf.RecordDir != "" {
+		if err := recordConfig.Validate(); err != nil {
+			log.Panic().Err(err).Msg("invalid recording config")
+		}
+	}
+
+	var sender *webhooks.Sender
+	if webhooksConf.URL != "" {
+		webhooksConfig := webhooks.Config{
+			URL:     webhooksConf.URL,
+			Secret:  webhooksConf.Secret,
+			Events:  webhooksConf.Events,
+			Retries: webhooksConf.Retries,
+			Backoff: webhooksConf.Backoff,
+			Timeout: webhooksConf.Timeout,
+		}
+
+		if err := webhooksConfig.Validate(); err != nil {
+			log.Panic().Err(err).Msg("invalid webhooks config")
+		}
+
+		sender = webhooks.New(webhooksConfig)
+	}
+
+	var verifier *auth.Verifier
+	authConfig := auth.Config{
+		Secret:  conf.AuthJWTSecret,
+		JWKSURL: conf.AuthJWTJWKSURL,
+		Issuer:  conf.AuthJWTIssuer,
+	}
+	if authConfig.Enabled() {
+		if err := authConfig.Validate(); err != nil {
+			log.Panic().Err(err).Msg("invalid auth config")
+		}
+		verifier = auth.New(authConfig)
+	}
+
+	var tracker *analytics.Tracker
+	if analyticsConf.Sink != "" {
+		analyticsConfig := analytics.Config{
+			Interval:    analyticsConf.Interval,
+			IdleTimeout: analyticsConf.IdleTimeout,
+		}
+
+		if err := analyticsConfig.Validate(); err != nil {
+			log.Panic().Err(err).Msg("invalid analytics config")
+		}
+
+		var sink analytics.Sink
+		switch analyticsConf.Sink {
+		case "log":
+			sink = analytics.NewLogSink()
+		case "http":
+			if analyticsConf.URL == "" {
+				log.Panic().Msg("analytics url is required for http sink")
+			}
+			sink = analytics.NewHTTPSink(analyticsConf.URL)
+		default:
+			log.Panic().Str("sink", analyticsConf.Sink).Msg("unknown analytics sink")
+		}
+
+		tracker = analytics.New(sink, analyticsConfig)
+	}
+
+	ingestServer := ingest.New(ingest.Config{
+		Bind:    conf.RTMPBind,
+		Timeout: conf.RTMPTimeout,
+		// only paths of configured streams can be published
+		Allowed: func(path string) bool {
+			for _, stream := range currentConf().Streams {
+				if stream.Ingest == path {
+					return true
+				}
+			}
+			return false
+		},
+	})
+
+	if err := hwaccel.Validate(conf.HWAccel); err != nil {
+		log.Panic().Err(err).Msg("invalid hwaccel config")
+	}
+
+	sessions := map[string]int{}
+	for name, value := range conf.HWAccelSessions {
+		if err := hwaccel.Validate([]string{name}); err != nil {
+			log.Panic().Err(err).Msg("invalid hwaccel sessions")
+		}
+
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			log.Panic().Str("encoder", name).Str("sessions", value).Msg("invalid hwaccel sessions")
+		}
+		sessions[name] = limit
+	}
+
+	encoders, err := hwaccel.Detect(context.Background(), conf.HWAccel, sessions, !conf.HWAccelFallback)
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to detect hardware encoders, using software encoder")
+	} else if len(conf.HWAccel) > 0 {
+		log.Info().Strs("encoders", encoders.Available()).Msg("detected hardware encoders")
+	}
+
+	if conf.RateLimit < 0 || conf.RateLimitBurst < 0 || conf.MaxClientConnections < 0 {
+		log.Panic().Msg("client limits must not be negative")
+	}
+
+	var clients *clientLimiter
+	if conf.RateLimit > 0 || conf.MaxClientConnections > 0 {
+		clients = newClientLimiter(conf.RateLimit, conf.RateLimitBurst, conf.MaxClientConnections)
+	}
+
+	if hlsConf.MaxTranscodes < 0 {
+		log.Panic().Msg("hls max transcodes must not be negative")
+	}
+
+	profileTranscodes := map[string]*utils.Semaphore{}
+	for profile, value := range hlsConf.MaxProfileTranscodes {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			log.Panic().Str("profile", profile).Str("transcodes", value).Msg("invalid hls max profile transcodes")
+		}
+		profileTranscodes[profile] = utils.NewSemaphore(limit)
+	}
+
+	dynamic, err := loadDynamicStreams(conf.StreamsState)
+	if err != nil {
+		log.Panic().Err(err).Msg("unable to load registered streams")
+	}
+
+	dynamic.mu.Lock()
+	merged := dynamic.merge(currentConf())
+	storeConf(merged)
+	dynamic.mu.Unlock()
+
+	var events *eventBroker
+	if conf.Events {
+		events = newEventBroker()
+	}
+
+	return &ApiManagerCtx{
+		config:            conf,
+		profilesAvailable: true,
+
+		hlsConfig:   hlsConfig,
+		hlsTempDir:  hlsConf.TempDir,
+		hlsStore:    hlsStore,
+		hlsManagers: make(map[string]hls.Manager),
+		hlsStreams:  make(map[string]StreamConf),
+		hlsViewers:  make(map[string]*hls.Viewers),
+
+		dashConfig: dash.Config{
+			TempRoot:          tempRoot,
+			SingleProcess:     !conf.ProcessGroup,
+			ProcessLimits:     processLimits,
+			RetryAfter:        hlsConfig.RetryAfter(),
+			ContentProtection: hlsConf.DRM,
+		},
+		dashManagers: make(map[string]dash.Manager),
+
+		whepConfig: whep.Config{
+			ICEServers:    conf.ICEServers,
+			SingleProcess: !conf.ProcessGroup,
+			ProcessLimits: processLimits,
+			RetryAfter:    hlsConfig.RetryAfter(),
+		},
+		whepManagers: make(map[string]whep.Manager),
+
+		thumbnailsConfig:   thumbnailsConfig,
+		thumbnailsManagers: make(map[string]thumbnails.Manager),
+
+		subtitlesConfig:   subtitlesConfig,
+		subtitlesManagers: make(map[string]subtitles.Manager),
+
+		broadcastManagers: make(map[string]broadcast.Manager),
+		broadcastStreams:  make(map[string]StreamConf),
+
+		vodConfig:   vodConfig,
+		vodManagers: make(map[string]vod.Manager),
+		vodKeys:     make(map[string]string),
+
+		storyboards:     make(map[string]*thumbnails.StoryboardCtx),
+		storyboardDirs:  make(map[string]string),
+		storyboardsDir:  storyboardsDir,
+		storyboardsTemp: storyboardsTemp,
+
+		recordConfig:   recordConfig,
+		recordManagers: make(map[string]recording.Manager),
+		recordConfs:    make(map[string]RecordConf),
+
+		analytics: tracker,
+		auth:      verifier,
+		ingest:    ingestServer,
+
+		events:   events,
+		webhooks: sender,
+		helpers:  utils.NewSemaphore(conf.HelperConcurrency),
+		probes:   make(map[string]probeCacheEntry),
+		probing:  make(map[string]*probeCall),
+
+		clients: clients,
+
+		transcodes:        utils.NewSemaphore(hlsConf.MaxTranscodes),
+		profileTranscodes: profileTranscodes,
+		processLimits:     processLimits,
+		dynamic:           dynamic,
+
+		snapshots:  make(map[string]snapshotCacheEntry),
+		streamLogs: make(map[string]*streamLog),
+
+		encoders:        encoders,
+		encoderSessions: make(map[*exec.Cmd]hwaccel.Encoder),
+
+		status: StatusStarting,
+	}
+}
+
+func (a *ApiManagerCtx) Start() {
+	go a.watchProfiles()
+
+	if a.config.ConfigWatch {
+		go a.watchConfig()
+	}
+
+	if a.analytics != nil {
+		a.analytics.Start()
+	}
+
+	if a.webhooks != nil {
+		a.webhooks.Start()
+	}
+
+	// last viewers leaving are noticed without further requests
+	if a.events != nil || a.webhooks != nil {
+		go a.watchViewers()
+	}
+
+	if err := a.ingest.Start(); err != nil {
+		log.Panic().Err(err).Msg("unable to start rtmp ingest")
+	}
+
+	// srt listeners are opened only on start, not on reload
+	for name, stream := range currentConf().Streams {
+		if stream.SRT == nil {
+			continue
+		}
+
+		srtURL := stream.SRT.url()
+		a.ingest.Relay(stream.ingestPath(name), func(ctx context.Context) *exec.Cmd {
+			// remux to flv, as published to rtmp ingest
+			return exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-loglevel", "error",
+				"-i", srtURL, "-c", "copy", "-f", "flv", "pipe:1")
+		})
+	}
+
+	a.syncRecordings()
+}
+
+// Shutdown stops all transcodes, killing their process groups and
+// removing their tempdirs before it returns.
+func (a *ApiManagerCtx) Shutdown() {
+	a.hlsMu.Lock()
+	for _, manager := range a.hlsManagers {
+		manager.Shutdown()
+	}
+	a.hlsMu.Unlock()
+
+	if a.hlsConfig.Memory != nil {
 		//nolint
-		w.Write([]byte("pong"))
+		a.hlsConfig.Memory.Close()
+	}
+
+	a.dashMu.Lock()
+	for _, manager := range a.dashManagers {
+		manager.Shutdown()
+	}
+	a.dashMu.Unlock()
+
+	a.whepMu.Lock()
+	for _, manager := range a.whepManagers {
+		manager.Stop()
+	}
+	a.whepMu.Unlock()
+
+	a.thumbnailsMu.Lock()
+	for _, manager := range a.thumbnailsManagers {
+		manager.Shutdown()
+	}
+	a.thumbnailsMu.Unlock()
+
+	a.subtitlesMu.Lock()
+	for _, manager := range a.subtitlesManagers {
+		manager.Shutdown()
+	}
+	a.subtitlesMu.Unlock()
+
+	a.vodMu.Lock()
+	for _, manager := range a.vodManagers {
+		manager.Shutdown()
+	}
+	a.vodMu.Unlock()
+
+	a.storyboardsMu.Lock()
+	for _, storyboard := range a.storyboards {
+		storyboard.Shutdown()
+	}
+	if a.storyboardsTemp {
+		//nolint
+		os.RemoveAll(a.storyboardsDir)
+	}
+	a.storyboardsMu.Unlock()
+
+	a.broadcastMu.Lock()
+	for _, manager := range a.broadcastManagers {
+		manager.Stop()
+	}
+	a.broadcastMu.Unlock()
+
+	// recordings finalize their last files
+	a.recordMu.Lock()
+	for _, manager := range a.recordManagers {
+		manager.Shutdown()
+	}
+	a.recordMu.Unlock()
+
+	//nolint
+	a.ingest.Shutdown()
+
+	if a.analytics != nil {
+		a.analytics.Stop()
+	}
+
+	// stop events of managers are delivered
+	if a.webhooks != nil {
+		a.webhooks.Stop()
+	}
+
+	a.closeStreamLogs()
+}
+
+func (a *ApiManagerCtx) Mount(r *chi.Mux) {
+	// admin and helper routes
+	r.Group(func(r chi.Router) {
+		r.Use(a.requestTimeout)
+
+		r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+			//nolint
+			w.Write([]byte("pong"))
+		})
+
+		r.Get("/healthz", a.Health)
+		r.Get("/readyz", a.Ready)
+
+		// accessible only by tokens allowing all streams, when
+		// authentication is configured
+		r.Group(func(r chi.Router) {
+			r.Use(a.authenticateAdmin)
+
+			r.Group(a.Streams)
+			r.Group(a.Management)
+			r.Group(a.Admin)
+			r.Group(a.Dashboard)
+
+			if a.config.StreamsAPI {
+				r.Group(a.DynamicStreams)
+			}
+
+			if a.config.Metrics {
+				r.Get("/metrics", a.Metrics)
+			}
+		})
+
+		// debug token replaces admin token, both are passed as bearer
+		if a.config.Debug && a.config.DebugToken != "" {
+			r.With(a.debugAuth).Get("/debug/transcode", a.Debug)
+		} else if a.config.Debug {
+			r.With(a.authenticateAdmin).Get("/debug/transcode", a.Debug)
+		}
+	})
+
+	// transcode starts wait for probing and start, not bound by request
+	// timeout
+	r.Group(func(r chi.Router) {
+		r.Use(a.authenticateAdmin)
+
+		r.Group(a.ManagementStart)
+	})
+
+	// long lived, not bound by request timeout
+	if a.events != nil {
+		r.Group(func(r chi.Router) {
+			r.Use(a.authenticateAdmin)
+
+			r.Get("/events", a.Events)
+			r.Get("/api/events", a.Events)
+		})
+	}
+
+	// stream endpoints, limited and authenticated when configured
+	r.Group(func(r chi.Router) {
+		r.Use(a.limitClients)
+		r.Use(a.authenticate)
+
+		r.Group(a.HLS)
+		r.Group(a.DASH)
+		r.Group(a.WHEP)
+		r.Group(a.Thumbnails)
+		r.Group(a.VOD)
+		r.Group(a.Http)
+		r.Group(a.Player)
 	})
+}
+
+// requestTimeout bounds admin routes, streaming and playlist
+// long-poll routes must not use it.
+func (a *ApiManagerCtx) requestTimeout(next http.Handler) http.Handler {
+	if a.config.RequestTimeout <= 0 {
+		return next
+	}
 
-	r.Group(a.HLS)
-	r.Group(a.Http)
+	return http.TimeoutHandler(next, a.config.RequestTimeout, "503 request timeout")
 }
 
-func transcodeStart(folder string, profile string, input string) (*exec.Cmd, error) {
-	url, ok := conf.Streams[input]
+// transcodeSource selects source of stream to be transcoded, primary one
+// by default.
+type transcodeSource struct {
+	// fallback source, without source specific input options
+	fallback bool
+	// primary http source, reconnecting on errors
+	reconnect bool
+	// backup source counted from 1
+	backup int
+}
+
+func (a *ApiManagerCtx) transcodeStart(mode string, profile string, input string) (*exec.Cmd, error) {
+	return a.transcodeCmd(mode, profile, input, transcodeSource{}, nil, hlsTrack{})
+}
+
+// transcodeTrackStart transcodes selected track of stream.
+func (a *ApiManagerCtx) transcodeTrackStart(mode string, profile string, input string, track hlsTrack) (*exec.Cmd, error) {
+	return a.transcodeCmd(mode, profile, input, transcodeSource{}, nil, track)
+}
+
+// transcodeFallbackStart transcodes fallback source of stream, without
+// source specific input options.
+func (a *ApiManagerCtx) transcodeFallbackStart(mode string, profile string, input string) (*exec.Cmd, error) {
+	return a.transcodeCmd(mode, profile, input, transcodeSource{fallback: true}, nil, hlsTrack{})
+}
+
+// transcodeReconnectStart transcodes selected track of stream, reconnecting
+// to its http source on errors.
+func (a *ApiManagerCtx) transcodeReconnectStart(mode string, profile string, input string, track hlsTrack) (*exec.Cmd, error) {
+	return a.transcodeCmd(mode, profile, input, transcodeSource{reconnect: true}, nil, track)
+}
+
+// transcodeBackupStart transcodes selected track of backup source of
+// stream, counted from 1.
+func (a *ApiManagerCtx) transcodeBackupStart(mode string, profile string, input string, backup int, track hlsTrack) (*exec.Cmd, error) {
+	return a.transcodeCmd(mode, profile, input, transcodeSource{backup: backup}, nil, track)
+}
+
+// transcodeClipStart transcodes only given range of vod source.
+func (a *ApiManagerCtx) transcodeClipStart(mode string, profile string, input string, clip clipRange) (*exec.Cmd, error) {
+	return a.transcodeCmd(mode, profile, input, transcodeSource{}, &clip, hlsTrack{})
+}
+
+func (a *ApiManagerCtx) transcodeCmd(mode string, profile string, input string, source transcodeSource, clip *clipRange, track hlsTrack) (*exec.Cmd, error) {
+	stream, ok := currentConf().stream(input)
 	if !ok {
-		return nil, fmt.Errorf("stream not found")
+		return nil, ErrStreamNotFound
 	}
 
-	re := regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
-	if !re.MatchString(profile) {
-		return nil, fmt.Errorf("invalid profile path")
+	fallback := source.fallback
+	switch {
+	case fallback:
+		if stream.FallbackSource == "" {
+			return nil, fmt.Errorf("stream has no fallback source")
+		}
+
+		stream.Source = stream.FallbackSource
+		stream.Reconnect = false
+		stream.RTSPTransport = ""
+	case source.backup > 0:
+		if source.backup > len(stream.BackupSources) {
+			return nil, fmt.Errorf("stream has no backup source %d", source.backup)
+		}
+
+		// source specific options are kept, when backup supports them
+		stream.Source = stream.BackupSources[source.backup-1]
+		if scheme := stream.scheme(); scheme != "http" && scheme != "https" {
+			stream.Reconnect = false
+			stream.ReconnectDelayMax = 0
+		}
+		if scheme := stream.scheme(); scheme != "rtsp" && scheme != "rtsps" {
+			stream.RTSPTransport = ""
+		}
+	case source.reconnect:
+		stream.Reconnect = true
 	}
 
-	profilePath := fmt.Sprintf("/app/%s/%s.sh", folder, profile)
-	if _, err := os.Stat(profilePath); os.IsNotExist(err) {
+	if err := profileAllowed(profile, input); err != nil {
 		return nil, err
 	}
 
-	log.Info().Str("profilePath", profilePath).Str("url", url).Msg("command startred")
-	return exec.Command(profilePath, url), nil
+	profilePath, err := a.profilePath(mode, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	ingested := stream.ingestPath(input) != "" && !fallback
+
+	// codecs of other audio tracks are not probed
+	if copyPath, ok := a.passthroughPath(mode, profilePath, stream, ingested, clip); ok && track.audio == 0 && track.burnSubtitles == 0 {
+		log.Info().Str("profile", profile).Str("input", input).Msg("source is compatible, remuxing using copy profile")
+		profilePath = copyPath
+	}
+
+	// subtitles of ingested flv are lost, fallback is shown without them
+	var burn *burnSubtitles
+	if track.burnSubtitles > 0 && !fallback {
+		if ingested {
+			return nil, fmt.Errorf("%w: ingested streams carry no subtitles", ErrInvalidBurnSubtitles)
+		}
+
+		burn, err = a.profileBurnSubtitles(profile, profilePath, stream.Source, track.burnSubtitles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var declared *yamlProfile
+	if isYAMLProfile(profilePath) {
+		declared, err = a.loadStreamYAMLProfile(profilePath, mode, profile, input, stream, !ingested)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// ingested flv is piped to stdin, it can not be probed beforehand
+	var stdin io.ReadCloser
+	if ingested {
+		stdin, err = a.ingest.Subscribe(stream.ingestPath(input))
+		if err != nil {
+			return nil, err
+		}
+
+		stream.Source = "pipe:0"
+		if stream.Audio == "" || stream.Audio == AudioAuto {
+			stream.Audio = AudioTranscode
+		}
+	}
+
+	options := a.streamProfileOptions(stream)
+	options.PartDuration = a.hlsConfig.PartDuration
+	options.Clip = clip
+	options.AudioTrack = track.audio
+	options.AudioOnly = track.audioOnly
+	options.BurnSubtitles = burn
+
+	// source already fitting profile is remuxed, except for webrtc needing
+	// baseline video, ingested sources can not be probed
+	if declared != nil && declared.Video.Remux && mode != profileModeWHEP && !ingested && len(options.videoFilterOptions()) == 0 {
+		if probe, err := a.probeCmd(stream.Source); err != nil {
+			log.Warn().Err(err).Str("source", stream.Source).Msg("unable to probe video, transcoding")
+		} else if sourceFitsVideo(probe, declared.Video) {
+			log.Debug().Str("profile", profile).Str("input", input).Msg("source video fits profile, remuxing")
+			declared.Video = yamlProfileVideo{Codec: "copy"}
+		}
+	}
+
+	copiesVideo := declared == nil && profileCopiesVideo(profilePath) || declared != nil && declared.Video.Codec == "copy"
+	if filters := options.videoFilterOptions(); len(filters) > 0 && copiesVideo {
+		if stdin != nil {
+			stdin.Close()
+		}
+		return nil, fmt.Errorf("%s can not be combined with copy profile %s", strings.Join(filters, ", "), profile)
+	}
+
+	var cmd *exec.Cmd
+	if declared != nil {
+		args, encoder, err := a.yamlProfileArgs(mode, declared, stream.Source, options)
+		if err != nil {
+			if stdin != nil {
+				stdin.Close()
+			}
+			return nil, err
+		}
+
+		log.Info().Str("profilePath", profilePath).Str("url", stream.Source).Msg("command startred")
+		cmd = exec.Command("ffmpeg", args...)
+		cmd.Env = os.Environ()
+		if options.AudioOnly {
+			cmd.Env = append(cmd.Env, audioOnlyEnv)
+		}
+		if encoder != nil {
+			a.holdEncoder(cmd, *encoder)
+		}
+	} else {
+		cmd = exec.Command(profilePath, stream.Source)
+
+		env := options.env()
+		if profileEncodesH264(profilePath) {
+			encoder, err := a.pickEncoder()
+			if err != nil {
+				if stdin != nil {
+					stdin.Close()
+				}
+				return nil, err
+			}
+			env = append(env, encoder.Env()...)
+			log.Debug().Str("profile", profile).Str("input", input).Str("encoder", encoder.Name).Msg("picked h264 encoder")
+			a.holdEncoder(cmd, encoder)
+		}
+
+		log.Info().Str("profilePath", profilePath).Str("url", stream.Source).Msg("command startred")
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	return cmd, nil
 }
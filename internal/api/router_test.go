@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// slowHLSManager serves playlists after given delay, as when waiting for
+// transcode to start.
+type slowHLSManager struct {
+	testHLSManager
+
+	delay time.Duration
+}
+
+func (m *slowHLSManager) ServePlaylist(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(m.delay)
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Write([]byte("#EXTM3U\n"))
+}
+
+// testProfiles creates profiles directory with hls profile.
+func testProfiles(t *testing.T, profile string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, profileModeHLS), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, profileModeHLS, profile+".sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// testSlowProbe puts fake ffprobe on path, that takes given time.
+func testSlowProbe(t *testing.T, delay string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	script := "#!/bin/sh\nsleep " + delay + "\necho '{\"streams\":[{\"codec_type\":\"audio\",\"codec_name\":\"aac\"}]}'\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffprobe"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRequestTimeout(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{
+		"cam":   {Source: "rtsp://camera/stream"},
+		"lobby": {Source: "rtsp://lobby/stream"},
+		"hall":  {Source: "rtsp://hall/stream"},
+	}})
+	testSlowProbe(t, "0.3")
+
+	a := newTestApi()
+	a.config.RequestTimeout = 100 * time.Millisecond
+	a.config.Profiles = testProfiles(t, "h264_720p")
+	a.hlsManagers["h264_720p/cam"] = &slowHLSManager{delay: 300 * time.Millisecond}
+	defer func() {
+		for _, manager := range a.hlsManagers {
+			manager.Stop()
+		}
+	}()
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	// admin route taking longer than request timeout
+	r.With(a.requestTimeout).Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{"admin", http.MethodGet, "/slow", http.StatusServiceUnavailable},
+		{"helper", http.MethodGet, "/ping", http.StatusOK},
+		{"stream", http.MethodGet, "/h264_720p/cam/index.m3u8", http.StatusOK},
+		// source is probed before start, taking longer than request timeout
+		{"managed start", http.MethodPost, "/api/streams/lobby/h264_720p/start", http.StatusNoContent},
+		{"managed restart", http.MethodPost, "/api/streams/lobby/h264_720p/restart", http.StatusNoContent},
+		{"explicit start", http.MethodPost, "/streams/hall/h264_720p/start", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, server.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != tt.want {
+				t.Errorf("got status %d, want %d", res.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/hls"
+)
+
+// how long can grabbing single frame take
+const snapshotTimeout = 15 * time.Second
+
+// snapshotSize parses requested dimensions, zero when not requested.
+// Oversized dimensions are clamped to maximum, or rejected.
+func (a *ApiManagerCtx) snapshotSize(r *http.Request) (int, int, error) {
+	size := func(name string, max int) (int, error) {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			return 0, nil
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid %s", name)
+		}
+
+		if n > max {
+			if !a.config.SnapshotClamp {
+				return 0, fmt.Errorf("%s must be at most %d", name, max)
+			}
+			n = max
+		}
+
+		return n, nil
+	}
+
+	width, err := size("w", a.config.SnapshotMaxWidth)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	height, err := size("h", a.config.SnapshotMaxHeight)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return width, height, nil
+}
+
+// snapshotFilter fits frame into requested box keeping aspect ratio, missing
+// dimensions are bounded by maximum. Source is not upscaled unless requested.
+func (a *ApiManagerCtx) snapshotFilter(width int, height int) string {
+	if width == 0 && height == 0 {
+		return fmt.Sprintf("scale=w='min(iw,%d)':h='min(ih,%d)':force_original_aspect_ratio=decrease",
+			a.config.SnapshotMaxWidth, a.config.SnapshotMaxHeight)
+	}
+
+	if width == 0 {
+		width = a.config.SnapshotMaxWidth
+	}
+
+	if height == 0 {
+		height = a.config.SnapshotMaxHeight
+	}
+
+	return fmt.Sprintf("scale=w=%d:h=%d:force_original_aspect_ratio=decrease", width, height)
+}
+
+// errSnapshotQueue is returned when snapshot could not get helper slot.
+var errSnapshotQueue = errors.New("snapshot helper unavailable")
+
+// snapshot of newest segment of stream, that is reused until newer one is
+// listed
+type snapshotCacheEntry struct {
+	segment string
+	image   []byte
+	modTime time.Time
+}
+
+// Snapshot serves single jpeg frame of stream source.
+func (a *ApiManagerCtx) Snapshot(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	stream, ok := currentConf().stream(name)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 stream not found"))
+		return
+	}
+
+	if stream.ingestPath(name) != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 snapshots of ingested streams are not supported"))
+		return
+	}
+
+	width, height, err := a.snapshotSize(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 " + err.Error()))
+		return
+	}
+
+	input := append(stream.inputOptions(), "-i", stream.Source)
+	image, err := a.grabSnapshot(r.Context(), name, input, nil, width, height)
+	if err != nil {
+		writeSnapshotError(w, err)
+		return
+	}
+
+	writeSnapshot(w, image, time.Time{})
+}
+
+// LiveSnapshot serves latest keyframe of stream, decoded from newest
+// segment of its running transcode, so that source is not opened again.
+// Streams without running transcode are grabbed from their source.
+func (a *ApiManagerCtx) LiveSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if _, ok := currentConf().stream(name); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 stream not found"))
+		return
+	}
+
+	width, height, err := a.snapshotSize(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 " + err.Error()))
+		return
+	}
+
+	manager, segment, ok := a.latestSegment(name)
+	if !ok {
+		a.Snapshot(w, r)
+		return
+	}
+
+	key := fmt.Sprintf("%s/%dx%d", name, width, height)
+	segmentKey := fmt.Sprintf("%s/%d", segment.Name, segment.Sequence)
+
+	a.snapshotsMu.Lock()
+	entry, ok := a.snapshots[key]
+	a.snapshotsMu.Unlock()
+
+	if ok && entry.segment == segmentKey {
+		writeSnapshot(w, entry.image, entry.modTime)
+		return
+	}
+
+	data, err := manager.ReadSegment(segment.Name)
+	if err != nil {
+		// removed meanwhile
+		log.Debug().Err(err).Str("stream", name).Str("segment", segment.Name).Msg("unable to read segment, grabbing source")
+		a.Snapshot(w, r)
+		return
+	}
+
+	// segments start with keyframe
+	input := []string{"-f", "mpegts", "-i", "pipe:0"}
+	image, err := a.grabSnapshot(r.Context(), name, input, data, width, height)
+	if err != nil {
+		writeSnapshotError(w, err)
+		return
+	}
+
+	a.snapshotsMu.Lock()
+	for k, entry := range a.snapshots {
+		// snapshots of streams no longer transcoded
+		if time.Since(entry.modTime) > time.Minute {
+			delete(a.snapshots, k)
+		}
+	}
+	a.snapshots[key] = snapshotCacheEntry{
+		segment: segmentKey,
+		image:   image,
+		modTime: segment.ModTime,
+	}
+	a.snapshotsMu.Unlock()
+
+	writeSnapshot(w, image, segment.ModTime)
+}
+
+// latestSegment returns newest mpegts segment of running transcodes of
+// stream in default track. Audio only tracks and fragmented mp4 segments
+// without init segment can not be decoded.
+func (a *ApiManagerCtx) latestSegment(name string) (hls.Manager, hls.Segment, bool) {
+	a.hlsMu.Lock()
+	managers := []hls.Manager{}
+	for id, manager := range a.hlsManagers {
+		profile, input := path.Split(id)
+		if input == name && !strings.Contains(profile, "@") {
+			managers = append(managers, manager)
+		}
+	}
+	a.hlsMu.Unlock()
+
+	var latest hls.Manager
+	var segment hls.Segment
+	for _, manager := range managers {
+		segments, ok := manager.Segments()
+		if !ok || len(segments) == 0 {
+			continue
+		}
+
+		last := segments[len(segments)-1]
+		if !strings.HasSuffix(path.Base(last.Name), ".ts") || last.ModTime.IsZero() {
+			continue
+		}
+
+		if latest == nil || last.ModTime.After(segment.ModTime) {
+			latest, segment = manager, last
+		}
+	}
+
+	return latest, segment, latest != nil
+}
+
+// grabSnapshot returns jpeg of first frame of input, written by ffmpeg
+// run as helper command. Stdin is piped to it, when set.
+func (a *ApiManagerCtx) grabSnapshot(ctx context.Context, name string, input []string, stdin []byte, width int, height int) ([]byte, error) {
+	release, err := a.acquireHelper(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errSnapshotQueue, err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	args = append(args, input...)
+	args = append(args,
+		"-an", "-frames:v", "1",
+		"-vf", a.snapshotFilter(width, height),
+		"-f", "image2", "-c:v", "mjpeg", "-",
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+
+	if err := cmd.Run(); err != nil {
+		log.Warn().Err(err).Str("stream", name).Str("stderr", strings.TrimSpace(stderr.String())).Msg("snapshot failed")
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func writeSnapshotError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errSnapshotQueue):
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 " + err.Error()))
+	case errors.Is(err, context.DeadlineExceeded):
+		w.WriteHeader(http.StatusGatewayTimeout)
+		w.Write([]byte("504 snapshot timeout"))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 snapshot failed"))
+	}
+}
+
+// writeSnapshot serves jpeg, modification time is of its segment when
+// known.
+func writeSnapshot(w http.ResponseWriter, image []byte, modTime time.Time) {
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	w.Write(image)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSnapshotSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		clamp  bool
+		width  int
+		height int
+		err    bool
+	}{
+		{"not requested", "", false, 0, 0, false},
+		{"within maximum", "w=640&h=360", false, 640, 360, false},
+		{"width only", "w=640", false, 640, 0, false},
+		{"at maximum", "w=1920&h=1080", false, 1920, 1080, false},
+		{"over maximum rejected", "w=3840", false, 0, 0, true},
+		{"over maximum clamped", "w=3840&h=2160", true, 1920, 1080, false},
+		{"height over maximum clamped", "w=640&h=2160", true, 640, 1080, false},
+		{"zero", "w=0", false, 0, 0, true},
+		{"zero clamped", "h=0", true, 0, 0, true},
+		{"negative", "w=-640", false, 0, 0, true},
+		{"negative clamped", "h=-1", true, 0, 0, true},
+		{"not a number", "w=wide", false, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApi()
+			a.config.SnapshotMaxWidth = 1920
+			a.config.SnapshotMaxHeight = 1080
+			a.config.SnapshotClamp = tt.clamp
+
+			width, height, err := a.snapshotSize(httptest.NewRequest(http.MethodGet, "/streams/cam/snapshot.jpg?"+tt.query, nil))
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if width != tt.width || height != tt.height {
+				t.Errorf("got %dx%d, want %dx%d", width, height, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestSnapshotFilter(t *testing.T) {
+	a := newTestApi()
+	a.config.SnapshotMaxWidth = 1920
+	a.config.SnapshotMaxHeight = 1080
+
+	// frame is fit into box, keeping its aspect ratio
+	tests := []struct {
+		name   string
+		width  int
+		height int
+		want   string
+	}{
+		{"source bounded by maximum", 0, 0, "scale=w='min(iw,1920)':h='min(ih,1080)':force_original_aspect_ratio=decrease"},
+		{"box", 640, 640, "scale=w=640:h=640:force_original_aspect_ratio=decrease"},
+		{"width only", 640, 0, "scale=w=640:h=1080:force_original_aspect_ratio=decrease"},
+		{"height only", 0, 360, "scale=w=1920:h=360:force_original_aspect_ratio=decrease"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.snapshotFilter(tt.width, tt.height); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
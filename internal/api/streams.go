@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/m1k1o/go-transcode/hls"
+)
+
+type streamStats struct {
+	Name string `json:"name"`
+	// hls stats by profile
+	HLS map[string]hls.Stats `json:"hls"`
+	// served bytes summed over profiles, when accounting is enabled
+	Bandwidth *hls.BandwidthStats `json:"bandwidth,omitempty"`
+}
+
+func (a *ApiManagerCtx) Streams(r chi.Router) {
+	r.Get("/streams", func(w http.ResponseWriter, r *http.Request) {
+		names := make([]string, 0, len(currentConf().Streams))
+		for name := range currentConf().Streams {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		res := make([]streamStats, 0, len(names))
+		for _, name := range names {
+			res = append(res, a.streamStats(name))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint
+		json.NewEncoder(w).Encode(res)
+	})
+
+	r.Get("/streams/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		if _, ok := currentConf().stream(name); !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 stream not found"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		//nolint
+		json.NewEncoder(w).Encode(a.streamStats(name))
+	})
+
+	r.Get("/streams/{name}/snapshot.jpg", a.Snapshot)
+
+	if a.config.SegmentsJSON {
+		r.Get("/streams/{name}/segments.json", func(w http.ResponseWriter, r *http.Request) {
+			name := chi.URLParam(r, "name")
+			if _, ok := currentConf().stream(name); !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("404 stream not found"))
+				return
+			}
+
+			res := a.streamSegments(name)
+			if len(res) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("404 stream not active"))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+
+			//nolint
+			json.NewEncoder(w).Encode(res)
+		})
+	}
+
+	// resets served bytes accounting of all stream profiles
+	r.Post("/streams/{name}/bandwidth/reset", func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		if _, ok := currentConf().stream(name); !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 stream not found"))
+			return
+		}
+
+		for _, manager := range a.hlsManagersOf(name) {
+			manager.ResetBandwidth()
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// explicitStart starts hls stream, even if it reached max duration.
+func (a *ApiManagerCtx) explicitStart(w http.ResponseWriter, r *http.Request) {
+	name, profile := chi.URLParam(r, "name"), chi.URLParam(r, "profile")
+	if _, ok := currentConf().stream(name); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 stream not found"))
+		return
+	}
+
+	if err := profileAllowed(profile, name); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 " + err.Error()))
+		return
+	}
+
+	if _, err := a.profilePath(profileModeHLS, profile); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err := a.hlsManager(profile, name).Start()
+	if err != nil && !errors.Is(err, hls.ErrAlreadyStarted) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *ApiManagerCtx) streamStats(name string) streamStats {
+	stats := streamStats{
+		Name: name,
+		HLS:  map[string]hls.Stats{},
+	}
+
+	for id, manager := range a.hlsManagersOf(name) {
+		profile, _ := splitManagerID(id)
+
+		s := manager.Stats()
+		stats.HLS[profile] = s
+
+		if s.Bandwidth == nil {
+			continue
+		}
+
+		if stats.Bandwidth == nil {
+			stats.Bandwidth = &hls.BandwidthStats{Since: s.Bandwidth.Since}
+		}
+
+		stats.Bandwidth.Total += s.Bandwidth.Total
+		stats.Bandwidth.Window += s.Bandwidth.Window
+		if s.Bandwidth.Since.Before(stats.Bandwidth.Since) {
+			stats.Bandwidth.Since = s.Bandwidth.Since
+		}
+	}
+
+	return stats
+}
+
+// streamSegments returns hls segments by profile of active managers.
+func (a *ApiManagerCtx) streamSegments(name string) map[string][]hls.Segment {
+	segments := map[string][]hls.Segment{}
+
+	for id, manager := range a.hlsManagersOf(name) {
+		profile, _ := splitManagerID(id)
+
+		if s, ok := manager.Segments(); ok {
+			segments[profile] = s
+		}
+	}
+
+	return segments
+}
+
+// hlsManagersOf returns copy of hls managers by id of stream, or of all
+// streams when name is empty. Managers take their own locks, so they are
+// used once global lock is released.
+func (a *ApiManagerCtx) hlsManagersOf(name string) map[string]hls.Manager {
+	a.hlsMu.Lock()
+	defer a.hlsMu.Unlock()
+
+	managers := map[string]hls.Manager{}
+	for id, manager := range a.hlsManagers {
+		if _, input := splitManagerID(id); name == "" || input == name {
+			managers[id] = manager
+		}
+	}
+
+	return managers
+}
+
+// splitManagerID splits <profile>/<input> manager id.
+func splitManagerID(id string) (string, string) {
+	i := strings.Index(id, "/")
+	if i < 0 {
+		return "", id
+	}
+	return id[:i], id[i+1:]
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/m1k1o/go-transcode/hls"
+)
+
+// testStatsManager is hls manager reporting given stats.
+type testStatsManager struct {
+	testHLSManager
+	stats hls.Stats
+}
+
+func (m *testStatsManager) Stats() hls.Stats {
+	return m.stats
+}
+
+func TestStreamStatsLastError(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Source: "rtsp://camera/stream"}}})
+
+	failed := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	a := newTestApi()
+	a.hlsManagers["h264_720p/cam"] = &testStatsManager{stats: hls.Stats{
+		LastError: &hls.StreamError{Message: "exit status 1", Time: failed},
+	}}
+	a.hlsManagers["h264_360p/cam"] = &testStatsManager{stats: hls.Stats{Running: true, Active: true}}
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/streams/cam", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	res := struct {
+		HLS map[string]struct {
+			LastError *struct {
+				Message string `json:"message"`
+				Time    string `json:"time"`
+			} `json:"last_error"`
+		} `json:"hls"`
+	}{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	lastError := res.HLS["h264_720p"].LastError
+	if lastError == nil {
+		t.Fatalf("last error missing: %+v", res)
+	}
+	if lastError.Message != "exit status 1" || lastError.Time != "2026-10-15T12:00:00Z" {
+		t.Errorf("got last error %+v", lastError)
+	}
+
+	if lastError := res.HLS["h264_360p"].LastError; lastError != nil {
+		t.Errorf("got last error %+v of healthy stream", lastError)
+	}
+}
+
+// testSegmentsManager is hls manager listing given segments, while active.
+type testSegmentsManager struct {
+	testHLSManager
+	segments []hls.Segment
+}
+
+func (m *testSegmentsManager) Segments() ([]hls.Segment, bool) {
+	return m.segments, m.segments != nil
+}
+
+func TestSegmentsJSON(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{
+		"cam":   {Source: "rtsp://camera/stream"},
+		"lobby": {Source: "rtsp://lobby/stream"},
+	}})
+
+	modified := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	a := newTestApi()
+	a.config.SegmentsJSON = true
+	a.hlsManagers["h264_720p/cam"] = &testSegmentsManager{segments: []hls.Segment{
+		{Name: "index5.ts", Duration: 2, Sequence: 5, ModTime: modified, Size: 1024},
+		{Name: "index6.ts", Duration: 1.5, Sequence: 6, ModTime: modified, Size: 512},
+	}}
+	// inactive manager is left out
+	a.hlsManagers["h264_360p/cam"] = &testSegmentsManager{}
+	a.hlsManagers["h264_720p/lobby"] = &testSegmentsManager{}
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/streams/cam/segments.json", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("got content type %q, want %q", got, "application/json")
+	}
+
+	want := `{"h264_720p":[` +
+		`{"name":"index5.ts","duration":2,"sequence":5,"mtime":"2026-10-15T12:00:00Z","size":1024},` +
+		`{"name":"index6.ts","duration":1.5,"sequence":6,"mtime":"2026-10-15T12:00:00Z","size":512}]}` + "\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"/streams/lobby/segments.json", http.StatusNotFound},
+		{"/streams/unknown/segments.json", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestSegmentsJSONDisabled(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Source: "rtsp://camera/stream"}}})
+
+	a := newTestApi()
+	a.hlsManagers["h264_720p/cam"] = &testSegmentsManager{segments: []hls.Segment{{Name: "index0.ts"}}}
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/streams/cam/segments.json", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// testBandwidthManager is hls manager accounting given served bytes.
+type testBandwidthManager struct {
+	testStatsManager
+	resets int
+}
+
+func (m *testBandwidthManager) ResetBandwidth() {
+	m.resets++
+}
+
+func TestStreamStatsBandwidth(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Source: "rtsp://camera/stream"}}})
+
+	since := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	a := newTestApi()
+	hd := &testBandwidthManager{testStatsManager: testStatsManager{stats: hls.Stats{
+		Bandwidth: &hls.BandwidthStats{Total: 1500, Window: 500, Since: since.Add(time.Hour)},
+	}}}
+	sd := &testBandwidthManager{testStatsManager: testStatsManager{stats: hls.Stats{
+		Bandwidth: &hls.BandwidthStats{Total: 700, Window: 100, Since: since},
+	}}}
+	a.hlsManagers["h264_720p/cam"] = hd
+	a.hlsManagers["h264_360p/cam"] = sd
+
+	r := chi.NewRouter()
+	a.Mount(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/streams/cam", nil))
+
+	res := struct {
+		HLS map[string]struct {
+			Bandwidth hls.BandwidthStats `json:"bandwidth"`
+		} `json:"hls"`
+		Bandwidth *hls.BandwidthStats `json:"bandwidth"`
+	}{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := res.HLS["h264_720p"].Bandwidth; got.Total != 1500 || got.Window != 500 {
+		t.Errorf("got profile bandwidth %+v", got)
+	}
+
+	// summed over profiles, since earliest of them
+	want := hls.BandwidthStats{Total: 2200, Window: 600, Since: since}
+	if res.Bandwidth == nil || *res.Bandwidth != want {
+		t.Errorf("got stream bandwidth %+v, want %+v", res.Bandwidth, want)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/streams/cam/bandwidth/reset", nil))
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if hd.resets != 1 || sd.resets != 1 {
+		t.Errorf("got %d and %d resets, want every profile reset", hd.resets, sd.resets)
+	}
+}
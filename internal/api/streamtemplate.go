@@ -0,0 +1,239 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// placeholder of stream template, e.g. {id} of cam_{id}
+var placeholderRegex = regexp.MustCompile(`\{([0-9A-Za-z_]+)\}`)
+
+// PlaceholderConf is allowlist of placeholder values, given either as
+// regex the whole value must match or as list of allowed values.
+type PlaceholderConf struct {
+	Regex  string
+	Values []string
+}
+
+// UnmarshalYAML allows placeholder to be specified by regex or by list.
+func (p *PlaceholderConf) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var values []string
+	if err := unmarshal(&values); err == nil {
+		p.Values = values
+		return nil
+	}
+
+	return unmarshal(&p.Regex)
+}
+
+// expr returns regex matching allowed values.
+func (p PlaceholderConf) expr() (string, error) {
+	if len(p.Values) > 0 {
+		values := make([]string, len(p.Values))
+		for i, value := range p.Values {
+			if !streamNameRegex.MatchString(value) {
+				return "", fmt.Errorf("invalid value %q", value)
+			}
+			values[i] = regexp.QuoteMeta(value)
+		}
+		return strings.Join(values, "|"), nil
+	}
+
+	if p.Regex == "" {
+		return "", errors.New("regex or list of values is required")
+	}
+
+	if _, err := regexp.Compile(p.Regex); err != nil {
+		return "", err
+	}
+
+	return p.Regex, nil
+}
+
+// streamTemplate defines streams, that are not listed in config, but whose
+// names match its pattern. Placeholders of its fields are replaced by
+// values matched in stream name.
+type streamTemplate struct {
+	pattern string
+	regex   *regexp.Regexp
+	stream  StreamConf
+}
+
+func newStreamTemplate(pattern string, stream StreamConf, placeholders map[string]PlaceholderConf) (*streamTemplate, error) {
+	// names of streams are used in urls and paths of tempdirs
+	if !streamNameRegex.MatchString(placeholderRegex.ReplaceAllString(pattern, "_")) {
+		return nil, fmt.Errorf("invalid stream name pattern")
+	}
+
+	// started without requests, template streams are known only once requested
+	switch {
+	case stream.Ingest != "" || stream.SRT != nil:
+		return nil, fmt.Errorf("ingested streams can not be templated")
+	case len(stream.Preload) > 0:
+		return nil, fmt.Errorf("preload is not supported by stream templates")
+	case stream.Record != nil:
+		return nil, fmt.Errorf("record is not supported by stream templates")
+	}
+
+	expr := "^"
+	used := map[string]bool{}
+	last := 0
+	for _, match := range placeholderRegex.FindAllStringSubmatchIndex(pattern, -1) {
+		name := pattern[match[2]:match[3]]
+		if used[name] {
+			return nil, fmt.Errorf("placeholder {%s} is used more than once", name)
+		}
+		used[name] = true
+
+		placeholder, ok := placeholders[name]
+		if !ok {
+			return nil, fmt.Errorf("placeholder {%s} has no allowlist in placeholders", name)
+		}
+
+		values, err := placeholder.expr()
+		if err != nil {
+			return nil, fmt.Errorf("placeholder {%s}: %w", name, err)
+		}
+
+		expr += regexp.QuoteMeta(pattern[last:match[0]]) + "(?P<" + name + ">" + values + ")"
+		last = match[1]
+	}
+	expr += regexp.QuoteMeta(pattern[last:]) + "$"
+
+	// placeholders of fields must be matched in name
+	for _, field := range stream.templated() {
+		for _, match := range placeholderRegex.FindAllStringSubmatch(field, -1) {
+			if !used[match[1]] {
+				return nil, fmt.Errorf("placeholder {%s} is not part of stream name", match[1])
+			}
+		}
+	}
+
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamTemplate{
+		pattern: pattern,
+		regex:   regex,
+		stream:  stream,
+	}, nil
+}
+
+// expand returns stream of given name, when it matches pattern and its
+// expansion is valid.
+func (t *streamTemplate) expand(name string) (StreamConf, bool) {
+	match := t.regex.FindStringSubmatch(name)
+	if match == nil {
+		return StreamConf{}, false
+	}
+
+	replacements := []string{}
+	for i, placeholder := range t.regex.SubexpNames() {
+		if placeholder == "" {
+			continue
+		}
+
+		// values are inserted into urls and paths, regex of allowlist
+		// must not let them escape
+		if !streamNameRegex.MatchString(match[i]) {
+			return StreamConf{}, false
+		}
+
+		replacements = append(replacements, "{"+placeholder+"}", match[i])
+	}
+
+	replacer := strings.NewReplacer(replacements...)
+
+	stream := t.stream
+	stream.Source = replacer.Replace(stream.Source)
+	stream.FallbackSource = replacer.Replace(stream.FallbackSource)
+	stream.TempDir = replacer.Replace(stream.TempDir)
+
+	stream.BackupSources = make([]string, len(t.stream.BackupSources))
+	for i, source := range t.stream.BackupSources {
+		stream.BackupSources[i] = replacer.Replace(source)
+	}
+
+	if t.stream.Vars != nil {
+		stream.Vars = make(map[string]string, len(t.stream.Vars))
+		for key, value := range t.stream.Vars {
+			stream.Vars[key] = replacer.Replace(value)
+		}
+	}
+
+	if err := stream.validate(); err != nil {
+		log.Warn().Err(err).Str("stream", name).Str("pattern", t.pattern).Msg("invalid expansion of stream template")
+		return StreamConf{}, false
+	}
+
+	if err := validateSource(stream.Source); err != nil {
+		log.Warn().Err(err).Str("stream", name).Str("pattern", t.pattern).Msg("invalid expansion of stream template")
+		return StreamConf{}, false
+	}
+
+	return stream, true
+}
+
+// templated returns fields of stream, in which placeholders are replaced.
+func (s *StreamConf) templated() []string {
+	fields := []string{s.Source, s.FallbackSource, s.TempDir}
+	fields = append(fields, s.BackupSources...)
+	for _, value := range s.Vars {
+		fields = append(fields, value)
+	}
+	return fields
+}
+
+// splitTemplates moves streams with placeholders in their names out of
+// listed streams into templates, that are matched in order of patterns.
+func (c *YamlConf) splitTemplates() error {
+	patterns := []string{}
+	for name := range c.Streams {
+		if strings.ContainsAny(name, "{}") {
+			patterns = append(patterns, name)
+		}
+	}
+	sort.Strings(patterns)
+
+	c.templates = nil
+	for _, pattern := range patterns {
+		stream := c.Streams[pattern]
+		delete(c.Streams, pattern)
+
+		template, err := newStreamTemplate(pattern, stream, c.Placeholders)
+		if err != nil {
+			return fmt.Errorf("stream %s: %w", pattern, err)
+		}
+
+		if err := stream.validate(); err != nil {
+			return fmt.Errorf("stream %s: %w", pattern, err)
+		}
+
+		c.templates = append(c.templates, template)
+	}
+
+	return nil
+}
+
+// stream returns stream of given name, listed ones take precedence over
+// templates.
+func (c *YamlConf) stream(name string) (StreamConf, bool) {
+	if stream, ok := c.Streams[name]; ok {
+		return stream, true
+	}
+
+	for _, template := range c.templates {
+		if stream, ok := template.expand(name); ok {
+			return stream, true
+		}
+	}
+
+	return StreamConf{}, false
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/hls"
+	"github.com/m1k1o/go-transcode/internal/ffprobe"
+	"github.com/m1k1o/go-transcode/subtitles"
+)
+
+var ErrSubtitlesNotFound = errors.New("subtitles track not found")
+
+// request parameter selecting subtitles track of stream, counted from one
+const subtitlesTrackParam = "subtitles"
+
+// highest selectable subtitles track
+const maxSubtitlesTracks = 32
+
+// subtitlesPlaylistName is webvtt segment playlist of subtitles track
+const subtitlesPlaylistName = "subtitles.m3u8"
+
+// segments listed by live subtitles playlists, as by hls profiles
+const subtitlesListSize = 5
+
+// text subtitles, that can be converted to WebVTT. Bitmap subtitles (e.g.
+// dvb_subtitle, hdmv_pgs_subtitle) can only be burned into video.
+var textSubtitlesCodecs = map[string]bool{
+	"subrip":       true,
+	"srt":          true,
+	"ass":          true,
+	"ssa":          true,
+	"mov_text":     true,
+	"webvtt":       true,
+	"text":         true,
+	"dvb_teletext": true,
+}
+
+// subtitlesTrackParams returns subtitles track selected by request, from
+// zero.
+func subtitlesTrackParams(r *http.Request) (int, error) {
+	track, err := strconv.Atoi(r.URL.Query().Get(subtitlesTrackParam))
+	if err != nil || track < 1 || track > maxSubtitlesTracks {
+		return 0, errors.New("invalid subtitles track")
+	}
+
+	return track - 1, nil
+}
+
+// subtitlesURI returns uri of subtitles track relative to master playlist.
+func subtitlesURI(track int, query url.Values) string {
+	values := url.Values{}
+	for key, value := range query {
+		values[key] = value
+	}
+
+	values.Set(subtitlesTrackParam, strconv.Itoa(track+1))
+	return subtitlesPlaylistName + "?" + values.Encode()
+}
+
+// subtitlesID returns manager id of subtitles track of stream or vod file.
+func subtitlesID(input string, track int) string {
+	return fmt.Sprintf("%s@subtitles%d", input, track+1)
+}
+
+// textSubtitles returns subtitles track of probed source, when it can be
+// converted to WebVTT.
+func textSubtitles(result *ffprobe.Result, track int) (ffprobe.Stream, error) {
+	tracks := result.Tracks("subtitle")
+	if track >= len(tracks) {
+		return ffprobe.Stream{}, fmt.Errorf("%w: %d", ErrSubtitlesNotFound, track+1)
+	}
+
+	stream := tracks[track]
+	if !textSubtitlesCodecs[stream.CodecName] {
+		return ffprobe.Stream{}, fmt.Errorf("%w: %s is not text subtitles codec", ErrSubtitlesNotFound, stream.CodecName)
+	}
+
+	return stream, nil
+}
+
+// subtitlesCmd returns ffmpeg converting subtitles track of source to
+// WebVTT written to its output.
+func subtitlesCmd(source string, inputOptions []string, stream ffprobe.Stream, track int) *exec.Cmd {
+	args := []string{"-hide_banner", "-loglevel", "warning"}
+	args = append(args, inputOptions...)
+
+	// teletext is decoded to bitmaps by default
+	if stream.CodecName == "dvb_teletext" {
+		args = append(args, "-txt_format", "text")
+	}
+
+	args = append(args,
+		"-i", source,
+		"-map", fmt.Sprintf("0:s:%d", track),
+		"-c:s", "webvtt",
+		"-flush_packets", "1",
+		"-f", "webvtt", "pipe:1",
+	)
+
+	log.Info().Str("url", source).Int("subtitles", track+1).Msg("command startred")
+	return exec.Command("ffmpeg", args...)
+}
+
+// subtitlesManager returns existing manager of subtitles track of stream
+// or creates new one, once track is probed.
+func (a *ApiManagerCtx) subtitlesManager(ctx context.Context, input string, track int) (subtitles.Manager, error) {
+	ID := subtitlesID(input, track)
+
+	a.subtitlesMu.Lock()
+	manager, ok := a.subtitlesManagers[ID]
+	a.subtitlesMu.Unlock()
+
+	if ok {
+		return manager, nil
+	}
+
+	stream, ok := currentConf().stream(input)
+	if !ok {
+		return nil, fmt.Errorf("%w: stream %s", ErrSubtitlesNotFound, input)
+	}
+
+	if stream.ingestPath(input) != "" {
+		return nil, fmt.Errorf("%w: ingested streams carry no subtitles", ErrSubtitlesNotFound)
+	}
+
+	result, err := a.probeSource(ctx, stream.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	subtitlesStream, err := textSubtitles(result, track)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.subtitlesManagerCreate(ID, a.subtitlesConfig, func() (*exec.Cmd, error) {
+		return subtitlesCmd(stream.Source, stream.inputOptions(), subtitlesStream, track), nil
+	}), nil
+}
+
+// vodSubtitlesManager returns existing manager of subtitles track of vod
+// file or creates new one, once track and duration of file are probed.
+func (a *ApiManagerCtx) vodSubtitlesManager(ctx context.Context, file string, track int) (subtitles.Manager, error) {
+	ID := subtitlesID("vod/"+file, track)
+
+	a.subtitlesMu.Lock()
+	manager, ok := a.subtitlesManagers[ID]
+	a.subtitlesMu.Unlock()
+
+	if ok {
+		return manager, nil
+	}
+
+	source, err := a.vodSource(file)
+	if err != nil {
+		return nil, err
+	}
+
+	duration, err := a.vodDuration(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := a.probeSource(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	subtitlesStream, err := textSubtitles(result, track)
+	if err != nil {
+		return nil, err
+	}
+
+	config := a.subtitlesConfig
+	config.Duration = duration
+
+	return a.subtitlesManagerCreate(ID, config, func() (*exec.Cmd, error) {
+		return subtitlesCmd(source, nil, subtitlesStream, track), nil
+	}), nil
+}
+
+// subtitlesManagerCreate returns manager created meanwhile or creates new
+// one.
+func (a *ApiManagerCtx) subtitlesManagerCreate(ID string, config subtitles.Config, cmdFactory func() (*exec.Cmd, error)) subtitles.Manager {
+	a.subtitlesMu.Lock()
+	defer a.subtitlesMu.Unlock()
+
+	manager, ok := a.subtitlesManagers[ID]
+	if ok {
+		return manager
+	}
+
+	manager = subtitles.New(cmdFactory, config)
+	a.subtitlesManagers[ID] = manager
+	return manager
+}
+
+// serveSubtitles serves playlist or segment of subtitles track selected by
+// request, from manager returned by factory.
+func (a *ApiManagerCtx) serveSubtitles(w http.ResponseWriter, r *http.Request, playlist bool, managerFactory func(track int) (subtitles.Manager, error)) {
+	track, err := subtitlesTrackParams(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 " + err.Error()))
+		return
+	}
+
+	manager, err := managerFactory(track)
+	if err != nil {
+		w.WriteHeader(transcodeErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if playlist {
+		manager.ServePlaylist(w, r)
+	} else {
+		manager.ServeSegment(w, r)
+	}
+}
+
+// liveSubtitlesConfig returns config of subtitles, segmented as hls
+// playlists of profiles.
+func liveSubtitlesConfig(hlsConfig hls.Config, singleProcess bool) subtitles.Config {
+	listSize := subtitlesListSize
+	if hlsConfig.DVRWindow > 0 {
+		listSize = int(math.Ceil(hlsConfig.DVRWindow.Seconds() / hlsConfig.SegmentDuration))
+	}
+
+	return subtitles.Config{
+		SegmentDuration: time.Duration(hlsConfig.SegmentDuration * float64(time.Second)),
+		ListSize:        listSize,
+
+		SingleProcess: singleProcess,
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/go-chi/chi"
+
+	"github.com/m1k1o/go-transcode/thumbnails"
+)
+
+func (a *ApiManagerCtx) Thumbnails(r chi.Router) {
+	r.Get("/thumbnails/{input}/thumbnails.vtt", func(w http.ResponseWriter, r *http.Request) {
+		manager, ok := a.thumbnailsManager(chi.URLParam(r, "input"))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 stream not found"))
+			return
+		}
+
+		manager.ServeVTT(w, r)
+	})
+
+	r.Get("/thumbnails/{input}/{file}.jpg", func(w http.ResponseWriter, r *http.Request) {
+		a.thumbnailsMu.Lock()
+		manager, ok := a.thumbnailsManagers[chi.URLParam(r, "input")]
+		a.thumbnailsMu.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 thumbnails not found"))
+			return
+		}
+
+		manager.ServeSprite(w, r)
+	})
+}
+
+// thumbnailsManager returns existing manager or creates new one.
+func (a *ApiManagerCtx) thumbnailsManager(input string) (thumbnails.Manager, bool) {
+	a.thumbnailsMu.Lock()
+	defer a.thumbnailsMu.Unlock()
+
+	re := regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+	if !re.MatchString(input) {
+		return nil, false
+	}
+
+	manager, ok := a.thumbnailsManagers[input]
+	if ok {
+		return manager, true
+	}
+
+	stream, ok := currentConf().stream(input)
+	if !ok || stream.ingestPath(input) != "" {
+		return nil, false
+	}
+
+	manager = thumbnails.New(stream.Source, a.thumbnailsConfig)
+	a.thumbnailsManagers[input] = manager
+	return manager, true
+}
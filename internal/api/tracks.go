@@ -0,0 +1,385 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/m1k1o/go-transcode/drm"
+	"github.com/m1k1o/go-transcode/hls"
+	"github.com/m1k1o/go-transcode/internal/ffprobe"
+)
+
+// request parameters selecting audio track of hls stream, counted from one
+const (
+	audioTrackParam = "audio"
+	audioOnlyParam  = "audio_only"
+)
+
+// highest selectable audio track
+const maxAudioTracks = 32
+
+// audioOnlyEnv is passed to profiles to drop video output.
+const audioOnlyEnv = "TRANSCODE_AUDIO_ONLY=1"
+
+// tracksPlaylistName is master playlist advertising tracks of stream
+const tracksPlaylistName = "tracks.m3u8"
+
+// hlsTrack selects tracks of source transcoded by hls manager, zero value
+// is default one.
+type hlsTrack struct {
+	// audio track, from zero
+	audio int
+	// video is dropped, for alternate audio renditions
+	audioOnly bool
+	// subtitles track burned into video, from one, zero for none
+	burnSubtitles int
+}
+
+// hlsTrackParams returns track selected by request.
+func hlsTrackParams(r *http.Request) (hlsTrack, error) {
+	query := r.URL.Query()
+	track := hlsTrack{}
+
+	if value := query.Get(audioTrackParam); value != "" {
+		audio, err := strconv.Atoi(value)
+		if err != nil || audio < 1 || audio > maxAudioTracks {
+			return track, errors.New("invalid audio track")
+		}
+		track.audio = audio - 1
+	}
+
+	switch query.Get(audioOnlyParam) {
+	case "", "0", "false":
+	case "1", "true":
+		track.audioOnly = true
+	default:
+		return track, errors.New("invalid audio only")
+	}
+
+	burnSubtitles, err := burnSubtitlesParams(r)
+	if err != nil {
+		return track, err
+	}
+	track.burnSubtitles = burnSubtitles
+
+	if track.audioOnly && track.burnSubtitles > 0 {
+		return track, errors.New("burned subtitles need video")
+	}
+
+	return track, nil
+}
+
+// name returns profile of track shown in manager ids, e.g.
+// h264_720p@audio2 or h264_720p@burnsubs1, default track keeps profile.
+func (t hlsTrack) name(profile string) string {
+	name := profile
+	if t.audio > 0 || t.audioOnly {
+		name += fmt.Sprintf("@audio%d", t.audio+1)
+		if t.audioOnly {
+			name += "-only"
+		}
+	}
+
+	if t.burnSubtitles > 0 {
+		name += fmt.Sprintf("@burnsubs%d", t.burnSubtitles)
+	}
+
+	return name
+}
+
+// id returns manager id of track.
+func (t hlsTrack) id(profile string, input string) string {
+	return fmt.Sprintf("%s/%s", t.name(profile), input)
+}
+
+// params returns request parameters selecting track.
+func (t hlsTrack) params() url.Values {
+	values := url.Values{}
+	if t.audio > 0 || t.audioOnly {
+		values.Set(audioTrackParam, strconv.Itoa(t.audio+1))
+		if t.audioOnly {
+			values.Set(audioOnlyParam, "1")
+		}
+	}
+
+	if t.burnSubtitles > 0 {
+		values.Set(burnSubtitlesParam, strconv.Itoa(t.burnSubtitles))
+	}
+
+	return values
+}
+
+// iso 639-2 codes of common iso 639-1 languages, as tagged in sources
+var languageCodes = map[string][]string{
+	"ar": {"ara"},
+	"cs": {"ces", "cze"},
+	"da": {"dan"},
+	"de": {"deu", "ger"},
+	"el": {"ell", "gre"},
+	"en": {"eng"},
+	"es": {"spa"},
+	"fi": {"fin"},
+	"fr": {"fra", "fre"},
+	"he": {"heb"},
+	"hi": {"hin"},
+	"hu": {"hun"},
+	"it": {"ita"},
+	"ja": {"jpn"},
+	"ko": {"kor"},
+	"nl": {"nld", "dut"},
+	"no": {"nor", "nob", "nno"},
+	"pl": {"pol"},
+	"pt": {"por"},
+	"ro": {"ron", "rum"},
+	"ru": {"rus"},
+	"sk": {"slk", "slo"},
+	"sv": {"swe"},
+	"tr": {"tur"},
+	"uk": {"ukr"},
+	"zh": {"zho", "chi"},
+}
+
+// acceptedLanguages returns primary subtags of Accept-Language header,
+// most preferred first.
+func acceptedLanguages(header string) []string {
+	type accepted struct {
+		language string
+		quality  float64
+	}
+
+	languages := []accepted{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		language := strings.ToLower(strings.SplitN(fields[0], "-", 2)[0])
+		if language == "" || language == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, field := range fields[1:] {
+			if value := strings.TrimSpace(field); strings.HasPrefix(value, "q=") {
+				quality, _ = strconv.ParseFloat(strings.TrimPrefix(value, "q="), 64)
+			}
+		}
+
+		if quality > 0 {
+			languages = append(languages, accepted{language, quality})
+		}
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].quality > languages[j].quality
+	})
+
+	result := make([]string, 0, len(languages))
+	for _, l := range languages {
+		result = append(result, l.language)
+	}
+	return result
+}
+
+// languageMatches reports whether track language tag matches primary
+// subtag of accepted language.
+func languageMatches(tag string, language string) bool {
+	tag = strings.ToLower(tag)
+	if tag == language {
+		return true
+	}
+
+	for _, code := range languageCodes[language] {
+		if tag == code {
+			return true
+		}
+	}
+	return false
+}
+
+// languageTag returns iso 639-1 language of track tag when known, as
+// expected by players.
+func languageTag(tag string) string {
+	for language := range languageCodes {
+		if languageMatches(tag, language) {
+			return language
+		}
+	}
+	return tag
+}
+
+// defaultTrack returns track matching most preferred accepted language,
+// otherwise the one marked as default by source, or first one.
+func defaultTrack(tracks []ffprobe.Stream, acceptLanguage string) int {
+	for _, language := range acceptedLanguages(acceptLanguage) {
+		for i, track := range tracks {
+			if languageMatches(track.Tags.Language, language) {
+				return i
+			}
+		}
+	}
+
+	for i, track := range tracks {
+		if track.Disposition.Default == 1 {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// trackName returns name of track shown by players, kind (e.g. Audio)
+// numbers tracks without title or language.
+func trackName(track ffprobe.Stream, kind string, i int) string {
+	if track.Tags.Title != "" {
+		return track.Tags.Title
+	}
+	if track.Tags.Language != "" && track.Tags.Language != "und" {
+		return track.Tags.Language
+	}
+	return fmt.Sprintf("%s %d", kind, i+1)
+}
+
+// tracksPlaylist renders master playlist of stream, advertising its audio
+// tracks as alternate renditions, unless they can not be selected. First
+// track is muxed in video variant, others are served as audio only. Text
+// subtitles tracks are advertised as WebVTT renditions. Query is appended
+// to its uris, session tags (e.g. of content protection) follow header.
+func tracksPlaylist(result *ffprobe.Result, acceptLanguage string, query url.Values, audio bool, sessionTags []string) string {
+	withQuery := func(uri string, track hlsTrack) string {
+		values := track.params()
+		for key, value := range query {
+			values[key] = value
+		}
+
+		if len(values) == 0 {
+			return uri
+		}
+		return uri + "?" + values.Encode()
+	}
+
+	lines := []string{"#EXTM3U", "#EXT-X-VERSION:3"}
+	lines = append(lines, sessionTags...)
+
+	tracks := []ffprobe.Stream{}
+	if audio {
+		tracks = result.Tracks("audio")
+	}
+
+	selected := defaultTrack(tracks, acceptLanguage)
+	for i, track := range tracks {
+		attrs := []string{
+			"TYPE=AUDIO",
+			`GROUP-ID="audio"`,
+			fmt.Sprintf("NAME=%q", trackName(track, "Audio", i)),
+		}
+
+		if language := track.Tags.Language; language != "" && language != "und" {
+			attrs = append(attrs, fmt.Sprintf("LANGUAGE=%q", languageTag(language)))
+		}
+
+		if i == selected {
+			attrs = append(attrs, "DEFAULT=YES")
+		} else {
+			attrs = append(attrs, "DEFAULT=NO")
+		}
+		attrs = append(attrs, "AUTOSELECT=YES")
+
+		if i > 0 {
+			uri := withQuery("index.m3u8", hlsTrack{audio: i, audioOnly: true})
+			attrs = append(attrs, fmt.Sprintf("URI=%q", uri))
+		}
+
+		lines = append(lines, "#EXT-X-MEDIA:"+strings.Join(attrs, ","))
+	}
+
+	// captions are shown only once selected, or by preferences of player
+	hasSubtitles := false
+	for i, track := range result.Tracks("subtitle") {
+		if !textSubtitlesCodecs[track.CodecName] {
+			continue
+		}
+
+		attrs := []string{
+			"TYPE=SUBTITLES",
+			`GROUP-ID="subs"`,
+			fmt.Sprintf("NAME=%q", trackName(track, "Subtitles", i)),
+		}
+
+		if language := track.Tags.Language; language != "" && language != "und" {
+			attrs = append(attrs, fmt.Sprintf("LANGUAGE=%q", languageTag(language)))
+		}
+
+		attrs = append(attrs, "DEFAULT=NO", "AUTOSELECT=YES", fmt.Sprintf("URI=%q", subtitlesURI(i, query)))
+		lines = append(lines, "#EXT-X-MEDIA:"+strings.Join(attrs, ","))
+		hasSubtitles = true
+	}
+
+	bandwidth := 0
+	for _, stream := range result.Streams {
+		bandwidth += parseBitRate(stream.BitRate)
+	}
+	if bandwidth == 0 {
+		bandwidth = parseBitRate(result.Format.BitRate)
+	}
+	if bandwidth == 0 {
+		// unknown for most live sources
+		bandwidth = 2000000
+	}
+
+	inf := fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d", bandwidth)
+	if len(tracks) > 0 {
+		inf += `,AUDIO="audio"`
+	}
+	if hasSubtitles {
+		inf += `,SUBTITLES="subs"`
+	}
+
+	lines = append(lines, inf, withQuery("index.m3u8", hlsTrack{}))
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func parseBitRate(value string) int {
+	n, _ := strconv.Atoi(value)
+	return n
+}
+
+// serveTracks serves master playlist of stream, tracks are probed.
+func (a *ApiManagerCtx) serveTracks(w http.ResponseWriter, r *http.Request, input string) {
+	stream, _ := currentConf().stream(input)
+	if stream.ingestPath(input) != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 ingested streams can not be probed"))
+		return
+	}
+
+	if err := hls.VerifySigned(a.hlsConfig.SigningSecret, r); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 " + err.Error()))
+		return
+	}
+
+	result, err := a.probeSource(r.Context(), stream.Source)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// other parameters, e.g. signature, apply to variant too
+	query := r.URL.Query()
+	query.Del(audioTrackParam)
+	query.Del(audioOnlyParam)
+	query.Del(subtitlesTrackParam)
+	query.Del(burnSubtitlesParam)
+
+	sessionTags := drm.HLSSessionTags(a.hlsConfig.ContentProtection)
+	playlist := tracksPlaylist(result, r.Header.Get("Accept-Language"), query, true, sessionTags)
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Vary", "Accept-Language")
+	w.Write([]byte(playlist))
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeTracksSigned(t *testing.T) {
+	storeConf(&YamlConf{Streams: map[string]StreamConf{"cam": {Source: "rtsp://source"}}})
+
+	a := newTestApi()
+	a.hlsConfig.SigningSecret = "secret"
+
+	// unsigned request is refused before source is probed
+	w := httptest.NewRecorder()
+	a.serveTracks(w, httptest.NewRequest(http.MethodGet, "/h264_720p/cam/tracks.m3u8", nil), "cam")
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if len(a.probes) > 0 {
+		t.Errorf("source probed by unsigned request")
+	}
+}
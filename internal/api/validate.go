@@ -0,0 +1,185 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/m1k1o/go-transcode/internal/config"
+)
+
+// commands required by transcodes, probes and snapshots
+var requiredCommands = []string{"ffmpeg", "ffprobe"}
+
+// Validate checks config, that would otherwise fail once used, and
+// returns all problems found. Checks do not start anything.
+func Validate(conf *config.Server) []error {
+	problems := []error{}
+
+	if confErr != nil {
+		problems = append(problems, fmt.Errorf("streams config %s: %w", confPath, confErr))
+	}
+
+	for _, name := range requiredCommands {
+		if _, err := exec.LookPath(name); err != nil {
+			problems = append(problems, fmt.Errorf("%s not found in PATH, install it or add its directory to PATH", name))
+		}
+	}
+
+	problems = append(problems, validateListeners(conf)...)
+
+	stat, err := os.Stat(conf.Profiles)
+	if err != nil || !stat.IsDir() {
+		problems = append(problems, fmt.Errorf("profiles directory %s does not exist, set it using --profiles", conf.Profiles))
+		return problems
+	}
+
+	problems = append(problems, validateProfiles(conf.Profiles)...)
+
+	a := &ApiManagerCtx{config: conf, profilesAvailable: true}
+	problems = append(problems, a.validateStreams(currentConf())...)
+
+	return problems
+}
+
+// validateListeners checks addresses of listeners and certificate of tls.
+func validateListeners(conf *config.Server) []error {
+	problems := []error{}
+
+	if _, _, err := net.SplitHostPort(conf.Bind); err != nil {
+		problems = append(problems, fmt.Errorf("invalid bind address %q, expected host:port, e.g. :8080: %v", conf.Bind, err))
+	}
+
+	if conf.RTMPBind != "" {
+		if _, _, err := net.SplitHostPort(conf.RTMPBind); err != nil {
+			problems = append(problems, fmt.Errorf("invalid rtmp bind address %q, expected host:port, e.g. :1935: %v", conf.RTMPBind, err))
+		} else if conf.RTMPBind == conf.Bind {
+			problems = append(problems, fmt.Errorf("rtmp bind address %q is already used by http server", conf.RTMPBind))
+		}
+	}
+
+	// server falls back to plain http with only one of them
+	if (conf.Cert == "") != (conf.Key == "") {
+		problems = append(problems, fmt.Errorf("both --cert and --key are required for tls, only one of them is set"))
+	} else if conf.Cert != "" {
+		if _, err := tls.LoadX509KeyPair(conf.Cert, conf.Key); err != nil {
+			problems = append(problems, fmt.Errorf("unable to load tls certificate %s and key %s: %v", conf.Cert, conf.Key, err))
+		}
+	}
+
+	return problems
+}
+
+// validateProfiles checks, that script profiles are executable and yaml
+// profiles load. Templates are checked once rendered.
+func validateProfiles(profiles string) []error {
+	problems := []error{}
+
+	scripts, _ := filepath.Glob(path.Join(profiles, "*", "*.sh"))
+	for _, script := range scripts {
+		stat, err := os.Stat(script)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+
+		if stat.Mode()&0111 == 0 {
+			problems = append(problems, fmt.Errorf("profile %s is not executable, run chmod +x %s", script, script))
+		}
+	}
+
+	yamls, _ := filepath.Glob(path.Join(profiles, "*", "*"+yamlProfileExt))
+	for _, profilePath := range yamls {
+		if _, err := loadYAMLProfile(profilePath); err != nil {
+			problems = append(problems, fmt.Errorf("invalid profile: %w", err))
+		}
+	}
+
+	return problems
+}
+
+// validateStreams checks sources of streams and profiles they refer to.
+func (a *ApiManagerCtx) validateStreams(c *YamlConf) []error {
+	problems := []error{}
+
+	names := make([]string, 0, len(c.Streams))
+	for name := range c.Streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		stream := c.Streams[name]
+
+		sources := append([]string{stream.Source}, stream.BackupSources...)
+		if stream.FallbackSource != "" {
+			sources = append(sources, stream.FallbackSource)
+		}
+
+		for _, source := range sources {
+			if err := validateSource(source); err != nil {
+				problems = append(problems, fmt.Errorf("stream %s: %w", name, err))
+			}
+		}
+
+		for _, profile := range stream.Preload {
+			if _, err := a.profilePath(profileModeHLS, profile); err != nil {
+				problems = append(problems, fmt.Errorf("stream %s: preload: %w", name, err))
+			}
+		}
+
+		for _, profile := range stream.Profiles {
+			if !a.profileExists(profile) {
+				problems = append(problems, fmt.Errorf("stream %s: allowed profile %q does not exist in any mode of %s", name, profile, a.config.Profiles))
+			}
+		}
+
+		if stream.Record != nil {
+			if _, err := a.profilePath(profileModeHTTP, stream.Record.profile()); err != nil {
+				problems = append(problems, fmt.Errorf("stream %s: record: %w", name, err))
+			}
+		}
+	}
+
+	return problems
+}
+
+// profileExists reports whether profile exists in some mode.
+func (a *ApiManagerCtx) profileExists(profile string) bool {
+	for _, mode := range []string{profileModeHLS, profileModeHTTP, profileModeDASH, profileModeWHEP, profileModeVOD} {
+		if _, err := a.profilePath(mode, profile); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSource checks, that source url is well-formed. Sources without
+// scheme are local files or devices, that may appear later.
+func validateSource(source string) error {
+	if source == "" || !strings.Contains(source, "://") {
+		return nil
+	}
+
+	u, err := url.Parse(source)
+	if err != nil {
+		// error of url.Parse contains whole url, including credentials
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("invalid source url, special characters of credentials must be percent-encoded: %w", err)
+	}
+
+	if u.Scheme != "file" && u.Host == "" {
+		return fmt.Errorf("source url %s has no host", u.Redacted())
+	}
+
+	return nil
+}
@@ -0,0 +1,369 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/subtitles"
+	"github.com/m1k1o/go-transcode/thumbnails"
+	"github.com/m1k1o/go-transcode/vod"
+)
+
+var ErrVODNotFound = errors.New("vod file not found")
+
+var vodSegmentRegex = regexp.MustCompile(`^([0-9]+)\.ts$`)
+
+var vodSubtitlesRegex = regexp.MustCompile(`^subtitles_[0-9]+\.vtt$`)
+
+var vodSpriteRegex = regexp.MustCompile(`^sprite_[0-9]{5}\.jpg$`)
+
+func (a *ApiManagerCtx) VOD(r chi.Router) {
+	if a.config.VODDir == "" {
+		return
+	}
+
+	r.With(a.refuseDraining).Get("/vod/{profile}/*", func(w http.ResponseWriter, r *http.Request) {
+		profile := chi.URLParam(r, "profile")
+		file, name := path.Split(chi.URLParam(r, "*"))
+		file = strings.TrimSuffix(file, "/")
+
+		switch {
+		case name == "thumbnails.vtt", vodSpriteRegex.MatchString(name):
+			storyboard, err := a.vodStoryboard(r.Context(), file)
+			if err != nil {
+				w.WriteHeader(transcodeErrorStatus(err))
+				w.Write([]byte(err.Error()))
+				return
+			}
+
+			if name == "thumbnails.vtt" {
+				storyboard.ServeVTT(w, r)
+			} else {
+				storyboard.ServeSprite(w, r)
+			}
+			return
+		case name == tracksPlaylistName:
+			a.serveVODTracks(w, r, file)
+			return
+		case name == subtitlesPlaylistName, vodSubtitlesRegex.MatchString(name):
+			a.serveSubtitles(w, r, name == subtitlesPlaylistName, func(track int) (subtitles.Manager, error) {
+				return a.vodSubtitlesManager(r.Context(), file, track)
+			})
+			return
+		}
+
+		segment := vodSegmentRegex.FindStringSubmatch(name)
+		if name != "index.m3u8" && segment == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 not found"))
+			return
+		}
+
+		burnSubtitles, err := burnSubtitlesParams(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 " + err.Error()))
+			return
+		}
+
+		manager, err := a.vodManager(r.Context(), profile, file, burnSubtitles)
+		if err != nil {
+			w.WriteHeader(transcodeErrorStatus(err))
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if segment == nil {
+			manager.ServePlaylist(w, r)
+			return
+		}
+
+		index, err := strconv.Atoi(segment[1])
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 segment not found"))
+			return
+		}
+
+		manager.ServeSegment(w, r, index)
+	})
+}
+
+// vodSource resolves file relative to media directory, paths escaping it
+// are rejected.
+func (a *ApiManagerCtx) vodSource(file string) (string, error) {
+	if file == "" || path.Clean("/"+file) != "/"+file {
+		return "", fmt.Errorf("%w: invalid path %q", ErrVODNotFound, file)
+	}
+
+	source := filepath.Join(a.config.VODDir, filepath.FromSlash(file))
+	info, err := os.Stat(source)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%w: %s", ErrVODNotFound, file)
+	}
+
+	return source, nil
+}
+
+// serveVODTracks serves master playlist of vod file advertising its
+// subtitles tracks, audio tracks can not be selected.
+func (a *ApiManagerCtx) serveVODTracks(w http.ResponseWriter, r *http.Request, file string) {
+	source, err := a.vodSource(file)
+	if err != nil {
+		w.WriteHeader(transcodeErrorStatus(err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	result, err := a.probeSource(r.Context(), source)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	query := r.URL.Query()
+	query.Del(subtitlesTrackParam)
+
+	playlist := tracksPlaylist(result, r.Header.Get("Accept-Language"), query, false, nil)
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(playlist))
+}
+
+// vodStoryboard returns storyboard of vod file, whose directory is given by
+// file and thumbnails config, so that it is generated again once either of
+// them changes.
+func (a *ApiManagerCtx) vodStoryboard(ctx context.Context, file string) (*thumbnails.StoryboardCtx, error) {
+	source, err := a.vodSource(file)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	config := a.thumbnailsConfig
+	key := strings.Join([]string{
+		source,
+		strconv.FormatInt(info.Size(), 10),
+		strconv.FormatInt(info.ModTime().UnixNano(), 10),
+		fmt.Sprintf("%s/%dx%d/%d", config.Interval, config.Columns, config.Rows, config.Width),
+	}, "\x00")
+
+	sum := sha256.Sum256([]byte(key))
+	dir := filepath.Join(a.storyboardsDir, hex.EncodeToString(sum[:]))
+
+	a.storyboardsMu.Lock()
+	storyboard, ok := a.storyboards[dir]
+	a.storyboardsMu.Unlock()
+
+	if ok {
+		return storyboard, nil
+	}
+
+	duration, err := a.vodDuration(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	a.storyboardsMu.Lock()
+	defer a.storyboardsMu.Unlock()
+
+	storyboard, ok = a.storyboards[dir]
+	if ok {
+		// created meanwhile
+		return storyboard, nil
+	}
+
+	if previous, ok := a.storyboardDirs[file]; ok {
+		a.storyboards[previous].Shutdown()
+		delete(a.storyboards, previous)
+
+		err := os.RemoveAll(previous)
+		log.Err(err).Str("file", file).Msg("removing storyboard of modified file")
+	}
+
+	storyboard = thumbnails.NewStoryboard(source, dir, duration, config)
+	a.storyboards[dir] = storyboard
+	a.storyboardDirs[file] = dir
+	return storyboard, nil
+}
+
+// vodCacheKey identifies segments of file transcoded by profile, with
+// burned subtitles track, that change once either of them is modified.
+func (a *ApiManagerCtx) vodCacheKey(profilePath string, source string, burnSubtitles int) (string, error) {
+	key := []string{}
+	for _, name := range []string{profilePath, source} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		key = append(key, name, strconv.FormatInt(info.Size(), 10), strconv.FormatInt(info.ModTime().UnixNano(), 10))
+	}
+
+	key = append(key, a.vodConfig.SegmentDuration.String())
+	if burnSubtitles > 0 {
+		key = append(key, burnSubtitlesParam+"="+strconv.Itoa(burnSubtitles))
+	}
+	return strings.Join(key, "\x00"), nil
+}
+
+// vodDuration probes duration of vod file.
+func (a *ApiManagerCtx) vodDuration(ctx context.Context, source string) (time.Duration, error) {
+	probe, err := a.probeSource(ctx, source)
+	if err != nil {
+		return 0, err
+	}
+
+	duration := probe.Duration()
+	if duration <= 0 {
+		return 0, fmt.Errorf("vod file has no duration")
+	}
+
+	return duration, nil
+}
+
+// vodManager returns existing manager or creates new one, once duration
+// of file is probed. Manager is replaced when file or profile is modified.
+// Subtitles track burned into video, from one, is transcoded separately.
+func (a *ApiManagerCtx) vodManager(ctx context.Context, profile string, file string, burnSubtitles int) (vod.Manager, error) {
+	ID := fmt.Sprintf("%s/%s", hlsTrack{burnSubtitles: burnSubtitles}.name(profile), file)
+
+	profilePath, err := a.profilePath(profileModeVOD, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := a.vodSource(file)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey, err := a.vodCacheKey(profilePath, source, burnSubtitles)
+	if err != nil {
+		return nil, err
+	}
+
+	a.vodMu.Lock()
+	manager, ok := a.vodManagers[ID]
+	ok = ok && a.vodKeys[ID] == cacheKey
+	a.vodMu.Unlock()
+
+	if ok {
+		return manager, nil
+	}
+
+	duration, err := a.vodDuration(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	// refused before any segment is requested
+	if burnSubtitles > 0 {
+		if _, err := a.profileBurnSubtitles(profile, profilePath, source, burnSubtitles); err != nil {
+			return nil, err
+		}
+	}
+
+	a.vodMu.Lock()
+	defer a.vodMu.Unlock()
+
+	manager, ok = a.vodManagers[ID]
+	if ok && a.vodKeys[ID] == cacheKey {
+		// created meanwhile
+		return manager, nil
+	} else if ok {
+		manager.Shutdown()
+	}
+
+	config := a.vodConfig
+	config.Duration = duration
+	config.CacheKey = cacheKey
+	config.ProcessLimits = a.profileProcessLimits(profileModeVOD, profile, file, StreamConf{Source: source})
+	config.Limits = a.transcodeLimits(profile)
+	config.Exited = a.transcodeExited
+
+	manager = vod.New(func(output vod.Output) (*exec.Cmd, error) {
+		return a.vodCmd(profile, file, source, output, burnSubtitles)
+	}, config)
+
+	a.vodManagers[ID] = manager
+	a.vodKeys[ID] = cacheKey
+	return manager, nil
+}
+
+// vodCmd returns transcode of vod file, starting at first segment of output.
+func (a *ApiManagerCtx) vodCmd(profile string, file string, source string, output vod.Output, burnSubtitles int) (*exec.Cmd, error) {
+	profilePath, err := a.profilePath(profileModeVOD, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	options := profileOptions{
+		AudioCodec: profileAudioCodec,
+		VOD:        &output,
+	}
+
+	if burnSubtitles > 0 {
+		options.BurnSubtitles, err = a.profileBurnSubtitles(profile, profilePath, source, burnSubtitles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if isYAMLProfile(profilePath) {
+		declared, err := a.loadStreamYAMLProfile(profilePath, profileModeVOD, profile, file, StreamConf{Source: source}, true)
+		if err != nil {
+			return nil, err
+		}
+
+		args, encoder, err := a.yamlProfileArgs(profileModeVOD, declared, source, options)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Info().Str("profilePath", profilePath).Str("url", source).Int("segment", output.StartNumber).Msg("command startred")
+		cmd := exec.Command("ffmpeg", args...)
+		cmd.Env = os.Environ()
+		if encoder != nil {
+			a.holdEncoder(cmd, *encoder)
+		}
+		return cmd, nil
+	}
+
+	cmd := exec.Command(profilePath, source)
+
+	env := options.env()
+	if profileEncodesH264(profilePath) {
+		encoder, err := a.pickEncoder()
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, encoder.Env()...)
+		log.Debug().Str("profile", profile).Str("file", file).Str("encoder", encoder.Name).Msg("picked h264 encoder")
+		a.holdEncoder(cmd, encoder)
+	}
+
+	log.Info().Str("profilePath", profilePath).Str("url", source).Int("segment", output.StartNumber).Msg("command startred")
+	cmd.Env = append(os.Environ(), env...)
+	return cmd, nil
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+
+	"github.com/go-chi/chi"
+
+	"github.com/m1k1o/go-transcode/whep"
+)
+
+func (a *ApiManagerCtx) WHEP(r chi.Router) {
+	r.With(a.refuseDraining).Post("/{profile}/{input}/whep", func(w http.ResponseWriter, r *http.Request) {
+		profile := chi.URLParam(r, "profile")
+		input := chi.URLParam(r, "input")
+
+		re := regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+		if !re.MatchString(profile) || !re.MatchString(input) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 invalid parameters"))
+			return
+		}
+
+		if err := profileAllowed(profile, input); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 " + err.Error()))
+			return
+		}
+
+		manager := a.whepManager(profile, input)
+
+		// transcode is started holding lock of manager
+		if manager.Pid() == 0 {
+			a.warmProbe(r.Context(), profileModeWHEP, profile, input)
+		}
+
+		manager.ServeOffer(w, r)
+	})
+
+	r.Delete("/{profile}/{input}/whep/{id}", func(w http.ResponseWriter, r *http.Request) {
+		profile := chi.URLParam(r, "profile")
+		input := chi.URLParam(r, "input")
+
+		a.whepMu.Lock()
+		manager, ok := a.whepManagers[fmt.Sprintf("%s/%s", profile, input)]
+		a.whepMu.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 transcode not found"))
+			return
+		}
+
+		manager.ServeDelete(w, r, chi.URLParam(r, "id"))
+	})
+}
+
+// whepManager returns existing manager or creates new one.
+func (a *ApiManagerCtx) whepManager(profile string, input string) whep.Manager {
+	a.whepMu.Lock()
+	defer a.whepMu.Unlock()
+
+	ID := fmt.Sprintf("%s/%s", profile, input)
+	manager, ok := a.whepManagers[ID]
+	if ok {
+		return manager
+	}
+
+	config := a.whepConfig
+	config.CmdLog = a.cmdLog(profileModeWHEP, profile, input)
+	stream, _ := currentConf().stream(input)
+	config.ProcessLimits = a.profileProcessLimits(profileModeWHEP, profile, input, stream)
+	config.Limits = a.transcodeLimits(profile)
+	config.Exited = a.transcodeExited
+
+	manager = whep.New(func() (*exec.Cmd, error) {
+		// get transcode cmd
+		return a.transcodeStart(profileModeWHEP, profile, input)
+	}, config)
+
+	a.whepManagers[ID] = manager
+	return manager
+}
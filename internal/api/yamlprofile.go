@@ -0,0 +1,469 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+
+	"github.com/m1k1o/go-transcode/internal/hwaccel"
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// extensions of declarative profiles and their templates, preferred over
+// scripts
+const (
+	yamlProfileExt         = ".yaml"
+	yamlProfileTemplateExt = ".yaml.tmpl"
+)
+
+// codec names and bitrates, e.g. 2800k
+var (
+	yamlCodecRegex   = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+	yamlBitrateRegex = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kKmM]?$`)
+)
+
+// yamlProfile is declarative profile, that is turned into ffmpeg arguments
+// by server instead of being executed as script.
+type yamlProfile struct {
+	Video   yamlProfileVideo   `yaml:"video"`
+	Audio   yamlProfileAudio   `yaml:"audio"`
+	Process yamlProfileProcess `yaml:"process"`
+}
+
+type yamlProfileVideo struct {
+	// h264 is encoded by hardware encoder when available, copy passes
+	// video through, otherwise ffmpeg encoder
+	Codec   string `yaml:"codec"`
+	Profile string `yaml:"profile"`
+	// tune of software h264 encoder, e.g. zerolatency
+	Tune string `yaml:"tune"`
+	// fitted into width and height keeping aspect ratio
+	Width   int    `yaml:"width"`
+	Height  int    `yaml:"height"`
+	Bitrate string `yaml:"bitrate"`
+	Maxrate string `yaml:"maxrate"`
+	Bufsize string `yaml:"bufsize"`
+	CRF     int    `yaml:"crf"`
+	// fixed keyframe interval in frames
+	GOP int `yaml:"gop"`
+	// ffmpeg filters applied after scaling
+	Filters []string `yaml:"filters"`
+	// h264 only, false uses software encoder
+	HWAccel *bool `yaml:"hwaccel"`
+	// copy source video, when it has the same codec and fits into width
+	// and height
+	Remux bool `yaml:"remux"`
+}
+
+type yamlProfileAudio struct {
+	// defaults to aac, or copy when source audio can be copied
+	Codec      string `yaml:"codec"`
+	Bitrate    string `yaml:"bitrate"`
+	SampleRate int    `yaml:"sample_rate"`
+	Channels   int    `yaml:"channels"`
+}
+
+// yamlProfileProcess overrides global limits of transcode process.
+type yamlProfileProcess struct {
+	Nice *int   `yaml:"nice"`
+	CPUs string `yaml:"cpus"`
+	// cores, e.g. 1.5
+	CPUMax float64 `yaml:"cpu_max"`
+	// megabytes
+	MemoryMax int `yaml:"memory_max"`
+}
+
+func isYAMLProfile(profilePath string) bool {
+	return strings.HasSuffix(profilePath, yamlProfileExt) || strings.HasSuffix(profilePath, yamlProfileTemplateExt)
+}
+
+func loadYAMLProfile(profilePath string) (*yamlProfile, error) {
+	data, err := ioutil.ReadFile(profilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseYAMLProfile(profilePath, data)
+}
+
+func parseYAMLProfile(profilePath string, data []byte) (*yamlProfile, error) {
+	profile := &yamlProfile{}
+	if err := yaml.UnmarshalStrict(data, profile); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", profilePath, err)
+	}
+
+	if err := profile.validate(); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", profilePath, err)
+	}
+
+	return profile, nil
+}
+
+func (p *yamlProfile) validate() error {
+	v := p.Video
+
+	if v.Codec == "" {
+		return errors.New("video codec is required")
+	}
+
+	for name, value := range map[string]string{
+		"video codec":   v.Codec,
+		"video profile": v.Profile,
+		"video tune":    v.Tune,
+		"audio codec":   p.Audio.Codec,
+	} {
+		if value != "" && !yamlCodecRegex.MatchString(value) {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+	}
+
+	for name, value := range map[string]string{
+		"video bitrate": v.Bitrate,
+		"video maxrate": v.Maxrate,
+		"video bufsize": v.Bufsize,
+		"audio bitrate": p.Audio.Bitrate,
+	} {
+		if value != "" && !yamlBitrateRegex.MatchString(value) {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+	}
+
+	if v.Width < 0 || v.Height < 0 || (v.Width == 0) != (v.Height == 0) {
+		return errors.New("video width and height must be both positive or omitted")
+	}
+
+	if v.CRF < 0 || v.CRF > 51 {
+		return errors.New("video crf must be between 0 and 51")
+	}
+
+	if v.GOP < 0 {
+		return errors.New("video gop must not be negative")
+	}
+
+	for _, filter := range v.Filters {
+		if strings.TrimSpace(filter) == "" {
+			return errors.New("video filters must not be empty")
+		}
+	}
+
+	copied := v.Profile != "" || v.Tune != "" || v.Width != 0 || v.Bitrate != "" || v.Maxrate != "" ||
+		v.Bufsize != "" || v.CRF != 0 || v.GOP != 0 || len(v.Filters) > 0
+	if v.Codec == "copy" && copied {
+		return errors.New("copied video can not be encoded or filtered")
+	}
+
+	if v.Codec == "copy" && v.Remux {
+		return errors.New("copied video is always remuxed")
+	}
+
+	if p.Audio.SampleRate < 0 || p.Audio.Channels < 0 {
+		return errors.New("audio sample rate and channels must not be negative")
+	}
+
+	if n := p.Process.Nice; n != nil && (*n < -20 || *n > 19) {
+		return errors.New("process nice must be between -20 and 19")
+	}
+
+	if p.Process.CPUs != "" {
+		if _, err := utils.ParseCPUList(p.Process.CPUs); err != nil {
+			return fmt.Errorf("process cpus: %w", err)
+		}
+	}
+
+	if p.Process.CPUMax < 0 || p.Process.MemoryMax < 0 {
+		return errors.New("process cpu and memory limits must not be negative")
+	}
+
+	return nil
+}
+
+// processLimits returns global limits overridden by process section.
+func (p *yamlProfile) processLimits(limits utils.ProcessLimits) utils.ProcessLimits {
+	if p.Process.Nice != nil {
+		limits.Nice = *p.Process.Nice
+	}
+	if p.Process.CPUs != "" {
+		limits.CPUs = p.Process.CPUs
+	}
+	if p.Process.CPUMax > 0 {
+		limits.CPUMax = p.Process.CPUMax
+	}
+	if p.Process.MemoryMax > 0 {
+		limits.MemoryMax = int64(p.Process.MemoryMax) << 20
+	}
+
+	return limits
+}
+
+// profileProcessLimits returns limits of transcode process of profile,
+// only yaml profiles override global ones.
+func (a *ApiManagerCtx) profileProcessLimits(mode string, profile string, input string, stream StreamConf) utils.ProcessLimits {
+	profilePath, err := a.profilePath(mode, profile)
+	if err != nil || !isYAMLProfile(profilePath) {
+		return a.processLimits
+	}
+
+	declared, err := a.loadStreamYAMLProfile(profilePath, mode, profile, input, stream, false)
+	if err != nil {
+		// reported once transcode is started
+		return a.processLimits
+	}
+
+	limits := declared.processLimits(a.processLimits)
+	if err := limits.Validate(); err != nil {
+		log.Warn().Err(err).Str("profile", profile).Msg("invalid process limits of profile, using global ones")
+		return a.processLimits
+	}
+
+	return limits
+}
+
+// yamlProfileEncoder returns h264 encoder picked by server, or nil for
+// other codecs.
+func (a *ApiManagerCtx) yamlProfileEncoder(p *yamlProfile) (*hwaccel.Encoder, error) {
+	if p.Video.Codec != "h264" {
+		return nil, nil
+	}
+
+	encoder := hwaccel.Software
+	if p.Video.HWAccel == nil || *p.Video.HWAccel {
+		var err error
+		if encoder, err = a.pickEncoder(); err != nil {
+			return nil, err
+		}
+	}
+	return &encoder, nil
+}
+
+// yamlProfileArgs returns ffmpeg arguments of profile in given mode, with
+// h264 encoder picked for them, that must be held by their transcode.
+// Environment given to transcode only before its start is referenced by
+// placeholders, that are resolved by utils.ExpandArgs.
+func (a *ApiManagerCtx) yamlProfileArgs(mode string, p *yamlProfile, source string, options profileOptions) ([]string, *hwaccel.Encoder, error) {
+	if mode == profileModeHLS && a.hlsConfig.LowLatency {
+		return nil, nil, errors.New("low latency hls is not supported by yaml profiles")
+	}
+
+	if mode == profileModeWHEP && p.Video.Codec != "h264" {
+		return nil, nil, errors.New("webrtc requires h264 video")
+	}
+
+	if mode == profileModeVOD && (p.Video.Codec == "copy" || options.VOD == nil) {
+		return nil, nil, errors.New("vod requires encoded video, with keyframes at segment boundaries")
+	}
+
+	encoder, err := a.yamlProfileEncoder(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "warning"}
+	// progress is reported only to hls managers
+	if mode == profileModeHLS {
+		args = append(args, "${TRANSCODE_PROGRESS_OPTIONS}")
+	}
+	if encoder != nil {
+		args = append(args, encoder.InputOptions...)
+	}
+	args = append(args, options.inputOptions()...)
+	args = append(args, "-i", source)
+
+	switch mode {
+	case profileModeHLS:
+		args = append(args, "-map", options.videoMap(), "-map", options.audioMap(), "${TRANSCODE_AUDIO_ONLY:+-vn}")
+	case profileModeDASH, profileModeWHEP:
+		args = append(args, "-map", options.videoMap())
+		if mode == profileModeDASH {
+			args = append(args, "-map", "0:a:0")
+		}
+	case profileModeVOD:
+		args = append(args, "-map", options.videoMap(), "-map", "0:a:0?")
+	}
+
+	if mode == profileModeWHEP {
+		// baseline without b-frames, parameter sets on every keyframe, so
+		// that browsers can join any time
+		args = append(args, yamlVideoArgs(p.Video, encoder, options)...)
+		return append(args,
+			"-bf", "0",
+			"-bsf:v", "dump_extra",
+			"-f", "rtp", "-payload_type", "96", "-pkt_size", "1200", "${TRANSCODE_RTP_VIDEO}",
+			"-map", "0:a:0?",
+			"-c:a", "libopus",
+			"-ar", "48000",
+			"-ac", "2",
+			"-b:a", yamlDefault(p.Audio.Bitrate, "128k"),
+			"-f", "rtp", "-payload_type", "111", "-pkt_size", "1200", "${TRANSCODE_RTP_AUDIO}",
+		), encoder, nil
+	}
+
+	args = append(args, yamlAudioArgs(p.Audio, options)...)
+	args = append(args, yamlVideoArgs(p.Video, encoder, options)...)
+
+	switch mode {
+	case profileModeHLS:
+		// keyinfo file is reread on every segment, when keys rotate
+		flags := "delete_segments"
+		if a.hlsConfig.Encryption.Enabled() {
+			flags += "+periodic_rekey"
+		}
+
+		args = append(args,
+			"-f", "hls",
+			"-hls_time", strconv.FormatFloat(a.hlsConfig.SegmentDuration, 'g', -1, 64),
+			"-hls_list_size", "${TRANSCODE_HLS_LIST_SIZE:-5}",
+			"-hls_wrap", "${TRANSCODE_HLS_WRAP:-10}",
+			"-hls_delete_threshold", "1",
+			"-hls_flags", flags,
+			"${TRANSCODE_HLS_KEY_INFO_FILE:+-hls_key_info_file}", "${TRANSCODE_HLS_KEY_INFO_FILE}",
+			"${TRANSCODE_HLS_METHOD:+-method}", "${TRANSCODE_HLS_METHOD}",
+			"-hls_start_number_source", "datetime",
+			"-hls_segment_filename", "${TRANSCODE_HLS_SEGMENT_FILENAME:-live_%03d.ts}", "-",
+		)
+	case profileModeDASH:
+		args = append(args,
+			"-f", "dash",
+			"-seg_duration", "2",
+			"-window_size", "5",
+			"-extra_window_size", "5",
+			"-remove_at_exit", "1",
+			"-use_template", "1",
+			"-use_timeline", "1",
+			"-init_seg_name", "init_$RepresentationID$.m4s",
+			"-media_seg_name", "chunk_$RepresentationID$_$Number%05d$.m4s",
+			"index.mpd",
+		)
+	case profileModeVOD:
+		// timestamps of seeked transcode continue where its first
+		// segment starts, keyframes are forced relative to it
+		duration := formatClipTime(options.VOD.SegmentDuration)
+		args = append(args,
+			"-force_key_frames", "expr:gte(t,n_forced*"+duration+")",
+			"-output_ts_offset", formatClipTime(options.VOD.Start),
+			"-f", "hls",
+			"-hls_time", duration,
+			"-hls_list_size", "0",
+			"-hls_flags", "temp_file",
+			"-start_number", strconv.Itoa(options.VOD.StartNumber),
+			"-hls_segment_filename", options.VOD.SegmentFilename,
+			options.VOD.Playlist,
+		)
+	default:
+		args = append(args, "-f", "${TRANSCODE_HTTP_FORMAT:-mpegts}", "${TRANSCODE_HTTP_FORMAT_OPTIONS}", "-")
+	}
+
+	return args, encoder, nil
+}
+
+func yamlVideoArgs(v yamlProfileVideo, encoder *hwaccel.Encoder, options profileOptions) []string {
+	if v.Codec == "copy" {
+		return []string{"-c:v", "copy"}
+	}
+
+	filters := []string{}
+	if filter := deinterlaceFilters[options.Deinterlace]; filter != "" {
+		filters = append(filters, filter)
+	}
+	if v.Width > 0 {
+		scale := fmt.Sprintf("scale=w=%d:h=%d:force_original_aspect_ratio=decrease", v.Width, v.Height)
+		if options.ScaleAlgorithm != "" {
+			scale += ":flags=" + options.ScaleAlgorithm
+		}
+		filters = append(filters, scale)
+	}
+	if filter := options.sharpenFilter(); filter != "" {
+		filters = append(filters, filter)
+	}
+	if burn := options.BurnSubtitles; burn != nil && !burn.Bitmap {
+		filters = append(filters, burn.filter(options.seek()))
+	}
+	filters = append(filters, v.Filters...)
+
+	codec := v.Codec
+	if encoder != nil {
+		codec = encoder.Codec
+		if encoder.UploadFilter != "" {
+			filters = append(filters, encoder.UploadFilter)
+		}
+	}
+
+	args := []string{}
+	if burn := options.BurnSubtitles; burn != nil && burn.Bitmap {
+		// bitmaps sized to source are overlaid before scaling, simple
+		// filtergraph can not be combined with complex one
+		graph := fmt.Sprintf("[0:v:0][0:s:%d]overlay", burn.Track)
+		if len(filters) > 0 {
+			graph += "," + strings.Join(filters, ",")
+		}
+		args = append(args, "-filter_complex", graph+"["+burnedVideoLabel+"]")
+	} else if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+
+	args = append(args, "-c:v", codec)
+	if encoder != nil {
+		args = append(args, encoder.EncoderOptions...)
+	}
+	for _, option := range []struct {
+		name  string
+		value string
+	}{
+		{"-profile:v", v.Profile},
+		{"-b:v", v.Bitrate},
+		{"-maxrate", v.Maxrate},
+		{"-bufsize", v.Bufsize},
+	} {
+		if option.value != "" {
+			args = append(args, option.name, option.value)
+		}
+	}
+
+	// tune values are specific to software encoder
+	if v.Tune != "" && codec == hwaccel.Software.Codec {
+		args = append(args, "-tune", v.Tune)
+	}
+
+	if v.CRF > 0 {
+		args = append(args, "-crf", strconv.Itoa(v.CRF))
+	}
+
+	if v.GOP > 0 {
+		gop := strconv.Itoa(v.GOP)
+		args = append(args, "-sc_threshold", "0", "-g", gop, "-keyint_min", gop)
+	}
+
+	return args
+}
+
+func yamlAudioArgs(audio yamlProfileAudio, options profileOptions) []string {
+	codec := yamlDefault(audio.Codec, options.AudioCodec)
+	args := []string{"-c:a", codec}
+	if codec == "copy" {
+		return args
+	}
+
+	if audio.SampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(audio.SampleRate))
+	}
+	if audio.Channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(audio.Channels))
+	}
+	if audio.Bitrate != "" {
+		args = append(args, "-b:a", audio.Bitrate)
+	}
+
+	return args
+}
+
+func yamlDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+type Analytics struct {
+	Sink        string
+	URL         string
+	Interval    time.Duration
+	IdleTimeout time.Duration
+}
+
+func (Analytics) Init(cmd *cobra.Command) error {
+	cmd.PersistentFlags().String("analytics-sink", "", "where to send viewer session events: log or http, empty disables analytics")
+	if err := viper.BindPFlag("analytics-sink", cmd.PersistentFlags().Lookup("analytics-sink")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("analytics-url", "", "url where are events posted by http analytics sink")
+	if err := viper.BindPFlag("analytics-url", cmd.PersistentFlags().Lookup("analytics-url")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("analytics-interval", time.Minute, "how often are session progress events emitted, 0 disables them")
+	if err := viper.BindPFlag("analytics-interval", cmd.PersistentFlags().Lookup("analytics-interval")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("analytics-idle-timeout", 30*time.Second, "how long after last request is hls session considered ended")
+	if err := viper.BindPFlag("analytics-idle-timeout", cmd.PersistentFlags().Lookup("analytics-idle-timeout")); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Analytics) Set() {
+	s.Sink = viper.GetString("analytics-sink")
+	s.URL = viper.GetString("analytics-url")
+	s.Interval = viper.GetDuration("analytics-interval")
+	s.IdleTimeout = viper.GetDuration("analytics-idle-timeout")
+}
@@ -0,0 +1,445 @@
+package config
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/m1k1o/go-transcode/drm"
+)
+
+type HLS struct {
+	SegmentDuration float64
+	LowLatency      bool
+	PartDuration    float64
+	CanBlockReload  bool
+	HoldBack        float64
+	PartHoldBack    float64
+	ExitGrace       time.Duration
+	MimeTypes       map[string]string
+	SniffMimeTypes  bool
+
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	ExplicitStart bool
+
+	DRM []drm.System
+
+	MaxSegments     int
+	MaxPlaylistSize int
+
+	TempDir      string
+	RestartGrace time.Duration
+
+	RestartBackoff    time.Duration
+	RestartBackoffMax time.Duration
+
+	PlaylistCache bool
+
+	KeyRotation       time.Duration
+	KeyRequireSession bool
+
+	DVRWindow time.Duration
+
+	Memory bool
+
+	SigningSecret string
+
+	StoreEndpoint  string
+	StoreRegion    string
+	StoreBucket    string
+	StoreAccessKey string
+	StoreSecretKey string
+	StorePublicURL string
+	StorePrefix    string
+	StoreWorkers   int
+
+	HeadColdStart bool
+
+	WarmPool int
+
+	ColdStartTimeout time.Duration
+	StartupTimeout   time.Duration
+
+	RetryAfterMin time.Duration
+
+	MaxDuration time.Duration
+
+	AudioOnlyThreshold int
+	AudioOnlyPeriod    time.Duration
+
+	MaxBlockingReloads int
+
+	FreezeTimeout time.Duration
+	FreezeReset   time.Duration
+	StallTimeout  time.Duration
+
+	FailbackPeriod time.Duration
+
+	MaxTranscodes         int
+	MaxProfileTranscodes  map[string]string
+	TranscodeQueueTimeout time.Duration
+	// aggregate size of tempdirs in megabytes, and size expected to be
+	// taken by new transcode
+	DiskBudget  int
+	DiskReserve int
+
+	BandwidthWindow time.Duration
+
+	CleanupPeriod       time.Duration
+	PlaylistTimeout     time.Duration
+	MinimumSegments     int
+	ActiveIdleTimeout   time.Duration
+	InactiveIdleTimeout time.Duration
+}
+
+func (HLS) Init(cmd *cobra.Command) error {
+	cmd.PersistentFlags().Float64("hls-segment-duration", 2, "target duration of segments produced by hls profiles, in seconds")
+	if err := viper.BindPFlag("hls-segment-duration", cmd.PersistentFlags().Lookup("hls-segment-duration")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("hls-low-latency", false, "enable low latency hls playlist tags")
+	if err := viper.BindPFlag("hls-low-latency", cmd.PersistentFlags().Lookup("hls-low-latency")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Float64("hls-part-duration", 0, "target duration of partial segments in low latency mode, in seconds")
+	if err := viper.BindPFlag("hls-part-duration", cmd.PersistentFlags().Lookup("hls-part-duration")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("hls-can-block-reload", false, "advertise blocking playlist reload support in low latency mode")
+	if err := viper.BindPFlag("hls-can-block-reload", cmd.PersistentFlags().Lookup("hls-can-block-reload")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Float64("hls-hold-back", 0, "server recommended live edge distance in low latency mode, in seconds (defaults to three target durations)")
+	if err := viper.BindPFlag("hls-hold-back", cmd.PersistentFlags().Lookup("hls-hold-back")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Float64("hls-part-hold-back", 0, "server recommended live edge distance for partial segments in low latency mode, in seconds, 0 is three part durations")
+	if err := viper.BindPFlag("hls-part-hold-back", cmd.PersistentFlags().Lookup("hls-part-hold-back")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-exit-grace", 2*time.Second, "how long to wait for final playlist after transcode process exits")
+	if err := viper.BindPFlag("hls-exit-grace", cmd.PersistentFlags().Lookup("hls-exit-grace")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().StringToString("hls-mime-types", map[string]string{}, "content type overrides by file extension, e.g. m4s=video/mp4")
+	if err := viper.BindPFlag("hls-mime-types", cmd.PersistentFlags().Lookup("hls-mime-types")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("hls-sniff-mime-types", false, "detect content type of segments with unknown extension by their content")
+	if err := viper.BindPFlag("hls-sniff-mime-types", cmd.PersistentFlags().Lookup("hls-sniff-mime-types")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("hls-breaker-threshold", 0, "consecutive stream start failures after which starts are rejected, 0 disables")
+	if err := viper.BindPFlag("hls-breaker-threshold", cmd.PersistentFlags().Lookup("hls-breaker-threshold")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-breaker-cooldown", 30*time.Second, "how long are stream starts rejected after reaching failure threshold")
+	if err := viper.BindPFlag("hls-breaker-cooldown", cmd.PersistentFlags().Lookup("hls-breaker-cooldown")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("hls-explicit-start", false, "do not start streams on playlist request, only preloaded streams are running")
+	if err := viper.BindPFlag("hls-explicit-start", cmd.PersistentFlags().Lookup("hls-explicit-start")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("hls-max-segments", 0, "maximum segments in served playlist, 0 means unlimited")
+	if err := viper.BindPFlag("hls-max-segments", cmd.PersistentFlags().Lookup("hls-max-segments")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("hls-max-playlist-size", 0, "maximum size of served playlist in bytes, 0 means unlimited")
+	if err := viper.BindPFlag("hls-max-playlist-size", cmd.PersistentFlags().Lookup("hls-max-playlist-size")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("hls-tempdir", "", "directory for stable per stream tempdirs <dir>/<stream>/<profile>, random tempdirs are used when empty")
+	if err := viper.BindPFlag("hls-tempdir", cmd.PersistentFlags().Lookup("hls-tempdir")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-restart-grace", 0, "restart within this period after stop reuses existing tempdir and its segments, 0 disables")
+	if err := viper.BindPFlag("hls-restart-grace", cmd.PersistentFlags().Lookup("hls-restart-grace")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-restart-backoff", time.Second, "restart transcode exiting on its own while still requested after this delay, doubled on consecutive exits, 0 disables")
+	if err := viper.BindPFlag("hls-restart-backoff", cmd.PersistentFlags().Lookup("hls-restart-backoff")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-restart-backoff-max", 30*time.Second, "maximum restart backoff, transcode running longer resets it")
+	if err := viper.BindPFlag("hls-restart-backoff-max", cmd.PersistentFlags().Lookup("hls-restart-backoff-max")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("hls-playlist-cache", true, "render playlist once per update instead of on every request, not used in low latency mode")
+	if err := viper.BindPFlag("hls-playlist-cache", cmd.PersistentFlags().Lookup("hls-playlist-cache")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-dvr-window", 0, "keep segments this long behind live edge in event playlists, 0 keeps live edge only")
+	if err := viper.BindPFlag("hls-dvr-window", cmd.PersistentFlags().Lookup("hls-dvr-window")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("hls-memory", false, "keep segments in memory instead of tempdir, profiles upload them over loopback http")
+	if err := viper.BindPFlag("hls-memory", cmd.PersistentFlags().Lookup("hls-memory")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-key-rotation", 0, "encrypt segments using AES-128 keys rotated this often, 0 disables")
+	if err := viper.BindPFlag("hls-key-rotation", cmd.PersistentFlags().Lookup("hls-key-rotation")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("hls-key-require-session", false, "serve encryption keys only to requests carrying session of current viewer")
+	if err := viper.BindPFlag("hls-key-require-session", cmd.PersistentFlags().Lookup("hls-key-require-session")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("hls-signing-secret", "", "secret of HMAC signed expiring urls required by playlists and segments, disabled when empty")
+	if err := viper.BindPFlag("hls-signing-secret", cmd.PersistentFlags().Lookup("hls-signing-secret")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("hls-store-endpoint", "", "endpoint of S3 compatible storage receiving segments, disabled when empty")
+	if err := viper.BindPFlag("hls-store-endpoint", cmd.PersistentFlags().Lookup("hls-store-endpoint")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("hls-store-region", "us-east-1", "region of segment storage")
+	if err := viper.BindPFlag("hls-store-region", cmd.PersistentFlags().Lookup("hls-store-region")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("hls-store-bucket", "", "bucket of segment storage")
+	if err := viper.BindPFlag("hls-store-bucket", cmd.PersistentFlags().Lookup("hls-store-bucket")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("hls-store-access-key", "", "access key of segment storage")
+	if err := viper.BindPFlag("hls-store-access-key", cmd.PersistentFlags().Lookup("hls-store-access-key")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("hls-store-secret-key", "", "secret key of segment storage")
+	if err := viper.BindPFlag("hls-store-secret-key", cmd.PersistentFlags().Lookup("hls-store-secret-key")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("hls-store-public-url", "", "base url (e.g. CDN) of stored segments referenced in playlists, defaults to storage endpoint")
+	if err := viper.BindPFlag("hls-store-public-url", cmd.PersistentFlags().Lookup("hls-store-public-url")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("hls-store-prefix", "", "prefix of stored segment keys <prefix>/<stream>/<profile>/<segment>")
+	if err := viper.BindPFlag("hls-store-prefix", cmd.PersistentFlags().Lookup("hls-store-prefix")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("hls-store-workers", 4, "parallel segment uploads per stream")
+	if err := viper.BindPFlag("hls-store-workers", cmd.PersistentFlags().Lookup("hls-store-workers")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("hls-head-cold-start", false, "start stream on HEAD playlist request, otherwise 503 is returned until stream is running")
+	if err := viper.BindPFlag("hls-head-cold-start", cmd.PersistentFlags().Lookup("hls-head-cold-start")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("hls-warm-pool", 0, "number of most recently requested streams kept running beyond idle timeout, 0 disables")
+	if err := viper.BindPFlag("hls-warm-pool", cmd.PersistentFlags().Lookup("hls-warm-pool")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-cold-start-timeout", 0, "how long can clients wait for stream to warm up before they are all failed and process is stopped, 0 disables")
+	if err := viper.BindPFlag("hls-cold-start-timeout", cmd.PersistentFlags().Lookup("hls-cold-start-timeout")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-startup-timeout", 0, "stop process that does not produce segments within this time after it was launched, 0 disables")
+	if err := viper.BindPFlag("hls-startup-timeout", cmd.PersistentFlags().Lookup("hls-startup-timeout")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-retry-after-min", time.Second, "minimum Retry-After of unavailable playlists, segment duration is used when higher")
+	if err := viper.BindPFlag("hls-retry-after-min", cmd.PersistentFlags().Lookup("hls-retry-after-min")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-max-duration", 0, "stop stream after running this long regardless of viewers, it must be then started explicitly, 0 disables")
+	if err := viper.BindPFlag("hls-max-duration", cmd.PersistentFlags().Lookup("hls-max-duration")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("hls-audio-only-threshold", 0, "video decode errors within 10s after which output is switched to audio only, 0 disables")
+	if err := viper.BindPFlag("hls-audio-only-threshold", cmd.PersistentFlags().Lookup("hls-audio-only-threshold")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-audio-only-period", time.Minute, "how long is audio only output kept before video is tried again")
+	if err := viper.BindPFlag("hls-audio-only-period", cmd.PersistentFlags().Lookup("hls-audio-only-period")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("hls-max-blocking-reloads", 0, "maximum blocking playlist reloads held at once per stream, further ones are not blocked, 0 is unlimited")
+	if err := viper.BindPFlag("hls-max-blocking-reloads", cmd.PersistentFlags().Lookup("hls-max-blocking-reloads")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-freeze-timeout", 0, "restart stream when its playlist is not updated for this long, escalation is configured per stream, 0 disables")
+	if err := viper.BindPFlag("hls-freeze-timeout", cmd.PersistentFlags().Lookup("hls-freeze-timeout")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-freeze-reset", 5*time.Minute, "consecutive freezes are forgotten after this long without freeze")
+	if err := viper.BindPFlag("hls-freeze-reset", cmd.PersistentFlags().Lookup("hls-freeze-reset")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-stall-timeout", 0, "restart watched stream with source reconnect options when it produces no segments for this long, 0 disables")
+	if err := viper.BindPFlag("hls-stall-timeout", cmd.PersistentFlags().Lookup("hls-stall-timeout")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-failback-period", 30*time.Second, "probe primary source this often while stream uses backup source, switching back once it recovers, 0 disables")
+	if err := viper.BindPFlag("hls-failback-period", cmd.PersistentFlags().Lookup("hls-failback-period")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("hls-max-transcodes", 0, "maximum concurrent transcodes of all modes, further streams are not started, 0 is unlimited")
+	if err := viper.BindPFlag("hls-max-transcodes", cmd.PersistentFlags().Lookup("hls-max-transcodes")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().StringToString("hls-max-profile-transcodes", map[string]string{}, "maximum concurrent transcodes of all modes by profile, e.g. h264_1080p=2, unlimited when not set")
+	if err := viper.BindPFlag("hls-max-profile-transcodes", cmd.PersistentFlags().Lookup("hls-max-profile-transcodes")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-transcode-queue-timeout", 0, "how long can playlist and raw http requests wait for transcode limits, before failing with 503, 0 fails immediately")
+	if err := viper.BindPFlag("hls-transcode-queue-timeout", cmd.PersistentFlags().Lookup("hls-transcode-queue-timeout")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("hls-disk-budget", 0, "aggregate size of hls tempdirs in megabytes, new transcodes are refused with 503 once it would be exceeded, 0 is unlimited")
+	if err := viper.BindPFlag("hls-disk-budget", cmd.PersistentFlags().Lookup("hls-disk-budget")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("hls-disk-reserve", 64, "size in megabytes expected to be taken by tempdir of new hls transcode, checked against disk budget")
+	if err := viper.BindPFlag("hls-disk-reserve", cmd.PersistentFlags().Lookup("hls-disk-reserve")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-bandwidth-window", 0, "account bytes served by streams, reporting totals and totals within this window in stats, 0 disables")
+	if err := viper.BindPFlag("hls-bandwidth-window", cmd.PersistentFlags().Lookup("hls-bandwidth-window")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-cleanup-period", 4*time.Second, "how often are streams checked for being idle")
+	if err := viper.BindPFlag("hls-cleanup-period", cmd.PersistentFlags().Lookup("hls-cleanup-period")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-playlist-timeout", 20*time.Second, "how long can first playlist request wait for stream to become active")
+	if err := viper.BindPFlag("hls-playlist-timeout", cmd.PersistentFlags().Lookup("hls-playlist-timeout")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("hls-minimum-segments", 2, "segments available to consider stream as active")
+	if err := viper.BindPFlag("hls-minimum-segments", cmd.PersistentFlags().Lookup("hls-minimum-segments")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-active-idle-timeout", 12*time.Second, "stop active stream that was not requested for this long")
+	if err := viper.BindPFlag("hls-active-idle-timeout", cmd.PersistentFlags().Lookup("hls-active-idle-timeout")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("hls-inactive-idle-timeout", 24*time.Second, "stop stream that is not active yet and was not requested for this long")
+	if err := viper.BindPFlag("hls-inactive-idle-timeout", cmd.PersistentFlags().Lookup("hls-inactive-idle-timeout")); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *HLS) Set() {
+	s.SegmentDuration = viper.GetFloat64("hls-segment-duration")
+	s.LowLatency = viper.GetBool("hls-low-latency")
+	s.PartDuration = viper.GetFloat64("hls-part-duration")
+	s.CanBlockReload = viper.GetBool("hls-can-block-reload")
+	s.HoldBack = viper.GetFloat64("hls-hold-back")
+	s.PartHoldBack = viper.GetFloat64("hls-part-hold-back")
+	s.ExitGrace = viper.GetDuration("hls-exit-grace")
+	s.MimeTypes = viper.GetStringMapString("hls-mime-types")
+	s.SniffMimeTypes = viper.GetBool("hls-sniff-mime-types")
+	s.BreakerThreshold = viper.GetInt("hls-breaker-threshold")
+	s.BreakerCooldown = viper.GetDuration("hls-breaker-cooldown")
+	s.ExplicitStart = viper.GetBool("hls-explicit-start")
+	s.MaxSegments = viper.GetInt("hls-max-segments")
+	s.MaxPlaylistSize = viper.GetInt("hls-max-playlist-size")
+	s.TempDir = viper.GetString("hls-tempdir")
+	s.RestartGrace = viper.GetDuration("hls-restart-grace")
+	s.RestartBackoff = viper.GetDuration("hls-restart-backoff")
+	s.RestartBackoffMax = viper.GetDuration("hls-restart-backoff-max")
+	s.PlaylistCache = viper.GetBool("hls-playlist-cache")
+	s.DVRWindow = viper.GetDuration("hls-dvr-window")
+	s.Memory = viper.GetBool("hls-memory")
+	s.KeyRotation = viper.GetDuration("hls-key-rotation")
+	s.KeyRequireSession = viper.GetBool("hls-key-require-session")
+	s.SigningSecret = viper.GetString("hls-signing-secret")
+	s.StoreEndpoint = viper.GetString("hls-store-endpoint")
+	s.StoreRegion = viper.GetString("hls-store-region")
+	s.StoreBucket = viper.GetString("hls-store-bucket")
+	s.StoreAccessKey = viper.GetString("hls-store-access-key")
+	s.StoreSecretKey = viper.GetString("hls-store-secret-key")
+	s.StorePublicURL = viper.GetString("hls-store-public-url")
+	s.StorePrefix = viper.GetString("hls-store-prefix")
+	s.StoreWorkers = viper.GetInt("hls-store-workers")
+	s.HeadColdStart = viper.GetBool("hls-head-cold-start")
+	s.WarmPool = viper.GetInt("hls-warm-pool")
+	s.ColdStartTimeout = viper.GetDuration("hls-cold-start-timeout")
+	s.StartupTimeout = viper.GetDuration("hls-startup-timeout")
+	s.RetryAfterMin = viper.GetDuration("hls-retry-after-min")
+	s.MaxDuration = viper.GetDuration("hls-max-duration")
+	s.AudioOnlyThreshold = viper.GetInt("hls-audio-only-threshold")
+	s.AudioOnlyPeriod = viper.GetDuration("hls-audio-only-period")
+	s.MaxBlockingReloads = viper.GetInt("hls-max-blocking-reloads")
+	s.FreezeTimeout = viper.GetDuration("hls-freeze-timeout")
+	s.FreezeReset = viper.GetDuration("hls-freeze-reset")
+	s.StallTimeout = viper.GetDuration("hls-stall-timeout")
+	s.FailbackPeriod = viper.GetDuration("hls-failback-period")
+	s.MaxTranscodes = viper.GetInt("hls-max-transcodes")
+	s.MaxProfileTranscodes = viper.GetStringMapString("hls-max-profile-transcodes")
+	s.TranscodeQueueTimeout = viper.GetDuration("hls-transcode-queue-timeout")
+	s.DiskBudget = viper.GetInt("hls-disk-budget")
+	s.DiskReserve = viper.GetInt("hls-disk-reserve")
+	s.BandwidthWindow = viper.GetDuration("hls-bandwidth-window")
+	s.CleanupPeriod = viper.GetDuration("hls-cleanup-period")
+	s.PlaylistTimeout = viper.GetDuration("hls-playlist-timeout")
+	s.MinimumSegments = viper.GetInt("hls-minimum-segments")
+	s.ActiveIdleTimeout = viper.GetDuration("hls-active-idle-timeout")
+	s.InactiveIdleTimeout = viper.GetDuration("hls-inactive-idle-timeout")
+
+	// only available in config file
+	if err := viper.UnmarshalKey("drm", &s.DRM); err != nil {
+		log.Warn().Err(err).Msg("unable to parse drm config")
+	}
+}
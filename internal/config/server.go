@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -11,6 +13,102 @@ type Server struct {
 	Bind   string
 	Static string
 	Proxy  bool
+	// request rate and concurrent requests of stream clients by address,
+	// zero is unlimited
+	RateLimit            float64
+	RateLimitBurst       int
+	MaxClientConnections int
+
+	Profiles      string
+	ProfilesPause bool
+	// profiles in root are used by all modes missing them
+	ProfilesMerge bool
+
+	// streams registered at runtime using api, persisted to state file
+	StreamsAPI   bool
+	StreamsState string
+	// reload streams config once changed, transcodes of removed streams
+	// are stopped after their viewers leave or drain timeout
+	ConfigWatch        bool
+	ReloadDrainTimeout time.Duration
+
+	RequestTimeout time.Duration
+	// how long can in-flight requests finish on shutdown
+	DrainTimeout time.Duration
+
+	// set by root debug flag
+	Debug      bool
+	DebugToken string
+	// jwt authentication of stream endpoints, disabled without secret
+	// and jwks url
+	AuthJWTSecret  string
+	AuthJWTIssuer  string
+	AuthJWTJWKSURL string
+	// cross origin requests of browser players, disabled without origins
+	CORSOrigins []string
+	CORSMethods []string
+	CORSHeaders []string
+	CORSMaxAge  time.Duration
+	// kill whole process groups of transcodes
+	ProcessGroup bool
+	// nice level, cpu list and cgroup v2 limits of transcodes, overridden
+	// by process section of yaml profiles
+	ProcessNice      int
+	ProcessCPUs      string
+	ProcessCgroupDir string
+	ProcessCPUMax    float64
+	ProcessMemoryMax int
+	// parent of tempdirs of segments, tmpfs is preferred when enabled
+	TempRoot        string
+	TempPreferTmpfs bool
+	TempTmpfs       string
+	// latest transcode output lines kept by stream, optionally appended
+	// to rotated files of streams
+	StreamLogLines    int
+	StreamLogDir      string
+	StreamLogMaxSize  int
+	StreamLogMaxFiles int
+	// concurrent helper commands, e.g. ffprobe
+	HelperConcurrency  int
+	HelperQueueTimeout time.Duration
+	// maximum snapshot dimensions, larger are clamped or rejected
+	SnapshotMaxWidth  int
+	SnapshotMaxHeight int
+	SnapshotClamp     bool
+	// serve manager events as server-sent events
+	Events bool
+	// serve current hls segments of streams as json
+	SegmentsJSON bool
+	// share one transcode among clients of raw http streams
+	HTTPShared bool
+	// serve prometheus metrics
+	Metrics bool
+	// rtmp ingest listener, disabled when empty
+	RTMPBind    string
+	RTMPTimeout time.Duration
+	// ice servers of webrtc peers
+	ICEServers []string
+	// hardware encoders in order of priority, and their session limits
+	HWAccel         []string
+	HWAccelSessions map[string]string
+	// software encoder is used once hardware sessions are exhausted,
+	// otherwise transcodes are rejected
+	HWAccelFallback bool
+	// remux compatible sources using copy profile of mode
+	Passthrough bool
+	// media directory of vod files, disabled when empty
+	VODDir             string
+	VODSegmentDuration time.Duration
+	VODBufferAhead     int
+	// segment cache of vod files, disabled when empty
+	VODCacheDir  string
+	VODCacheSize int
+	// archive directory of stream recordings, disabled when empty
+	RecordDir             string
+	RecordFormat          string
+	RecordSegmentDuration time.Duration
+	RecordMaxAge          time.Duration
+	RecordMaxSize         int
 }
 
 func (Server) Init(cmd *cobra.Command) error {
@@ -39,6 +137,301 @@ func (Server) Init(cmd *cobra.Command) error {
 		return err
 	}
 
+	cmd.PersistentFlags().Float64("rate-limit", 0, "maximum stream requests per second of client address, 0 is unlimited")
+	if err := viper.BindPFlag("rate-limit", cmd.PersistentFlags().Lookup("rate-limit")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("rate-limit-burst", 50, "stream requests of client address allowed in burst above rate limit")
+	if err := viper.BindPFlag("rate-limit-burst", cmd.PersistentFlags().Lookup("rate-limit-burst")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("max-client-connections", 0, "maximum concurrent stream requests of client address, 0 is unlimited")
+	if err := viper.BindPFlag("max-client-connections", cmd.PersistentFlags().Lookup("max-client-connections")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("profiles", "/app/profiles", "path to transcoding profiles")
+	if err := viper.BindPFlag("profiles", cmd.PersistentFlags().Lookup("profiles")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("profiles-pause", false, "pause running streams while profiles are unavailable")
+	if err := viper.BindPFlag("profiles-pause", cmd.PersistentFlags().Lookup("profiles-pause")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("profiles-merge", false, "use profiles in profiles root for all modes, when missing in directory of mode")
+	if err := viper.BindPFlag("profiles-merge", cmd.PersistentFlags().Lookup("profiles-merge")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("streams-api", false, "register and remove streams at runtime using POST /api/streams and DELETE /api/streams/<name>")
+	if err := viper.BindPFlag("streams-api", cmd.PersistentFlags().Lookup("streams-api")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("streams-state", "", "state file of streams registered at runtime, they are kept only in memory when empty")
+	if err := viper.BindPFlag("streams-state", cmd.PersistentFlags().Lookup("streams-state")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("config-watch", true, "reload streams config once its file changes and check changed profiles, besides reload on SIGHUP")
+	if err := viper.BindPFlag("config-watch", cmd.PersistentFlags().Lookup("config-watch")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("reload-drain-timeout", time.Minute, "how long are transcodes of streams removed by reload kept for their viewers, before they are stopped")
+	if err := viper.BindPFlag("reload-drain-timeout", cmd.PersistentFlags().Lookup("reload-drain-timeout")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("request-timeout", 30*time.Second, "timeout of admin and helper requests, streaming requests are not limited, 0 disables")
+	if err := viper.BindPFlag("request-timeout", cmd.PersistentFlags().Lookup("request-timeout")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("drain-timeout", 10*time.Second, "how long can in-flight requests (e.g. segment downloads) finish on shutdown, before remaining connections are closed")
+	if err := viper.BindPFlag("drain-timeout", cmd.PersistentFlags().Lookup("drain-timeout")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("debug-token", "", "bearer token required by debug endpoints")
+	if err := viper.BindPFlag("debug-token", cmd.PersistentFlags().Lookup("debug-token")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("auth-jwt-secret", "", "secret of HMAC signed jwt bearer tokens required by stream endpoints")
+	if err := viper.BindPFlag("auth-jwt-secret", cmd.PersistentFlags().Lookup("auth-jwt-secret")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("auth-jwt-jwks-url", "", "url of key set verifying RSA and ECDSA signed jwt bearer tokens required by stream endpoints")
+	if err := viper.BindPFlag("auth-jwt-jwks-url", cmd.PersistentFlags().Lookup("auth-jwt-jwks-url")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("auth-jwt-issuer", "", "required issuer of jwt bearer tokens, any when empty")
+	if err := viper.BindPFlag("auth-jwt-issuer", cmd.PersistentFlags().Lookup("auth-jwt-issuer")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().StringSlice("cors-origins", []string{}, "origins allowed to request playlists, segments and api from browsers, e.g. https://player.example.com, * allows any, empty disables cors")
+	if err := viper.BindPFlag("cors-origins", cmd.PersistentFlags().Lookup("cors-origins")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().StringSlice("cors-methods", []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"}, "methods allowed to cors requests")
+	if err := viper.BindPFlag("cors-methods", cmd.PersistentFlags().Lookup("cors-methods")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().StringSlice("cors-headers", []string{"Authorization", "Content-Type", "Range"}, "request headers allowed to cors requests, * allows any")
+	if err := viper.BindPFlag("cors-headers", cmd.PersistentFlags().Lookup("cors-headers")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("cors-max-age", 10*time.Minute, "how long can browsers cache cors preflight responses, 0 omits it")
+	if err := viper.BindPFlag("cors-max-age", cmd.PersistentFlags().Lookup("cors-max-age")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("process-group", true, "run transcodes in own process groups and kill whole groups, disable to signal only direct child")
+	if err := viper.BindPFlag("process-group", cmd.PersistentFlags().Lookup("process-group")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("process-nice", 0, "nice level of transcodes from -20 to 19, positive values give way to http server")
+	if err := viper.BindPFlag("process-nice", cmd.PersistentFlags().Lookup("process-nice")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("process-cpus", "", "cpu list transcodes are pinned to, e.g. 0-3,6")
+	if err := viper.BindPFlag("process-cpus", cmd.PersistentFlags().Lookup("process-cpus")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("process-cgroup-dir", "", "delegated cgroup v2 directory, where transcodes get own cgroups with cpu and memory limits")
+	if err := viper.BindPFlag("process-cgroup-dir", cmd.PersistentFlags().Lookup("process-cgroup-dir")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Float64("process-cpu-max", 0, "cpu time of transcode in cores, e.g. 1.5, 0 is unlimited")
+	if err := viper.BindPFlag("process-cpu-max", cmd.PersistentFlags().Lookup("process-cpu-max")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("process-memory-max", 0, "memory of transcode in megabytes, it is killed once exceeded, 0 is unlimited")
+	if err := viper.BindPFlag("process-memory-max", cmd.PersistentFlags().Lookup("process-memory-max")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("temp-root", "", "parent of temporary segment directories of hls, dash and vod, default of os when empty")
+	if err := viper.BindPFlag("temp-root", cmd.PersistentFlags().Lookup("temp-root")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("temp-prefer-tmpfs", false, "use tmpfs directory as temp root when it is available and temp root is not set")
+	if err := viper.BindPFlag("temp-prefer-tmpfs", cmd.PersistentFlags().Lookup("temp-prefer-tmpfs")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("temp-tmpfs", "/dev/shm", "tmpfs directory preferred with temp-prefer-tmpfs")
+	if err := viper.BindPFlag("temp-tmpfs", cmd.PersistentFlags().Lookup("temp-tmpfs")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("stream-log-lines", 200, "latest lines of ffmpeg output kept by stream, served at /api/streams/<name>/logs")
+	if err := viper.BindPFlag("stream-log-lines", cmd.PersistentFlags().Lookup("stream-log-lines")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("stream-log-dir", "", "directory where is ffmpeg output of streams appended to <name>.log files, disabled when empty")
+	if err := viper.BindPFlag("stream-log-dir", cmd.PersistentFlags().Lookup("stream-log-dir")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("stream-log-max-size", 10, "size of stream log files in megabytes, after which they are rotated")
+	if err := viper.BindPFlag("stream-log-max-size", cmd.PersistentFlags().Lookup("stream-log-max-size")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("stream-log-max-files", 3, "rotated stream log files kept, oldest are removed")
+	if err := viper.BindPFlag("stream-log-max-files", cmd.PersistentFlags().Lookup("stream-log-max-files")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("helper-concurrency", 4, "maximum concurrent helper commands (e.g. ffprobe), separate from transcodes, 0 is unlimited")
+	if err := viper.BindPFlag("helper-concurrency", cmd.PersistentFlags().Lookup("helper-concurrency")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("helper-queue-timeout", 5*time.Second, "how long can helper command wait for free slot before failing, 0 fails immediately")
+	if err := viper.BindPFlag("helper-queue-timeout", cmd.PersistentFlags().Lookup("helper-queue-timeout")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("snapshot-max-width", 1920, "maximum width of snapshots")
+	if err := viper.BindPFlag("snapshot-max-width", cmd.PersistentFlags().Lookup("snapshot-max-width")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("snapshot-max-height", 1080, "maximum height of snapshots")
+	if err := viper.BindPFlag("snapshot-max-height", cmd.PersistentFlags().Lookup("snapshot-max-height")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("snapshot-clamp", true, "clamp oversized snapshot dimensions to maximum, reject them with 400 otherwise")
+	if err := viper.BindPFlag("snapshot-clamp", cmd.PersistentFlags().Lookup("snapshot-clamp")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("events", false, "stream manager lifecycle events as server-sent events at /events")
+	if err := viper.BindPFlag("events", cmd.PersistentFlags().Lookup("events")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("segments-json", false, "serve current hls segments of streams as json at /streams/<name>/segments.json, for debugging")
+	if err := viper.BindPFlag("segments-json", cmd.PersistentFlags().Lookup("segments-json")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("http-shared", false, "share one transcode among all clients of the same raw ts/mp4 http stream")
+	if err := viper.BindPFlag("http-shared", cmd.PersistentFlags().Lookup("http-shared")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("metrics", false, "serve prometheus metrics of transcodes at /metrics, also enables bandwidth accounting")
+	if err := viper.BindPFlag("metrics", cmd.PersistentFlags().Lookup("metrics")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("rtmp-bind", "", "address/port of rtmp ingest for encoders publishing to rtmp://<host>/<app>/<key>, empty disables")
+	if err := viper.BindPFlag("rtmp-bind", cmd.PersistentFlags().Lookup("rtmp-bind")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("rtmp-timeout", 10*time.Second, "close rtmp connections receiving no data for this long")
+	if err := viper.BindPFlag("rtmp-timeout", cmd.PersistentFlags().Lookup("rtmp-timeout")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().StringSlice("ice-servers", []string{}, "stun/turn server urls of webrtc (whep) peers, e.g. stun:stun.l.google.com:19302, only host candidates are used when empty")
+	if err := viper.BindPFlag("ice-servers", cmd.PersistentFlags().Lookup("ice-servers")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().StringSlice("hwaccel", []string{"nvenc", "vaapi", "qsv"}, "hardware h264 encoders used by profiles when detected, in order of priority, empty uses software encoder only")
+	if err := viper.BindPFlag("hwaccel", cmd.PersistentFlags().Lookup("hwaccel")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().StringToString("hwaccel-sessions", map[string]string{}, "maximum concurrent sessions of hardware encoders, e.g. nvenc=3, after which next encoder is used, unlimited when not set")
+	if err := viper.BindPFlag("hwaccel-sessions", cmd.PersistentFlags().Lookup("hwaccel-sessions")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("hwaccel-fallback", true, "use software encoder when all hardware encoder sessions are in use, otherwise transcodes are rejected with 503")
+	if err := viper.BindPFlag("hwaccel-fallback", cmd.PersistentFlags().Lookup("hwaccel-fallback")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Bool("passthrough", false, "remux sources with h264 video and aac audio using copy profile, instead of transcoding them by requested profile")
+	if err := viper.BindPFlag("passthrough", cmd.PersistentFlags().Lookup("passthrough")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("vod-dir", "", "media directory, whose files are served as vod hls transcoded on demand, disabled when empty")
+	if err := viper.BindPFlag("vod-dir", cmd.PersistentFlags().Lookup("vod-dir")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("vod-segment-duration", 6*time.Second, "duration of vod segments")
+	if err := viper.BindPFlag("vod-segment-duration", cmd.PersistentFlags().Lookup("vod-segment-duration")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("vod-buffer-ahead", 10, "how many vod segments can be transcoded ahead of last requested segment, before transcode is paused")
+	if err := viper.BindPFlag("vod-buffer-ahead", cmd.PersistentFlags().Lookup("vod-buffer-ahead")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("vod-cache-dir", "", "directory caching transcoded vod segments across viewers and restarts, disabled when empty")
+	if err := viper.BindPFlag("vod-cache-dir", cmd.PersistentFlags().Lookup("vod-cache-dir")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("vod-cache-size", 10240, "maximum size of vod segment cache in megabytes, least recently used segments are removed")
+	if err := viper.BindPFlag("vod-cache-size", cmd.PersistentFlags().Lookup("vod-cache-size")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("record-dir", "", "archive directory of recorded streams, recording is disabled when empty")
+	if err := viper.BindPFlag("record-dir", cmd.PersistentFlags().Lookup("record-dir")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("record-format", "mp4", "container of recorded files: mp4 or ts")
+	if err := viper.BindPFlag("record-format", cmd.PersistentFlags().Lookup("record-format")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("record-segment-duration", 10*time.Minute, "duration of recorded files, split at multiples of it in wall clock time")
+	if err := viper.BindPFlag("record-segment-duration", cmd.PersistentFlags().Lookup("record-segment-duration")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("record-max-age", 0, "remove recorded files older than this, 0 keeps them")
+	if err := viper.BindPFlag("record-max-age", cmd.PersistentFlags().Lookup("record-max-age")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("record-max-size", 0, "maximum size of recordings of stream in megabytes, oldest files are removed, 0 disables")
+	if err := viper.BindPFlag("record-max-size", cmd.PersistentFlags().Lookup("record-max-size")); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -48,4 +441,64 @@ func (s *Server) Set() {
 	s.Bind = viper.GetString("bind")
 	s.Static = viper.GetString("static")
 	s.Proxy = viper.GetBool("proxy")
+	s.RateLimit = viper.GetFloat64("rate-limit")
+	s.RateLimitBurst = viper.GetInt("rate-limit-burst")
+	s.MaxClientConnections = viper.GetInt("max-client-connections")
+	s.Profiles = viper.GetString("profiles")
+	s.ProfilesPause = viper.GetBool("profiles-pause")
+	s.ProfilesMerge = viper.GetBool("profiles-merge")
+	s.StreamsAPI = viper.GetBool("streams-api")
+	s.StreamsState = viper.GetString("streams-state")
+	s.ConfigWatch = viper.GetBool("config-watch")
+	s.ReloadDrainTimeout = viper.GetDuration("reload-drain-timeout")
+	s.RequestTimeout = viper.GetDuration("request-timeout")
+	s.DrainTimeout = viper.GetDuration("drain-timeout")
+	s.Debug = viper.GetBool("debug")
+	s.DebugToken = viper.GetString("debug-token")
+	s.AuthJWTSecret = viper.GetString("auth-jwt-secret")
+	s.AuthJWTJWKSURL = viper.GetString("auth-jwt-jwks-url")
+	s.AuthJWTIssuer = viper.GetString("auth-jwt-issuer")
+	s.CORSOrigins = viper.GetStringSlice("cors-origins")
+	s.CORSMethods = viper.GetStringSlice("cors-methods")
+	s.CORSHeaders = viper.GetStringSlice("cors-headers")
+	s.CORSMaxAge = viper.GetDuration("cors-max-age")
+	s.SegmentsJSON = viper.GetBool("segments-json")
+	s.Events = viper.GetBool("events")
+	s.HTTPShared = viper.GetBool("http-shared")
+	s.Metrics = viper.GetBool("metrics")
+	s.RTMPBind = viper.GetString("rtmp-bind")
+	s.RTMPTimeout = viper.GetDuration("rtmp-timeout")
+	s.ICEServers = viper.GetStringSlice("ice-servers")
+	s.HWAccel = viper.GetStringSlice("hwaccel")
+	s.HWAccelSessions = viper.GetStringMapString("hwaccel-sessions")
+	s.HWAccelFallback = viper.GetBool("hwaccel-fallback")
+	s.Passthrough = viper.GetBool("passthrough")
+	s.VODDir = viper.GetString("vod-dir")
+	s.VODSegmentDuration = viper.GetDuration("vod-segment-duration")
+	s.VODBufferAhead = viper.GetInt("vod-buffer-ahead")
+	s.VODCacheDir = viper.GetString("vod-cache-dir")
+	s.VODCacheSize = viper.GetInt("vod-cache-size")
+	s.RecordDir = viper.GetString("record-dir")
+	s.RecordFormat = viper.GetString("record-format")
+	s.RecordSegmentDuration = viper.GetDuration("record-segment-duration")
+	s.RecordMaxAge = viper.GetDuration("record-max-age")
+	s.RecordMaxSize = viper.GetInt("record-max-size")
+	s.SnapshotMaxWidth = viper.GetInt("snapshot-max-width")
+	s.SnapshotMaxHeight = viper.GetInt("snapshot-max-height")
+	s.SnapshotClamp = viper.GetBool("snapshot-clamp")
+	s.ProcessGroup = viper.GetBool("process-group")
+	s.ProcessNice = viper.GetInt("process-nice")
+	s.ProcessCPUs = viper.GetString("process-cpus")
+	s.ProcessCgroupDir = viper.GetString("process-cgroup-dir")
+	s.ProcessCPUMax = viper.GetFloat64("process-cpu-max")
+	s.ProcessMemoryMax = viper.GetInt("process-memory-max")
+	s.TempRoot = viper.GetString("temp-root")
+	s.TempPreferTmpfs = viper.GetBool("temp-prefer-tmpfs")
+	s.TempTmpfs = viper.GetString("temp-tmpfs")
+	s.StreamLogLines = viper.GetInt("stream-log-lines")
+	s.StreamLogDir = viper.GetString("stream-log-dir")
+	s.StreamLogMaxSize = viper.GetInt("stream-log-max-size")
+	s.StreamLogMaxFiles = viper.GetInt("stream-log-max-files")
+	s.HelperConcurrency = viper.GetInt("helper-concurrency")
+	s.HelperQueueTimeout = viper.GetDuration("helper-queue-timeout")
 }
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+type Thumbnails struct {
+	Interval time.Duration
+	Columns  int
+	Rows     int
+	Width    int
+	Sprites  int
+}
+
+func (Thumbnails) Init(cmd *cobra.Command) error {
+	cmd.PersistentFlags().Duration("thumbnails-interval", 10*time.Second, "time between thumbnails in sprites")
+	if err := viper.BindPFlag("thumbnails-interval", cmd.PersistentFlags().Lookup("thumbnails-interval")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("thumbnails-columns", 5, "number of thumbnail columns in sprite")
+	if err := viper.BindPFlag("thumbnails-columns", cmd.PersistentFlags().Lookup("thumbnails-columns")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("thumbnails-rows", 5, "number of thumbnail rows in sprite")
+	if err := viper.BindPFlag("thumbnails-rows", cmd.PersistentFlags().Lookup("thumbnails-rows")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("thumbnails-width", 160, "width of single thumbnail")
+	if err := viper.BindPFlag("thumbnails-width", cmd.PersistentFlags().Lookup("thumbnails-width")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("thumbnails-sprites", 6, "how many latest sprites are kept for live streams")
+	if err := viper.BindPFlag("thumbnails-sprites", cmd.PersistentFlags().Lookup("thumbnails-sprites")); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Thumbnails) Set() {
+	s.Interval = viper.GetDuration("thumbnails-interval")
+	s.Columns = viper.GetInt("thumbnails-columns")
+	s.Rows = viper.GetInt("thumbnails-rows")
+	s.Width = viper.GetInt("thumbnails-width")
+	s.Sprites = viper.GetInt("thumbnails-sprites")
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/m1k1o/go-transcode/webhooks"
+)
+
+type Webhooks struct {
+	URL     string
+	Secret  string
+	Events  []string
+	Retries int
+	Backoff time.Duration
+	Timeout time.Duration
+}
+
+func (Webhooks) Init(cmd *cobra.Command) error {
+	cmd.PersistentFlags().String("webhook-url", "", "url where are stream lifecycle events posted, empty disables webhooks")
+	if err := viper.BindPFlag("webhook-url", cmd.PersistentFlags().Lookup("webhook-url")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().String("webhook-secret", "", "secret signing webhook payloads using HMAC-SHA256, unsigned when empty")
+	if err := viper.BindPFlag("webhook-secret", cmd.PersistentFlags().Lookup("webhook-secret")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().StringSlice("webhook-events", webhooks.DefaultEvents, "types of events posted to webhook url")
+	if err := viper.BindPFlag("webhook-events", cmd.PersistentFlags().Lookup("webhook-events")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Int("webhook-retries", 3, "retries of failed webhook deliveries")
+	if err := viper.BindPFlag("webhook-retries", cmd.PersistentFlags().Lookup("webhook-retries")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("webhook-backoff", time.Second, "delay before first retry of webhook delivery, doubled on every retry")
+	if err := viper.BindPFlag("webhook-backoff", cmd.PersistentFlags().Lookup("webhook-backoff")); err != nil {
+		return err
+	}
+
+	cmd.PersistentFlags().Duration("webhook-timeout", 5*time.Second, "timeout of single webhook delivery attempt")
+	if err := viper.BindPFlag("webhook-timeout", cmd.PersistentFlags().Lookup("webhook-timeout")); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Webhooks) Set() {
+	s.URL = viper.GetString("webhook-url")
+	s.Secret = viper.GetString("webhook-secret")
+	s.Events = viper.GetStringSlice("webhook-events")
+	s.Retries = viper.GetInt("webhook-retries")
+	s.Backoff = viper.GetDuration("webhook-backoff")
+	s.Timeout = viper.GetDuration("webhook-timeout")
+}
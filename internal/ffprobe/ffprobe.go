@@ -0,0 +1,204 @@
+package ffprobe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how long can single probe take
+const probeTimeout = 10 * time.Second
+
+type Stream struct {
+	Index     int    `json:"index"`
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	Profile   string `json:"profile"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	PixFmt    string `json:"pix_fmt"`
+	// frame rate as fraction, e.g. 30000/1001
+	AvgFrameRate  string `json:"avg_frame_rate"`
+	SampleRate    string `json:"sample_rate"`
+	Channels      int    `json:"channels"`
+	ChannelLayout string `json:"channel_layout"`
+	BitRate       string `json:"bit_rate"`
+	Tags          struct {
+		Language string `json:"language"`
+		Title    string `json:"title"`
+	} `json:"tags"`
+	Disposition struct {
+		Default int `json:"default"`
+	} `json:"disposition"`
+}
+
+type Format struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	BitRate    string `json:"bit_rate"`
+}
+
+type Result struct {
+	Streams []Stream `json:"streams"`
+	Format  Format   `json:"format"`
+}
+
+func Probe(ctx context.Context, url string) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-hide_banner", "-loglevel", "error",
+		"-print_format", "json",
+		"-show_format", "-show_streams",
+		url,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	result := &Result{}
+	if err := json.Unmarshal(stdout.Bytes(), result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Dimensions returns width and height of first video stream, or zeros.
+func (r *Result) Dimensions() (int, int) {
+	for _, stream := range r.Streams {
+		if stream.CodecType == "video" {
+			return stream.Width, stream.Height
+		}
+	}
+
+	return 0, 0
+}
+
+// Codec returns codec name of first stream with given type, or empty string.
+func (r *Result) Codec(codecType string) string {
+	for _, stream := range r.Streams {
+		if stream.CodecType == codecType {
+			return stream.CodecName
+		}
+	}
+
+	return ""
+}
+
+// Tracks returns streams of given type in their order, so that their
+// positions match stream specifiers, e.g. 0:a:1.
+func (r *Result) Tracks(codecType string) []Stream {
+	tracks := []Stream{}
+	for _, stream := range r.Streams {
+		if stream.CodecType == codecType {
+			tracks = append(tracks, stream)
+		}
+	}
+
+	return tracks
+}
+
+// Duration returns duration of source, or zero for live sources.
+func (r *Result) Duration() time.Duration {
+	seconds, err := strconv.ParseFloat(r.Format.Duration, 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Info summarizes probed source.
+type Info struct {
+	Format string `json:"format"`
+	// in seconds, omitted for live sources
+	Duration float64 `json:"duration,omitempty"`
+	// in bits per second, omitted when unknown
+	BitRate int          `json:"bit_rate,omitempty"`
+	Streams []InfoStream `json:"streams"`
+}
+
+type InfoStream struct {
+	Index    int    `json:"index"`
+	Type     string `json:"type"`
+	Codec    string `json:"codec"`
+	Profile  string `json:"profile,omitempty"`
+	Language string `json:"language,omitempty"`
+	BitRate  int    `json:"bit_rate,omitempty"`
+	// video
+	Width       int     `json:"width,omitempty"`
+	Height      int     `json:"height,omitempty"`
+	FrameRate   float64 `json:"frame_rate,omitempty"`
+	PixelFormat string  `json:"pixel_format,omitempty"`
+	// audio
+	SampleRate    int    `json:"sample_rate,omitempty"`
+	Channels      int    `json:"channels,omitempty"`
+	ChannelLayout string `json:"channel_layout,omitempty"`
+}
+
+func (r *Result) Info() Info {
+	info := Info{
+		Format:   r.Format.FormatName,
+		Duration: r.Duration().Seconds(),
+		BitRate:  parseInt(r.Format.BitRate),
+		Streams:  []InfoStream{},
+	}
+
+	for _, stream := range r.Streams {
+		info.Streams = append(info.Streams, InfoStream{
+			Index:         stream.Index,
+			Type:          stream.CodecType,
+			Codec:         stream.CodecName,
+			Profile:       stream.Profile,
+			Language:      stream.Tags.Language,
+			BitRate:       parseInt(stream.BitRate),
+			Width:         stream.Width,
+			Height:        stream.Height,
+			FrameRate:     parseRate(stream.AvgFrameRate),
+			PixelFormat:   stream.PixFmt,
+			SampleRate:    parseInt(stream.SampleRate),
+			Channels:      stream.Channels,
+			ChannelLayout: stream.ChannelLayout,
+		})
+	}
+
+	return info
+}
+
+// parseInt returns zero for missing or invalid values, e.g. N/A.
+func parseInt(value string) int {
+	n, _ := strconv.Atoi(value)
+	return n
+}
+
+// parseRate parses fraction, e.g. 30000/1001, rounded to milliframes.
+func parseRate(value string) float64 {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return 0
+	}
+
+	num, err1 := strconv.ParseFloat(parts[0], 64)
+	den, err2 := strconv.ParseFloat(parts[1], 64)
+	if err1 != nil || err2 != nil || den == 0 {
+		return 0
+	}
+
+	return math.Round(num/den*1000) / 1000
+}
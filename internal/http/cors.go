@@ -0,0 +1,73 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/m1k1o/go-transcode/internal/config"
+)
+
+// response headers readable by scripts of other origins, e.g. hls.js
+// requesting byte ranges of segments or whep clients reading session url
+const corsExposedHeaders = "Content-Length, Content-Range, Accept-Ranges, ETag, Retry-After, Location"
+
+// CORS allows requests of configured origins to every route, answering
+// their preflight requests. Requests of other origins are served without
+// cors headers, so browsers refuse them. Disabled without origins.
+func CORS(conf *config.Server) func(http.Handler) http.Handler {
+	origins := map[string]bool{}
+	for _, origin := range conf.CORSOrigins {
+		origins[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	methods := strings.Join(conf.CORSMethods, ", ")
+	headers := strings.Join(conf.CORSHeaders, ", ")
+	maxAge := strconv.Itoa(int(conf.CORSMaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		if len(origins) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// responses differ by origin, caches must not mix them
+			w.Header().Add("Vary", "Origin")
+			if !origins["*"] && !origins[origin] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if origins["*"] {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+
+			// preflight request, that is not routed
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", methods)
+				if headers == "*" {
+					// wildcard is not honored for authorization header
+					w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+				} else {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+				if conf.CORSMaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", maxAge)
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,9 +2,10 @@ package http
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/http"
 	"os"
-	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -29,6 +30,7 @@ func New(ApiManager types.ApiManager, conf *config.Server) *ServerCtx {
 	router.Use(middleware.Recoverer) // Recover from panics without crashing server
 	router.Use(middleware.RequestID) // Create a request ID for each request
 	router.Use(Logger)               // Log API request calls using custom logger function
+	router.Use(CORS(conf))           // Allow cross origin requests of browser players
 
 	ApiManager.Mount(router)
 
@@ -61,17 +63,24 @@ func New(ApiManager types.ApiManager, conf *config.Server) *ServerCtx {
 	}
 }
 
+// Start binds listener synchronously, so that server is reachable
+// as soon as it returns.
 func (s *ServerCtx) Start() {
+	listener, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		s.logger.Panic().Err(err).Msg("unable to bind server")
+	}
+
 	if s.conf.Cert != "" && s.conf.Key != "" {
 		go func() {
-			if err := s.http.ListenAndServeTLS(s.conf.Cert, s.conf.Key); err != http.ErrServerClosed {
+			if err := s.http.ServeTLS(listener, s.conf.Cert, s.conf.Key); err != http.ErrServerClosed {
 				s.logger.Panic().Err(err).Msg("unable to start https server")
 			}
 		}()
 		s.logger.Info().Msgf("https listening on %s", s.http.Addr)
 	} else {
 		go func() {
-			if err := s.http.ListenAndServe(); err != http.ErrServerClosed {
+			if err := s.http.Serve(listener); err != http.ErrServerClosed {
 				s.logger.Panic().Err(err).Msg("unable to start http server")
 			}
 		}()
@@ -79,9 +88,17 @@ func (s *ServerCtx) Start() {
 	}
 }
 
+// Shutdown stops accepting connections and waits for in-flight requests
+// up to drain timeout, remaining connections are closed then.
 func (s *ServerCtx) Shutdown() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.conf.DrainTimeout)
 	defer cancel()
 
-	return s.http.Shutdown(ctx)
+	err := s.http.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		s.logger.Warn().Dur("timeout", s.conf.DrainTimeout).Msg("drain timeout reached, closing remaining connections")
+		return s.http.Close()
+	}
+
+	return err
 }
@@ -0,0 +1,301 @@
+package hwaccel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long can encoders detection take
+const detectTimeout = 10 * time.Second
+
+// render node shared by vaapi and qsv, that must be present
+const renderDevice = "/dev/dri/renderD128"
+
+// device nodes of encoders, every one is separate device
+const (
+	renderDevicePattern = "/dev/dri/renderD[0-9]*"
+	nvidiaDevicePattern = "/dev/nvidia[0-9]*"
+)
+
+// Encoder of h264 video, passed to profiles as environment variables.
+type Encoder struct {
+	Name string
+	// ffmpeg encoder
+	Codec string
+	// device node required by encoder
+	Device string
+	// index of device among devices of encoder, gpu number of nvenc
+	Index int
+	// ffmpeg options initializing device, preceding input
+	InputOptions []string
+	// ffmpeg options of encoder selecting device, following codec
+	EncoderOptions []string
+	// filter uploading software frames to device, appended to video filters
+	UploadFilter string
+}
+
+// Software encoder is used when no hardware encoder is available.
+var Software = Encoder{
+	Name:  "software",
+	Codec: "libx264",
+}
+
+// ErrSaturated is returned when all sessions of hardware encoders are in
+// use and software fallback is disabled.
+var ErrSaturated = errors.New("hardware encoder sessions exhausted")
+
+var hardware = map[string]Encoder{
+	"nvenc": {
+		Name:   "nvenc",
+		Codec:  "h264_nvenc",
+		Device: "/dev/nvidiactl",
+	},
+	"vaapi": {
+		Name:         "vaapi",
+		Codec:        "h264_vaapi",
+		Device:       renderDevice,
+		UploadFilter: "format=nv12,hwupload",
+	},
+	"qsv": {
+		Name:         "qsv",
+		Codec:        "h264_qsv",
+		Device:       renderDevice,
+		UploadFilter: "hwupload=extra_hw_frames=64,format=qsv",
+	},
+}
+
+// devices returns encoder for every present device of hardware encoder.
+func devices(encoder Encoder) []Encoder {
+	if _, err := os.Stat(encoder.Device); err != nil {
+		return nil
+	}
+
+	pattern := renderDevicePattern
+	if encoder.Name == "nvenc" {
+		pattern = nvidiaDevicePattern
+	}
+
+	paths, _ := filepath.Glob(pattern)
+	nodes := []int{}
+	for _, path := range paths {
+		digits := strings.TrimLeftFunc(filepath.Base(path), func(r rune) bool {
+			return r < '0' || r > '9'
+		})
+		if n, err := strconv.Atoi(digits); err == nil {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Ints(nodes)
+
+	result := []Encoder{}
+	for i, node := range nodes {
+		device := encoder
+		device.Index = i
+
+		switch encoder.Name {
+		case "nvenc":
+			// gpus are numbered by their device nodes
+			device.Device = fmt.Sprintf("/dev/nvidia%d", node)
+			device.Index = node
+			device.EncoderOptions = []string{"-gpu", strconv.Itoa(node)}
+		case "vaapi":
+			device.Device = fmt.Sprintf("/dev/dri/renderD%d", node)
+			device.InputOptions = []string{"-vaapi_device", device.Device}
+		case "qsv":
+			device.Device = fmt.Sprintf("/dev/dri/renderD%d", node)
+			device.InputOptions = []string{"-init_hw_device", "qsv=hw:" + device.Device, "-filter_hw_device", "hw"}
+		}
+
+		result = append(result, device)
+	}
+
+	return result
+}
+
+func (e Encoder) Env() []string {
+	return []string{
+		"TRANSCODE_H264_ENCODER=" + e.Codec,
+		"TRANSCODE_HW_INPUT_OPTIONS=" + strings.Join(e.InputOptions, " "),
+		"TRANSCODE_HW_ENCODER_OPTIONS=" + strings.Join(e.EncoderOptions, " "),
+		"TRANSCODE_HW_UPLOAD_FILTER=" + e.UploadFilter,
+	}
+}
+
+// id identifies device of encoder.
+func (e Encoder) id() string {
+	return e.Name + ":" + e.Device
+}
+
+// Validate returns error for unknown hardware encoder names.
+func Validate(names []string) error {
+	for _, name := range names {
+		if _, ok := hardware[name]; !ok {
+			return fmt.Errorf("unknown hardware encoder %q", name)
+		}
+	}
+	return nil
+}
+
+// Selector picks least loaded device of first not saturated hardware
+// encoder, falling back to software encoder.
+type Selector struct {
+	mu sync.Mutex
+	// devices of detected hardware encoders in order of priority
+	encoders []Encoder
+	// maximum concurrent sessions of every device by encoder name,
+	// unlimited when missing
+	sessions map[string]int
+	// transcodes are rejected instead of using software encoder, when
+	// all hardware sessions are in use
+	reject bool
+	// sessions of transcodes using devices by id, held from pick until
+	// their process exits
+	active map[string]int
+}
+
+// Detect returns selector of given hardware encoders in order of priority,
+// that are both supported by ffmpeg and have their devices present.
+func Detect(ctx context.Context, priority []string, sessions map[string]int, reject bool) (*Selector, error) {
+	s := &Selector{
+		sessions: sessions,
+		reject:   reject,
+		active:   map[string]int{},
+	}
+
+	if len(priority) == 0 {
+		return s, nil
+	}
+
+	if err := Validate(priority); err != nil {
+		return s, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, detectTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-encoders")
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return s, err
+	}
+
+	// lines list capabilities followed by encoder name
+	supported := map[string]bool{}
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 {
+			supported[fields[1]] = true
+		}
+	}
+
+	for _, name := range priority {
+		encoder := hardware[name]
+		if !supported[encoder.Codec] {
+			continue
+		}
+
+		s.encoders = append(s.encoders, devices(encoder)...)
+	}
+
+	return s, nil
+}
+
+// Available returns names of detected hardware encoders.
+func (s *Selector) Available() []string {
+	names := []string{}
+	for _, encoder := range s.encoders {
+		if len(names) == 0 || names[len(names)-1] != encoder.Name {
+			names = append(names, encoder.Name)
+		}
+	}
+	return names
+}
+
+// Sessions of device of hardware encoder, e.g. for dashboards.
+type Sessions struct {
+	Name   string `json:"name"`
+	Device string `json:"device"`
+	Index  int    `json:"index"`
+	// transcodes of this server using device
+	Running int `json:"running"`
+	// zero when unlimited
+	Limit int `json:"limit,omitempty"`
+}
+
+// Sessions returns sessions of devices of detected hardware encoders.
+func (s *Selector) Sessions() []Sessions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := []Sessions{}
+	for _, encoder := range s.encoders {
+		sessions = append(sessions, Sessions{
+			Name:    encoder.Name,
+			Device:  encoder.Device,
+			Index:   encoder.Index,
+			Running: s.active[encoder.id()],
+			Limit:   s.sessions[encoder.Name],
+		})
+	}
+	return sessions
+}
+
+// Pick returns encoder for new transcode, using least loaded device of
+// first hardware encoder that has device with free sessions. Session of
+// picked device is held until it is given back by Release. Returns
+// ErrSaturated instead of software encoder, when rejecting.
+func (s *Selector) Pick() (Encoder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	best := -1
+	for i, encoder := range s.encoders {
+		// devices of preferred encoder are exhausted first
+		if best >= 0 && s.encoders[best].Name != encoder.Name {
+			break
+		}
+
+		load := s.active[encoder.id()]
+		limit, ok := s.sessions[encoder.Name]
+		if ok && limit > 0 && load >= limit {
+			continue
+		}
+
+		if best < 0 || load < s.active[s.encoders[best].id()] {
+			best = i
+		}
+	}
+
+	if best < 0 {
+		if s.reject && len(s.encoders) > 0 {
+			return Software, ErrSaturated
+		}
+		return Software, nil
+	}
+
+	encoder := s.encoders[best]
+	s.active[encoder.id()]++
+	return encoder, nil
+}
+
+// Release gives back session of encoder returned by Pick, once process of
+// its transcode exits or fails to start. Software encoder holds none.
+func (s *Selector) Release(encoder Encoder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active[encoder.id()] > 0 {
+		s.active[encoder.id()]--
+	}
+}
@@ -0,0 +1,77 @@
+package hwaccel
+
+import (
+	"errors"
+	"testing"
+)
+
+func testSelector(limit int, reject bool) *Selector {
+	return &Selector{
+		encoders: []Encoder{
+			{Name: "nvenc", Codec: "h264_nvenc", Device: "/dev/nvidia0"},
+			{Name: "nvenc", Codec: "h264_nvenc", Device: "/dev/nvidia1", Index: 1},
+			{Name: "vaapi", Codec: "h264_vaapi", Device: "/dev/dri/renderD128"},
+		},
+		sessions: map[string]int{"nvenc": limit, "vaapi": limit},
+		reject:   reject,
+		active:   map[string]int{},
+	}
+}
+
+func TestPickReleased(t *testing.T) {
+	s := testSelector(1, false)
+
+	picked := []Encoder{}
+	for _, want := range []string{"/dev/nvidia0", "/dev/nvidia1", "/dev/dri/renderD128", ""} {
+		encoder, err := s.Pick()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if encoder.Device != want {
+			t.Errorf("got device %q, want %q", encoder.Device, want)
+		}
+		picked = append(picked, encoder)
+	}
+
+	// sessions are held until released, not for a period
+	for _, sessions := range s.Sessions() {
+		if sessions.Running != 1 {
+			t.Errorf("%s: got %d running, want 1", sessions.Device, sessions.Running)
+		}
+	}
+
+	// software encoder holds no session
+	s.Release(picked[3])
+	s.Release(picked[1])
+	if encoder, _ := s.Pick(); encoder.Device != "/dev/nvidia1" {
+		t.Errorf("got device %q, want released /dev/nvidia1", encoder.Device)
+	}
+}
+
+func TestPickLeastLoaded(t *testing.T) {
+	s := testSelector(0, false)
+
+	for _, want := range []string{"/dev/nvidia0", "/dev/nvidia1", "/dev/nvidia0"} {
+		if encoder, _ := s.Pick(); encoder.Device != want {
+			t.Errorf("got device %q, want %q", encoder.Device, want)
+		}
+	}
+}
+
+func TestPickRejected(t *testing.T) {
+	s := testSelector(1, true)
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.Pick(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	encoder, err := s.Pick()
+	if !errors.Is(err, ErrSaturated) {
+		t.Fatalf("got error %v, want %v", err, ErrSaturated)
+	}
+	if encoder.Codec != Software.Codec {
+		t.Errorf("got codec %q, want %q", encoder.Codec, Software.Codec)
+	}
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// argPlaceholderRegex matches whole argument referencing transcode environment
+// variable, e.g. ${TRANSCODE_RTP_VIDEO} or ${TRANSCODE_AUDIO_ONLY:+-vn}.
+var argPlaceholderRegex = regexp.MustCompile(`^\$\{(TRANSCODE_[0-9A-Z_]+)(?::([-+])([^}]*))?\}$`)
+
+// ExpandArgs resolves placeholder arguments of cmd using its environment,
+// for commands built without shell, that get parts of their environment
+// only before start. As in unquoted shell expansion, values are split into
+// fields and empty ones are dropped.
+func ExpandArgs(cmd *exec.Cmd) {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	lookup := func(name string) string {
+		value := ""
+		for _, v := range env {
+			if strings.HasPrefix(v, name+"=") {
+				value = v[len(name)+1:]
+			}
+		}
+		return value
+	}
+
+	args := []string{}
+	for i, arg := range cmd.Args {
+		match := argPlaceholderRegex.FindStringSubmatch(arg)
+		if i == 0 || match == nil {
+			args = append(args, arg)
+			continue
+		}
+
+		value := lookup(match[1])
+		switch match[2] {
+		case "-":
+			if value == "" {
+				value = match[3]
+			}
+		case "+":
+			if value != "" {
+				value = match[3]
+			}
+		}
+
+		args = append(args, strings.Fields(value)...)
+	}
+
+	cmd.Args = args
+}
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// cgroups of transcodes are created in cgroup dir with this prefix
+const processCgroupPrefix = "transcode-"
+
+// cgroup v2 period of cpu.max in microseconds
+const processCPUPeriod = 100000
+
+// ProcessLimits of spawned transcode process, zero values keep it
+// unlimited.
+type ProcessLimits struct {
+	// niceness from -20 to 19, negative requires privileges
+	Nice int
+	// cpu list the process is pinned to, e.g. 0-3,6
+	CPUs string
+	// delegated cgroup v2 directory, required for cpu and memory limits
+	CgroupDir string
+	// cpu time in cores, e.g. 1.5
+	CPUMax float64
+	// memory in bytes, the process is killed once exceeded
+	MemoryMax int64
+}
+
+func (l ProcessLimits) Validate() error {
+	if l.Nice < -20 || l.Nice > 19 {
+		return errors.New("nice must be between -20 and 19")
+	}
+
+	if l.CPUs != "" {
+		if _, err := ParseCPUList(l.CPUs); err != nil {
+			return err
+		}
+	}
+
+	if l.CPUMax < 0 || l.MemoryMax < 0 {
+		return errors.New("cpu and memory limits must not be negative")
+	}
+
+	if (l.CPUMax > 0 || l.MemoryMax > 0) && l.CgroupDir == "" {
+		return errors.New("cpu and memory limits require cgroup dir")
+	}
+
+	return nil
+}
+
+func (l ProcessLimits) cgroup() bool {
+	return l.CgroupDir != "" && (l.CPUMax > 0 || l.MemoryMax > 0)
+}
+
+// Apply sets limits of started process. Niceness and affinity are
+// inherited by its children, cgroup contains them as well.
+func (l ProcessLimits) Apply(pid int) error {
+	var errs []string
+
+	if l.Nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, pid, l.Nice); err != nil {
+			errs = append(errs, fmt.Sprintf("nice: %v", err))
+		}
+	}
+
+	if l.CPUs != "" {
+		if err := l.setAffinity(pid); err != nil {
+			errs = append(errs, fmt.Sprintf("affinity: %v", err))
+		}
+	}
+
+	if l.cgroup() {
+		if err := l.joinCgroup(pid); err != nil {
+			errs = append(errs, fmt.Sprintf("cgroup: %v", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+func (l ProcessLimits) setAffinity(pid int) error {
+	cpus, err := ParseCPUList(l.CPUs)
+	if err != nil {
+		return err
+	}
+
+	set := unix.CPUSet{}
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+
+	return unix.SchedSetaffinity(pid, &set)
+}
+
+// joinCgroup moves process to its own cgroup with cpu and memory limits.
+// Cgroups of exited transcodes are removed, once they become empty.
+func (l ProcessLimits) joinCgroup(pid int) error {
+	removeStaleCgroups(l.CgroupDir)
+
+	// controllers are enabled for children, missing ones fail below
+	ioutil.WriteFile(filepath.Join(l.CgroupDir, "cgroup.subtree_control"), []byte("+cpu +memory"), 0644)
+
+	dir := filepath.Join(l.CgroupDir, processCgroupPrefix+strconv.Itoa(pid))
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	if l.CPUMax > 0 {
+		quota := fmt.Sprintf("%d %d", int64(l.CPUMax*processCPUPeriod), processCPUPeriod)
+		if err := ioutil.WriteFile(filepath.Join(dir, "cpu.max"), []byte(quota), 0644); err != nil {
+			os.Remove(dir)
+			return err
+		}
+	}
+
+	if l.MemoryMax > 0 {
+		if err := ioutil.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatInt(l.MemoryMax, 10)), 0644); err != nil {
+			os.Remove(dir)
+			return err
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		os.Remove(dir)
+		return err
+	}
+
+	return nil
+}
+
+// removeStaleCgroups removes cgroups of transcodes, removal of ones with
+// running processes fails.
+func removeStaleCgroups(cgroupDir string) {
+	dirs, _ := filepath.Glob(filepath.Join(cgroupDir, processCgroupPrefix+"*"))
+	for _, dir := range dirs {
+		os.Remove(dir)
+	}
+}
+
+// ParseCPUList parses cpu list in format of cpuset, e.g. 0-3,6.
+func ParseCPUList(list string) ([]int, error) {
+	cpus := []int{}
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid cpu list %q", list)
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid cpu list %q", list)
+			}
+		}
+
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+
+	return cpus, nil
+}
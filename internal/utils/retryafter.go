@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// RetryAfter returns value of Retry-After header asking client to retry in
+// about one segment duration, but not sooner than min. It is in whole
+// seconds, at least one.
+func RetryAfter(segmentDuration time.Duration, min time.Duration) string {
+	retryAfter := segmentDuration
+	if retryAfter < min {
+		retryAfter = min
+	}
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return strconv.Itoa(seconds)
+}
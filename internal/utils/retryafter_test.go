@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name            string
+		segmentDuration time.Duration
+		min             time.Duration
+		want            string
+	}{
+		{"segment duration", 4 * time.Second, time.Second, "4"},
+		{"rounded up", 2500 * time.Millisecond, 0, "3"},
+		{"floor", 2 * time.Second, 5 * time.Second, "5"},
+		{"at least one second", 0, 0, "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RetryAfter(tt.segmentDuration, tt.min); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
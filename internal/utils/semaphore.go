@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrSemaphoreFull = errors.New("too many concurrent operations")
+
+// Semaphore limits number of concurrent operations, nil is unlimited.
+type Semaphore struct {
+	slots chan struct{}
+}
+
+func NewSemaphore(size int) *Semaphore {
+	if size <= 0 {
+		return nil
+	}
+
+	return &Semaphore{
+		slots: make(chan struct{}, size),
+	}
+}
+
+// Acquire waits for free slot until context is done.
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	// free slot is taken even if context is already done
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ErrSemaphoreFull
+	}
+}
+
+func (s *Semaphore) Release() {
+	if s == nil {
+		return
+	}
+
+	<-s.slots
+}
+
+// AcquireAll takes slot of every semaphore, in order, or none of them.
+func AcquireAll(ctx context.Context, limits []*Semaphore) error {
+	for i, limit := range limits {
+		if err := limit.Acquire(ctx); err != nil {
+			ReleaseAll(limits[:i])
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TryAcquireAll takes slot of every semaphore without waiting, or none of
+// them.
+func TryAcquireAll(limits []*Semaphore) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return AcquireAll(ctx, limits)
+}
+
+// ReleaseAll frees slots taken by AcquireAll.
+func ReleaseAll(limits []*Semaphore) {
+	for _, limit := range limits {
+		limit.Release()
+	}
+}
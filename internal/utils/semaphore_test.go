@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSemaphoreCap(t *testing.T) {
+	s := NewSemaphore(2)
+
+	var running, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := s.Acquire(context.Background()); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			defer s.Release()
+
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+
+	if peak != 2 {
+		t.Errorf("got %d concurrent operations, want 2", peak)
+	}
+}
+
+func TestSemaphoreTimeout(t *testing.T) {
+	s := NewSemaphore(1)
+	if err := s.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := s.Acquire(ctx); err != ErrSemaphoreFull {
+		t.Errorf("got %v, want %v", err, ErrSemaphoreFull)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("gave up after %v, before timeout", elapsed)
+	}
+
+	// released slot is taken by next one
+	s.Release()
+	if err := s.Acquire(ctx); err != nil {
+		t.Errorf("got %v once released, want slot", err)
+	}
+}
+
+func TestSemaphoreUnlimited(t *testing.T) {
+	s := NewSemaphore(0)
+	if s != nil {
+		t.Fatal("got semaphore, want unlimited")
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := s.Acquire(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	s.Release()
+}
+
+func TestTryAcquireAll(t *testing.T) {
+	first, second := NewSemaphore(1), NewSemaphore(1)
+	if err := second.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := TryAcquireAll([]*Semaphore{first, second}); err != ErrSemaphoreFull {
+		t.Fatalf("got %v, want %v", err, ErrSemaphoreFull)
+	}
+
+	// slot of first one is given back
+	if err := TryAcquireAll([]*Semaphore{first}); err != nil {
+		t.Errorf("got %v, want slot released on failure", err)
+	}
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// TempRoot returns parent of temporary directories of transcodes. Given
+// dir is created when missing, otherwise tmpfs is returned when preferred
+// and writable, or empty string for default of os.
+func TempRoot(dir string, preferTmpfs bool, tmpfs string) (string, error) {
+	if dir != "" {
+		return dir, os.MkdirAll(dir, 0755)
+	}
+
+	if preferTmpfs && IsTmpfs(tmpfs) && unix.Access(tmpfs, unix.W_OK) == nil {
+		return tmpfs, nil
+	}
+
+	return "", nil
+}
+
+// IsTmpfs reports whether dir is on tmpfs, being kept in memory.
+func IsTmpfs(dir string) bool {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return false
+	}
+
+	return stat.Type == unix.TMPFS_MAGIC
+}
@@ -3,6 +3,7 @@ package transcode
 import (
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -19,12 +20,22 @@ func init() {
 	Service = &Main{
 		RootConfig:   &config.Root{},
 		ServerConfig: &config.Server{},
+		HLSConfig:    &config.HLS{},
+
+		ThumbnailsConfig: &config.Thumbnails{},
+		AnalyticsConfig:  &config.Analytics{},
+		WebhooksConfig:   &config.Webhooks{},
 	}
 }
 
 type Main struct {
 	RootConfig   *config.Root
 	ServerConfig *config.Server
+	HLSConfig    *config.HLS
+
+	ThumbnailsConfig *config.Thumbnails
+	AnalyticsConfig  *config.Analytics
+	WebhooksConfig   *config.Webhooks
 
 	logger     zerolog.Logger
 	apiManager *api.ApiManagerCtx
@@ -36,31 +47,75 @@ func (main *Main) Preflight() {
 }
 
 func (main *Main) Start() {
-	main.apiManager = api.New()
+	main.apiManager = api.New(main.ServerConfig, main.HLSConfig, main.ThumbnailsConfig, main.AnalyticsConfig, main.WebhooksConfig)
 
 	main.server = http.New(
 		main.apiManager,
 		main.ServerConfig,
 	)
 	main.server.Start()
+	main.apiManager.Start()
+
+	// preload after server is reachable
+	go main.apiManager.Preload()
 }
 
 func (main *Main) Shutdown() {
+	// load balancers see server as not ready, while it drains
+	main.apiManager.Drain()
+
 	if err := main.server.Shutdown(); err != nil {
 		main.logger.Err(err).Msg("server shutdown with an error")
 	} else {
 		main.logger.Debug().Msg("server shutdown")
 	}
+
+	main.apiManager.Shutdown()
+}
+
+// validate logs problems of config and reports whether it is valid.
+func (main *Main) validate() bool {
+	problems := api.Validate(main.ServerConfig)
+	for _, err := range problems {
+		main.logger.Error().Msg(err.Error())
+	}
+	return len(problems) == 0
+}
+
+func (main *Main) ValidateCommand(cmd *cobra.Command, args []string) {
+	if !main.validate() {
+		main.logger.Error().Msg("config is invalid")
+		os.Exit(1)
+	}
+	main.logger.Info().Msg("config is valid")
 }
 
 func (main *Main) ServeCommand(cmd *cobra.Command, args []string) {
+	// fail fast, instead of once streams are requested
+	if !main.validate() {
+		main.logger.Panic().Msg("config is invalid, check it using validate command")
+	}
+
 	main.logger.Info().Msg("starting main server")
 	main.Start()
 	main.logger.Info().Msg("main ready")
 
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
-	sig := <-quit
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	var sig os.Signal
+	for sig == nil {
+		select {
+		case <-reload:
+			// failed reload is logged and current config is kept
+			main.logger.Info().Msg("received SIGHUP, reloading config")
+			_ = main.apiManager.Reload()
+		case sig = <-quit:
+		}
+	}
 
 	main.logger.Warn().Msgf("received %s, attempting graceful shutdown: \n", sig)
 	main.Shutdown()
@@ -0,0 +1,28 @@
+#!/bin/sh
+
+exec ffmpeg -hide_banner -loglevel warning ${TRANSCODE_PROGRESS_OPTIONS} \
+  ${TRANSCODE_INPUT_OPTIONS} -i "${1}" \
+  -filter_complex "[0:v:0]${TRANSCODE_DEINTERLACE_FILTER:+${TRANSCODE_DEINTERLACE_FILTER},}split=3[v1][v2][v3];[v1]scale=w=1920:h=1080:force_original_aspect_ratio=decrease${TRANSCODE_SCALE_FLAGS:+:flags=${TRANSCODE_SCALE_FLAGS}}[v1080];[v2]scale=w=1280:h=720:force_original_aspect_ratio=decrease${TRANSCODE_SCALE_FLAGS:+:flags=${TRANSCODE_SCALE_FLAGS}}[v720];[v3]scale=w=854:h=480:force_original_aspect_ratio=decrease${TRANSCODE_SCALE_FLAGS:+:flags=${TRANSCODE_SCALE_FLAGS}}[v480]" \
+  -map "[v1080]" -map "[v720]" -map "[v480]" \
+  -map 0:a:0 -map 0:a:0 -map 0:a:0 \
+    -c:a "${TRANSCODE_AUDIO_CODEC:-aac}" \
+      -ar 48000 \
+      -b:a 128k \
+    -c:v h264 \
+      -profile:v main \
+      -crf 20 \
+      -sc_threshold 0 \
+      -g 48 \
+      -keyint_min 48 \
+      -b:v:0 5000k -maxrate:v:0 5350k -bufsize:v:0 7500k \
+      -b:v:1 2800k -maxrate:v:1 2996k -bufsize:v:1 4200k \
+      -b:v:2 1400k -maxrate:v:2 1498k -bufsize:v:2 2100k \
+  -f hls \
+    -hls_time 2 \
+    -hls_list_size 5 \
+    -hls_delete_threshold 1 \
+    -hls_flags delete_segments+independent_segments \
+    -hls_start_number_source datetime \
+    -master_pl_name master.m3u8 \
+    -var_stream_map "v:0,a:0,name:1080p v:1,a:1,name:720p v:2,a:2,name:480p" \
+    -hls_segment_filename "%v_%03d.ts" "%v.m3u8"
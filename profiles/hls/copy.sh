@@ -1,15 +1,17 @@
 #!/bin/sh
 
-exec ffmpeg -hide_banner -loglevel warning \
-  -i "${1}" \
-  -map 0:v:0 -map 0:a:0 \
+exec ffmpeg -hide_banner -loglevel warning ${TRANSCODE_PROGRESS_OPTIONS} \
+  ${TRANSCODE_INPUT_OPTIONS} -i "${1}" \
+  -map 0:v:0 -map "${TRANSCODE_AUDIO_MAP:-0:a:0}" ${TRANSCODE_AUDIO_ONLY:+-vn} \
   -c:a copy \
   -c:v copy \
   -f hls \
     -hls_time 2 \
-    -hls_list_size 5 \
-    -hls_wrap 10 \
+    -hls_list_size ${TRANSCODE_HLS_LIST_SIZE:-5} \
+    -hls_wrap ${TRANSCODE_HLS_WRAP:-10} \
     -hls_delete_threshold 1 \
-    -hls_flags delete_segments \
+    -hls_flags delete_segments${TRANSCODE_HLS_KEY_INFO_FILE:++periodic_rekey} \
+    ${TRANSCODE_HLS_KEY_INFO_FILE:+-hls_key_info_file "${TRANSCODE_HLS_KEY_INFO_FILE}"} \
     -hls_start_number_source datetime \
-    -hls_segment_filename "live_%03d.ts" -
+    ${TRANSCODE_HLS_METHOD:+-method "${TRANSCODE_HLS_METHOD}"} \
+    -hls_segment_filename "${TRANSCODE_HLS_SEGMENT_FILENAME:-live_%03d.ts}" -
@@ -0,0 +1,28 @@
+#!/bin/sh
+
+exec ffmpeg -hide_banner -loglevel warning ${TRANSCODE_PROGRESS_OPTIONS} \
+  ${TRANSCODE_INPUT_OPTIONS} -i "${1}" \
+  -map 0:v:0 -map "${TRANSCODE_AUDIO_MAP:-0:a:0}" ${TRANSCODE_AUDIO_ONLY:+-vn} \
+  -vf "${TRANSCODE_DEINTERLACE_FILTER:+${TRANSCODE_DEINTERLACE_FILTER},}scale=w=1280:h=720:force_original_aspect_ratio=decrease${TRANSCODE_SCALE_FLAGS:+:flags=${TRANSCODE_SCALE_FLAGS}}${TRANSCODE_SHARPEN_FILTER:+,${TRANSCODE_SHARPEN_FILTER}}${TRANSCODE_SUBTITLES_FILTER:+,${TRANSCODE_SUBTITLES_FILTER}}" \
+    -c:a "${TRANSCODE_AUDIO_CODEC:-aac}" \
+      -ar 48000 \
+      -b:a 128k \
+    -c:v h264 \
+      -profile:v main \
+      -tune zerolatency \
+      -b:v 2800k \
+      -maxrate 2996k \
+      -bufsize 4200k \
+      -crf 20 \
+      -sc_threshold 0 \
+      -g 48 \
+      -keyint_min 48 \
+  -f hls \
+    -hls_time 2 \
+    -hls_list_size ${TRANSCODE_HLS_LIST_SIZE:-5} \
+    -hls_delete_threshold 3 \
+    -hls_flags delete_segments \
+    -hls_segment_type fmp4 \
+    -hls_fmp4_init_filename "init.mp4" \
+    -hls_segment_options "frag_duration=${TRANSCODE_HLS_PART_DURATION_US:-500000}" \
+    -hls_segment_filename "live_%d.m4s" -
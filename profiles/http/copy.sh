@@ -1,7 +1,7 @@
 #!/bin/sh
 
 exec ffmpeg -hide_banner -loglevel warning \
-  -i "${1}" \
+  ${TRANSCODE_INPUT_OPTIONS} -i "${1}" \
   -c:a copy \
   -c:v copy \
-  -f mpegts -
+  -f "${TRANSCODE_HTTP_FORMAT:-mpegts}" ${TRANSCODE_HTTP_FORMAT_OPTIONS} -
@@ -1,18 +0,0 @@
-#!/bin/sh
-
-exec ffmpeg -hide_banner -loglevel warning \
-  -i "${1}" \
-  -vf scale=w=960:h=540:force_original_aspect_ratio=decrease \
-    -c:a aac \
-      -ar 48000 \
-      -b:a 128k \
-    -c:v h264 \
-      -profile:v main \
-      -b:v 1800k \
-      -maxrate 1800k \
-      -bufsize 3100k \
-      -crf 20 \
-      -sc_threshold 0 \
-      -g 48 \
-      -keyint_min 48 \
-  -f mpegts -
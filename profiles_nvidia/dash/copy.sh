@@ -0,0 +1,21 @@
+#!/bin/bash
+
+source "$(dirname "$0")/../.helpers.sh"
+
+exec ffmpeg -hide_banner -loglevel warning \
+  -hwaccel_output_format cuda \
+  -c:v "$(cuvid_codec "${1}")" \
+  ${TRANSCODE_INPUT_OPTIONS} -i "${1}" \
+  -map 0:v:0 -map 0:a:0 \
+  -c:a copy \
+  -c:v copy \
+  -f dash \
+    -seg_duration 2 \
+    -window_size 5 \
+    -extra_window_size 5 \
+    -remove_at_exit 1 \
+    -use_template 1 \
+    -use_timeline 1 \
+    -init_seg_name 'init_$RepresentationID$.m4s' \
+    -media_seg_name 'chunk_$RepresentationID$_$Number%05d$.m4s' \
+    index.mpd
@@ -0,0 +1,32 @@
+#!/bin/bash
+
+source "$(dirname "$0")/../.helpers.sh"
+
+exec ffmpeg -hide_banner -loglevel warning \
+  -hwaccel_output_format cuda \
+  -c:v "$(cuvid_codec "${1}")" \
+  ${TRANSCODE_INPUT_OPTIONS} -i "${1}" \
+  -map 0:v:0 -map 0:a:0 \
+  -vf "${TRANSCODE_DEINTERLACE_CUDA_FILTER:-hwupload_cuda,yadif_cuda=0:-1:0},scale_npp=1280:720:interp_algo=super" \
+    -c:a "${TRANSCODE_AUDIO_CODEC:-aac}" \
+      -ar 48000 \
+      -b:a 128k \
+    -c:v h264_nvenc \
+      -profile:v main \
+      -b:v 2800k \
+      -maxrate 2996k \
+      -bufsize 4200k \
+      -crf 20 \
+      -sc_threshold 0 \
+      -g 48 \
+      -keyint_min 48 \
+  -f dash \
+    -seg_duration 2 \
+    -window_size 5 \
+    -extra_window_size 5 \
+    -remove_at_exit 1 \
+    -use_template 1 \
+    -use_timeline 1 \
+    -init_seg_name 'init_$RepresentationID$.m4s' \
+    -media_seg_name 'chunk_$RepresentationID$_$Number%05d$.m4s' \
+    index.mpd
@@ -2,13 +2,13 @@
 
 source "$(dirname "$0")/../.helpers.sh"
 
-exec ffmpeg -hide_banner -loglevel warning \
+exec ffmpeg -hide_banner -loglevel warning ${TRANSCODE_PROGRESS_OPTIONS} \
   -hwaccel_output_format cuda \
   -c:v "$(cuvid_codec "${1}")" \
-  -i "${1}" \
-  -map 0:v:0 -map 0:a:0 \
-  -vf hwupload_cuda,yadif_cuda=0:-1:0,scale_npp=640:360:interp_algo=super \
-    -c:a aac \
+  ${TRANSCODE_INPUT_OPTIONS} -i "${1}" \
+  -map 0:v:0 -map "${TRANSCODE_AUDIO_MAP:-0:a:0}" ${TRANSCODE_AUDIO_ONLY:+-vn} \
+  -vf "${TRANSCODE_DEINTERLACE_CUDA_FILTER:-hwupload_cuda,yadif_cuda=0:-1:0},scale_npp=640:360:interp_algo=super" \
+    -c:a "${TRANSCODE_AUDIO_CODEC:-aac}" \
       -ar 48000 \
       -b:a 96k \
     -c:v h264_nvenc \
@@ -22,9 +22,10 @@ exec ffmpeg -hide_banner -loglevel warning \
       -keyint_min 48 \
   -f hls \
     -hls_time 2 \
-    -hls_list_size 5 \
-    -hls_wrap 10 \
+    -hls_list_size ${TRANSCODE_HLS_LIST_SIZE:-5} \
+    -hls_wrap ${TRANSCODE_HLS_WRAP:-10} \
     -hls_delete_threshold 1 \
     -hls_flags delete_segments \
     -hls_start_number_source datetime \
-    -hls_segment_filename "live_%03d.ts" -
+    ${TRANSCODE_HLS_METHOD:+-method "${TRANSCODE_HLS_METHOD}"} \
+    -hls_segment_filename "${TRANSCODE_HLS_SEGMENT_FILENAME:-live_%03d.ts}" -
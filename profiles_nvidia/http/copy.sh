@@ -5,7 +5,7 @@ source "$(dirname "$0")/../.helpers.sh"
 exec ffmpeg -hide_banner -loglevel warning \
   -hwaccel_output_format cuda \
   -c:v "$(cuvid_codec "${1}")" \
-  -i "${1}" \
+  ${TRANSCODE_INPUT_OPTIONS} -i "${1}" \
   -c:a copy \
   -c:v copy \
-  -f mpegts -
+  -f "${TRANSCODE_HTTP_FORMAT:-mpegts}" ${TRANSCODE_HTTP_FORMAT_OPTIONS} -
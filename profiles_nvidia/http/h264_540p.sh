@@ -5,9 +5,9 @@ source "$(dirname "$0")/../.helpers.sh"
 exec ffmpeg -hide_banner -loglevel warning \
   -hwaccel_output_format cuda \
   -c:v "$(cuvid_codec "${1}")" \
-  -i "${1}" \
-  -vf hwupload_cuda,yadif_cuda=0:-1:0,scale_npp=960:540:interp_algo=super \
-    -c:a aac \
+  ${TRANSCODE_INPUT_OPTIONS} -i "${1}" \
+  -vf "${TRANSCODE_DEINTERLACE_CUDA_FILTER:-hwupload_cuda,yadif_cuda=0:-1:0},scale_npp=960:540:interp_algo=super" \
+    -c:a "${TRANSCODE_AUDIO_CODEC:-aac}" \
       -ar 48000 \
       -b:a 128k \
     -c:v h264_nvenc \
@@ -19,4 +19,4 @@ exec ffmpeg -hide_banner -loglevel warning \
       -sc_threshold 0 \
       -g 48 \
       -keyint_min 48 \
-  -f mpegts -
+  -f "${TRANSCODE_HTTP_FORMAT:-mpegts}" ${TRANSCODE_HTTP_FORMAT_OPTIONS} -
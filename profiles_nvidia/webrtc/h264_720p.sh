@@ -0,0 +1,29 @@
+#!/bin/bash
+
+source "$(dirname "$0")/../.helpers.sh"
+
+# baseline without b-frames and with short gop, so that browsers can join
+# quickly, sent as rtp to ports given by server
+exec ffmpeg -hide_banner -loglevel warning \
+  -hwaccel_output_format cuda \
+  -c:v "$(cuvid_codec "${1}")" \
+  ${TRANSCODE_INPUT_OPTIONS} -i "${1}" \
+  -map 0:v:0 \
+  -vf "${TRANSCODE_DEINTERLACE_CUDA_FILTER:-hwupload_cuda,yadif_cuda=0:-1:0},scale_npp=1280:720:interp_algo=super" \
+    -c:v h264_nvenc \
+      -profile:v baseline \
+      -zerolatency 1 \
+      -bf 0 \
+      -b:v 2800k \
+      -maxrate 2996k \
+      -bufsize 2100k \
+      -g 30 \
+      -keyint_min 30 \
+    -bsf:v dump_extra \
+  -f rtp -payload_type 96 -pkt_size 1200 "${TRANSCODE_RTP_VIDEO}" \
+  -map "0:a:0?" \
+    -c:a libopus \
+      -ar 48000 \
+      -ac 2 \
+      -b:a 128k \
+  -f rtp -payload_type 111 -pkt_size 1200 "${TRANSCODE_RTP_AUDIO}"
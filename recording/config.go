@@ -0,0 +1,75 @@
+package recording
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// containers of recorded files, also their extensions
+const (
+	FormatMP4    = "mp4"
+	FormatMPEGTS = "ts"
+)
+
+type Config struct {
+	// directory of stream recordings
+	Dir string
+	// container of recorded files, FormatMP4 or FormatMPEGTS
+	Format string
+	// files are split at multiples of duration in wall clock time
+	SegmentDuration time.Duration
+
+	// files older than max age are removed, zero keeps them
+	MaxAge time.Duration
+	// oldest files are removed once recordings exceed size in bytes,
+	// zero disables
+	MaxSize int64
+
+	// how long to wait before exited transcode is started again
+	RestartDelay time.Duration
+	// signal only transcode process instead of its whole process group
+	SingleProcess bool
+	// nice level, cpu affinity and cgroup limits of transcode process
+	ProcessLimits utils.ProcessLimits
+	// called once cmd returned by factory exits or fails to start, e.g.
+	// releasing its hardware encoder session
+	Exited func(cmd *exec.Cmd)
+}
+
+func (c *Config) Validate() error {
+	if c.Dir == "" {
+		return errors.New("recording directory is required")
+	}
+
+	switch c.Format {
+	case FormatMP4, FormatMPEGTS:
+	default:
+		return fmt.Errorf("unknown recording format %q", c.Format)
+	}
+
+	if c.SegmentDuration < time.Second {
+		return errors.New("recording segment duration must be at least one second")
+	}
+
+	if c.MaxAge < 0 || c.MaxSize < 0 {
+		return errors.New("recording retention must not be negative")
+	}
+
+	if c.RestartDelay <= 0 {
+		return errors.New("recording restart delay must be positive")
+	}
+
+	return nil
+}
+
+// muxerFormat is ffmpeg format of recorded files.
+func (c *Config) muxerFormat() string {
+	if c.Format == FormatMPEGTS {
+		return "mpegts"
+	}
+	return c.Format
+}
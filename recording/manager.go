@@ -0,0 +1,371 @@
+package recording
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// how often are recordings exceeding retention removed
+const cleanupPeriod = time.Minute
+
+// how long can muxer finalize its last file on shutdown
+const finalizeTimeout = 10 * time.Second
+
+var ErrAlreadyStarted = errors.New("has already started")
+
+// ManagerCtx records transcode of stream regardless of its viewers. Its
+// output is split into files by separate muxer, that finalizes its last
+// file once transcode exits.
+type ManagerCtx struct {
+	logger     zerolog.Logger
+	mu         sync.Mutex
+	cmdFactory func() (*exec.Cmd, error)
+	config     Config
+
+	running  bool
+	cmd      *exec.Cmd
+	shutdown chan struct{}
+	// closed once muxer of current run exits
+	finalized chan struct{}
+}
+
+func New(cmdFactory func() (*exec.Cmd, error), config Config) *ManagerCtx {
+	return &ManagerCtx{
+		logger:     log.With().Str("module", "recording").Str("submodule", "manager").Str("dir", config.Dir).Logger(),
+		cmdFactory: cmdFactory,
+		config:     config,
+	}
+}
+
+// Start starts recording, that is kept running until stopped. Transcode
+// failing to start is retried after restart delay.
+func (m *ManagerCtx) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.running {
+		return ErrAlreadyStarted
+	}
+
+	if err := os.MkdirAll(m.config.Dir, 0755); err != nil {
+		return err
+	}
+
+	m.running = true
+	m.shutdown = make(chan struct{})
+
+	go func(shutdown chan struct{}) {
+		ticker := time.NewTicker(cleanupPeriod)
+		defer ticker.Stop()
+
+		for {
+			m.cleanup()
+
+			select {
+			case <-shutdown:
+				return
+			case <-ticker.C:
+			}
+		}
+	}(m.shutdown)
+
+	err := m.start()
+	if err != nil {
+		m.logger.Err(err).Msg("recording could not be started")
+		m.restartLater()
+	}
+
+	return err
+}
+
+// start must be called with lock held.
+func (m *ManagerCtx) start() error {
+	m.logger.Debug().Msg("performing start")
+
+	cmd, err := m.cmdFactory()
+	if err != nil {
+		return err
+	}
+
+	started := false
+	defer func() {
+		if !started {
+			m.cmdExited(cmd)
+		}
+	}()
+
+	muxer := m.muxerCmd()
+
+	read, write, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+
+	// children hold their own ends, muxer sees end of file once
+	// transcode exits
+	defer read.Close()
+	defer write.Close()
+
+	cmd.Stdout = write
+	cmd.Stderr = utils.LogWriter(m.logger)
+	muxer.Stdin = read
+	muxer.Stderr = utils.LogWriter(m.logger)
+
+	//create a new process group
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: !m.config.SingleProcess}
+
+	if err := muxer.Start(); err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		write.Close()
+		//nolint
+		muxer.Wait()
+		return err
+	}
+
+	if err := m.config.ProcessLimits.Apply(cmd.Process.Pid); err != nil {
+		m.logger.Warn().Err(err).Msg("unable to apply process limits")
+	}
+
+	started = true
+	finalized := make(chan struct{})
+	m.cmd = cmd
+	m.finalized = finalized
+
+	go func() {
+		err := cmd.Wait()
+		m.logger.Info().Err(err).Msg("cmd exited")
+		m.cmdExited(cmd)
+
+		err = muxer.Wait()
+		m.logger.Info().Err(err).Msg("muxer exited")
+		close(finalized)
+
+		m.exited(cmd)
+	}()
+
+	return nil
+}
+
+// muxerCmd splits transcode output into files aligned to wall clock.
+func (m *ManagerCtx) muxerCmd() *exec.Cmd {
+	args := []string{
+		"-hide_banner", "-loglevel", "warning",
+		"-f", "mpegts", "-i", "pipe:0",
+		"-map", "0", "-c", "copy",
+		"-f", "segment",
+		"-segment_time", strconv.FormatFloat(m.config.SegmentDuration.Seconds(), 'f', -1, 64),
+		"-segment_atclocktime", "1",
+		"-segment_format", m.config.muxerFormat(),
+		"-reset_timestamps", "1",
+		"-strftime", "1",
+	}
+
+	if m.config.Format == FormatMP4 {
+		args = append(args, "-segment_format_options", "movflags=+faststart")
+	}
+
+	args = append(args, filepath.Join(m.config.Dir, "%Y%m%d-%H%M%S."+m.config.Format))
+	return exec.Command("ffmpeg", args...)
+}
+
+// cmdExited calls exit hook of cmd, that exited or failed to start.
+func (m *ManagerCtx) cmdExited(cmd *exec.Cmd) {
+	if m.config.Exited != nil {
+		m.config.Exited(cmd)
+	}
+}
+
+// exited restarts recording after its transcode exited on its own.
+func (m *ManagerCtx) exited(cmd *exec.Cmd) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd != cmd {
+		return
+	}
+
+	m.cmd = nil
+	m.restartLater()
+}
+
+// restartLater must be called with lock held.
+func (m *ManagerCtx) restartLater() {
+	shutdown := m.shutdown
+	time.AfterFunc(m.config.RestartDelay, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		select {
+		case <-shutdown:
+			return
+		default:
+		}
+
+		if m.cmd != nil {
+			return
+		}
+
+		if err := m.start(); err != nil {
+			m.logger.Err(err).Msg("recording could not be restarted")
+			m.restartLater()
+		}
+	})
+}
+
+// stop must be called with lock held.
+func (m *ManagerCtx) stop() {
+	if !m.running {
+		return
+	}
+
+	m.logger.Debug().Msg("performing stop")
+	m.running = false
+	close(m.shutdown)
+
+	if m.cmd == nil {
+		return
+	}
+
+	// muxer finalizes once output of transcode is closed
+	if m.cmd.Process != nil && m.config.SingleProcess {
+		err := m.cmd.Process.Kill()
+		m.logger.Err(err).Msg("killing proccess")
+	} else if m.cmd.Process != nil {
+		pgid, err := syscall.Getpgid(m.cmd.Process.Pid)
+		if err == nil {
+			err := syscall.Kill(-pgid, syscall.SIGKILL)
+			m.logger.Err(err).Msg("killing proccess group")
+		} else {
+			m.logger.Err(err).Msg("could not get proccess group id")
+			err := m.cmd.Process.Kill()
+			m.logger.Err(err).Msg("killing proccess")
+		}
+	}
+
+	m.cmd = nil
+}
+
+func (m *ManagerCtx) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stop()
+}
+
+// Shutdown stops recording and waits until its last file is finalized.
+func (m *ManagerCtx) Shutdown() {
+	m.mu.Lock()
+	m.stop()
+	finalized := m.finalized
+	m.mu.Unlock()
+
+	if finalized == nil {
+		return
+	}
+
+	select {
+	case <-finalized:
+	case <-time.After(finalizeTimeout):
+		m.logger.Warn().Msg("muxer did not finalize recording in time")
+	}
+}
+
+func (m *ManagerCtx) Running() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.running
+}
+
+// Pid returns transcode process id, or zero when not running.
+func (m *ManagerCtx) Pid() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil || m.cmd.Process == nil {
+		return 0
+	}
+
+	return m.cmd.Process.Pid
+}
+
+func (m *ManagerCtx) Recordings() ([]Recording, error) {
+	files, err := ioutil.ReadDir(m.config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	recordings := []Recording{}
+	for _, file := range files {
+		if !file.Mode().IsRegular() || !strings.HasSuffix(file.Name(), "."+m.config.Format) {
+			continue
+		}
+
+		recordings = append(recordings, Recording{
+			Name:    file.Name(),
+			Size:    file.Size(),
+			ModTime: file.ModTime(),
+		})
+	}
+
+	// names are start times
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].Name < recordings[j].Name
+	})
+
+	return recordings, nil
+}
+
+// cleanup removes recordings exceeding retention, oldest first. File
+// being recorded is kept.
+func (m *ManagerCtx) cleanup() {
+	if m.config.MaxAge == 0 && m.config.MaxSize == 0 {
+		return
+	}
+
+	recordings, err := m.Recordings()
+	if err != nil {
+		m.logger.Err(err).Msg("could not list recordings")
+		return
+	}
+
+	if m.Running() && len(recordings) > 0 {
+		recordings = recordings[:len(recordings)-1]
+	}
+
+	size := int64(0)
+	for _, recording := range recordings {
+		size += recording.Size
+	}
+
+	for _, recording := range recordings {
+		expired := m.config.MaxAge > 0 && time.Since(recording.ModTime) > m.config.MaxAge
+		oversized := m.config.MaxSize > 0 && size > m.config.MaxSize
+		if !expired && !oversized {
+			break
+		}
+
+		path := filepath.Join(m.config.Dir, recording.Name)
+		err := os.Remove(path)
+		m.logger.Err(err).Str("path", path).Bool("expired", expired).Msg("removing recording")
+
+		size -= recording.Size
+	}
+}
@@ -0,0 +1,21 @@
+package recording
+
+import "time"
+
+// Recording is single file of stream recordings.
+type Recording struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+type Manager interface {
+	Start() error
+	Stop()
+	Shutdown()
+	Running() bool
+	Pid() int
+
+	// files of stream recordings, oldest first
+	Recordings() ([]Recording, error)
+}
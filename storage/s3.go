@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+type S3Config struct {
+	// endpoint of S3 compatible storage, e.g. https://s3.eu-central-1.amazonaws.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// base url of CDN serving bucket, endpoint is used when empty
+	PublicURL string
+	// prefix of all object keys
+	Prefix string
+}
+
+func (c *S3Config) Validate() error {
+	if c.Endpoint == "" || c.Bucket == "" {
+		return errors.New("storage endpoint and bucket are required")
+	}
+
+	if _, err := url.Parse(c.Endpoint); err != nil {
+		return fmt.Errorf("invalid storage endpoint: %w", err)
+	}
+
+	if c.Region == "" {
+		return errors.New("storage region is required")
+	}
+
+	return nil
+}
+
+// S3 stores segments in bucket of S3 compatible storage, using path
+// style requests signed with AWS signature version 4.
+type S3 struct {
+	config S3Config
+	client *http.Client
+}
+
+func NewS3(config S3Config) *S3 {
+	return &S3{
+		config: config,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// WithPrefix returns store putting objects under additional prefix.
+func (s *S3) WithPrefix(prefix string) *S3 {
+	config := s.config
+	config.Prefix = path.Join(config.Prefix, prefix)
+
+	return &S3{
+		config: config,
+		client: s.client,
+	}
+}
+
+func (s *S3) key(name string) string {
+	return strings.TrimPrefix(path.Join(s.config.Prefix, name), "/")
+}
+
+func (s *S3) URL(name string) string {
+	if s.config.PublicURL != "" {
+		return strings.TrimRight(s.config.PublicURL, "/") + "/" + s.key(name)
+	}
+
+	return strings.TrimRight(s.config.Endpoint, "/") + "/" + s.config.Bucket + "/" + s.key(name)
+}
+
+func (s *S3) Put(name string, filePath string, contentType string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := url.Parse(s.config.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	objectPath := "/" + s.config.Bucket + "/" + s.key(name)
+	endpoint.Path = strings.TrimRight(endpoint.Path, "/") + objectPath
+
+	req, err := http.NewRequest(http.MethodPut, endpoint.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, data, time.Now().UTC())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// sign adds AWS signature version 4 authorization to request.
+func (s *S3) sign(req *http.Request, payload []byte, now time.Time) {
+	payloadHash := sha256Hex(payload)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := []byte("AWS4" + s.config.SecretKey)
+	for _, part := range []string{date, s.config.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
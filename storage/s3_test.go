@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestS3Put(t *testing.T) {
+	var method, objectPath, contentType, authorization, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, objectPath, body = r.Method, r.URL.Path, string(data)
+		contentType, authorization = r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "index0.ts")
+	if err := os.WriteFile(file, []byte("segment"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewS3(S3Config{
+		Endpoint:  server.URL,
+		Region:    "eu-central-1",
+		Bucket:    "media",
+		AccessKey: "key",
+		SecretKey: "secret",
+		Prefix:    "live",
+	}).WithPrefix("cam/h264_720p")
+
+	if err := s.Put("index0.ts", file, "video/mp2t"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if method != http.MethodPut || objectPath != "/media/live/cam/h264_720p/index0.ts" {
+		t.Errorf("got %s %s, want PUT /media/live/cam/h264_720p/index0.ts", method, objectPath)
+	}
+	if contentType != "video/mp2t" || body != "segment" {
+		t.Errorf("got %q of type %q", body, contentType)
+	}
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=key/") || !strings.Contains(authorization, "/eu-central-1/s3/aws4_request") {
+		t.Errorf("got authorization %q", authorization)
+	}
+
+	if got, want := s.URL("index0.ts"), server.URL+"/media/live/cam/h264_720p/index0.ts"; got != want {
+		t.Errorf("got url %q, want %q", got, want)
+	}
+}
+
+func TestS3PutFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("AccessDenied"))
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "index0.ts")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewS3(S3Config{Endpoint: server.URL, Region: "eu-central-1", Bucket: "media"})
+	if err := s.Put("index0.ts", file, "video/mp2t"); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("got error %v, want AccessDenied", err)
+	}
+}
+
+func TestS3PublicURL(t *testing.T) {
+	s := NewS3(S3Config{Endpoint: "https://s3.example.com", Bucket: "media", PublicURL: "https://cdn.example.com/"})
+
+	if got, want := s.URL("index0.ts"), "https://cdn.example.com/index0.ts"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,38 @@
+package subtitles
+
+import (
+	"errors"
+	"time"
+)
+
+type Config struct {
+	// duration of segments listed by live playlist
+	SegmentDuration time.Duration
+	// how many latest segments are listed by live playlist
+	ListSize int
+	// duration of vod source, whose cues are served as single segment
+	// once extracted, zero for live sources
+	Duration time.Duration
+	// signal only ffmpeg process instead of its whole process group
+	SingleProcess bool
+}
+
+func (c *Config) Validate() error {
+	if c.SegmentDuration <= 0 {
+		return errors.New("subtitles segment duration must be positive")
+	}
+
+	if c.ListSize <= 0 {
+		return errors.New("subtitles list size must be positive")
+	}
+
+	if c.Duration < 0 {
+		return errors.New("subtitles duration must not be negative")
+	}
+
+	return nil
+}
+
+func (c *Config) vod() bool {
+	return c.Duration > 0
+}
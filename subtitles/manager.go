@@ -0,0 +1,384 @@
+package subtitles
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// how often should be cleanup called
+const cleanupPeriod = 4 * time.Second
+
+// how long must be subtitles idle to be considered as dead
+const idleTimeout = 60 * time.Second
+
+var segmentRegex = regexp.MustCompile(`^subtitles_([0-9]+)\.vtt$`)
+
+// ManagerCtx extracts text subtitles of source using ffmpeg, that writes
+// them as WebVTT to its output. Cues of live sources are split into
+// segments of wall clock, cues of vod sources are served as single
+// segment once extracted.
+type ManagerCtx struct {
+	logger     zerolog.Logger
+	mu         sync.Mutex
+	cmdFactory func() (*exec.Cmd, error)
+	config     Config
+
+	cmd     *exec.Cmd
+	started time.Time
+	cues    []cue
+	// closed once all cues written by cmd are read
+	extracted   chan struct{}
+	lastRequest time.Time
+
+	shutdown chan interface{}
+}
+
+func New(cmdFactory func() (*exec.Cmd, error), config Config) *ManagerCtx {
+	return &ManagerCtx{
+		logger:     log.With().Str("module", "subtitles").Str("submodule", "manager").Logger(),
+		cmdFactory: cmdFactory,
+		config:     config,
+
+		shutdown: make(chan interface{}),
+	}
+}
+
+func (m *ManagerCtx) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd != nil {
+		return errors.New("has already started")
+	}
+
+	m.logger.Debug().Msg("performing start")
+
+	cmd, err := m.cmdFactory()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	cmd.Stderr = utils.LogWriter(m.logger)
+
+	//create a new process group
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: !m.config.SingleProcess}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	extracted := make(chan struct{})
+	m.cmd = cmd
+	m.started = time.Now()
+	m.cues = nil
+	m.extracted = extracted
+	m.lastRequest = time.Now()
+	m.shutdown = make(chan interface{})
+
+	go func() {
+		err := readCues(stdout, func(c cue) {
+			m.addCue(cmd, c)
+		})
+		m.logger.Err(err).Msg("cues read")
+
+		err = cmd.Wait()
+		m.logger.Info().Err(err).Msg("cmd exited")
+		close(extracted)
+	}()
+
+	shutdown := m.shutdown
+	go func() {
+		ticker := time.NewTicker(cleanupPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-shutdown:
+				return
+			case <-ticker.C:
+				m.Cleanup()
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (m *ManagerCtx) addCue(cmd *exec.Cmd, c cue) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd != cmd {
+		return
+	}
+
+	m.cues = append(m.cues, c)
+}
+
+// Shutdown stops extraction right away. Manager must not be used
+// afterwards.
+func (m *ManagerCtx) Shutdown() {
+	m.Stop()
+}
+
+func (m *ManagerCtx) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil {
+		return
+	}
+
+	m.logger.Debug().Msg("performing stop")
+	close(m.shutdown)
+
+	if m.cmd.Process != nil && m.config.SingleProcess {
+		err := m.cmd.Process.Kill()
+		m.logger.Err(err).Msg("killing proccess")
+	} else if m.cmd.Process != nil {
+		pgid, err := syscall.Getpgid(m.cmd.Process.Pid)
+		if err == nil {
+			err := syscall.Kill(-pgid, syscall.SIGKILL)
+			m.logger.Err(err).Msg("killing proccess group")
+		} else {
+			m.logger.Err(err).Msg("could not get proccess group id")
+			err := m.cmd.Process.Kill()
+			m.logger.Err(err).Msg("killing proccess")
+		}
+	}
+
+	m.cmd = nil
+	m.cues = nil
+}
+
+// Pid returns ffmpeg process id, or zero when not running.
+func (m *ManagerCtx) Pid() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil || m.cmd.Process == nil {
+		return 0
+	}
+
+	select {
+	case <-m.extracted:
+		return 0
+	default:
+	}
+
+	return m.cmd.Process.Pid
+}
+
+func (m *ManagerCtx) Cleanup() {
+	m.mu.Lock()
+	diff := time.Since(m.lastRequest)
+	stop := diff > idleTimeout
+	m.mu.Unlock()
+
+	m.logger.Debug().
+		Dur("diff", diff).
+		Bool("stop", stop).
+		Msg("performing cleanup")
+
+	if stop {
+		m.Stop()
+		return
+	}
+
+	if m.config.vod() {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// keep only cues of segments that can still be requested
+	first, _ := m.segments()
+	start := time.Duration(first) * m.config.SegmentDuration
+
+	cues := m.cues[:0]
+	for _, c := range m.cues {
+		if c.end > start {
+			cues = append(cues, c)
+		}
+	}
+	m.cues = cues
+}
+
+// segments returns range of live segments that can be requested, last
+// one is excluded. Segment is completed once wall clock passes its end,
+// with one more segment of delay for cues being transcoded. Must be called
+// with lock held.
+func (m *ManagerCtx) segments() (int, int) {
+	completed := int((time.Since(m.started) - m.config.SegmentDuration) / m.config.SegmentDuration)
+	if completed < 0 {
+		completed = 0
+	}
+
+	// segments dropped from playlist might be still requested
+	first := completed - 2*m.config.ListSize
+	if first < 0 {
+		first = 0
+	}
+
+	return first, completed
+}
+
+func segmentName(index int) string {
+	return fmt.Sprintf("subtitles_%d.vtt", index)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// start starts extraction on request, vod sources are waited for until
+// their cues are extracted.
+func (m *ManagerCtx) start(w http.ResponseWriter, r *http.Request) bool {
+	m.mu.Lock()
+	m.lastRequest = time.Now()
+	running := m.cmd != nil
+	m.mu.Unlock()
+
+	if !running {
+		err := m.Start()
+		if err != nil {
+			m.logger.Warn().Err(err).Msg("subtitles could not be started")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return false
+		}
+	}
+
+	if !m.config.vod() {
+		return true
+	}
+
+	m.mu.Lock()
+	extracted := m.extracted
+	m.mu.Unlock()
+
+	select {
+	case <-extracted:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// ServePlaylist serves playlist of segments, query of request (e.g.
+// selected track) is appended to their uris.
+func (m *ManagerCtx) ServePlaylist(w http.ResponseWriter, r *http.Request) {
+	if !m.start(w, r) {
+		return
+	}
+
+	query := ""
+	if r.URL.RawQuery != "" {
+		query = "?" + r.URL.RawQuery
+	}
+
+	lines := []string{"#EXTM3U", "#EXT-X-VERSION:3"}
+
+	if m.config.vod() {
+		lines = append(lines,
+			fmt.Sprintf("#EXT-X-TARGETDURATION:%d", int(math.Ceil(m.config.Duration.Seconds()))),
+			"#EXT-X-PLAYLIST-TYPE:VOD",
+			"#EXT-X-MEDIA-SEQUENCE:0",
+			"#EXTINF:"+formatSeconds(m.config.Duration)+",",
+			segmentName(0)+query,
+			"#EXT-X-ENDLIST",
+		)
+	} else {
+		m.mu.Lock()
+		_, completed := m.segments()
+		m.mu.Unlock()
+
+		first := completed - m.config.ListSize
+		if first < 0 {
+			first = 0
+		}
+
+		lines = append(lines,
+			fmt.Sprintf("#EXT-X-TARGETDURATION:%d", int(math.Ceil(m.config.SegmentDuration.Seconds()))),
+			fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d", first),
+		)
+
+		for i := first; i < completed; i++ {
+			lines = append(lines, "#EXTINF:"+formatSeconds(m.config.SegmentDuration)+",", segmentName(i)+query)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(strings.Join(lines, "\n") + "\n"))
+}
+
+// ServeSegment serves segment of playlist, e.g. subtitles_12.vtt.
+func (m *ManagerCtx) ServeSegment(w http.ResponseWriter, r *http.Request) {
+	match := segmentRegex.FindStringSubmatch(path.Base(r.URL.Path))
+	if match == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 invalid segment"))
+		return
+	}
+
+	index, err := strconv.Atoi(match[1])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 segment not found"))
+		return
+	}
+
+	if !m.start(w, r) {
+		return
+	}
+
+	m.mu.Lock()
+	start := time.Duration(index) * m.config.SegmentDuration
+	end := start + m.config.SegmentDuration
+	available := false
+
+	if m.config.vod() {
+		start, end = 0, m.config.Duration
+		available = index == 0
+	} else {
+		first, completed := m.segments()
+		available = index >= first && index < completed
+	}
+
+	segment := ""
+	if available {
+		segment = renderSegment(m.cues, start, end)
+	}
+	m.mu.Unlock()
+
+	if !available {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 segment not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt")
+	w.Write([]byte(segment))
+}
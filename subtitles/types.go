@@ -0,0 +1,14 @@
+package subtitles
+
+import "net/http"
+
+type Manager interface {
+	Start() error
+	Stop()
+	Shutdown()
+	Cleanup()
+	Pid() int
+
+	ServePlaylist(w http.ResponseWriter, r *http.Request)
+	ServeSegment(w http.ResponseWriter, r *http.Request)
+}
@@ -0,0 +1,124 @@
+package subtitles
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cue times start at zero, as do timestamps of transcodes of the same
+// source, that are delayed by 1.4s by mpegts muxer
+const timestampMap = "X-TIMESTAMP-MAP=MPEGTS:126000,LOCAL:00:00:00.000"
+
+var cueTimingRegex = regexp.MustCompile(`^((?:[0-9]+:)?[0-9]{2}:[0-9]{2}\.[0-9]{3})[ \t]+-->[ \t]+((?:[0-9]+:)?[0-9]{2}:[0-9]{2}\.[0-9]{3})(.*)$`)
+
+type cue struct {
+	start time.Duration
+	end   time.Duration
+	// cue settings following its timing, e.g. position
+	settings string
+	payload  string
+}
+
+// readCues reads WebVTT cues written by ffmpeg until end of file, header
+// and other blocks are skipped.
+func readCues(reader io.Reader, add func(cue)) error {
+	scanner := bufio.NewScanner(reader)
+	block := []string{}
+
+	flush := func() {
+		defer func() {
+			block = block[:0]
+		}()
+
+		// cue might be preceded by its identifier
+		for i, line := range block {
+			match := cueTimingRegex.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+
+			start, err := parseTimestamp(match[1])
+			if err != nil {
+				return
+			}
+
+			end, err := parseTimestamp(match[2])
+			if err != nil || end < start {
+				return
+			}
+
+			add(cue{
+				start:    start,
+				end:      end,
+				settings: match[3],
+				payload:  strings.Join(block[i+1:], "\n"),
+			})
+			return
+		}
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			flush()
+			continue
+		}
+		block = append(block, line)
+	}
+
+	flush()
+	return scanner.Err()
+}
+
+// parseTimestamp parses cue timestamp, hours are optional.
+func parseTimestamp(value string) (time.Duration, error) {
+	parts := strings.Split(value, ":")
+
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	d := time.Duration(seconds * float64(time.Second))
+	for i, part := range parts[:len(parts)-1] {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, err
+		}
+
+		unit := time.Minute
+		if len(parts) == 3 && i == 0 {
+			unit = time.Hour
+		}
+		d += time.Duration(n) * unit
+	}
+
+	return d.Round(time.Millisecond), nil
+}
+
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// renderSegment renders WebVTT segment of cues shown between start and
+// end, cues spanning multiple segments are repeated in each of them.
+func renderSegment(cues []cue, start time.Duration, end time.Duration) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n" + timestampMap + "\n")
+
+	for _, c := range cues {
+		if c.start >= end || c.end <= start {
+			continue
+		}
+
+		fmt.Fprintf(&b, "\n%s --> %s%s\n%s\n", vttTimestamp(c.start), vttTimestamp(c.end), c.settings, c.payload)
+	}
+
+	return b.String()
+}
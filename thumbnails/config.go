@@ -0,0 +1,45 @@
+package thumbnails
+
+import (
+	"errors"
+	"time"
+)
+
+type Config struct {
+	// time between thumbnails
+	Interval time.Duration
+	// sprite grid size
+	Columns int
+	Rows    int
+	// thumbnail width, height is computed to keep aspect ratio
+	Width int
+	// how many latest sprites are kept for live streams
+	Sprites int
+	// signal only ffmpeg process instead of its whole process group
+	SingleProcess bool
+}
+
+func (c *Config) Validate() error {
+	if c.Interval <= 0 {
+		return errors.New("thumbnails interval must be positive")
+	}
+
+	if c.Columns <= 0 || c.Rows <= 0 {
+		return errors.New("thumbnails grid must be positive")
+	}
+
+	if c.Width <= 0 {
+		return errors.New("thumbnails width must be positive")
+	}
+
+	if c.Sprites <= 0 {
+		return errors.New("thumbnails sprites count must be positive")
+	}
+
+	return nil
+}
+
+// thumbnails in one sprite
+func (c *Config) perSprite() int {
+	return c.Columns * c.Rows
+}
@@ -0,0 +1,323 @@
+package thumbnails
+
+import (
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// how often should be cleanup called
+const cleanupPeriod = 4 * time.Second
+
+// how long must be stream idle to be considered as dead
+const idleTimeout = 60 * time.Second
+
+var spriteRegex = regexp.MustCompile(`^sprite_([0-9]+)\.jpg$`)
+
+type ManagerCtx struct {
+	logger zerolog.Logger
+	mu     sync.Mutex
+	source string
+	config Config
+
+	cmd         *exec.Cmd
+	tempdir     string
+	lastRequest time.Time
+
+	shutdown chan interface{}
+}
+
+func New(source string, config Config) *ManagerCtx {
+	return &ManagerCtx{
+		logger: log.With().Str("module", "thumbnails").Str("submodule", "manager").Logger(),
+		source: source,
+		config: config,
+
+		shutdown: make(chan interface{}),
+	}
+}
+
+func (m *ManagerCtx) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd != nil {
+		return errors.New("has already started")
+	}
+
+	m.logger.Debug().Msg("performing start")
+
+	tempdir, err := os.MkdirTemp("", "go-transcode-thumbnails")
+	if err != nil {
+		return err
+	}
+
+	filter := fmt.Sprintf("fps=1/%g,scale=%d:-2,tile=%dx%d",
+		m.config.Interval.Seconds(), m.config.Width, m.config.Columns, m.config.Rows)
+
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "warning",
+		"-i", m.source,
+		"-an", "-vf", filter,
+		"-q:v", "5",
+		"-f", "image2", "sprite_%05d.jpg",
+	)
+	cmd.Dir = tempdir
+	cmd.Stderr = utils.LogWriter(m.logger)
+
+	//create a new process group
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: !m.config.SingleProcess}
+
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(tempdir)
+		return err
+	}
+
+	m.cmd = cmd
+	m.tempdir = tempdir
+	m.lastRequest = time.Now()
+	m.shutdown = make(chan interface{})
+
+	go func() {
+		err := cmd.Wait()
+		m.logger.Info().Err(err).Msg("cmd exited")
+	}()
+
+	shutdown := m.shutdown
+	go func() {
+		ticker := time.NewTicker(cleanupPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-shutdown:
+				return
+			case <-ticker.C:
+				m.Cleanup()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Shutdown stops transcode and removes its tempdir right away, instead of
+// after delay. Manager must not be used afterwards.
+func (m *ManagerCtx) Shutdown() {
+	m.mu.Lock()
+	tempdir := m.tempdir
+	m.mu.Unlock()
+
+	m.Stop()
+
+	// also tempdir of previous run, whose removal is pending
+	if tempdir != "" {
+		err := os.RemoveAll(tempdir)
+		m.logger.Err(err).Msg("removing tempdir")
+	}
+}
+
+func (m *ManagerCtx) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil {
+		return
+	}
+
+	m.logger.Debug().Msg("performing stop")
+	close(m.shutdown)
+
+	if m.cmd.Process != nil && m.config.SingleProcess {
+		err := m.cmd.Process.Kill()
+		m.logger.Err(err).Msg("killing proccess")
+	} else if m.cmd.Process != nil {
+		pgid, err := syscall.Getpgid(m.cmd.Process.Pid)
+		if err == nil {
+			err := syscall.Kill(-pgid, syscall.SIGKILL)
+			m.logger.Err(err).Msg("killing proccess group")
+		} else {
+			m.logger.Err(err).Msg("could not get proccess group id")
+			err := m.cmd.Process.Kill()
+			m.logger.Err(err).Msg("killing proccess")
+		}
+	}
+
+	m.cmd = nil
+
+	tempdir := m.tempdir
+	time.AfterFunc(2*time.Second, func() {
+		err := os.RemoveAll(tempdir)
+		m.logger.Err(err).Msg("removing tempdir")
+	})
+}
+
+// Pid returns ffmpeg process id, or zero when not running.
+func (m *ManagerCtx) Pid() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil || m.cmd.Process == nil {
+		return 0
+	}
+
+	return m.cmd.Process.Pid
+}
+
+func (m *ManagerCtx) Cleanup() {
+	m.mu.Lock()
+	diff := time.Since(m.lastRequest)
+	stop := diff > idleTimeout
+	m.mu.Unlock()
+
+	m.logger.Debug().
+		Dur("diff", diff).
+		Bool("stop", stop).
+		Msg("performing cleanup")
+
+	if stop {
+		m.Stop()
+		return
+	}
+
+	// keep only rolling window of latest sprites
+	sprites := m.sprites()
+	for len(sprites) > m.config.Sprites {
+		err := os.Remove(path.Join(m.tempdir, spriteName(sprites[0])))
+		m.logger.Err(err).Int("sprite", sprites[0]).Msg("removing old sprite")
+		sprites = sprites[1:]
+	}
+}
+
+// sprites returns sorted indexes of sprites available in tempdir.
+func (m *ManagerCtx) sprites() []int {
+	m.mu.Lock()
+	tempdir := m.tempdir
+	m.mu.Unlock()
+
+	entries, err := os.ReadDir(tempdir)
+	if err != nil {
+		return nil
+	}
+
+	sprites := []int{}
+	for _, entry := range entries {
+		match := spriteRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		index, err := strconv.Atoi(match[1])
+		if err == nil {
+			sprites = append(sprites, index)
+		}
+	}
+
+	sort.Ints(sprites)
+	return sprites
+}
+
+func spriteName(index int) string {
+	return fmt.Sprintf("sprite_%05d.jpg", index)
+}
+
+// vtt renders WebVTT index referencing regions of available sprites.
+func (m *ManagerCtx) vtt() string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+
+	for _, index := range m.sprites() {
+		name := spriteName(index)
+
+		file, err := os.Open(path.Join(m.tempdir, name))
+		if err != nil {
+			continue
+		}
+
+		// sprite might be still being written
+		img, err := jpeg.DecodeConfig(file)
+		file.Close()
+		if err != nil {
+			continue
+		}
+
+		width := img.Width / m.config.Columns
+		height := img.Height / m.config.Rows
+
+		for i := 0; i < m.config.perSprite(); i++ {
+			// sprites are numbered from 1
+			start := time.Duration((index-1)*m.config.perSprite()+i) * m.config.Interval
+			x, y := (i%m.config.Columns)*width, (i/m.config.Columns)*height
+
+			fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+				vttTimestamp(start), vttTimestamp(start+m.config.Interval), name, x, y, width, height)
+		}
+	}
+
+	return b.String()
+}
+
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func (m *ManagerCtx) ServeVTT(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.lastRequest = time.Now()
+	running := m.cmd != nil
+	m.mu.Unlock()
+
+	if !running {
+		err := m.Start()
+		if err != nil {
+			m.logger.Warn().Err(err).Msg("thumbnails could not be started")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/vtt")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(m.vtt()))
+}
+
+func (m *ManagerCtx) ServeSprite(w http.ResponseWriter, r *http.Request) {
+	fileName := path.Base(r.URL.Path)
+	if !spriteRegex.MatchString(fileName) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 invalid sprite"))
+		return
+	}
+
+	m.mu.Lock()
+	m.lastRequest = time.Now()
+	path := path.Join(m.tempdir, fileName)
+	m.mu.Unlock()
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		m.logger.Warn().Str("path", path).Msg("sprite not found")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 sprite not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, path)
+}
@@ -0,0 +1,103 @@
+package thumbnails
+
+import (
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSprite(t *testing.T, dir string, index, width, height int) {
+	t.Helper()
+
+	file, err := os.Create(filepath.Join(dir, spriteName(index)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := jpeg.Encode(file, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVTTTimestamp(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "00:00:00.000"},
+		{1500 * time.Millisecond, "00:00:01.500"},
+		{61 * time.Second, "00:01:01.000"},
+		{time.Hour + 2*time.Minute + 3*time.Second + 4*time.Millisecond, "01:02:03.004"},
+	}
+
+	for _, tt := range tests {
+		if got := vttTimestamp(tt.d); got != tt.want {
+			t.Errorf("vttTimestamp(%v): got %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestManagerVTT(t *testing.T) {
+	m := New("", Config{Interval: 10 * time.Second, Columns: 2, Rows: 2, Width: 160, Sprites: 5})
+	m.tempdir = t.TempDir()
+
+	// second sprite only, first one was already cleaned up
+	testSprite(t, m.tempdir, 2, 320, 180)
+
+	want := "WEBVTT\n" +
+		"\n00:00:40.000 --> 00:00:50.000\nsprite_00002.jpg#xywh=0,0,160,90\n" +
+		"\n00:00:50.000 --> 00:01:00.000\nsprite_00002.jpg#xywh=160,0,160,90\n" +
+		"\n00:01:00.000 --> 00:01:10.000\nsprite_00002.jpg#xywh=0,90,160,90\n" +
+		"\n00:01:10.000 --> 00:01:20.000\nsprite_00002.jpg#xywh=160,90,160,90\n"
+
+	if got := m.vtt(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestManagerVTTIncompleteSprite(t *testing.T) {
+	m := New("", Config{Interval: time.Second, Columns: 2, Rows: 1, Width: 160, Sprites: 5})
+	m.tempdir = t.TempDir()
+
+	testSprite(t, m.tempdir, 1, 320, 90)
+
+	// sprite still being written is skipped
+	if err := os.WriteFile(filepath.Join(m.tempdir, spriteName(2)), []byte{0xff, 0xd8}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "WEBVTT\n" +
+		"\n00:00:00.000 --> 00:00:01.000\nsprite_00001.jpg#xywh=0,0,160,90\n" +
+		"\n00:00:01.000 --> 00:00:02.000\nsprite_00001.jpg#xywh=160,0,160,90\n"
+
+	if got := m.vtt(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStoryboardVTT(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStoryboard("", t.TempDir(), 25*time.Second, Config{Interval: 10 * time.Second, Columns: 2, Rows: 1, Width: 100})
+
+	testSprite(t, dir, 1, 200, 50)
+	testSprite(t, dir, 2, 200, 50)
+
+	// last thumbnail is cut to duration of file
+	want := "WEBVTT\n" +
+		"\n00:00:00.000 --> 00:00:10.000\nsprite_00001.jpg#xywh=0,0,100,50\n" +
+		"\n00:00:10.000 --> 00:00:20.000\nsprite_00001.jpg#xywh=100,0,100,50\n" +
+		"\n00:00:20.000 --> 00:00:25.000\nsprite_00002.jpg#xywh=0,0,100,50\n"
+
+	got, err := s.vtt(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,296 @@
+package thumbnails
+
+import (
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// storyboardVTT is WebVTT index of storyboard, written once its sprites
+// are complete
+const storyboardVTT = "thumbnails.vtt"
+
+// StoryboardCtx generates sprites and WebVTT index of whole vod file once,
+// into directory where they are kept, e.g. next to vod cache. Directory is
+// written only once generation is complete, partial ones are discarded.
+type StoryboardCtx struct {
+	logger   zerolog.Logger
+	mu       sync.Mutex
+	source   string
+	dir      string
+	duration time.Duration
+	config   Config
+
+	cmd *exec.Cmd
+	// closed once running generation finishes
+	done chan struct{}
+	err  error
+}
+
+func NewStoryboard(source string, dir string, duration time.Duration, config Config) *StoryboardCtx {
+	return &StoryboardCtx{
+		logger:   log.With().Str("module", "thumbnails").Str("submodule", "storyboard").Str("dir", dir).Logger(),
+		source:   source,
+		dir:      dir,
+		duration: duration,
+		config:   config,
+	}
+}
+
+// generated reports whether storyboard is complete.
+func (s *StoryboardCtx) generated() bool {
+	_, err := os.Stat(filepath.Join(s.dir, storyboardVTT))
+	return err == nil
+}
+
+// generate starts generation, unless it is already running, and returns
+// channel closed once it finishes. Must be called with lock held.
+func (s *StoryboardCtx) generate() (chan struct{}, error) {
+	if s.cmd != nil {
+		return s.done, nil
+	}
+
+	s.logger.Debug().Msg("performing generation")
+
+	if err := os.MkdirAll(filepath.Dir(s.dir), 0755); err != nil {
+		return nil, err
+	}
+
+	tempdir, err := ioutil.TempDir(filepath.Dir(s.dir), filepath.Base(s.dir)+".tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	filter := fmt.Sprintf("fps=1/%g,scale=%d:-2,tile=%dx%d",
+		s.config.Interval.Seconds(), s.config.Width, s.config.Columns, s.config.Rows)
+
+	// only keyframes are decoded, close enough for scrubbing
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "warning",
+		"-skip_frame", "nokey",
+		"-i", s.source,
+		"-an", "-vf", filter,
+		"-q:v", "5",
+		"-f", "image2", "sprite_%05d.jpg",
+	)
+	cmd.Dir = tempdir
+	cmd.Stderr = utils.LogWriter(s.logger)
+
+	//create a new process group
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: !s.config.SingleProcess}
+
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(tempdir)
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	s.cmd = cmd
+	s.done = done
+
+	go func() {
+		err := cmd.Wait()
+		s.logger.Info().Err(err).Msg("cmd exited")
+
+		if err == nil {
+			err = s.complete(tempdir)
+		}
+		if err != nil {
+			os.RemoveAll(tempdir)
+		}
+
+		s.mu.Lock()
+		s.cmd = nil
+		s.err = err
+		s.mu.Unlock()
+
+		close(done)
+	}()
+
+	return done, nil
+}
+
+// complete writes WebVTT index of generated sprites and moves them into
+// storyboard directory.
+func (s *StoryboardCtx) complete(tempdir string) error {
+	vtt, err := s.vtt(tempdir)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(tempdir, storyboardVTT), []byte(vtt), 0644); err != nil {
+		return err
+	}
+
+	// storyboard of modified file, that was generated meanwhile
+	os.RemoveAll(s.dir)
+	return os.Rename(tempdir, s.dir)
+}
+
+// vtt renders WebVTT index referencing regions of all sprites, covering
+// whole duration of file.
+func (s *StoryboardCtx) vtt(tempdir string) (string, error) {
+	file, err := os.Open(filepath.Join(tempdir, spriteName(1)))
+	if err != nil {
+		return "", fmt.Errorf("no sprites generated: %w", err)
+	}
+
+	img, err := jpeg.DecodeConfig(file)
+	file.Close()
+	if err != nil {
+		return "", err
+	}
+
+	width := img.Width / s.config.Columns
+	height := img.Height / s.config.Rows
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+
+	thumbnails := int(math.Ceil(float64(s.duration) / float64(s.config.Interval)))
+	for i := 0; i < thumbnails; i++ {
+		// thumbnails of last sprites might be missing
+		name := spriteName(i/s.config.perSprite() + 1)
+		if _, err := os.Stat(filepath.Join(tempdir, name)); err != nil {
+			break
+		}
+
+		start := time.Duration(i) * s.config.Interval
+		end := start + s.config.Interval
+		if end > s.duration {
+			end = s.duration
+		}
+
+		j := i % s.config.perSprite()
+		x, y := (j%s.config.Columns)*width, (j/s.config.Columns)*height
+
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), name, x, y, width, height)
+	}
+
+	return b.String(), nil
+}
+
+// wait waits until storyboard is generated, generation is started on
+// first request and keeps running when request is gone.
+func (s *StoryboardCtx) wait(w http.ResponseWriter, r *http.Request) bool {
+	if s.generated() {
+		return true
+	}
+
+	s.mu.Lock()
+	done, err := s.generate()
+	s.mu.Unlock()
+
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("storyboard could not be generated")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return false
+	}
+
+	select {
+	case <-done:
+	case <-r.Context().Done():
+		return false
+	}
+
+	s.mu.Lock()
+	err = s.err
+	s.mu.Unlock()
+
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("storyboard could not be generated")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 storyboard could not be generated"))
+		return false
+	}
+
+	return true
+}
+
+func (s *StoryboardCtx) ServeVTT(w http.ResponseWriter, r *http.Request) {
+	if !s.wait(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt")
+	http.ServeFile(w, r, filepath.Join(s.dir, storyboardVTT))
+}
+
+func (s *StoryboardCtx) ServeSprite(w http.ResponseWriter, r *http.Request) {
+	fileName := path.Base(r.URL.Path)
+	if !spriteRegex.MatchString(fileName) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 invalid sprite"))
+		return
+	}
+
+	if !s.wait(w, r) {
+		return
+	}
+
+	path := filepath.Join(s.dir, fileName)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 sprite not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, path)
+}
+
+// Pid returns ffmpeg process id, or zero when not generating.
+func (s *StoryboardCtx) Pid() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cmd == nil || s.cmd.Process == nil {
+		return 0
+	}
+
+	return s.cmd.Process.Pid
+}
+
+// Shutdown stops running generation, its partial sprites are discarded.
+func (s *StoryboardCtx) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cmd == nil || s.cmd.Process == nil {
+		return
+	}
+
+	s.logger.Debug().Msg("performing stop")
+
+	if s.config.SingleProcess {
+		err := s.cmd.Process.Kill()
+		s.logger.Err(err).Msg("killing proccess")
+	} else {
+		pgid, err := syscall.Getpgid(s.cmd.Process.Pid)
+		if err == nil {
+			err := syscall.Kill(-pgid, syscall.SIGKILL)
+			s.logger.Err(err).Msg("killing proccess group")
+		} else {
+			s.logger.Err(err).Msg("could not get proccess group id")
+			err := s.cmd.Process.Kill()
+			s.logger.Err(err).Msg("killing proccess")
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package thumbnails
+
+import "net/http"
+
+type Manager interface {
+	Start() error
+	Stop()
+	Shutdown()
+	Cleanup()
+	Pid() int
+
+	ServeVTT(w http.ResponseWriter, r *http.Request)
+	ServeSprite(w http.ResponseWriter, r *http.Request)
+}
@@ -0,0 +1,221 @@
+package vod
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// extension of cached segments, other files of cache directory are ignored
+const cacheExt = ".ts"
+
+// Cache keeps transcoded segments on disk across transcodes and restarts,
+// least recently used segments are removed when it exceeds its size.
+type Cache struct {
+	logger  zerolog.Logger
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+
+	size int64
+	// most recently used first
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	name string
+	size int64
+}
+
+// NewCache opens cache directory, indexing segments cached by previous
+// runs by their modification time.
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	c := &Cache{
+		logger:  log.With().Str("module", "vod").Str("submodule", "cache").Logger(),
+		dir:     dir,
+		maxSize: maxSize,
+
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	type cached struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+
+	files := []cached{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		// interrupted writes
+		if strings.HasSuffix(path, ".tmp") {
+			return os.Remove(path)
+		}
+
+		if name := filepath.Base(path); len(name) == len(cacheName("")) && strings.HasSuffix(name, cacheExt) {
+			files = append(files, cached{name, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, file := range files {
+		c.entries[file.name] = c.lru.PushBack(&cacheEntry{file.name, file.size})
+		c.size += file.size
+	}
+	c.evict()
+
+	c.logger.Info().Int("segments", c.lru.Len()).Int64("size", c.size).Msg("cache opened")
+	return c, nil
+}
+
+// cacheName returns file name of cached segment, hashed to fit any key.
+func cacheName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + cacheExt
+}
+
+func (c *Cache) path(name string) string {
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// Get returns path of cached segment and marks it as recently used.
+func (c *Cache) Get(key string) (string, bool) {
+	name := cacheName(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[name]
+	if !ok {
+		return "", false
+	}
+
+	c.lru.MoveToFront(elem)
+
+	// recency survives restarts
+	now := time.Now()
+	path := c.path(name)
+	if err := os.Chtimes(path, now, now); err != nil {
+		c.logger.Warn().Err(err).Str("path", path).Msg("cached segment disappeared")
+		c.remove(elem)
+		return "", false
+	}
+
+	return path, true
+}
+
+// Put moves segment file into cache, evicting least recently used ones.
+func (c *Cache) Put(key string, src string) error {
+	name := cacheName(key)
+	dst := c.path(name)
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	// cache might be on another filesystem than tempdirs
+	if err := os.Rename(src, dst); err != nil {
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+		os.Remove(src)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[name]; ok {
+		c.size -= elem.Value.(*cacheEntry).size
+		c.lru.Remove(elem)
+	}
+
+	c.entries[name] = c.lru.PushFront(&cacheEntry{name, info.Size()})
+	c.size += info.Size()
+	c.evict()
+
+	return nil
+}
+
+// evict removes least recently used segments over size, must be called
+// with lock held.
+func (c *Cache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		elem := c.lru.Back()
+		path := c.path(elem.Value.(*cacheEntry).name)
+
+		err := os.Remove(path)
+		c.logger.Err(err).Str("path", path).Msg("evicting cached segment")
+
+		c.remove(elem)
+	}
+}
+
+// remove forgets cached segment, must be called with lock held.
+func (c *Cache) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.size -= entry.size
+	c.lru.Remove(elem)
+	delete(c.entries, entry.name)
+}
+
+// copyFile writes copy of src atomically, so that interrupted copies are
+// never indexed.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+
+	return os.Rename(out.Name(), dst)
+}
@@ -0,0 +1,62 @@
+package vod
+
+import (
+	"errors"
+	"math"
+	"os/exec"
+	"time"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+type Config struct {
+	// duration of source file, probed before its manager is created
+	Duration time.Duration
+	// fixed duration of segments, keyframes are forced at their boundaries
+	SegmentDuration time.Duration
+	// transcode is paused when it gets this many segments ahead of last
+	// requested segment, and resumed on request
+	BufferAhead int
+	// cache of transcoded segments shared by managers, disabled when nil
+	Cache *Cache
+	// identifies source file and its transcode in cache
+	CacheKey string
+	// parent of tempdirs of segments, default of os when empty
+	TempRoot string
+	// signal only transcode process instead of its whole process group
+	SingleProcess bool
+	// nice level, cpu affinity and cgroup limits of transcode process
+	ProcessLimits utils.ProcessLimits
+	// slots held while transcode runs, start fails once any is full
+	Limits []*utils.Semaphore
+	// Retry-After of responses refused because of transcode limits
+	RetryAfter string
+	// called once cmd returned by factory exits or fails to start, e.g.
+	// releasing its hardware encoder session
+	Exited func(cmd *exec.Cmd)
+}
+
+func (c *Config) Validate() error {
+	if c.SegmentDuration <= 0 {
+		return errors.New("vod segment duration must be positive")
+	}
+
+	if c.BufferAhead <= 0 {
+		return errors.New("vod buffer ahead must be positive")
+	}
+
+	return nil
+}
+
+// segments returns number of segments covering whole source.
+func (c *Config) segments() int {
+	return int(math.Ceil(float64(c.Duration) / float64(c.SegmentDuration)))
+}
+
+// segmentDuration returns duration of segment, last one is shorter.
+func (c *Config) segmentDuration(index int) time.Duration {
+	if rest := c.Duration - time.Duration(index)*c.SegmentDuration; rest < c.SegmentDuration {
+		return rest
+	}
+	return c.SegmentDuration
+}
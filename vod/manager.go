@@ -0,0 +1,521 @@
+package vod
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// how often should be cleanup called
+const cleanupPeriod = 4 * time.Second
+
+// how long must be file idle to have its segments removed
+const idleTimeout = 60 * time.Second
+
+// how often is playlist of running transcode checked for new segments
+const pollPeriod = 250 * time.Millisecond
+
+// requested segment at most this many segments ahead of running transcode
+// is awaited, instead of restarting transcode at it
+const seekThreshold = 3
+
+// how long can segment be awaited
+const segmentTimeout = 30 * time.Second
+
+var ErrSegmentNotProduced = errors.New("segment was not produced")
+
+// limit of concurrent transcodes is reached
+var ErrTooManyTranscodes = errors.New("too many transcodes")
+
+type ManagerCtx struct {
+	logger     zerolog.Logger
+	mu         sync.Mutex
+	cmdFactory func(Output) (*exec.Cmd, error)
+	config     Config
+
+	tempdir     string
+	lastRequest time.Time
+	lastSegment int
+	// completed segments by index, their files are named by run, or
+	// empty when moved to cache
+	segments map[int]string
+	// closed and replaced, when segments or transcode change
+	changed chan interface{}
+
+	// running transcode and its next produced segment
+	cmd      *exec.Cmd
+	run      int
+	produced int
+	// error of last exited transcode
+	failed error
+
+	shutdown chan interface{}
+}
+
+func New(cmdFactory func(Output) (*exec.Cmd, error), config Config) *ManagerCtx {
+	return &ManagerCtx{
+		logger:     log.With().Str("module", "vod").Str("submodule", "manager").Logger(),
+		cmdFactory: cmdFactory,
+		config:     config,
+
+		segments: map[int]string{},
+		changed:  make(chan interface{}),
+		shutdown: make(chan interface{}),
+	}
+}
+
+// activate creates tempdir for segments and starts cleanup, must be called
+// with lock held.
+func (m *ManagerCtx) activate() error {
+	if m.tempdir != "" {
+		return nil
+	}
+
+	tempdir, err := os.MkdirTemp(m.config.TempRoot, "go-transcode-vod")
+	if err != nil {
+		return err
+	}
+
+	m.tempdir = tempdir
+	m.segments = map[int]string{}
+	m.shutdown = make(chan interface{})
+
+	shutdown := m.shutdown
+	go func() {
+		ticker := time.NewTicker(cleanupPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-shutdown:
+				return
+			case <-ticker.C:
+				m.Cleanup()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// notify wakes up awaited segments, must be called with lock held.
+func (m *ManagerCtx) notify() {
+	close(m.changed)
+	m.changed = make(chan interface{})
+}
+
+// startRun restarts transcode at given segment, must be called with lock
+// held.
+func (m *ManagerCtx) startRun(index int) error {
+	m.stopRun()
+
+	m.run++
+	output := Output{
+		StartNumber:     index,
+		Start:           time.Duration(index) * m.config.SegmentDuration,
+		SegmentDuration: m.config.SegmentDuration,
+		SegmentFilename: fmt.Sprintf("run%d_%%d.ts", m.run),
+		Playlist:        fmt.Sprintf("run%d.m3u8", m.run),
+	}
+
+	m.logger.Debug().Int("segment", index).Msg("performing start")
+
+	if err := utils.TryAcquireAll(m.config.Limits); err != nil {
+		m.logger.Warn().Msg("transcode limit reached")
+		return ErrTooManyTranscodes
+	}
+
+	cmd, err := m.cmdFactory(output)
+	if err != nil {
+		utils.ReleaseAll(m.config.Limits)
+		return err
+	}
+
+	cmd.Dir = m.tempdir
+	cmd.Stderr = utils.LogWriter(m.logger)
+
+	//create a new process group
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: !m.config.SingleProcess}
+
+	if err := cmd.Start(); err != nil {
+		utils.ReleaseAll(m.config.Limits)
+		m.exited(cmd)
+		return err
+	}
+
+	if err := m.config.ProcessLimits.Apply(cmd.Process.Pid); err != nil {
+		m.logger.Warn().Err(err).Msg("unable to apply process limits")
+	}
+
+	m.cmd = cmd
+	m.produced = index
+	m.failed = nil
+	m.notify()
+
+	tempdir := m.tempdir
+	done := make(chan interface{})
+
+	go func() {
+		err := cmd.Wait()
+		m.logger.Info().Err(err).Int("segment", index).Msg("cmd exited")
+		m.exited(cmd)
+
+		m.collect(cmd, tempdir, output)
+
+		m.mu.Lock()
+		if m.cmd == cmd {
+			m.cmd = nil
+			m.failed = err
+			utils.ReleaseAll(m.config.Limits)
+			m.notify()
+		}
+		m.mu.Unlock()
+
+		close(done)
+	}()
+
+	go func() {
+		ticker := time.NewTicker(pollPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				m.collect(cmd, tempdir, output)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// exited calls exit hook of cmd, that exited or failed to start.
+func (m *ManagerCtx) exited(cmd *exec.Cmd) {
+	if m.config.Exited != nil {
+		m.config.Exited(cmd)
+	}
+}
+
+// stopRun kills running transcode, must be called with lock held.
+func (m *ManagerCtx) stopRun() {
+	if m.cmd == nil {
+		return
+	}
+
+	if m.cmd.Process != nil && m.config.SingleProcess {
+		err := m.cmd.Process.Kill()
+		m.logger.Err(err).Msg("killing proccess")
+	} else if m.cmd.Process != nil {
+		pgid, err := syscall.Getpgid(m.cmd.Process.Pid)
+		if err == nil {
+			err := syscall.Kill(-pgid, syscall.SIGKILL)
+			m.logger.Err(err).Msg("killing proccess group")
+		} else {
+			m.logger.Err(err).Msg("could not get proccess group id")
+			err := m.cmd.Process.Kill()
+			m.logger.Err(err).Msg("killing proccess")
+		}
+	}
+
+	m.cmd = nil
+	utils.ReleaseAll(m.config.Limits)
+	m.notify()
+}
+
+// collect adds segments listed in playlist of transcode run, that lists
+// only completed segments, and pauses transcode ahead of requests.
+func (m *ManagerCtx) collect(cmd *exec.Cmd, tempdir string, output Output) {
+	playlist, err := os.ReadFile(path.Join(tempdir, output.Playlist))
+	if err != nil {
+		return
+	}
+
+	prefix := strings.SplitN(output.SegmentFilename, "%d", 2)
+	if len(prefix) != 2 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// stopped meanwhile
+	if m.tempdir != tempdir {
+		return
+	}
+
+	added := false
+	for _, line := range strings.Split(string(playlist), "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+
+		index, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, prefix[0]), prefix[1]))
+		if err != nil {
+			continue
+		}
+
+		if _, ok := m.segments[index]; !ok {
+			m.segments[index] = m.store(index, name)
+			added = true
+		}
+
+		if m.cmd == cmd && index >= m.produced {
+			m.produced = index + 1
+		}
+	}
+
+	if added {
+		m.notify()
+	}
+
+	if m.cmd != cmd {
+		return
+	}
+
+	if m.produced > m.lastSegment+m.config.BufferAhead {
+		m.logger.Debug().Int("produced", m.produced).Int("requested", m.lastSegment).Msg("pausing transcode ahead of requests")
+		m.stopRun()
+		return
+	}
+
+	// following segments were transcoded by previous runs
+	if _, ok := m.segmentPath(m.produced); ok {
+		m.logger.Debug().Int("produced", m.produced).Msg("stopping transcode at available segments")
+		m.stopRun()
+	}
+}
+
+func (m *ManagerCtx) cacheKey(index int) string {
+	return fmt.Sprintf("%s/%d", m.config.CacheKey, index)
+}
+
+// store moves completed segment to cache, returning its name in tempdir
+// or empty name once cached. Must be called with lock held.
+func (m *ManagerCtx) store(index int, name string) string {
+	if m.config.Cache == nil {
+		return name
+	}
+
+	if err := m.config.Cache.Put(m.cacheKey(index), path.Join(m.tempdir, name)); err != nil {
+		m.logger.Warn().Err(err).Int("segment", index).Msg("unable to cache segment")
+		return name
+	}
+
+	return ""
+}
+
+// segmentPath returns path of completed segment, in tempdir or in cache.
+// Must be called with lock held.
+func (m *ManagerCtx) segmentPath(index int) (string, bool) {
+	if name := m.segments[index]; name != "" {
+		return path.Join(m.tempdir, name), true
+	}
+
+	if m.config.Cache != nil && index < m.config.segments() {
+		return m.config.Cache.Get(m.cacheKey(index))
+	}
+
+	return "", false
+}
+
+// Shutdown stops transcode and removes its tempdir right away, instead of
+// after delay. Manager must not be used afterwards.
+func (m *ManagerCtx) Shutdown() {
+	m.mu.Lock()
+	tempdir := m.tempdir
+	m.mu.Unlock()
+
+	m.Stop()
+
+	// also tempdir of previous run, whose removal is pending
+	if tempdir != "" {
+		err := os.RemoveAll(tempdir)
+		m.logger.Err(err).Msg("removing tempdir")
+	}
+}
+
+// Stop stops transcode and removes its segments.
+func (m *ManagerCtx) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tempdir == "" {
+		return
+	}
+
+	m.logger.Debug().Msg("performing stop")
+	close(m.shutdown)
+	m.stopRun()
+
+	// killed transcode might be still writing
+	tempdir := m.tempdir
+	time.AfterFunc(2*time.Second, func() {
+		err := os.RemoveAll(tempdir)
+		m.logger.Err(err).Msg("removing tempdir")
+	})
+
+	m.tempdir = ""
+	m.segments = map[int]string{}
+}
+
+// Pid returns transcode process id, or zero when not running.
+func (m *ManagerCtx) Pid() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil || m.cmd.Process == nil {
+		return 0
+	}
+
+	return m.cmd.Process.Pid
+}
+
+func (m *ManagerCtx) Cleanup() {
+	m.mu.Lock()
+	diff := time.Since(m.lastRequest)
+	stop := diff > idleTimeout
+	m.mu.Unlock()
+
+	m.logger.Debug().
+		Dur("diff", diff).
+		Bool("stop", stop).
+		Msg("performing cleanup")
+
+	if stop {
+		m.Stop()
+	}
+}
+
+// ServePlaylist serves playlist covering whole source, its segments are
+// transcoded once requested. Query of request (e.g. burned subtitles) is
+// appended to their uris.
+func (m *ManagerCtx) ServePlaylist(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.lastRequest = time.Now()
+	m.mu.Unlock()
+
+	query := ""
+	if r.URL.RawQuery != "" {
+		query = "?" + r.URL.RawQuery
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(m.config.SegmentDuration.Seconds())))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+
+	for i := 0; i < m.config.segments(); i++ {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%d.ts%s\n", m.config.segmentDuration(i).Seconds(), i, query)
+	}
+
+	b.WriteString("#EXT-X-ENDLIST\n")
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Write([]byte(b.String()))
+}
+
+// ServeSegment serves segment once it is transcoded. Transcode is restarted
+// at requested segment, unless it is going to be produced soon.
+func (m *ManagerCtx) ServeSegment(w http.ResponseWriter, r *http.Request, index int) {
+	if index < 0 || index >= m.config.segments() {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 segment not found"))
+		return
+	}
+
+	timeout := time.NewTimer(segmentTimeout)
+	defer timeout.Stop()
+
+	m.mu.Lock()
+	m.lastRequest = time.Now()
+	m.lastSegment = index
+
+	restarted := false
+	for {
+		if segmentPath, ok := m.segmentPath(index); ok {
+			m.mu.Unlock()
+
+			w.Header().Set("Content-Type", "video/MP2T")
+			http.ServeFile(w, r, segmentPath)
+			return
+		}
+
+		if err := m.activate(); err != nil {
+			m.mu.Unlock()
+
+			m.logger.Warn().Err(err).Msg("vod could not be started")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		awaited := m.cmd != nil && index >= m.produced && index <= m.produced+seekThreshold
+		if !awaited {
+			// transcode restarted for this request exited without segment
+			if restarted && m.cmd == nil {
+				err := m.failed
+				if err == nil {
+					err = ErrSegmentNotProduced
+				}
+				m.mu.Unlock()
+
+				m.logger.Warn().Err(err).Int("segment", index).Msg("segment could not be transcoded")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+
+			if err := m.startRun(index); err != nil {
+				m.mu.Unlock()
+
+				if errors.Is(err, ErrTooManyTranscodes) {
+					w.Header().Set("Retry-After", m.config.RetryAfter)
+					w.WriteHeader(http.StatusServiceUnavailable)
+					w.Write([]byte("503 too many transcodes"))
+					return
+				}
+
+				m.logger.Warn().Err(err).Msg("transcode could not be started")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+
+			restarted = true
+		}
+
+		changed := m.changed
+		m.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			w.WriteHeader(http.StatusGatewayTimeout)
+			w.Write([]byte("504 segment timeout"))
+			return
+		case <-r.Context().Done():
+			return
+		}
+
+		m.mu.Lock()
+	}
+}
@@ -0,0 +1,28 @@
+package vod
+
+import (
+	"net/http"
+	"time"
+)
+
+// Output of transcode run, that starts at given segment and writes
+// segments into its working directory.
+type Output struct {
+	StartNumber     int
+	Start           time.Duration
+	SegmentDuration time.Duration
+	// segment filename pattern, %d is replaced by segment index
+	SegmentFilename string
+	// hls playlist of completed segments
+	Playlist string
+}
+
+type Manager interface {
+	Stop()
+	Shutdown()
+	Cleanup()
+	Pid() int
+
+	ServePlaylist(w http.ResponseWriter, r *http.Request)
+	ServeSegment(w http.ResponseWriter, r *http.Request, index int)
+}
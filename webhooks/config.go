@@ -0,0 +1,45 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// events sent by default
+var DefaultEvents = []string{"start", "stop", "error", "first_viewer", "last_viewer"}
+
+type Config struct {
+	// url where are events posted
+	URL string
+	// signs payloads using HMAC-SHA256, unsigned when empty
+	Secret string
+	// types of sent events
+	Events []string
+	// failed deliveries are retried after backoff, doubled on every retry
+	Retries int
+	Backoff time.Duration
+	// timeout of single delivery attempt
+	Timeout time.Duration
+}
+
+func (c *Config) Validate() error {
+	if _, err := url.ParseRequestURI(c.URL); err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+
+	if c.Retries < 0 {
+		return errors.New("webhook retries must not be negative")
+	}
+
+	if c.Retries > 0 && c.Backoff <= 0 {
+		return errors.New("webhook backoff must be positive")
+	}
+
+	if c.Timeout <= 0 {
+		return errors.New("webhook timeout must be positive")
+	}
+
+	return nil
+}
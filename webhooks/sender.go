@@ -0,0 +1,174 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// how many events can wait for delivery, further are dropped
+const queueSize = 1024
+
+// headers of delivered events
+const (
+	EventHeader     = "X-Webhook-Event"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+type delivery struct {
+	event string
+	body  []byte
+}
+
+// Sender posts events to webhook url one by one, in order of their
+// occurrence, so that slow receiver never blocks caller.
+type Sender struct {
+	logger zerolog.Logger
+	config Config
+	client *http.Client
+	events map[string]bool
+
+	// events sent after stop are dropped
+	mu      sync.Mutex
+	stopped bool
+
+	queue    chan delivery
+	shutdown chan struct{}
+	done     chan struct{}
+}
+
+func New(config Config) *Sender {
+	events := map[string]bool{}
+	for _, event := range config.Events {
+		events[event] = true
+	}
+
+	return &Sender{
+		logger: log.With().Str("module", "webhooks").Str("url", config.URL).Logger(),
+		config: config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+		},
+		events: events,
+
+		queue:    make(chan delivery, queueSize),
+		shutdown: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *Sender) Start() {
+	go func() {
+		defer close(s.done)
+
+		for d := range s.queue {
+			s.deliver(d)
+		}
+	}()
+}
+
+// Stop delivers queued events, without retrying failed ones.
+func (s *Sender) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	close(s.shutdown)
+	close(s.queue)
+	s.mu.Unlock()
+
+	<-s.done
+}
+
+// Send queues payload of event, unless its type is not configured.
+func (s *Sender) Send(event string, payload interface{}) {
+	if !s.events[event] {
+		return
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		s.logger.Err(err).Str("event", event).Msg("unable to marshal event")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return
+	}
+
+	select {
+	case s.queue <- delivery{event, body}:
+	default:
+		s.logger.Warn().Str("event", event).Msg("webhook queue full, dropping event")
+	}
+}
+
+// Sign returns signature of body, as sent in signature header.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Sender) deliver(d delivery) {
+	backoff := s.config.Backoff
+
+	for attempt := 0; ; attempt++ {
+		err := s.post(d)
+		if err == nil {
+			s.logger.Debug().Str("event", d.event).Int("attempt", attempt).Msg("event delivered")
+			return
+		}
+
+		if attempt >= s.config.Retries {
+			s.logger.Warn().Err(err).Str("event", d.event).Msg("unable to deliver event")
+			return
+		}
+
+		s.logger.Debug().Err(err).Str("event", d.event).Dur("backoff", backoff).Msg("retrying event delivery")
+
+		select {
+		case <-s.shutdown:
+			s.logger.Warn().Err(err).Str("event", d.event).Msg("unable to deliver event before shutdown")
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+func (s *Sender) post(d delivery) error {
+	req, err := http.NewRequest(http.MethodPost, s.config.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.event)
+	if s.config.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(s.config.Secret, d.body))
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package whep
+
+import (
+	"io"
+	"os/exec"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+type Config struct {
+	// receives transcode output besides logger, e.g. log of stream
+	CmdLog io.Writer
+
+	// stun/turn server urls announced to peers, host candidates only when empty
+	ICEServers []string
+
+	// signal only transcode process instead of its whole process group
+	SingleProcess bool
+	// nice level, cpu affinity and cgroup limits of transcode process
+	ProcessLimits utils.ProcessLimits
+	// slots held while transcode runs, start fails once any is full
+	Limits []*utils.Semaphore
+	// Retry-After of responses refused because of transcode limits
+	RetryAfter string
+	// called once cmd returned by factory exits or fails to start, e.g.
+	// releasing its hardware encoder session
+	Exited func(cmd *exec.Cmd)
+}
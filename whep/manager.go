@@ -0,0 +1,474 @@
+package whep
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/m1k1o/go-transcode/internal/utils"
+)
+
+// how often should be cleanup called
+const cleanupPeriod = 4 * time.Second
+
+// how long can transcode run without peers
+const idleTimeout = 12 * time.Second
+
+// maximum size of sdp offer
+const maxOfferSize = 64 << 10
+
+// maximum size of rtp packet received from transcode
+const maxPacketSize = 1500
+
+// how long can offer wait for ice candidates to be gathered
+const gatherTimeout = 5 * time.Second
+
+var ErrAlreadyStarted = errors.New("has already started")
+
+// limit of concurrent transcodes is reached
+var ErrTooManyTranscodes = errors.New("too many transcodes")
+
+type ManagerCtx struct {
+	logger     zerolog.Logger
+	mu         sync.Mutex
+	cmdFactory func() (*exec.Cmd, error)
+	config     Config
+
+	cmd      *exec.Cmd
+	video    *webrtc.TrackLocalStaticRTP
+	audio    *webrtc.TrackLocalStaticRTP
+	conns    []*net.UDPConn
+	peers    map[string]*webrtc.PeerConnection
+	lastPeer time.Time
+
+	shutdown chan interface{}
+}
+
+func New(cmdFactory func() (*exec.Cmd, error), config Config) *ManagerCtx {
+	return &ManagerCtx{
+		logger:     log.With().Str("module", "whep").Str("submodule", "manager").Logger(),
+		cmdFactory: cmdFactory,
+		config:     config,
+
+		peers:    map[string]*webrtc.PeerConnection{},
+		shutdown: make(chan interface{}),
+	}
+}
+
+func (m *ManagerCtx) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd != nil {
+		return ErrAlreadyStarted
+	}
+
+	m.logger.Debug().Msg("performing start")
+
+	if err := utils.TryAcquireAll(m.config.Limits); err != nil {
+		m.logger.Warn().Msg("transcode limit reached")
+		return ErrTooManyTranscodes
+	}
+
+	started := false
+	defer func() {
+		if !started {
+			utils.ReleaseAll(m.config.Limits)
+		}
+	}()
+
+	cmd, err := m.cmdFactory()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if !started {
+			m.exited(cmd)
+		}
+	}()
+
+	video, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
+		MimeType:    webrtc.MimeTypeH264,
+		ClockRate:   90000,
+		SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+	}, "video", "go-transcode")
+	if err != nil {
+		return err
+	}
+
+	audio, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
+		MimeType:  webrtc.MimeTypeOpus,
+		ClockRate: 48000,
+		Channels:  2,
+	}, "audio", "go-transcode")
+	if err != nil {
+		return err
+	}
+
+	// transcode sends rtp of every track to its own local port
+	var conns []*net.UDPConn
+	for range []*webrtc.TrackLocalStaticRTP{video, audio} {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return err
+		}
+		conns = append(conns, conn)
+	}
+
+	cmd.Env = append(cmd.Env,
+		fmt.Sprintf("TRANSCODE_RTP_VIDEO=rtp://%s", conns[0].LocalAddr()),
+		fmt.Sprintf("TRANSCODE_RTP_AUDIO=rtp://%s", conns[1].LocalAddr()),
+	)
+	utils.ExpandArgs(cmd)
+	cmd.Stderr = utils.LogWriter(m.logger)
+	if m.config.CmdLog != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, m.config.CmdLog)
+	}
+
+	//create a new process group
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: !m.config.SingleProcess}
+
+	if err := cmd.Start(); err != nil {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		return err
+	}
+
+	if err := m.config.ProcessLimits.Apply(cmd.Process.Pid); err != nil {
+		m.logger.Warn().Err(err).Msg("unable to apply process limits")
+	}
+
+	started = true
+	m.cmd = cmd
+	m.video = video
+	m.audio = audio
+	m.conns = conns
+	m.lastPeer = time.Now()
+	m.shutdown = make(chan interface{})
+
+	go m.forward(conns[0], video)
+	go m.forward(conns[1], audio)
+
+	go func() {
+		err := cmd.Wait()
+		m.logger.Info().Err(err).Msg("cmd exited")
+		m.exited(cmd)
+
+		m.mu.Lock()
+		exited := m.cmd == cmd
+		m.mu.Unlock()
+
+		// clean up after process, that exited by itself
+		if exited {
+			m.Stop()
+		}
+	}()
+
+	shutdown := m.shutdown
+	go func() {
+		ticker := time.NewTicker(cleanupPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-shutdown:
+				return
+			case <-ticker.C:
+				m.Cleanup()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// exited calls exit hook of cmd, that exited or failed to start.
+func (m *ManagerCtx) exited(cmd *exec.Cmd) {
+	if m.config.Exited != nil {
+		m.config.Exited(cmd)
+	}
+}
+
+// forward writes rtp packets of transcode to track of every peer.
+func (m *ManagerCtx) forward(conn *net.UDPConn, track *webrtc.TrackLocalStaticRTP) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		if _, err := track.Write(buf[:n]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			m.logger.Debug().Err(err).Msg("unable to write rtp packet")
+		}
+	}
+}
+
+func (m *ManagerCtx) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil {
+		return
+	}
+
+	m.logger.Debug().Msg("performing stop")
+	close(m.shutdown)
+
+	if m.cmd.Process != nil && m.config.SingleProcess {
+		err := m.cmd.Process.Kill()
+		m.logger.Err(err).Msg("killing proccess")
+	} else if m.cmd.Process != nil {
+		pgid, err := syscall.Getpgid(m.cmd.Process.Pid)
+		if err == nil {
+			err := syscall.Kill(-pgid, syscall.SIGKILL)
+			m.logger.Err(err).Msg("killing proccess group")
+		} else {
+			m.logger.Err(err).Msg("could not get proccess group id")
+			err := m.cmd.Process.Kill()
+			m.logger.Err(err).Msg("killing proccess")
+		}
+	}
+
+	for _, conn := range m.conns {
+		conn.Close()
+	}
+
+	// peers are closed outside of lock, their state handlers use it
+	peers := m.peers
+	go func() {
+		for _, peer := range peers {
+			peer.Close()
+		}
+	}()
+
+	m.cmd = nil
+	m.conns = nil
+	utils.ReleaseAll(m.config.Limits)
+	m.peers = map[string]*webrtc.PeerConnection{}
+}
+
+func (m *ManagerCtx) Cleanup() {
+	m.mu.Lock()
+	diff := time.Since(m.lastPeer)
+	stop := len(m.peers) == 0 && diff > idleTimeout
+	m.mu.Unlock()
+
+	m.logger.Debug().
+		Dur("diff", diff).
+		Bool("stop", stop).
+		Msg("performing cleanup")
+
+	if stop {
+		m.Stop()
+	}
+}
+
+// Peers returns count of connected peers.
+func (m *ManagerCtx) Peers() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.peers)
+}
+
+// Pid returns transcode process id, or zero when not running.
+func (m *ManagerCtx) Pid() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cmd == nil || m.cmd.Process == nil {
+		return 0
+	}
+
+	return m.cmd.Process.Pid
+}
+
+// newPeer creates peer connection receiving tracks of running transcode.
+func (m *ManagerCtx) newPeer() (*webrtc.PeerConnection, string, error) {
+	m.mu.Lock()
+	running, video, audio := m.cmd != nil, m.video, m.audio
+	m.mu.Unlock()
+
+	if !running {
+		return nil, "", errors.New("transcode is not running")
+	}
+
+	configuration := webrtc.Configuration{}
+	if len(m.config.ICEServers) > 0 {
+		configuration.ICEServers = []webrtc.ICEServer{{URLs: m.config.ICEServers}}
+	}
+
+	peer, err := webrtc.NewPeerConnection(configuration)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, track := range []*webrtc.TrackLocalStaticRTP{video, audio} {
+		sender, err := peer.AddTrack(track)
+		if err != nil {
+			peer.Close()
+			return nil, "", err
+		}
+
+		// rtcp must be read for interceptors to work
+		go func() {
+			buf := make([]byte, maxPacketSize)
+			for {
+				if _, _, err := sender.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		peer.Close()
+		return nil, "", err
+	}
+	id := hex.EncodeToString(idBytes)
+
+	peer.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		m.logger.Debug().Str("id", id).Str("state", state.String()).Msg("peer connection state changed")
+
+		switch state {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			m.removePeer(id)
+		}
+	})
+
+	m.mu.Lock()
+	if m.cmd == nil || m.video != video {
+		m.mu.Unlock()
+		peer.Close()
+		return nil, "", errors.New("transcode is not running")
+	}
+	m.peers[id] = peer
+	m.lastPeer = time.Now()
+	m.mu.Unlock()
+
+	return peer, id, nil
+}
+
+// removePeer forgets peer and closes its connection.
+func (m *ManagerCtx) removePeer(id string) bool {
+	m.mu.Lock()
+	peer, ok := m.peers[id]
+	delete(m.peers, id)
+	m.lastPeer = time.Now()
+	m.mu.Unlock()
+
+	if ok {
+		go peer.Close()
+	}
+
+	return ok
+}
+
+func (m *ManagerCtx) ServeOffer(w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/sdp" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		w.Write([]byte("415 offer must be application/sdp"))
+		return
+	}
+
+	offer, err := io.ReadAll(io.LimitReader(r.Body, maxOfferSize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 unable to read offer"))
+		return
+	}
+
+	err = m.Start()
+	if errors.Is(err, ErrTooManyTranscodes) {
+		w.Header().Set("Retry-After", m.config.RetryAfter)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 too many transcodes"))
+		return
+	}
+
+	if err != nil && !errors.Is(err, ErrAlreadyStarted) {
+		m.logger.Warn().Err(err).Msg("transcode could not be started")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 transcode could not be started"))
+		return
+	}
+
+	peer, id, err := m.newPeer()
+	if err != nil {
+		m.logger.Warn().Err(err).Msg("peer could not be created")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 peer could not be created"))
+		return
+	}
+
+	answer, err := m.answer(peer, string(offer))
+	if err != nil {
+		m.removePeer(id)
+		m.logger.Warn().Err(err).Str("id", id).Msg("offer could not be answered")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 " + err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+id)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(answer))
+}
+
+// answer sets remote offer and returns local answer including all ice
+// candidates, since trickle ice is not supported.
+func (m *ManagerCtx) answer(peer *webrtc.PeerConnection, offer string) (string, error) {
+	if err := peer.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return "", err
+	}
+
+	answer, err := peer.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+
+	gathered := webrtc.GatheringCompletePromise(peer)
+	if err := peer.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+
+	select {
+	case <-gathered:
+	case <-time.After(gatherTimeout):
+		return "", errors.New("ice gathering timed out")
+	}
+
+	return peer.LocalDescription().SDP, nil
+}
+
+func (m *ManagerCtx) ServeDelete(w http.ResponseWriter, r *http.Request, id string) {
+	if !m.removePeer(id) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 session not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,17 @@
+package whep
+
+import "net/http"
+
+type Manager interface {
+	Start() error
+	Stop()
+	Cleanup()
+	Peers() int
+	Pid() int
+
+	// ServeOffer answers sdp offer of new peer, resource of its session
+	// is created under request path.
+	ServeOffer(w http.ResponseWriter, r *http.Request)
+	// ServeDelete closes session of peer.
+	ServeDelete(w http.ResponseWriter, r *http.Request, id string)
+}